@@ -0,0 +1,382 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"gtp-sim-initiator/sim"
+)
+
+// options is everything the command line sets: the client configuration
+// plus the values main turns into it or acts on itself.
+type options struct {
+	c            sim.Config
+	ratU, ebiU   uint
+	changeNotify bool
+	sets         []string // -set overrides, applied after the other flags
+	fm           sim.FindMaxOptions
+
+	// shared
+	nodeIP    string
+	nodeIP6   string
+	logOnly   string
+	logExcept string
+	waitPath  time.Duration
+	seed      uint64
+	httpAddr  string
+	pprofAddr string
+	spoofSrc  string
+	recovery  uint
+	dscpMap   string
+	teidField string
+	nodeFeats string
+
+	// CreateSessionRequest contents
+	omit        string
+	apns        string
+	csids       string
+	subscribers string
+	bearers     string
+	bearerFlags string
+	requireIEs  string
+	traceRef    string
+	traceDepth  string
+	traceIP     string
+	teidSource  string
+	csgMode     string
+
+	// follow-up and single-shot procedures
+	suspend    bool
+	resume     bool
+	brc        bool
+	ddn        bool
+	mabr       bool
+	brcQCI     uint
+	brcTAD     string
+	cnRAT      uint
+	cnTAC      uint
+	cnECI      uint
+	deleteCSID bool
+	identify   string
+	contextReq string
+	contextTAU string
+	console    bool
+	compare    string
+
+	// load
+	scanStart string
+	scanCount int
+	scanRate  float64
+	findMax   bool
+	burst     int
+
+	// serve
+	ddnFailure uint
+	slaMS      int
+	unknownMsg int
+	respCause  uint
+
+	// echo command
+	echoCount    int
+	echoInterval time.Duration
+
+	// modes without a command
+	selftest    bool
+	selftestPGW string
+	scenario    string
+}
+
+// command is a subcommand and the flag groups that apply to it.
+type command struct {
+	name, args, summary string
+	groups              []func(*options, *flag.FlagSet)
+}
+
+var commands = []command{
+	{"echo", "", "send EchoRequests to -remote, report the RTTs and exit",
+		[]func(*options, *flag.FlagSet){(*options).echoFlags}},
+	{"session", "", "create sessions, run the follow-up procedures and hold the sessions until interrupted",
+		[]func(*options, *flag.FlagSet){(*options).csrFlags, (*options).procedureFlags, (*options).gateFlags}},
+	{"load", "", "create sessions at a rate (-rate), scan IMSIs (-imsi-range) or find the max CSR rate (-find-max)",
+		[]func(*options, *flag.FlagSet){(*options).csrFlags, (*options).loadFlags, (*options).gateFlags}},
+	{"serve", "", "play the PGW: answer CSR/MBR/DSR from any peer until interrupted",
+		[]func(*options, *flag.FlagSet){(*options).serveFlags}},
+	{"replay", "FILE", "run the scenario script FILE (see README) and exit with its verdict",
+		[]func(*options, *flag.FlagSet){(*options).csrFlags}},
+	{"selftest", "", "run an in-process PGW and create sessions against it (-remote not needed)",
+		[]func(*options, *flag.FlagSet){(*options).csrFlags, (*options).procedureFlags, (*options).serveFlags, (*options).selftestFlags}},
+}
+
+// sharedFlags are the socket, timer and logging flags every mode uses. With a
+// command they go before it: gtp-init -remote ip:port session -apn ims.
+func (o *options) sharedFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.nodeIP, "node-ip", "127.0.0.1", "SGW IP to put inside F-TEID (IPv4)")
+	fs.StringVar(&o.nodeIP6, "node-ip6", "", "SGW global IPv6 to put inside F-TEIDs as well (a %zone is dropped: it only matters to the socket, see -local/-remote)")
+	fs.StringVar(&o.c.Local, "local", "0.0.0.0:2123", "local bind ip:port")
+	fs.StringVar(&o.c.Remote, "remote", "", "PGW ip:port (e.g. 172.16.10.170:2123)")
+	fs.Func("peer", "a PGW and the local address to reach it from: remote=ip:port,local=ip:port[,node-ip=ip] (repeatable, instead of -remote and -local); each gets its own socket, node-ip (default: local's) goes in its sessions' F-TEIDs, and sessions go to the peers in turn", func(s string) error {
+		p, err := sim.ParsePeer(s)
+		if err != nil {
+			return err
+		}
+		o.c.Peers = append(o.c.Peers, p)
+		return nil
+	})
+	fs.DurationVar(&o.c.EchoEvery, "echo", 10*time.Second, "send Echo Request every duration, skipped while the peer answered other requests within it")
+	fs.DurationVar(&o.c.Timeout, "timeout", 5*time.Second, "wait timeout for a response; with -t3, at least -t3 * (-n3+1) so every retransmission is waited for")
+	fs.DurationVar(&o.c.T3, "t3", 0, "retransmit an unanswered request after this long (T3-RESPONSE); 0 disables retransmission")
+	fs.IntVar(&o.c.N3, "n3", 3, "max retransmissions per request (N3-REQUESTS)")
+	fs.BoolVar(&o.c.AdaptiveT3, "adaptive-t3", false, "adapt T3 to measured RTTs (SRTT+4*RTTVAR); uses -t3 until enough samples")
+	fs.BoolVar(&o.c.IgnoreEchoReq, "ignore-echo-req", false, "log but never answer received EchoRequests (tests peer path-failure detection)")
+	fs.DurationVar(&o.c.EchoRespDelay, "echo-resp-delay", 0, "delay before answering a received EchoRequest (tests peer echo timeout)")
+	fs.UintVar(&o.recovery, "recovery", 1, "restart counter to send in the Recovery IE of our EchoRequests/EchoResponses (0..255)")
+	fs.StringVar(&o.nodeFeats, "node-features", "", "add a Sending Node Features IE to our EchoRequests/EchoResponses: feature names, e.g. PRN,MABR (also NTSR, CIOT, S1UN, ETH, MTEDT), or the octet, e.g. 0x03; the peer's are logged either way")
+	fs.DurationVar(&o.c.StatsEvery, "stats-every", 0, "log a stats line (msgs by type, in-flight, RTT p95) every interval; 0 disables")
+	fs.IntVar(&o.c.BadLength, "bad-length", 0, "add this delta to the GTPv2 header length field of every request after marshaling (negative tests)")
+	fs.StringVar(&o.teidField, "teid-field", "", "force requests of these types to carry a TEID field (T flag set) or none, e.g. EchoReq=present,CSR=absent (negative tests)")
+	fs.IntVar(&o.c.SendBatch, "send-batch", 0, "coalesce up to N queued outgoing datagrams into one sendmmsg call (linux; 0 = one write per datagram)")
+	fs.IntVar(&o.c.MaxInflight, "max-inflight", 0, "max outstanding transactions; further sends wait for a slot (0 = unlimited)")
+	fs.IntVar(&o.c.RxWorkers, "rx-workers", 1, "goroutines parsing/handling received packets (sharded by peer)")
+	fs.IntVar(&o.c.WriteRetries, "write-retries", 3, "retries (with short backoff) of a send failing with a transient error such as ENOBUFS")
+	fs.IntVar(&o.c.DupSend, "dup-send", 0, "TESTING: send every request this many times back to back with the same sequence (stresses the peer's duplicate handling); logs how many answers the copies drew and whether they were identical (0/1 = once)")
+	fs.IntVar(&o.c.RebindTries, "rebind-attempts", 10, "when a socket fails with a fatal error (e.g. its interface went away), close it and listen on the same address again, up to this many attempts with backoff (0 = give the socket up)")
+	fs.StringVar(&o.c.MetricsFile, "metrics-file", "", "on exit, write the run's metrics (as served on -http /metrics) to FILE in OpenMetrics text format, e.g. for the node_exporter textfile collector")
+	fs.BoolVar(&o.c.DecodeJSON, "decode-json", false, "print every received GTPv2 message as a JSON object (one per line) on stdout")
+	fs.BoolVar(&o.c.LogIEs, "log-ies", false, "log the IE names (grouped IEs with their children) of every received message, e.g. CSRsp: Cause, F-TEID, PAA, BearerContext{Cause, EBI, F-TEID:2}")
+	fs.BoolVar(&o.c.SessionLog, "session-log", false, "instead of a line per message, log one line per session when it ends: imsi, apn, result, UE address, duration, the procedures it went through with their causes and how it ended")
+	fs.StringVar(&o.logOnly, "log-only", "", "log only these received message types, e.g. CSRsp,DSRsp (names as in the logs, or numbers)")
+	fs.StringVar(&o.logExcept, "log-except", "", "don't log these received message types, e.g. EchoReq,EchoResp")
+	fs.BoolVar(&o.c.Debug, "debug", false, "debug logging (e.g. encoded IE bytes)")
+	fs.IntVar(&o.c.DSCP, "dscp", -1, "DSCP (0..63) to mark outgoing GTP-C packets with; -1 keeps the OS default")
+	fs.StringVar(&o.dscpMap, "dscp-map", "", "per message type DSCP overriding -dscp, e.g. EchoReq=8,EchoResp=8,CSR=46 (types as in the logs, or numbers; linux)")
+	fs.BoolVar(&o.c.DF, "df", false, "set the don't-fragment bit on outgoing GTP-C packets (linux)")
+	fs.IntVar(&o.c.RcvBuf, "so-rcvbuf", 0, "GTP-C socket receive buffer (SO_RCVBUF) in bytes; 0 keeps the OS default. The OS may clamp it; the applied size is logged")
+	fs.IntVar(&o.c.SndBuf, "so-sndbuf", 0, "GTP-C socket send buffer (SO_SNDBUF) in bytes; 0 keeps the OS default. The OS may clamp it; the applied size is logged")
+	fs.Float64Var(&o.c.RxLoss, "rx-loss", 0, "TESTING: drop this fraction (0..1) of received GTP-C datagrams before handling them, to exercise -t3/-n3 against a lossy path")
+	fs.DurationVar(&o.c.RxDelay, "rx-delay", 0, "TESTING: delay handling every received GTP-C datagram by this much")
+	fs.DurationVar(&o.c.RxJitter, "rx-jitter", 0, "TESTING: add a random delay up to this on top of -rx-delay (may reorder datagrams)")
+	fs.BoolVar(&o.c.FollowPeer, "follow-peer", false, "learn the peer address from its responses and send there (PGW behind NAT)")
+	fs.BoolVar(&o.c.Strict, "strict", false, "make a -node-ip / egress source address mismatch fatal instead of a warning")
+	fs.DurationVar(&o.c.GTPUEcho, "gtpu-echo", 0, "send a GTP-U Echo Request every duration to check the user-plane path; 0 disables")
+	fs.StringVar(&o.c.GTPURemote, "gtpu-remote", "", "GTP-U peer ip:port (default: -remote host, port 2152)")
+	fs.StringVar(&o.c.GTPULocal, "gtpu-local", "", "GTP-U local bind ip:port (default: -local host, ephemeral port)")
+	fs.DurationVar(&o.waitPath, "wait-path", 0, "before the first CreateSession, send Echos until the peer answers one, failing after this long (0 = don't wait)")
+	fs.Uint64Var(&o.seed, "seed", 0, "TESTING ONLY: seed a predictable PRNG for TEIDs, sequence numbers and random IMSIs so runs replay exactly (0 = crypto/rand)")
+	fs.StringVar(&o.httpAddr, "http", "", "serve /healthz, /status (JSON) and /metrics (OpenMetrics) on this ip:port")
+	fs.StringVar(&o.pprofAddr, "pprof-addr", "", "serve the Go runtime profiles (CPU, heap, goroutines, ...) under /debug/pprof/ on this ip:port, e.g. 127.0.0.1:6060; off by default")
+	fs.IntVar(&o.c.FD, "fd", -1, "use the already bound UDP socket on this file descriptor (socket activation) instead of binding -local")
+	fs.StringVar(&o.spoofSrc, "spoof-src", "", "send GTP-C with this IPv4 source address via a raw socket (needs root/CAP_NET_RAW; lab use); answers are still read on -local")
+	fs.BoolVar(&o.c.Connected, "connect", false, "use a connected UDP socket to -remote (faster single-peer I/O, sync ICMP errors); ignored when answering requests (-respond, serve), which takes any peer")
+	fs.IntVar(&o.c.SrcPorts, "src-ports", 1, "send requests from this many local UDP ports in turn (-local's plus ephemeral ones); retransmissions keep their request's port")
+	fs.BoolVar(&o.c.RotateSrcPort, "rotate-src-port", false, "with -src-ports, retransmit from the next port instead of the original's (tests peers that match retransmissions by port)")
+}
+
+// csrFlags shape the CreateSessionRequests.
+func (o *options) csrFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.c.IMSI, "imsi", "001010123456789", "IMSI")
+	fs.IntVar(&o.c.MNCLen, "mnc-len", 2, "digits of the MNC in the IMSI (2 or 3), for the home PLMN in the Change Notification ULI and the User CSG Information")
+	fs.StringVar(&o.c.MSISDN, "msisdn", "919999999999", "MSISDN (optional)")
+	fs.BoolVar(&o.c.NoMSISDN, "no-msisdn", false, "never send the MSISDN IE, whatever -msisdn says")
+	fs.StringVar(&o.omit, "omit", "", "comma-separated CSR IEs to leave out for negative tests: apn,imsi,rat,fteid,pdn,bearer")
+	fs.Func("bad-instance", "send one CSR IE at a wrong instance and report whether the peer rejects it: NAME[:N], NAME one of apn,imsi,rat,fteid,pdn,bearer or random (a different one per CSR), N the instance (default: a random wrong one)", func(s string) error {
+		var err error
+		o.c.BadInstance, err = sim.ParseBadInstance(s)
+		return err
+	})
+	fs.Func("raw-ie", "append a raw IE type:instance:hexbytes to the CSR, e.g. 255:0:0001abcd (repeatable)", func(s string) error {
+		ie, err := sim.ParseRawIE(s)
+		if err == nil {
+			o.c.RawIEs = append(o.c.RawIEs, ie)
+		}
+		return err
+	})
+	fs.Func("epco", "send an Extended PCO IE in the CSR: comma-separated hex container IDs with optional :hex contents, e.g. 000d,0010 (DNS IPv4, IPv4 MTU)", func(s string) error {
+		var err error
+		o.c.EPCO, err = sim.ParseEPCO(s)
+		return err
+	})
+	fs.Func("set", "override one CSR setting, key=value (repeatable, applied after the other flags), e.g. -set rat=8 -set qci=5 -set apn=ims; keys: imsi msisdn apn pdn rat ebi qci arp omit bad-instance cn-rat tac eci", func(s string) error {
+		o.sets = append(o.sets, s)
+		return nil
+	})
+	fs.StringVar(&o.c.APN, "apn", "internet", "APN")
+	fs.StringVar(&o.c.Group, "group", "", "label the sessions for the per-group run report (default: group by APN); a subscriber CSV group column overrides it")
+	fs.StringVar(&o.apns, "apns", "", "comma-separated APNs: one PDN connection per APN for each subscriber (replaces -apn)")
+	fs.StringVar(&o.c.PDNType, "pdn", "ipv4", "pdn: ipv4|ipv6|ipv4v6")
+	fs.UintVar(&o.ratU, "rat", 6, "RAT-Type (e.g. 6=EUTRAN)")
+	fs.IntVar(&o.c.Sessions, "sessions", 1, "number of sessions to create; with >1 each gets a random MSIN under the -imsi PLMN")
+	fs.StringVar(&o.csids, "csid", "", "comma-separated CSIDs to send in an SGW FQ-CSID IE in the CSR")
+	fs.StringVar(&o.c.CSIDNode, "csid-node", "", "FQ-CSID node ID: IPv4/IPv6 address or 8 hex digits (default: -node-ip)")
+	fs.StringVar(&o.subscribers, "subscribers", "", "CSV file of subscribers (header: imsi[,msisdn,apn,pdn,group]); creates one session per row instead of -sessions")
+	fs.StringVar(&o.bearers, "bearers", "", "comma-separated EBIs of all bearers to create in the CSR (must include -ebi), e.g. 5,6,7")
+	fs.StringVar(&o.requireIEs, "require-resp-ie", "", "comma-separated IEs an accepted CSRsp must carry, e.g. PAA,PCO,BearerContext.ChargingID (names as in -decode-json or -log-ies); one missing fails the CreateSession, whose session is then deleted")
+	fs.IntVar(&o.c.SPLMNRateUL, "splmn-rate-ul", -1, "CIoT: send a Serving PLMN Rate Control IE in the CSR with this uplink limit, NAS data PDUs per 6 minutes (10..65535, 65535 = unlimited; needs -splmn-rate-dl; -1 omits the IE)")
+	fs.IntVar(&o.c.SPLMNRateDL, "splmn-rate-dl", -1, "downlink limit of the Serving PLMN Rate Control IE (10..65535; needs -splmn-rate-ul)")
+	fs.IntVar(&o.c.CSGID, "csg-id", -1, "femtocell: send a User CSG Information IE in the CSR with this CSG ID (0..134217727, 27 bits; the IMSI's PLMN; -1 omits the IE)")
+	fs.StringVar(&o.csgMode, "csg-mode", "closed", "access mode of the -csg-id cell: closed or hybrid")
+	fs.BoolVar(&o.c.CSGMember, "csg-member", false, "the UE is a member of the -csg-id CSG (CMI=1); hybrid cells only, closed ones imply it")
+	fs.StringVar(&o.bearerFlags, "bearer-flags", "", "add a Bearer Flags IE to each bearer context of the CSR: flag names, e.g. VB,PPC (also VIND, ASI), or the octet, e.g. 0x03")
+	fs.UintVar(&o.ebiU, "ebi", 5, "EPS Bearer ID (default bearer usually 5)")
+	fs.StringVar(&o.traceRef, "trace-ref", "", "include Trace Information IE with trace reference MCC-MNC-TRACEID (hex), e.g. 001-01-00abcd")
+	fs.StringVar(&o.traceDepth, "trace-depth", "maximum", "trace depth: minimum|medium|maximum[-no-vendor]")
+	fs.StringVar(&o.traceIP, "trace-ip", "", "trace collection entity IP (required with -trace-ref)")
+	fs.StringVar(&o.c.JUnit, "junit", "", "write the run outcome as JUnit XML to FILE at the end: a test case per session (failed if a procedure failed, with its error), per failed CreateSession and per -scenario step")
+	fs.StringVar(&o.c.StateFile, "state-file", "", "save the session store (imsi, TEIDs, EBI, bearers, ue ip, state) to FILE every 5s and at the end, and restore it from FILE at start, so the follow-up procedures, the console and replay refs reach sessions an earlier run created; with -sessions 0 no new ones are created")
+	fs.StringVar(&o.c.DB, "db", "", "record each session (identity, TEIDs, UE ip, causes, timings, procedures) in the SQLite database FILE, created with its tables on first use")
+	fs.StringVar(&o.c.FlowOut, "flow-out", "", "write a JSON flow record per session (start, imsi, apn, ue ip, charging id, duration, end cause) when it is deleted or the run ends; FILE or udp:HOST:PORT")
+	fs.StringVar(&o.c.IPOut, "ip-out", "", "append IMSI and assigned PAA (v4/v6) of each accepted session to FILE")
+	fs.DurationVar(&o.c.GTPUKeepalive, "gtpu-keepalive", 0, "GTP-U Echo each session's PGW user-plane address every duration and track per-session path health; 0 disables")
+	fs.StringVar(&o.teidSource, "teid-source", "assigned", "header TEID of ModifyBearer/DeleteSession: assigned (the PGW's control TEID) or fixed:0xNNNN (negative tests)")
+}
+
+// procedureFlags select what runs once the sessions are up, or the
+// single-shot procedures that replace them.
+func (o *options) procedureFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&o.changeNotify, "change-notify", false, "send a ChangeNotificationRequest once the session is up")
+	fs.BoolVar(&o.suspend, "suspend", false, "send a SuspendNotification once the session is up (after -change-notify)")
+	fs.BoolVar(&o.resume, "resume", false, "send a ResumeNotification once the session is up (after -suspend)")
+	fs.BoolVar(&o.brc, "brc", false, "send a BearerResourceCommand for a dedicated bearer once the session is up (after -resume)")
+	fs.UintVar(&o.brcQCI, "brc-qci", 1, "QCI of the Flow QoS in the BearerResourceCommand")
+	fs.Uint64Var(&o.c.BRCQoS.MBRUL, "brc-mbr-ul", 128, "uplink MBR (kbps) in the BearerResourceCommand Flow QoS")
+	fs.Uint64Var(&o.c.BRCQoS.MBRDL, "brc-mbr-dl", 128, "downlink MBR (kbps) in the BearerResourceCommand Flow QoS")
+	fs.Uint64Var(&o.c.BRCQoS.GBRUL, "brc-gbr-ul", 64, "uplink GBR (kbps) in the BearerResourceCommand Flow QoS; 0 for non-GBR QCIs")
+	fs.Uint64Var(&o.c.BRCQoS.GBRDL, "brc-gbr-dl", 64, "downlink GBR (kbps) in the BearerResourceCommand Flow QoS; 0 for non-GBR QCIs")
+	fs.StringVar(&o.brcTAD, "brc-tad", "proto=17,rport=5060", "TAD packet filters for -brc, ';'-separated, each key=value,...: dir=bi|ul|dl proto raddr=CIDR rport lport (N or LO-HI) prec")
+	fs.UintVar(&o.cnRAT, "cn-rat", 0, "RAT-Type in the ChangeNotificationRequest (0 = same as -rat)")
+	fs.UintVar(&o.cnTAC, "cn-tac", 1, "TAC in the ChangeNotificationRequest ULI")
+	fs.UintVar(&o.cnECI, "cn-eci", 1, "ECGI cell ID (28-bit) in the ChangeNotificationRequest ULI")
+	fs.StringVar(&o.c.TUN, "tun", "", "once the session is up, create this TUN interface with the UE's IPv4 PAA and carry its traffic through the GTP-U tunnel (linux, root); add routes into it yourself")
+	fs.BoolVar(&o.ddn, "ddn", false, "send a DownlinkDataNotification for each session once it is up and log any DDN Failure Indication that follows")
+	fs.BoolVar(&o.c.PiggybackCBRsp, "piggyback-cbrsp", false, "answer a CreateBearerRequest piggybacked on the CSRsp with the CreateBearerResponse piggybacked on an MBR (as at attach) instead of on its own")
+	fs.BoolVar(&o.mabr, "mabr", false, "S11: send a ModifyAccessBearersRequest moving each session's bearers to a new eNodeB S1-U F-TEID once it is up (after -ddn)")
+	fs.BoolVar(&o.deleteCSID, "delete-csid", false, "after creating the sessions, send a DeletePDNConnectionSetRequest for -csid and check the peer dropped those sessions")
+	fs.StringVar(&o.identify, "identify", "", "send an IdentificationRequest for this GUTI (MCC-MNC-MMEGI-MMEC-MTMSI, hex for the last three) and exit")
+	fs.StringVar(&o.compare, "compare", "", "send one CSR, byte for byte the same, to -remote (A) and to this ip:port (B), print an IE-by-IE diff of the two CSRsps, delete what they accepted and exit (1 if they behave differently)")
+	fs.StringVar(&o.contextReq, "context-req", "", "S10: send a ContextRequest for this GUTI (as -identify), print the UE context, acknowledge it and exit")
+	fs.IntVar(&o.unknownMsg, "unknown-msg-type", -1, "send an EchoRequest whose message type octet is overwritten with this value (0..255), report whether the peer answers or drops it, and exit")
+	fs.StringVar(&o.contextTAU, "context-tau", "", "hex NAS TAU Request to send as the Complete TAU Request Message IE with -context-req")
+	fs.BoolVar(&o.console, "console", false, "while holding the sessions, read commands from stdin (resend SEQ|last, sent [N], help)")
+}
+
+func (o *options) loadFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.scanStart, "imsi-range", "", "scan mode: probe IMSIs from START with a CSR each, report accepted/rejected, delete accepted")
+	fs.IntVar(&o.scanCount, "imsi-count", 10, "number of IMSIs to probe with -imsi-range")
+	fs.Float64Var(&o.c.Rate, "rate", 0, "send the CSRs open-loop at this many per second instead of one after another (0 = wait for each answer)")
+	fs.IntVar(&o.c.ClosedLoop, "closed-loop", 0, "keep up to this many CSRs outstanding, each sent as soon as an answer frees a slot; the window starts at 1 and widens over -closed-loop-ramp (0 = off; excludes -rate)")
+	fs.DurationVar(&o.c.ClosedLoopRamp, "closed-loop-ramp", 0, "how long -closed-loop takes to widen from 1 to its target concurrency (0 = target at once)")
+	fs.IntVar(&o.burst, "burst", 0, "send this many CSRs all at once, without pacing (sets -sessions), and report the drain time, timeouts and response rate curve (0 = off; excludes -rate and -closed-loop)")
+	fs.Float64Var(&o.scanRate, "scan-rate", 5, "CSRs per second in -imsi-range scan mode")
+	fs.BoolVar(&o.findMax, "find-max", false, "capacity mode: raise the CSR rate step by step until the success ratio or p95 latency crosses its threshold, report the max sustainable rate")
+	fs.Float64Var(&o.fm.Start, "find-max-start", 10, "first CSR rate (per second) of -find-max")
+	fs.Float64Var(&o.fm.Limit, "find-max-limit", 10000, "highest CSR rate -find-max offers")
+	fs.DurationVar(&o.fm.Step, "find-max-step", 5*time.Second, "how long -find-max offers each rate")
+	fs.Float64Var(&o.fm.MinSuccess, "find-max-success", 0.99, "accepted/sent ratio a rate must reach in -find-max")
+	fs.DurationVar(&o.fm.MaxP95, "find-max-p95", 500*time.Millisecond, "CSR latency p95 a rate must stay under in -find-max (0 = ignore latency)")
+	fs.DurationVar(&o.c.Warmup, "warmup", 0, "leave the CSRs sent (and RTTs begun) in this first stretch of a load run out of the statistics; -find-max offers -find-max-start for this long before its first judged step")
+}
+
+// gateFlags fail the run when too many CreateSessions failed.
+func (o *options) gateFlags(fs *flag.FlagSet) {
+	fs.IntVar(&o.c.Failures.MaxFailures, "max-failures", -1, "exit 7 if more than this many CreateSessions failed (-1 = no limit)")
+	fs.Float64Var(&o.c.Failures.MaxFailureRate, "max-failure-rate", -1, "exit 7 if more than this fraction (0..1) of CreateSessions failed (-1 = no limit)")
+	fs.IntVar(&o.slaMS, "sla-ms", 0, "exit 7 if a CSRsp takes longer than this many milliseconds (0 = no SLA); the run report names the slowest")
+	fs.Float64Var(&o.c.Failures.MaxSLARate, "sla-max-rate", 0, "fraction (0..1) of CSRsps allowed over -sla-ms before the run fails")
+}
+
+func (o *options) serveFlags(fs *flag.FlagSet) {
+	fs.IntVar(&o.c.APNRestriction, "apn-restriction", -1, "APN Restriction (0..4) to put in CSRsp in -respond mode; -1 omits it")
+	fs.UintVar(&o.respCause, "resp-cause", 0, "in -respond mode, reject every CSR with this cause (64..239, e.g. 93 APN access denied) instead of accepting it (0 = accept)")
+	fs.BoolVar(&o.c.RespPiggybackCBR, "piggyback-cbr", false, "in -respond mode, piggyback a CreateBearerRequest for a QCI 1 dedicated bearer (SIP TFT) on every accepted CSRsp")
+	fs.UintVar(&o.ddnFailure, "ddn-failure", 0, "in -respond mode, follow each DDN Ack with a DDN Failure Indication with this cause (0 = off)")
+}
+
+func (o *options) echoFlags(fs *flag.FlagSet) {
+	fs.IntVar(&o.echoCount, "count", 1, "number of EchoRequests to send")
+	fs.DurationVar(&o.echoInterval, "interval", time.Second, "pause between EchoRequests")
+}
+
+func (o *options) selftestFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.selftestPGW, "selftest-pgw", "", "PGW bind ip:port (default: -local host, free port)")
+	fs.BoolVar(&o.c.IntegrityCheck, "integrity-check", false, "hash every datagram between SGW and PGW when sent and check it when received, as is and parsed and marshaled again; a mismatch is logged with a byte diff and the run exits 5")
+}
+
+// legacyFlags are the mode switches from before commands existed.
+func (o *options) legacyFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&o.selftest, "selftest", false, "loopback mode: run an in-process PGW responder and point the initiator at it (-remote not needed)")
+	fs.StringVar(&o.selftestPGW, "selftest-pgw", "", "PGW bind ip:port for -selftest (default: -local host, free port)")
+	fs.BoolVar(&o.c.IntegrityCheck, "integrity-check", false, "with -selftest: hash every datagram between SGW and PGW when sent and check it when received, as is and parsed and marshaled again; a mismatch is logged with a byte diff and the run exits 5")
+	fs.BoolVar(&o.c.Respond, "respond", false, "responder mode: act as the PGW and accept incoming CSR/MBR/DSR (-remote optional)")
+	fs.StringVar(&o.scenario, "scenario", "", "run the steps in FILE (create/modify/delete/..., wait, assert-cause) instead of the default CSR")
+}
+
+// parseCommandLine parses the shared flags and the command after them, if
+// any, with its own flags. Without a command every flag is accepted at the
+// top level, so command lines from before commands existed keep working;
+// the returned command name is then empty.
+func parseCommandLine() (*options, string) {
+	o := &options{c: sim.DefaultConfig()}
+	// All flag sets are defined before anything is parsed: defining a flag
+	// resets its variable to the default.
+	shared := flag.NewFlagSet("", flag.ContinueOnError)
+	o.sharedFlags(shared)
+	for _, g := range []func(*options, *flag.FlagSet){(*options).sharedFlags, (*options).csrFlags,
+		(*options).procedureFlags, (*options).loadFlags, (*options).gateFlags, (*options).serveFlags, (*options).legacyFlags} {
+		g(o, flag.CommandLine)
+	}
+	prog := flag.CommandLine.Name()
+	sets := make(map[string]*flag.FlagSet, len(commands))
+	for _, cmd := range commands {
+		fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+		for _, g := range cmd.groups {
+			g(o, fs)
+		}
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "usage: %s [shared flags] %s [flags] %s\n\n%s.\n\nflags:\n", prog, cmd.name, cmd.args, cmd.summary)
+			fs.PrintDefaults()
+		}
+		sets[cmd.name] = fs
+	}
+	flag.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "usage: %s [shared flags] <command> [flags]\n\ncommands:\n", prog)
+		for _, cmd := range commands {
+			fmt.Fprintf(w, "  %-9s %s\n", cmd.name, cmd.summary)
+		}
+		fmt.Fprintf(w, "\nRun %s <command> -h for a command's flags. Without a command, all flags\n"+
+			"are accepted here as before (-respond, -selftest, -scenario FILE select the mode).\n\nshared flags:\n", prog)
+		shared.SetOutput(w)
+		shared.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		return o, ""
+	}
+
+	name := flag.Arg(0)
+	fs, ok := sets[name]
+	if !ok {
+		fmt.Fprintf(flag.CommandLine.Output(), "unknown command %q\n", name)
+		flag.Usage()
+		os.Exit(2)
+	}
+	fs.Parse(flag.Args()[1:])
+	args := 0
+	switch name {
+	case "serve":
+		o.c.Respond = true
+	case "selftest":
+		o.selftest = true
+	case "echo":
+		o.c.EchoEvery = 0 // the command sends its own
+	case "replay":
+		args = 1
+		o.scenario = fs.Arg(0)
+	}
+	if fs.NArg() != args {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return o, name
+}
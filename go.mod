@@ -2,4 +2,23 @@ module gtp-sim-initiator
 
 go 1.23.0
 
-require github.com/wmnsk/go-gtp v0.8.12
+require (
+	github.com/wmnsk/go-gtp v0.8.12
+	modernc.org/sqlite v1.34.5
+)
+
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	modernc.org/libc v1.55.3 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.8.0 // indirect
+)
+
+require (
+	golang.org/x/net v0.39.0
+	golang.org/x/sys v0.32.0 // indirect
+)
@@ -1,250 +1,508 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/binary"
-	"flag"
-	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"net/http/pprof"
+	"net/netip"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
-	gtp "github.com/wmnsk/go-gtp"
-	gtpv2 "github.com/wmnsk/go-gtp/gtpv2"
-	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
-	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+	"gtp-sim-initiator/sim"
 )
 
-type cfg struct {
-	local   string
-	remote  string
-	nodeIP  net.IP
-	imsi    string
-	msisdn  string
-	apn     string
-	pdnType string // ipv4|ipv6|ipv4v6
-	ratType uint8
-	ebi     uint8
-
-	echoEvery time.Duration
-	timeout   time.Duration
-}
-
 func main() {
-	var c cfg
-	var ratU, ebiU uint
-
-	nodeIP := flag.String("node-ip", "127.0.0.1", "SGW IP to put inside F-TEID (IPv4)")
-	flag.StringVar(&c.local, "local", "0.0.0.0:2123", "local bind ip:port")
-	flag.StringVar(&c.remote, "remote", "", "PGW ip:port (e.g. 172.16.10.170:2123)")
-	flag.StringVar(&c.imsi, "imsi", "001010123456789", "IMSI")
-	flag.StringVar(&c.msisdn, "msisdn", "919999999999", "MSISDN (optional)")
-	flag.StringVar(&c.apn, "apn", "internet", "APN")
-	flag.StringVar(&c.pdnType, "pdn", "ipv4", "pdn: ipv4|ipv6|ipv4v6")
-	flag.UintVar(&ratU, "rat", 6, "RAT-Type (e.g. 6=EUTRAN)")
-	flag.UintVar(&ebiU, "ebi", 5, "EPS Bearer ID (default bearer usually 5)")
-	flag.DurationVar(&c.echoEvery, "echo", 10*time.Second, "send Echo Request every duration")
-	flag.DurationVar(&c.timeout, "timeout", 5*time.Second, "wait timeout for CSRsp")
-	flag.Parse()
-
-	if c.remote == "" {
-		log.Fatalf("missing -remote")
-	}
-	if ratU > 255 || ebiU > 255 {
+	o, cmd := parseCommandLine()
+	c := o.c
+
+	if o.seed != 0 {
+		log.Printf("WARNING: -seed %d: TEIDs and sequence numbers are predictable (testing only)", o.seed)
+		sim.SetSeed(o.seed)
+	}
+	if c.Remote == "" && len(c.Peers) == 0 && !c.Respond && !o.selftest {
+		log.Fatalf("missing -remote (or -peer)")
+	}
+	if c.IntegrityCheck && !o.selftest {
+		log.Fatalf("-integrity-check needs selftest: it checks the datagrams between the in-process SGW and PGW")
+	}
+	if o.ratU > 255 || o.ebiU > 255 {
 		log.Fatalf("rat/ebi must be <=255")
 	}
-	c.ratType = uint8(ratU)
-	c.ebi = uint8(ebiU)
+	if o.traceRef != "" {
+		var err error
+		if c.TraceMCC, c.TraceMNC, c.TraceID, err = sim.ParseTraceRef(o.traceRef); err != nil {
+			log.Fatalf("invalid -trace-ref: %v", err)
+		}
+		if c.TraceDepth, err = sim.ParseTraceDepth(o.traceDepth); err != nil {
+			log.Fatalf("invalid -trace-depth: %v", err)
+		}
+		if c.TraceIP = net.ParseIP(o.traceIP); c.TraceIP == nil {
+			log.Fatalf("invalid -trace-ip %q (required with -trace-ref)", o.traceIP)
+		}
+	}
+	if o.cnRAT > 255 || o.cnTAC > 0xffff || o.cnECI > 0x0fffffff {
+		log.Fatalf("cn-rat must be <=255, cn-tac <=65535, cn-eci <=0x0fffffff")
+	}
+	c.CNRAT, c.CNTAC, c.CNECI = uint8(o.cnRAT), uint16(o.cnTAC), uint32(o.cnECI)
+	if o.logOnly != "" {
+		var err error
+		if c.LogOnly, err = sim.ParseMsgTypes(o.logOnly); err != nil {
+			log.Fatalf("invalid -log-only: %v", err)
+		}
+	}
+	if o.logExcept != "" {
+		var err error
+		if c.LogExcept, err = sim.ParseMsgTypes(o.logExcept); err != nil {
+			log.Fatalf("invalid -log-except: %v", err)
+		}
+	}
+	if o.bearers != "" {
+		var err error
+		if c.Bearers, err = sim.ParseBearers(o.bearers); err != nil {
+			log.Fatalf("invalid -bearers: %v", err)
+		}
+	}
+	if o.requireIEs != "" {
+		var err error
+		if c.RequireRespIEs, err = sim.ParseRequiredIEs(o.requireIEs); err != nil {
+			log.Fatalf("invalid -require-resp-ie: %v", err)
+		}
+	}
+	if o.bearerFlags != "" {
+		var err error
+		if c.BearerFlags, err = sim.ParseBearerFlags(o.bearerFlags); err != nil {
+			log.Fatalf("invalid -bearer-flags: %v", err)
+		}
+	}
+	if o.csgMode != "" {
+		var err error
+		if c.CSGMode, err = sim.ParseCSGMode(o.csgMode); err != nil {
+			log.Fatalf("invalid -csg-mode: %v", err)
+		}
+	}
+	for _, apn := range strings.Split(o.apns, ",") {
+		if apn = strings.TrimSpace(apn); apn != "" {
+			c.APNs = append(c.APNs, apn)
+		}
+	}
+	if o.subscribers != "" {
+		var err error
+		if c.Subscribers, err = sim.LoadSubscribers(o.subscribers); err != nil {
+			log.Fatalf("subscribers: %v", err)
+		}
+	}
+	if o.csids != "" {
+		var err error
+		if c.CSIDs, err = sim.ParseCSIDs(o.csids); err != nil {
+			log.Fatalf("invalid -csid: %v", err)
+		}
+	}
+	if o.brc {
+		if o.brcQCI > 255 {
+			log.Fatalf("brc-qci must be <=255")
+		}
+		c.BRCQoS.QCI = uint8(o.brcQCI)
+		var err error
+		if c.BRCTAD, err = sim.ParseTAD(o.brcTAD); err != nil {
+			log.Fatalf("invalid -brc-tad: %v", err)
+		}
+	}
+	if o.ddnFailure > 255 {
+		log.Fatalf("ddn-failure must be <=255")
+	}
+	c.DDNFailure = uint8(o.ddnFailure)
+	c.Failures.SLA = time.Duration(o.slaMS) * time.Millisecond
+	if o.respCause > 255 {
+		log.Fatalf("resp-cause must be <=255")
+	}
+	c.RespCause = uint8(o.respCause)
+	if o.teidSource != "assigned" {
+		var err error
+		if c.TEIDFixed, c.FixedTEID, err = sim.ParseTEIDSource(o.teidSource); err != nil {
+			log.Fatalf("invalid -teid-source: %v", err)
+		}
+		log.Printf("teid-source: follow-up requests use header TEID 0x%08x instead of the PGW's", c.FixedTEID)
+	}
+	if o.omit != "" {
+		var err error
+		if c.Omit, err = sim.ParseOmit(o.omit); err != nil {
+			log.Fatalf("invalid -omit: %v", err)
+		}
+	}
+	c.RATType = uint8(o.ratU)
+	c.EBI = uint8(o.ebiU)
+	for _, kv := range o.sets {
+		if err := c.Set(kv); err != nil {
+			log.Fatalf("invalid -set: %v", err)
+		}
+	}
 
-	c.nodeIP = net.ParseIP(*nodeIP).To4()
-	if c.nodeIP == nil {
-		log.Fatalf("invalid -node-ip %q (must be IPv4)", *nodeIP)
+	c.NodeIP = net.ParseIP(o.nodeIP).To4()
+	if c.NodeIP == nil {
+		log.Fatalf("invalid -node-ip %q (must be IPv4)", o.nodeIP)
+	}
+	if o.nodeIP6 != "" {
+		a, err := netip.ParseAddr(o.nodeIP6)
+		if err != nil || !a.Is6() || a.Is4In6() {
+			log.Fatalf("invalid -node-ip6 %q (must be IPv6)", o.nodeIP6)
+		}
+		if z := a.Zone(); z != "" {
+			log.Printf("node-ip6: F-TEIDs carry %s without its zone %%%s", a.WithZone(""), z)
+		}
+		c.NodeIP6 = net.IP(a.WithZone("").AsSlice())
 	}
 
-	laddr, err := net.ResolveUDPAddr("udp", c.local)
-	if err != nil {
-		log.Fatalf("resolve local: %v", err)
+	if o.dscpMap != "" {
+		var err error
+		if c.DSCPMap, err = sim.ParseDSCPMap(o.dscpMap); err != nil {
+			log.Fatalf("invalid -dscp-map: %v", err)
+		}
+	}
+	if o.burst < 0 {
+		log.Fatalf("burst must be >= 0")
+	}
+	if o.burst > 0 {
+		c.Sessions, c.Burst = o.burst, true
 	}
-	raddr, err := net.ResolveUDPAddr("udp", c.remote)
-	if err != nil {
-		log.Fatalf("resolve remote: %v", err)
+	if o.teidField != "" {
+		var err error
+		if c.TEIDField, err = sim.ParseTEIDField(o.teidField); err != nil {
+			log.Fatalf("invalid -teid-field: %v", err)
+		}
+	}
+	if o.recovery > 255 {
+		log.Fatalf("recovery must be <=255")
+	}
+	c.Recovery = uint8(o.recovery)
+	if o.nodeFeats != "" {
+		v, err := sim.ParseNodeFeatures(o.nodeFeats)
+		if err != nil {
+			log.Fatalf("invalid -node-features: %v", err)
+		}
+		c.NodeFeatures = int(v)
+	}
+
+	if o.spoofSrc != "" {
+		if c.SpoofSrc = net.ParseIP(o.spoofSrc).To4(); c.SpoofSrc == nil {
+			log.Fatalf("invalid -spoof-src %q (must be IPv4)", o.spoofSrc)
+		}
 	}
 
-	udpConn, err := net.ListenUDP("udp", laddr)
-	if err != nil {
-		log.Fatalf("listen udp: %v", err)
+	var sc *sim.Scenario
+	if o.scenario != "" {
+		var err error
+		if sc, err = sim.LoadScenario(o.scenario); err != nil {
+			log.Fatalf("scenario: %v", err)
+		}
 	}
-	defer udpConn.Close()
 
-	log.Printf("S5/S8 SGW initiator up: local=%s remote=%s node-ip=%s", udpConn.LocalAddr(), raddr, c.nodeIP)
+	if o.pprofAddr != "" {
+		// Its own listener and mux, so the profiles never show up on -http.
+		ln, err := net.Listen("tcp", o.pprofAddr)
+		if err != nil {
+			log.Fatalf("pprof: %v", err)
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		log.Printf("pprof on http://%s/debug/pprof/", ln.Addr())
+		go func() { log.Printf("pprof: %v", http.Serve(ln, mux)) }()
+	}
 
-	// Channel to deliver CSRsp back to sender (match by seq).
-	csRspCh := make(chan *gtpv2msg.CreateSessionResponse, 8)
+	var (
+		cl, pgw *sim.Client
+		err     error
+	)
+	if o.selftest {
+		if cl, pgw, err = sim.NewSelfTest(c, o.selftestPGW); err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer pgw.Close()
+	} else if cl, err = sim.NewClient(c); err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer cl.Close()
+
+	// os.Exit skips the deferred Closes, and with them the flush of the
+	// output files and the -db WAL; exit closes the clients first.
+	exit := func(code int) {
+		cl.Close()
+		if pgw != nil {
+			pgw.Close()
+		}
+		os.Exit(code)
+	}
 
-	// RX loop: respond EchoReq, forward CSRsp to channel, log others.
-	go rxLoop(udpConn, csRspCh)
+	// A selftest's -integrity-check mismatch overrides the run's own exit
+	// code: the run's results can't be trusted.
+	integrity := func(err error) error {
+		if ierr := cl.CheckIntegrity(); ierr != nil {
+			log.Printf("%v", ierr)
+			return ierr
+		}
+		return err
+	}
 
-	// Periodic Echo Requests
-	go func() {
-		t := time.NewTicker(c.echoEvery)
-		defer t.Stop()
-		for range t.C {
-			seq := uint32(time.Now().UnixNano() & 0x00ffffff)
+	if o.httpAddr != "" {
+		ln, err := net.Listen("tcp", o.httpAddr)
+		if err != nil {
+			log.Fatalf("http: %v", err)
+		}
+		log.Printf("status server on http://%s (/healthz, /status, /metrics)", ln.Addr())
+		go func() { log.Printf("http: %v", http.Serve(ln, cl.StatusHandler())) }()
+	}
 
-			req := gtpv2msg.NewEchoRequest(0, gtpv2ie.NewRecovery(1))
-			req.SetSequenceNumber(seq)
+	if c.Respond && (c.Remote == "" || cmd == "serve") {
+		// Nothing to initiate towards; just answer until interrupted.
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+		<-sigCh
+		cl.Report()
+		return
+	}
 
-			b, err := gtp.Marshal(req)
-			if err != nil {
-				log.Printf("echo req marshal err: %v", err)
-				continue
+	if cmd == "echo" {
+		var err error
+		for i := 0; i < o.echoCount; i++ {
+			if i > 0 {
+				time.Sleep(o.echoInterval)
+			}
+			if _, e := cl.Echo(); e != nil {
+				log.Printf("Echo failed: %v", e)
+				err = e
 			}
-			_, _ = udpConn.WriteToUDP(b, raddr)
-			log.Printf("tx EchoReq seq=%d -> %s", seq, raddr.String())
 		}
-	}()
-
-	// Trigger Create Session
-	if err := sendCreateSession(udpConn, raddr, c, csRspCh); err != nil {
-		log.Fatalf("CreateSession failed: %v", err)
+		cl.Report()
+		exit(sim.ExitCode(err))
 	}
 
-	select {} // keep alive
-}
+	if o.identify != "" {
+		guti, err := sim.ParseGUTI(o.identify)
+		if err != nil {
+			log.Fatalf("invalid -identify: %v", err)
+		}
+		_, err = cl.Identify(guti)
+		if err != nil {
+			log.Printf("Identification failed: %v", err)
+		}
+		cl.Report()
+		exit(sim.ExitCode(err))
+	}
 
-func rxLoop(udpConn *net.UDPConn, csRspCh chan<- *gtpv2msg.CreateSessionResponse) {
-	buf := make([]byte, 8192)
-	for {
-		n, peer, err := udpConn.ReadFromUDP(buf)
+	if o.unknownMsg >= 0 {
+		if o.unknownMsg > 255 {
+			log.Fatalf("unknown-msg-type must be <=255")
+		}
+		err := cl.UnknownMessage(uint8(o.unknownMsg))
 		if err != nil {
-			log.Printf("rx err: %v", err)
-			continue
+			log.Printf("unknown-msg-type: %v", err)
 		}
-		pkt := make([]byte, n)
-		copy(pkt, buf[:n])
+		cl.Report()
+		exit(sim.ExitCode(err))
+	}
 
-		// Parse any GTP message
-		m, err := gtp.Parse(pkt)
+	if o.contextReq != "" {
+		guti, err := sim.ParseGUTI(o.contextReq)
+		if err != nil {
+			log.Fatalf("invalid -context-req: %v", err)
+		}
+		var tau []byte
+		if o.contextTAU != "" {
+			if tau, err = sim.ParseTAURequest(o.contextTAU); err != nil {
+				log.Fatalf("invalid -context-tau: %v", err)
+			}
+		}
+		_, err = cl.RequestContext(guti, tau)
 		if err != nil {
-			continue
+			log.Printf("ContextRequest failed: %v", err)
 		}
+		cl.Report()
+		exit(sim.ExitCode(err))
+	}
 
-		v2m, ok := m.(gtpv2msg.Message)
-		if !ok {
-			continue
+	if o.waitPath > 0 {
+		if err := cl.WaitPath(o.waitPath); err != nil {
+			log.Printf("%v", err)
+			cl.Report()
+			exit(sim.ExitCode(err))
 		}
+	}
 
-		switch v2m.MessageType() {
-		case gtpv2msg.MsgTypeEchoRequest:
-			er := v2m.(*gtpv2msg.EchoRequest)
-			resp := gtpv2msg.NewEchoResponse(0, gtpv2ie.NewRecovery(1))
-			resp.SetSequenceNumber(er.Sequence())
-			b, err := gtp.Marshal(resp)
-			if err == nil {
-				_, _ = udpConn.WriteToUDP(b, peer)
-			}
-			log.Printf("rx EchoReq from %s -> EchoResp (seq=%d)", peer.String(), er.Sequence())
+	if o.compare != "" {
+		res, err := cl.Compare(o.compare)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		for _, line := range strings.Split(res.Summary(), "\n") {
+			log.Printf("compare: %s", line)
+		}
+		cl.Report()
+		if res.Behavioral() {
+			exit(1)
+		}
+		return
+	}
 
-		case gtpv2msg.MsgTypeEchoResponse:
-			log.Printf("rx EchoResp from %s seq=%d", peer.String(), v2m.Sequence())
+	if o.scanStart != "" {
+		res, err := cl.ScanIMSIs(o.scanStart, o.scanCount, o.scanRate)
+		if err != nil {
+			log.Fatalf("imsi scan: %v", err)
+		}
+		for _, line := range strings.Split(res.Summary(), "\n") {
+			log.Printf("scan result: %s", line)
+		}
+		cl.Report()
+		return
+	}
 
-		case gtpv2msg.MsgTypeCreateSessionResponse:
-			resp := v2m.(*gtpv2msg.CreateSessionResponse)
-			select {
-			case csRspCh <- resp:
-			default:
-			}
-			log.Printf("rx CSRsp from %s teid=0x%08x seq=%d", peer.String(), resp.TEID(), resp.Sequence())
+	if o.findMax {
+		res, err := cl.FindMaxRate(o.fm)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		for _, line := range strings.Split(res.Summary(), "\n") {
+			log.Printf("find-max result: %s", line)
+		}
+		cl.Report()
+		if res.Max == 0 {
+			exit(1)
+		}
+		return
+	}
 
-		default:
-			log.Printf("rx msgType=%d from %s teid=0x%08x seq=%d", v2m.MessageType(), peer.String(), v2m.TEID(), v2m.Sequence())
+	if sc != nil {
+		err := cl.RunScenario(sc)
+		if err != nil {
+			log.Printf("scenario failed: %v", err)
+		} else {
+			log.Printf("scenario %s passed (%d steps)", sc.Name, len(sc.Steps))
 		}
+		cl.Report()
+		exit(sim.ExitCode(integrity(err)))
 	}
-}
 
-func sendCreateSession(udpConn *net.UDPConn, raddr *net.UDPAddr, c cfg, csRspCh <-chan *gtpv2msg.CreateSessionResponse) error {
-	seq := uint32(time.Now().UnixNano() & 0x00ffffff)
+	// -integrity-check, -max-failures/-max-failure-rate and -sla-ms take
+	// precedence over the run's own exit code.
+	gate := func(err error) error {
+		if ierr := integrity(nil); ierr != nil {
+			return ierr
+		}
+		if ferr := cl.CheckFailures(); ferr != nil {
+			log.Printf("%v", ferr)
+			return ferr
+		}
+		if serr := cl.CheckSLA(); serr != nil {
+			log.Printf("%v", serr)
+			return serr
+		}
+		return err
+	}
 
-	// Sender F-TEID for CP (S5/S8 SGW GTP-C)
-	localCTeid := randUint32()
-	senderFTEID := gtpv2ie.NewFullyQualifiedTEID(
-		gtpv2.IFTypeS5S8SGWGTPC,
-		localCTeid,
-		c.nodeIP.String(), // v4
-		"",                // v6
-	)
-	senderFTEID.SetInstance(0)
-
-	// PDN Type
-	var pdnVal uint8
-	switch strings.ToLower(c.pdnType) {
-	case "ipv6":
-		pdnVal = 2
-	case "ipv4v6":
-		pdnVal = 3
-	default:
-		pdnVal = 1
-	}
-
-	// Bearer Context (to be created) — instance 0
-	bearerQoS := gtpv2ie.NewBearerQoS(0, 9, 0, 9, 0, 0, 0, 0)
-	bearerCtx := gtpv2ie.NewBearerContext(
-		gtpv2ie.NewEPSBearerID(c.ebi),
-		bearerQoS,
-	)
-	bearerCtx.SetInstance(0)
+	// Trigger Create Session; the follow-ups also take the sessions restored
+	// from -state-file.
+	sessions, err := cl.CreateSessions()
+	sessions = append(cl.RestoredSessions(), sessions...)
+	if len(sessions) == 0 && err == nil {
+		log.Printf("no sessions: none created (-sessions 0) and none restored from -state-file")
+		cl.Report()
+		exit(sim.ExitCode(gate(nil)))
+	}
+	if len(sessions) == 0 {
+		log.Printf("CreateSession failed: %v", err)
+		cl.Report()
+		exit(sim.ExitCode(gate(err)))
+	}
 
-	ies := []*gtpv2ie.IE{
-		gtpv2ie.NewIMSI(c.imsi),
-		gtpv2ie.NewAccessPointName(c.apn),
-		gtpv2ie.NewRATType(c.ratType),
-		gtpv2ie.NewPDNType(pdnVal),
-		senderFTEID,
-		bearerCtx,
+	// Follow-up procedures don't abort the run, but the last failure sets
+	// the exit code.
+	runErr := err
+	if o.changeNotify {
+		for _, sess := range sessions {
+			if err := cl.ChangeNotification(sess); err != nil {
+				log.Printf("ChangeNotification failed: %v", err)
+				runErr = err
+			}
+		}
 	}
-	if c.msisdn != "" {
-		ies = append(ies, gtpv2ie.NewMSISDN(c.msisdn))
+	if o.suspend {
+		for _, sess := range sessions {
+			if err := cl.SuspendNotification(sess); err != nil {
+				log.Printf("SuspendNotification failed: %v", err)
+				runErr = err
+			}
+		}
+	}
+	if o.resume {
+		for _, sess := range sessions {
+			if err := cl.ResumeNotification(sess); err != nil {
+				log.Printf("ResumeNotification failed: %v", err)
+				runErr = err
+			}
+		}
 	}
 
-	// Your version requires (teid, seq, ies...)
-	req := gtpv2msg.NewCreateSessionRequest(0, seq, ies...)
+	if o.brc {
+		for _, sess := range sessions {
+			if _, err := cl.BearerResourceCommand(sess); err != nil {
+				log.Printf("BearerResourceCommand failed: %v", err)
+				runErr = err
+			}
+		}
+	}
 
-	b, err := gtp.Marshal(req)
-	if err != nil {
-		return fmt.Errorf("marshal csr: %w", err)
+	if o.ddn {
+		for _, sess := range sessions {
+			if err := cl.DownlinkDataNotification(sess); err != nil {
+				log.Printf("DDN failed: %v", err)
+				runErr = err
+			}
+		}
 	}
 
-	if _, err := udpConn.WriteToUDP(b, raddr); err != nil {
-		return fmt.Errorf("send csr: %w", err)
+	if o.mabr {
+		for _, sess := range sessions {
+			if err := cl.ModifyAccessBearers(sess); err != nil {
+				log.Printf("ModifyAccessBearers failed: %v", err)
+				runErr = err
+			}
+		}
 	}
-	log.Printf("tx CSR seq=%d localCTeid=0x%08x -> %s", seq, localCTeid, raddr.String())
 
-	// Wait for matching CSRsp
-	deadline := time.NewTimer(c.timeout)
-	defer deadline.Stop()
+	if c.TUN != "" {
+		if len(sessions) > 1 {
+			log.Printf("tun: only the first session (imsi=%s) gets the TUN data path", sessions[0].IMSI)
+		}
+		if err := cl.StartTUN(sessions[0]); err != nil {
+			log.Printf("%v", err)
+			runErr = err
+		}
+	}
 
-	for {
-		select {
-		case resp := <-csRspCh:
-			if resp.Sequence() != seq {
-				// ignore unrelated responses
-				continue
-			}
-			log.Printf("CSR succeeded seq=%d (resp teid=0x%08x). Next: DeleteSession / ModifyBearer.", seq, resp.TEID())
-			return nil
-		case <-deadline.C:
-			return fmt.Errorf("timeout waiting CSRsp (seq=%d)", seq)
+	if o.deleteCSID {
+		removed, total, err := cl.DeletePDNConnectionSet()
+		if err != nil {
+			log.Printf("DeletePDNConnectionSet failed: %v", err)
+			runErr = err
+		} else if removed < total {
+			log.Printf("DeletePDNConnectionSet: %d session(s) survived", total-removed)
 		}
 	}
-}
 
-func randUint32() uint32 {
-	var b [4]byte
-	_, _ = rand.Read(b[:])
-	v := binary.BigEndian.Uint32(b[:])
-	if v == 0 {
-		return 1
+	if o.console {
+		go cl.Console(os.Stdin)
 	}
-	return v
+
+	// Keep alive until interrupted, then print the run report.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	<-sigCh
+	cl.Report()
+	exit(sim.ExitCode(gate(runErr)))
 }
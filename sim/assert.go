@@ -0,0 +1,250 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	gtp "github.com/wmnsk/go-gtp"
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// An Assertion checks one IE of a response, e.g.
+//
+//	Cause == 16
+//	PDNAddressAllocation != 0.0.0.0
+//	BearerContext[ebi=5].Cause == 16
+//	FullyQualifiedTEID[1].teid != 0
+//	APNRestriction absent
+//
+// The selector walks IE names as in -decode-json (case-insensitive), a
+// "[n]" picking the IE with instance n and "[ebi=n]" the grouped IE whose
+// EPSBearerID is n; a last segment that is not an IE names a field of the
+// decoded value (F-TEID teid, ipv4, ...). Values compare as decoded, see
+// ieValue; IEs without a decoder compare as hex.
+type Assertion struct {
+	Selector string
+	Op       string // == != present absent
+	Want     string
+	path     []selector
+}
+
+type selector struct {
+	name string
+	ins  int // -1: any
+	ebi  int // -1: any
+}
+
+func (a *Assertion) String() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s", a.Selector, a.Op, a.Want))
+}
+
+// ParseAssertion parses the words of an assertion: selector, operator and,
+// for == and !=, the value.
+func ParseAssertion(args []string) (*Assertion, error) {
+	if len(args) < 2 {
+		return nil, errors.New("assert needs a selector and an operator")
+	}
+	a := &Assertion{Selector: args[0], Op: args[1]}
+	switch a.Op {
+	case "==", "!=":
+		if len(args) != 3 {
+			return nil, fmt.Errorf("assert %s %s needs one value", a.Selector, a.Op)
+		}
+		a.Want = args[2]
+	case "present", "absent":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("assert %s %s takes no value", a.Selector, a.Op)
+		}
+	default:
+		return nil, fmt.Errorf("assert operator %q: want == != present absent", a.Op)
+	}
+	for _, seg := range strings.Split(a.Selector, ".") {
+		s := selector{name: seg, ins: -1, ebi: -1}
+		if name, filter, ok := strings.Cut(seg, "["); ok {
+			filter, ok = strings.CutSuffix(filter, "]")
+			if !ok {
+				return nil, fmt.Errorf("assert selector %q: unclosed [", a.Selector)
+			}
+			s.name = name
+			v, isEBI := strings.CutPrefix(filter, "ebi=")
+			n, err := strconv.ParseUint(v, 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("assert selector %q: bad filter [%s]", a.Selector, filter)
+			}
+			if isEBI {
+				s.ebi = int(n)
+			} else {
+				s.ins = int(n)
+			}
+		}
+		if s.name == "" {
+			return nil, fmt.Errorf("assert selector %q: empty segment", a.Selector)
+		}
+		a.path = append(a.path, s)
+	}
+	return a, nil
+}
+
+// Check evaluates a against the IEs of m. The error says what was found.
+func (a *Assertion) Check(m gtpv2msg.Message) error {
+	ies, err := messageIEs(m)
+	if err != nil {
+		return err
+	}
+	got, found := lookup(ies, a.path)
+	name := msgName(m.MessageType())
+	switch {
+	case a.Op == "absent" && found:
+		return fmt.Errorf("failed: present in %s (%s)", name, got)
+	case a.Op != "absent" && !found:
+		return fmt.Errorf("failed: not present in %s", name)
+	case a.Op == "==" && got != a.Want, a.Op == "!=" && got == a.Want:
+		return fmt.Errorf("failed: got %s in %s", got, name)
+	}
+	return nil
+}
+
+// ParseRequiredIEs parses a -require-resp-ie list: comma-separated
+// assertion selectors (e.g. PAA, PCO, BearerContext.ChargingID) that must
+// be present. Segments may use the -log-ies short names.
+func ParseRequiredIEs(s string) ([]*Assertion, error) {
+	var out []*Assertion
+	for _, sel := range strings.Split(s, ",") {
+		sel = strings.TrimSpace(sel)
+		if sel == "" {
+			continue
+		}
+		segs := strings.Split(sel, ".")
+		for k, seg := range segs {
+			name, filter, _ := strings.Cut(seg, "[")
+			for t, short := range ieShortNames {
+				if strings.EqualFold(name, short) {
+					segs[k] = strings.TrimSuffix(gtpv2ie.New(t, 0, nil).Name()+"["+filter, "[")
+				}
+			}
+		}
+		a, err := ParseAssertion([]string{strings.Join(segs, "."), "present"})
+		if err != nil {
+			return nil, err
+		}
+		a.Selector = sel
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+// checkRequiredIEs fails an accepted response m to req that lacks any of
+// the IEs in required, as a parse failure naming the missing ones.
+func (c *Client) checkRequiredIEs(required []*Assertion, req, m gtpv2msg.Message) error {
+	var missing []string
+	for _, a := range required {
+		if a.Check(m) != nil {
+			missing = append(missing, a.Selector)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	c.tr.st.countErr(TxnParse)
+	te := &TxnError{Kind: TxnParse, MsgType: req.MessageType(), Seq: req.Sequence(), Peer: c.tr.remote(),
+		Err: fmt.Errorf("%s missing required IEs: %s", msgName(m.MessageType()), strings.Join(missing, ", "))}
+	c.health.failed(te)
+	return te
+}
+
+// messageIEs decodes the IEs of m the same way -decode-json does.
+func messageIEs(m gtpv2msg.Message) ([]ieJSON, error) {
+	b, err := gtp.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	h, err := gtpv2msg.ParseHeader(b)
+	if err != nil {
+		return nil, err
+	}
+	ies, err := gtpv2ie.ParseMultiIEs(h.Payload)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ieJSON, 0, len(ies))
+	for _, i := range ies {
+		out = append(out, ieToJSON(i))
+	}
+	return out, nil
+}
+
+func lookup(ies []ieJSON, path []selector) (string, bool) {
+	s := path[0]
+	for _, ie := range ies {
+		if !strings.EqualFold(ie.Name, s.name) || (s.ins >= 0 && int(ie.Instance) != s.ins) {
+			continue
+		}
+		if s.ebi >= 0 && !hasEBI(ie, s.ebi) {
+			continue
+		}
+		if len(path) == 1 {
+			return ieText(ie), true
+		}
+		if ie.IEs != nil {
+			if v, ok := lookup(ie.IEs, path[1:]); ok {
+				return v, true
+			}
+			continue
+		}
+		// A field of a decoded value, e.g. F-TEID teid.
+		if f, ok := ie.Value.(map[string]any); ok && len(path) == 2 {
+			if v, ok := f[strings.ToLower(path[1].name)]; ok {
+				return fmt.Sprint(v), true
+			}
+		}
+	}
+	return "", false
+}
+
+func hasEBI(ie ieJSON, ebi int) bool {
+	for _, c := range ie.IEs {
+		if c.Type == gtpv2ie.EPSBearerID && fmt.Sprint(c.Value) == strconv.Itoa(ebi) {
+			return true
+		}
+	}
+	return false
+}
+
+func ieText(ie ieJSON) string {
+	if ie.Value != nil {
+		return fmt.Sprint(ie.Value)
+	}
+	return ie.Raw
+}
+
+// lastResponses keeps the latest response of each message type, for
+// scenario assertions.
+type lastResponses struct {
+	mu sync.Mutex
+	m  map[uint8]gtpv2msg.Message
+}
+
+func (l *lastResponses) store(m gtpv2msg.Message) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.m == nil {
+		l.m = make(map[uint8]gtpv2msg.Message)
+	}
+	l.m[m.MessageType()] = m
+}
+
+func (l *lastResponses) forget(t uint8) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.m, t)
+}
+
+func (l *lastResponses) get(t uint8) gtpv2msg.Message {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.m[t]
+}
@@ -0,0 +1,80 @@
+package sim
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	gtpv2 "github.com/wmnsk/go-gtp/gtpv2"
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+// maxBackoff caps the pause for an infinite (or absurd) PGW Back-off Time,
+// so a load run stalls visibly instead of forever.
+const maxBackoff = time.Hour
+
+// backoffs holds, per APN, until when the PGW asked us not to send
+// CreateSessionRequests (its Back-off Time with cause "APN congestion" or
+// "no resources available", TS 29.274 8.87 / TS 23.401 4.3.7.4.2.2).
+type backoffs struct {
+	mu sync.Mutex
+	m  map[string]time.Time
+}
+
+// congestion records the back-off of a rejected CSRsp for apn. Only the
+// overload causes count; a missing Back-off Time IE leaves nothing to honour.
+func (b *backoffs) congestion(apn string, cause uint8, timer *gtpv2ie.IE) {
+	if timer == nil || cause != gtpv2.CauseAPNCongestion && cause != gtpv2.CauseNoResourcesAvailable {
+		return
+	}
+	d, err := timer.EPCTimer()
+	if err != nil {
+		log.Printf("CSRsp PGW Back-off Time: %v", err)
+		return
+	}
+	if d == 0 {
+		return // timer stopped
+	}
+	if d == math.MaxInt64 || d > maxBackoff {
+		log.Printf("CSRsp PGW Back-off Time for apn=%s: %s capped to %s", apn, backoffString(d), maxBackoff)
+		d = maxBackoff
+	}
+	until := time.Now().Add(d)
+	b.mu.Lock()
+	if b.m == nil {
+		b.m = make(map[string]time.Time)
+	}
+	if until.After(b.m[apn]) {
+		b.m[apn] = until
+	}
+	b.mu.Unlock()
+	log.Printf("CSRsp cause %d with PGW Back-off Time %s: holding CSRs for apn=%s until %s", cause, d, apn, until.Format("15:04:05"))
+}
+
+// until returns when sending for apn may resume; the zero time if it may now.
+func (b *backoffs) until(apn string) time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.m[apn]
+	if !t.After(time.Now()) {
+		delete(b.m, apn)
+		return time.Time{}
+	}
+	return t
+}
+
+// wait blocks while apn is backed off.
+func (b *backoffs) wait(apn string) {
+	if t := b.until(apn); !t.IsZero() {
+		log.Printf("apn=%s backed off by the PGW: waiting %s", apn, time.Until(t).Round(time.Millisecond))
+		time.Sleep(time.Until(t))
+	}
+}
+
+func backoffString(d time.Duration) string {
+	if d == math.MaxInt64 {
+		return "infinite"
+	}
+	return d.String()
+}
@@ -0,0 +1,113 @@
+package sim
+
+import (
+	"log"
+	"net"
+	"sync/atomic"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// batchWriter is the WriteBatch of an ipv4 or ipv6 PacketConn; on Linux it
+// is one sendmmsg per call.
+type batchWriter interface {
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+type sendReq struct {
+	b    []byte
+	peer *net.UDPAddr // nil on a connected socket
+	done chan error
+}
+
+// batcher coalesces datagrams queued by concurrent senders and writes them
+// with as few syscalls as possible. Each sender still gets its own result.
+type batcher struct {
+	t    *transport
+	conn *net.UDPConn // the socket w wraps; rewrapped if it was rebound
+	w    batchWriter
+	v6   bool
+	q    chan sendReq
+	max  int
+
+	syscalls, pkts atomic.Uint64
+}
+
+func newBatcher(t *transport, max int, v6 bool) *batcher {
+	bt := &batcher{t: t, v6: v6, q: make(chan sendReq, max), max: max}
+	bt.wrap(t.conn)
+	go bt.loop()
+	return bt
+}
+
+func (bt *batcher) wrap(conn *net.UDPConn) {
+	bt.conn = conn
+	if bt.v6 {
+		bt.w = ipv6.NewPacketConn(conn)
+	} else {
+		bt.w = ipv4.NewPacketConn(conn)
+	}
+}
+
+// send queues b for peer and waits for it to be written.
+func (bt *batcher) send(b []byte, peer *net.UDPAddr) error {
+	if bt.t.connected {
+		peer = nil
+	}
+	r := sendReq{b: b, peer: peer, done: make(chan error, 1)}
+	bt.q <- r
+	return <-r.done
+}
+
+func (bt *batcher) loop() {
+	batch := make([]sendReq, 0, bt.max)
+	msgs := make([]ipv4.Message, bt.max)
+	for first := range bt.q {
+		batch = append(batch[:0], first)
+	fill:
+		for len(batch) < bt.max {
+			select {
+			case r := <-bt.q:
+				batch = append(batch, r)
+			default:
+				break fill
+			}
+		}
+		for k, r := range batch {
+			msgs[k] = ipv4.Message{Buffers: [][]byte{r.b}, OOB: bt.t.typeTOS(r.b)}
+			if r.peer != nil {
+				msgs[k].Addr = r.peer
+			}
+		}
+
+		if conn := bt.t.srcConn(0); conn != bt.conn {
+			bt.wrap(conn)
+		}
+		for off := 0; off < len(batch); {
+			n, err := bt.w.WriteBatch(msgs[off:len(batch)], 0)
+			bt.syscalls.Add(1)
+			bt.pkts.Add(uint64(n))
+			for _, r := range batch[off : off+n] {
+				r.done <- nil
+			}
+			off += n
+			if err != nil {
+				// Let the rest take the single-datagram path, with its
+				// retries on transient errors.
+				for _, r := range batch[off:] {
+					r.done <- bt.t.write(bt.conn, r.b, r.peer)
+				}
+				break
+			}
+		}
+	}
+}
+
+func (bt *batcher) report() {
+	calls, pkts := bt.syscalls.Load(), bt.pkts.Load()
+	if calls == 0 {
+		return
+	}
+	log.Printf("run report: batched send: %d pkts in %d sendmmsg calls (%.2f pkts/call)", pkts, calls, float64(pkts)/float64(calls))
+}
@@ -0,0 +1,336 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	gtpv2 "github.com/wmnsk/go-gtp/gtpv2"
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// FlowQoS is the QoS the UE asks for in a Bearer Resource Command. Bit
+// rates are in kbps.
+type FlowQoS struct {
+	QCI          uint8
+	MBRUL, MBRDL uint64
+	GBRUL, GBRDL uint64
+}
+
+// maxBitRate is the largest bit rate the 40-bit Flow QoS fields hold.
+const maxBitRate = 1<<40 - 1
+
+// gbrQCI reports whether qci is a standardized GBR QCI (TS 23.203 6.1.7).
+func gbrQCI(qci uint8) bool {
+	switch {
+	case qci >= 1 && qci <= 4, qci >= 65 && qci <= 67, qci >= 71 && qci <= 76, qci >= 82 && qci <= 85:
+		return true
+	}
+	return false
+}
+
+// check validates q: a QCI is required, GBR only goes with a GBR QCI and
+// may not exceed the MBR.
+func (q FlowQoS) check() error {
+	if q.QCI == 0 {
+		return errors.New("flow qos: qci must be set")
+	}
+	for _, v := range []uint64{q.MBRUL, q.MBRDL, q.GBRUL, q.GBRDL} {
+		if v > maxBitRate {
+			return fmt.Errorf("flow qos: bit rate %d kbps exceeds 40 bits", v)
+		}
+	}
+	if !gbrQCI(q.QCI) {
+		if q.GBRUL != 0 || q.GBRDL != 0 {
+			return fmt.Errorf("flow qos: qci %d is non-GBR, guaranteed bit rates must be 0", q.QCI)
+		}
+		return nil
+	}
+	if q.GBRUL > q.MBRUL || q.GBRDL > q.MBRDL {
+		return fmt.Errorf("flow qos: gbr %d/%d kbps exceeds mbr %d/%d kbps (ul/dl)", q.GBRUL, q.GBRDL, q.MBRUL, q.MBRDL)
+	}
+	return nil
+}
+
+func (q FlowQoS) String() string {
+	return fmt.Sprintf("qci=%d mbr=%d/%d gbr=%d/%d", q.QCI, q.MBRUL, q.MBRDL, q.GBRUL, q.GBRDL)
+}
+
+// ParseTAD builds a Traffic Aggregate Description (create new TFT) from
+// packet filters separated by ';', each a comma-separated list of
+// key=value: dir=bi|ul|dl (default bi), proto=N, raddr=CIDR, rport=N or
+// LO-HI, lport=N or LO-HI, prec=N (default: the filter's position). Example:
+// "proto=17,rport=5060;proto=6,raddr=10.0.0.0/8".
+func ParseTAD(s string) (*gtpv2ie.IE, error) {
+	var filters []*gtpv2ie.TFTPacketFilter
+	for n, spec := range strings.Split(s, ";") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		id := uint8(len(filters) + 1)
+		if id > 15 {
+			return nil, errors.New("tad: at most 15 packet filters")
+		}
+		f, err := parseTADFilter(spec, id)
+		if err != nil {
+			return nil, fmt.Errorf("tad filter %d: %w", n+1, err)
+		}
+		filters = append(filters, f)
+	}
+	if len(filters) == 0 {
+		return nil, errors.New("tad: no packet filters")
+	}
+	i := gtpv2ie.NewTrafficAggregateDescriptionCreateNewTFT(filters, nil)
+	if i == nil {
+		return nil, errors.New("tad: encoding failed")
+	}
+	return i, nil
+}
+
+func parseTADFilter(spec string, id uint8) (*gtpv2ie.TFTPacketFilter, error) {
+	dir, prec := gtpv2ie.TFTPFBidirectional, id
+	var comps []*gtpv2ie.TFTPFComponent
+	for _, kv := range strings.Split(spec, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q: want key=value", kv)
+		}
+		switch k {
+		case "dir":
+			switch v {
+			case "bi":
+				dir = gtpv2ie.TFTPFBidirectional
+			case "ul":
+				dir = gtpv2ie.TFTPFUplinkOnly
+			case "dl":
+				dir = gtpv2ie.TFTPFDownlinkOnly
+			default:
+				return nil, fmt.Errorf("dir %q: want bi, ul or dl", v)
+			}
+		case "prec":
+			p, err := strconv.ParseUint(v, 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("prec %q: want 0..255", v)
+			}
+			prec = uint8(p)
+		case "proto":
+			p, err := strconv.ParseUint(v, 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("proto %q: want 0..255", v)
+			}
+			comps = append(comps, gtpv2ie.NewTFTPFComponentProtocolIdentifierNextHeader(uint8(p)))
+		case "raddr":
+			ip, ipnet, err := net.ParseCIDR(v)
+			if err != nil {
+				if ip = net.ParseIP(v); ip == nil {
+					return nil, fmt.Errorf("raddr %q: want an address or CIDR", v)
+				}
+				bits := 8 * net.IPv6len
+				if ip.To4() != nil {
+					bits = 8 * net.IPv4len
+				}
+				ipnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+			}
+			if v4 := ip.To4(); v4 != nil {
+				comps = append(comps, gtpv2ie.NewTFTPFComponentIPv4RemoteAddress(v4, ipnet.Mask))
+			} else {
+				ones, _ := ipnet.Mask.Size()
+				comps = append(comps, gtpv2ie.NewTFTPFComponentIPv6RemoteAddressPrefixLength(ip, uint8(ones)))
+			}
+		case "rport", "lport":
+			lo, hi, err := parsePortRange(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s %q: %w", k, v, err)
+			}
+			switch {
+			case k == "rport" && lo == hi:
+				comps = append(comps, gtpv2ie.NewTFTPFComponentSingleRemotePort(lo))
+			case k == "rport":
+				comps = append(comps, gtpv2ie.NewTFTPFComponentRemotePortRange(lo, hi))
+			case lo == hi:
+				comps = append(comps, gtpv2ie.NewTFTPFComponentSingleLocalPort(lo))
+			default:
+				comps = append(comps, gtpv2ie.NewTFTPFComponentLocalPortRange(lo, hi))
+			}
+		default:
+			return nil, fmt.Errorf("unknown key %q (want dir, prec, proto, raddr, rport or lport)", k)
+		}
+	}
+	if len(comps) == 0 {
+		return nil, errors.New("no match components (proto, raddr, rport, lport)")
+	}
+	return gtpv2ie.NewTFTPacketFilter(dir, id, prec, comps...), nil
+}
+
+// parsePortRange parses "N" or "LO-HI".
+func parsePortRange(s string) (lo, hi uint16, err error) {
+	a, b, isRange := strings.Cut(s, "-")
+	l, err := strconv.ParseUint(a, 10, 16)
+	if err != nil {
+		return 0, 0, errors.New("want a port or LO-HI")
+	}
+	if !isRange {
+		return uint16(l), uint16(l), nil
+	}
+	h, err := strconv.ParseUint(b, 10, 16)
+	if err != nil || h < l {
+		return 0, 0, errors.New("want a port or LO-HI with LO <= HI")
+	}
+	return uint16(l), uint16(h), nil
+}
+
+// BearerResourceCommand asks the PGW for a dedicated bearer on sess's PDN
+// connection with the configured Flow QoS and TAD (UE-initiated, TS 23.401
+// 5.4.5). The PGW answers with a CreateBearerRequest carrying our sequence
+// number, which is accepted with a CreateBearerResponse; the new bearers are
+// added to sess.Bearers and returned. A BearerResourceFailureIndication is
+// reported as a rejection.
+func (c *Client) BearerResourceCommand(sess *Session) ([]Bearer, error) {
+	cfg := c.cfg
+	if cfg.BRCTAD == nil {
+		return nil, errors.New("bearer resource command needs a TAD")
+	}
+	q := cfg.BRCQoS
+	pti := uint8(randUint32()%254 + 1)
+	seq := c.seq.next()
+	req := gtpv2msg.NewGeneric(gtpv2msg.MsgTypeBearerResourceCommand, sess.RemoteCTEID, seq,
+		gtpv2ie.NewEPSBearerID(sess.EBI), // LBI
+		gtpv2ie.NewProcedureTransactionID(pti),
+		gtpv2ie.NewFlowQoS(q.QCI, q.MBRUL, q.MBRDL, q.GBRUL, q.GBRDL),
+		cfg.BRCTAD,
+		gtpv2ie.NewRATType(cfg.RATType),
+	)
+
+	c.msgLogf("tx BearerResourceCommand seq=%d teid=0x%08x imsi=%s lbi=%d pti=%d %s", seq, sess.RemoteCTEID, sess.IMSI, sess.EBI, pti, q)
+	m, rtt, err := c.transactTo(req, c.peerOf(sess))
+	if err != nil {
+		sess.record("BRC", 0, err)
+		return nil, err
+	}
+
+	if m.MessageType() == gtpv2msg.MsgTypeBearerResourceFailureIndication {
+		var causeIE *gtpv2ie.IE
+		if g, ok := m.(*gtpv2msg.Generic); ok {
+			for _, i := range g.IEs {
+				if i.Type == gtpv2ie.Cause {
+					causeIE = i
+				}
+			}
+		}
+		cause, err := c.checkResponse(req, m, gtpv2msg.MsgTypeBearerResourceFailureIndication, causeIE)
+		if err == nil {
+			// An accepted cause makes no sense here; still a failure.
+			c.tr.st.countErr(TxnRejected)
+			err = &TxnError{Kind: TxnRejected, MsgType: req.MessageType(), Seq: seq, Peer: c.peerOf(sess), Cause: cause}
+		}
+		sess.record("BRC", cause, err)
+		return nil, err
+	}
+	cbr, ok := m.(*gtpv2msg.CreateBearerRequest)
+	if !ok {
+		c.tr.st.countErr(TxnParse)
+		err := &TxnError{Kind: TxnParse, MsgType: req.MessageType(), Seq: seq, Peer: c.peerOf(sess), Err: fmt.Errorf("unexpected %s", m.MessageTypeName())}
+		sess.record("BRC", 0, err)
+		return nil, err
+	}
+	if cbr.PTI != nil {
+		if v, _ := cbr.PTI.ProcedureTransactionID(); v != pti {
+			log.Printf("WARNING: CBReq seq=%d pti=%d, sent %d", seq, v, pti)
+		}
+	}
+	c.msgLogf("BearerResourceCommand answered seq=%d rtt=%s with CBReq (%d bearer contexts)", seq, rtt, len(cbr.BearerContexts))
+	return c.acceptCreateBearer(sess, cbr), nil
+}
+
+// acceptCreateBearer answers cbr with a CreateBearerResponse accepting each
+// bearer context (see createBearerResponse).
+func (c *Client) acceptCreateBearer(sess *Session, cbr *gtpv2msg.CreateBearerRequest) []Bearer {
+	resp, out := c.createBearerResponse(sess, cbr)
+	c.reply(resp, c.peerOf(sess))
+	cause, _ := resp.Cause.Cause()
+	c.msgLogf("tx CBRsp seq=%d teid=0x%08x cause=%d", cbr.Sequence(), sess.RemoteCTEID, cause)
+	sess.record("CBR", cause, nil)
+	logDedicatedBearers(out)
+	return out
+}
+
+// createBearerResponse builds the CreateBearerResponse to cbr: each bearer
+// context gets the next free EBI and an S5/S8-U TEID of ours, and is
+// recorded in sess with the PGW's F-TEID.
+func (c *Client) createBearerResponse(sess *Session, cbr *gtpv2msg.CreateBearerRequest) (*gtpv2msg.CreateBearerResponse, []Bearer) {
+	var (
+		out []Bearer
+		bcs []*gtpv2ie.IE
+	)
+	cfg := c.sessCfg(c.cfg, sess)
+	sess.mu.Lock()
+	for _, bc := range cbr.BearerContexts {
+		ebi := freeEBI(sess.Bearers)
+		if ebi == 0 {
+			bcs = append(bcs, gtpv2ie.NewBearerContext(
+				gtpv2ie.NewEPSBearerID(0),
+				gtpv2ie.NewCause(gtpv2.CauseNoResourcesAvailable, 0, 0, 0, nil),
+			))
+			continue
+		}
+		b := &Bearer{EBI: ebi, LocalUTEID: randUint32()}
+		for _, ie := range bc.ChildIEs {
+			switch ie.Type {
+			case gtpv2ie.BearerFlags:
+				b.Flags, _ = ie.BearerFlags()
+			case gtpv2ie.ChargingID:
+				b.ChargingID, _ = ie.ChargingID()
+			case gtpv2ie.FullyQualifiedTEID:
+				if t, err := ie.InterfaceType(); err == nil && t == gtpv2.IFTypeS5S8PGWGTPU {
+					b.RemoteUTEID, _ = ie.TEID()
+					b.RemoteUIP, _ = ie.IPv4()
+				}
+			}
+		}
+		sess.Bearers[ebi] = b
+		out = append(out, *b)
+		children := []*gtpv2ie.IE{
+			gtpv2ie.NewEPSBearerID(ebi),
+			gtpv2ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
+			newNodeFTEID(cfg, gtpv2.IFTypeS5S8SGWGTPU, b.LocalUTEID).WithInstance(2),
+		}
+		if b.RemoteUIP != nil {
+			children = append(children, gtpv2ie.NewFullyQualifiedTEID(gtpv2.IFTypeS5S8PGWGTPU, b.RemoteUTEID, b.RemoteUIP.String(), "").WithInstance(3))
+		}
+		bcs = append(bcs, gtpv2ie.NewBearerContext(children...))
+	}
+	sess.mu.Unlock()
+
+	cause := gtpv2.CauseRequestAccepted
+	if len(out) == 0 {
+		cause = gtpv2.CauseNoResourcesAvailable
+	} else if len(out) < len(cbr.BearerContexts) {
+		cause = gtpv2.CauseRequestAcceptedPartially
+	}
+	ies := append([]*gtpv2ie.IE{gtpv2ie.NewCause(cause, 0, 0, 0, nil)}, bcs...)
+	return gtpv2msg.NewCreateBearerResponse(sess.RemoteCTEID, cbr.Sequence(), ies...), out
+}
+
+func logDedicatedBearers(out []Bearer) {
+	for _, b := range out {
+		flags := ""
+		if b.Flags != 0 {
+			flags = ", Bearer Flags " + bearerFlagsString(b.Flags)
+		}
+		log.Printf("dedicated bearer ebi=%d: SGW S5/S8-U teid=0x%08x, PGW S5/S8-U teid=0x%08x ip=%s charging-id=%d%s", b.EBI, b.LocalUTEID, b.RemoteUTEID, b.RemoteUIP, b.ChargingID, flags)
+	}
+}
+
+// freeEBI returns the lowest EBI (5..15) not used in bearers, or 0.
+func freeEBI(bearers map[uint8]*Bearer) uint8 {
+	for ebi := uint8(5); ebi <= 15; ebi++ {
+		if bearers[ebi] == nil {
+			return ebi
+		}
+	}
+	return 0
+}
@@ -0,0 +1,57 @@
+package sim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// bearerFlagNames are the Bearer Flags IE flags (TS 29.274 8.44), bit 1
+// first: Prohibit Payload Compression, Voice Bearer, vSRVCC indicator and
+// Activity Status Indicator.
+var bearerFlagNames = []string{"PPC", "VB", "VIND", "ASI"}
+
+// ParseBearerFlags parses a -bearer-flags value: a comma-separated list of
+// flag names (PPC, VB, VIND, ASI; any case) or the octet as a number.
+func ParseBearerFlags(s string) (uint8, error) {
+	if v, err := strconv.ParseUint(s, 0, 8); err == nil {
+		return uint8(v), nil
+	}
+	var out uint8
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		found := false
+		for i, n := range bearerFlagNames {
+			if strings.EqualFold(n, f) {
+				out, found = out|1<<i, true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown bearer flag %q (want a number or one of %s)", f, strings.Join(bearerFlagNames, ","))
+		}
+	}
+	return out, nil
+}
+
+// bearerFlagsString renders a Bearer Flags octet, e.g. "PPC,VB (0x03)";
+// spare bits show as bitN.
+func bearerFlagsString(v uint8) string {
+	var names []string
+	for i := range 8 {
+		if v&(1<<i) == 0 {
+			continue
+		}
+		if i < len(bearerFlagNames) {
+			names = append(names, bearerFlagNames[i])
+		} else {
+			names = append(names, fmt.Sprintf("bit%d", i+1))
+		}
+	}
+	if len(names) == 0 {
+		names = append(names, "none")
+	}
+	return fmt.Sprintf("%s (0x%02x)", strings.Join(names, ","), v)
+}
@@ -0,0 +1,87 @@
+package sim
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// burstBuckets is how many intervals the burst's response-rate curve
+// splits the drain time into.
+const burstBuckets = 10
+
+// createBurst runs create for all of cfgs at once (Config.Burst): every
+// CreateSession goroutine is started first and then released together, so
+// the CSRs go out as fast as the CPU and socket allow, with no pacing and
+// no window. How long the peer takes to answer them all is logged by
+// logBurst.
+func (c *Client) createBurst(cfgs []Config, create func(int, Config), timings []csrTiming) {
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for k, cfg := range cfgs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-release
+			create(k, cfg)
+		}()
+	}
+	start := time.Now()
+	close(release)
+	wg.Wait()
+	logBurst(start, timings)
+}
+
+// logBurst logs the outcome of a burst started at start: how long it took
+// to start all the CreateSessions, the drain time (start to the last answer), the timeouts and
+// the response rate over burstBuckets intervals of the drain time, e.g.
+//
+//	burst: 1000 CSRs started within 38ms; 940 answered, last after 1.842s (drain), 60 timed out, 0 other failures
+//	burst +0.000-0.185s: 112 answers (605/s), 112 so far
+func logBurst(start time.Time, ts []csrTiming) {
+	var (
+		lastSend time.Time
+		answers  []time.Duration // since start
+		timeouts int
+		other    int
+	)
+	for _, t := range ts {
+		if t.at.After(lastSend) {
+			lastSend = t.at
+		}
+		var te *TxnError
+		switch {
+		case t.answered():
+			answers = append(answers, t.at.Add(t.rtt).Sub(start))
+		case errors.As(t.err, &te) && te.Kind == TxnTimeout:
+			timeouts++
+		default:
+			other++
+		}
+	}
+	var drain time.Duration
+	for _, a := range answers {
+		drain = max(drain, a)
+	}
+	log.Printf("burst: %d CSRs started within %s; %d answered, last after %s (drain), %d timed out, %d other failures",
+		len(ts), lastSend.Sub(start).Round(time.Millisecond), len(answers), drain.Round(time.Millisecond), timeouts, other)
+	if len(answers) == 0 {
+		return
+	}
+	width := max(drain/burstBuckets, time.Millisecond)
+	counts := make([]int, burstBuckets)
+	for _, a := range answers {
+		counts[min(int(a/width), burstBuckets-1)]++
+	}
+	total := 0
+	for i, n := range counts {
+		total += n
+		from, to := width*time.Duration(i), width*time.Duration(i+1)
+		if i == burstBuckets-1 {
+			to = max(to, drain) // the last interval takes the rounding
+		}
+		log.Printf("burst +%.3f-%.3fs: %d answers (%.0f/s), %d so far",
+			from.Seconds(), to.Seconds(), n, float64(n)/(to-from).Seconds(), total)
+	}
+}
@@ -0,0 +1,149 @@
+package sim
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// causeNames are short descriptions of the rejection causes a PGW commonly
+// sends (TS 29.274 8.4), for summaries.
+var causeNames = map[uint8]string{
+	64:  "context not found",
+	65:  "invalid message format",
+	66:  "version not supported",
+	67:  "invalid length",
+	68:  "service not supported",
+	69:  "mandatory IE incorrect",
+	70:  "mandatory IE missing",
+	72:  "system failure",
+	73:  "no resources available",
+	74:  "semantic error in TFT",
+	75:  "syntactic error in TFT",
+	76:  "semantic error in packet filters",
+	77:  "syntactic error in packet filters",
+	78:  "missing or unknown APN",
+	83:  "preferred PDN type not supported",
+	84:  "all dynamic addresses occupied",
+	86:  "protocol type not supported",
+	89:  "service denied",
+	91:  "no memory available",
+	92:  "user authentication failed",
+	93:  "APN access denied, no subscription",
+	94:  "request rejected, reason not specified",
+	96:  "IMSI/IMEI not known",
+	100: "remote peer not responding",
+	101: "collision with network initiated request",
+	103: "conditional IE missing",
+	104: "APN restriction incompatible",
+	109: "invalid peer",
+	110: "temporarily rejected, handover in progress",
+	113: "APN congestion",
+	116: "multiple PDN connections for the APN not allowed",
+	120: "GTP-C entity congestion",
+	121: "late overlapping request",
+	122: "timed out request",
+}
+
+// causeName renders a cause value with its description, e.g.
+// "73 (no resources available)".
+func causeName(v uint8) string {
+	if n, ok := causeNames[v]; ok {
+		return fmt.Sprintf("%d (%s)", v, n)
+	}
+	return fmt.Sprintf("%d", v)
+}
+
+// FailureLimits are the CreateSession failure thresholds a run must stay
+// within (see CheckFailures); a negative value disables the check.
+type FailureLimits struct {
+	MaxFailures    int     // failed CreateSessions, any kind
+	MaxFailureRate float64 // failed/attempted CreateSessions, 0..1
+
+	// SLA bounds the CSRsp RTT (0 disables it); CheckSLA fails the run
+	// when more than MaxSLARate (0..1) of the CSRsps exceed it.
+	SLA        time.Duration
+	MaxSLARate float64
+}
+
+// csrOutcomes counts the run's CreateSessions for the failure thresholds;
+// those sent during the warmup only count in warm.
+type csrOutcomes struct {
+	mu                       sync.Mutex
+	attempts, failures, warm int
+}
+
+func (o *csrOutcomes) count(err error, warm bool) {
+	o.mu.Lock()
+	if warm {
+		o.warm++
+		o.mu.Unlock()
+		return
+	}
+	o.attempts++
+	if err != nil {
+		o.failures++
+	}
+	o.mu.Unlock()
+}
+
+// FailureThresholdError reports a run whose CreateSession failures exceeded
+// the configured limits.
+type FailureThresholdError struct {
+	Attempts, Failures int
+	Limit              string // which limit was crossed
+	TopCauses          string // most frequent rejection causes
+}
+
+func (e *FailureThresholdError) Error() string {
+	s := fmt.Sprintf("%d of %d CreateSessions failed (%.1f%%), over %s", e.Failures, e.Attempts,
+		100*float64(e.Failures)/float64(e.Attempts), e.Limit)
+	if e.TopCauses != "" {
+		s += "; top rejection causes: " + e.TopCauses
+	}
+	return s
+}
+
+// CheckFailures compares the run's CreateSession failures against
+// Config.Failures and returns a *FailureThresholdError if a limit was
+// crossed, so CI can gate on a quality bar without scraping logs.
+func (c *Client) CheckFailures() error {
+	lim := c.cfg.Failures
+	c.csrs.mu.Lock()
+	attempts, failures := c.csrs.attempts, c.csrs.failures
+	c.csrs.mu.Unlock()
+	var limit string
+	switch {
+	case attempts == 0:
+		return nil
+	case lim.MaxFailures >= 0 && failures > lim.MaxFailures:
+		limit = fmt.Sprintf("-max-failures %d", lim.MaxFailures)
+	case lim.MaxFailureRate >= 0 && float64(failures)/float64(attempts) > lim.MaxFailureRate:
+		limit = fmt.Sprintf("-max-failure-rate %g", lim.MaxFailureRate)
+	default:
+		return nil
+	}
+	return &FailureThresholdError{Attempts: attempts, Failures: failures, Limit: limit, TopCauses: c.tr.st.topCauses(5)}
+}
+
+// topCauses renders the n most frequent rejection causes with their counts,
+// most frequent first, e.g. "73 (no resources available)=12, 78 (...)=3".
+func (s *stats) topCauses(n int) string {
+	s.mu.Lock()
+	causes := make([]uint8, 0, len(s.causes))
+	for v := range s.causes {
+		causes = append(causes, v)
+	}
+	sort.Slice(causes, func(i, j int) bool {
+		a, b := s.causes[causes[i]], s.causes[causes[j]]
+		return a > b || a == b && causes[i] < causes[j]
+	})
+	parts := make([]string, 0, min(n, len(causes)))
+	for _, v := range causes[:min(n, len(causes))] {
+		parts = append(parts, fmt.Sprintf("%s=%d", causeName(v), s.causes[v]))
+	}
+	s.mu.Unlock()
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,537 @@
+// Package sim is a GTPv2-C S5/S8 SGW simulator. A Client owns the UDP
+// socket, matches responses to requests and tracks the sessions it creates,
+// so test harnesses can drive a PGW directly.
+package sim
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	mrand "math/rand/v2"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// Client is an SGW-side GTP-C endpoint talking to one PGW.
+type Client struct {
+	cfg      Config
+	tr       *transport
+	reg      *txnRegistry
+	seq      seqAllocator
+	sessions *sessionStore
+	ips      *ipOut
+	flows    *flowOut
+	db       *sqlDB
+	rs       *responder // nil unless cfg.Respond
+	u        *gtpuPath  // nil unless the GTP-U path check is on
+	tun      *tunnel    // nil until StartTUN
+	dec      *jsonDecoder
+	rtt      rttEstimator
+	health   health
+	resps    lastResponses
+	backoff  backoffs
+	csrs     csrOutcomes
+	groups   groupStats
+	sla      slaTracker
+	sent     sentLog
+	netem    rxImpair
+	piggy    piggyCBRs
+	paths    peerPaths
+	peers    peerSet
+	dups     dupSends
+	junit    *junitReport
+	probe    atomic.Pointer[probeWait] // pending UnknownMessage, if any
+
+	lateDeleted atomic.Uint64 // sessions accepted by a late CSRsp and deleted again
+
+	state    *stateFile // nil unless Config.StateFile is set
+	restored []*Session // loaded from the state file at start
+
+	integrity *integrityCheck // nil unless Config.IntegrityCheck is set
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewClient validates cfg, opens the socket and starts the receive loop and
+// the periodic Echo/stats goroutines configured in cfg.
+func NewClient(cfg Config) (*Client, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.CNRAT == 0 {
+		cfg.CNRAT = cfg.RATType
+	}
+	if cfg.Connected && cfg.Respond {
+		// A responder answers any peer, but a connected socket only hears
+		// from its remote (and needs one).
+		log.Printf("connect: responder mode answers any peer; using an unconnected socket")
+		cfg.Connected = false
+	}
+	if len(cfg.Peers) > 0 {
+		// The first peer takes the main socket; openPeers adds the others.
+		cfg.Local, cfg.Remote = cfg.Peers[0].Local, cfg.Peers[0].Remote
+	}
+
+	laddr, err := net.ResolveUDPAddr("udp", cfg.Local)
+	if err != nil {
+		return nil, fmt.Errorf("resolve local: %w", err)
+	}
+	// A responder may run without a remote; it answers whoever asks.
+	var raddr *net.UDPAddr
+	v6 := laddr.IP != nil && laddr.IP.To4() == nil
+	if cfg.Remote != "" {
+		if raddr, err = net.ResolveUDPAddr("udp", cfg.Remote); err != nil {
+			return nil, fmt.Errorf("resolve remote: %w", err)
+		}
+		v6 = raddr.IP.To4() == nil
+		if len(cfg.Peers) == 0 {
+			if err := checkNodeIP(cfg, laddr, raddr); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	tr, err := newTransport(laddr, raddr, cfg.Connected, cfg.FD)
+	if err != nil {
+		return nil, fmt.Errorf("listen udp: %w", err)
+	}
+	if cfg.SrcPorts > 1 {
+		if err := tr.addSrcPorts(laddr.IP, cfg.SrcPorts-1); err != nil {
+			tr.Close()
+			return nil, fmt.Errorf("src-ports: %w", err)
+		}
+	}
+	tr.follow = cfg.FollowPeer
+	tr.retries = cfg.WriteRetries
+	if cfg.SendBatch > 0 {
+		if sendmmsgSupported {
+			tr.batch = newBatcher(tr, cfg.SendBatch, v6)
+		} else {
+			log.Printf("send-batch: sendmmsg is linux-only; sending one datagram per syscall")
+		}
+	}
+	if cfg.SpoofSrc != nil {
+		if raddr != nil && raddr.IP.To4() == nil {
+			tr.Close()
+			return nil, errors.New("spoof-src needs an IPv4 remote")
+		}
+		port := tr.conn.LocalAddr().(*net.UDPAddr).Port
+		if tr.spoof, err = newSpoofer(cfg.SpoofSrc, port, cfg.DSCP, cfg.DF); err != nil {
+			tr.Close()
+			return nil, fmt.Errorf("spoof-src: %w", err)
+		}
+		for t, dscp := range cfg.DSCPMap {
+			if tr.spoof.typeTOS == nil {
+				tr.spoof.typeTOS = make(map[uint8]int)
+			}
+			tr.spoof.typeTOS[t] = dscp << 2
+		}
+		log.Printf("WARNING: spoof-src: GTP-C leaves from %s:%d via a raw socket; answers reach us only if %s is routed here", cfg.SpoofSrc, port, cfg.SpoofSrc)
+	}
+	c := &Client{
+		cfg:      cfg,
+		tr:       tr,
+		reg:      newTxnRegistry(cfg.MaxInflight),
+		sessions: newSessionStore(),
+		done:     make(chan struct{}),
+	}
+	c.seq.init()
+	c.reg.onLate = c.lateResponse
+	if cfg.Respond {
+		c.rs = newResponder()
+	}
+	if cfg.DecodeJSON {
+		c.dec = newJSONDecoder(os.Stdout)
+	}
+	if cfg.IntegrityCheck {
+		c.integrity = newIntegrityCheck()
+		tr.tap = c.integrity.record
+	}
+
+	if err := c.openPeers(); err != nil {
+		tr.Close()
+		return nil, err
+	}
+	for t, dscp := range cfg.DSCPMap {
+		if tr.tos == nil {
+			tr.tos = make(map[uint8][]byte)
+		}
+		if tr.tos[t], err = tosControl(dscp<<2, v6); err != nil {
+			tr.Close()
+			return nil, err
+		}
+	}
+	tr.setup = func(conn *net.UDPConn) error { return setSockopts(conn, cfg, v6) }
+	for _, conn := range tr.conns() {
+		if err := tr.setup(conn); err != nil {
+			tr.Close()
+			return nil, err
+		}
+	}
+	if cfg.IPOut != "" {
+		if c.ips, err = openIPOut(cfg.IPOut); err != nil {
+			tr.Close()
+			return nil, fmt.Errorf("open ip-out: %w", err)
+		}
+	}
+	if cfg.FlowOut != "" {
+		if c.flows, err = openFlowOut(cfg.FlowOut); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("open flow-out: %w", err)
+		}
+	}
+	if cfg.DB != "" {
+		if c.db, err = openSQLDB(cfg.DB); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("open db: %w", err)
+		}
+	}
+	if cfg.JUnit != "" {
+		c.junit = newJUnitReport()
+	}
+	if cfg.StateFile != "" {
+		c.state = &stateFile{path: cfg.StateFile}
+		if c.restored, err = c.loadState(); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("state-file: %w", err)
+		}
+	}
+
+	if cfg.GTPUEcho > 0 || cfg.GTPURemote != "" || cfg.GTPUKeepalive > 0 || cfg.TUN != "" {
+		ul, ur, err := gtpuAddrs(cfg)
+		if err == nil {
+			c.u, err = newGTPUPath(ul, ur)
+		}
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("gtp-u: %w", err)
+		}
+		log.Printf("GTP-U path: local=%s remote=%s", c.u.conn.LocalAddr(), ur)
+	}
+
+	if cfg.Respond {
+		log.Printf("S5/S8 PGW responder up: local=%s node-ip=%s apn-restriction=%d", tr.LocalAddr(), cfg.NodeIP, cfg.APNRestriction)
+	} else {
+		log.Printf("S5/S8 SGW initiator up: local=%s remote=%s node-ip=%s socket=%s", tr.LocalAddr(), raddr, cfg.NodeIP, tr.mode())
+	}
+
+	// RX loop: respond EchoReq, deliver responses to waiters, log others.
+	go c.rxLoop()
+
+	if c.state != nil {
+		go c.every(stateSaveEvery, func() {
+			if err := c.saveState(); err != nil {
+				log.Printf("state-file: %v", err)
+			}
+		})
+	}
+	if cfg.EchoEvery > 0 && raddr != nil {
+		go c.every(cfg.EchoEvery, func() {
+			for _, to := range c.remotes() {
+				if !c.paths.get(to).echoDue(cfg.EchoEvery) {
+					continue
+				}
+				if _, err := c.echo(to); err != nil {
+					log.Printf("Echo failed: %v", err)
+				}
+			}
+		})
+	}
+	if cfg.GTPUEcho > 0 {
+		go c.every(cfg.GTPUEcho, func() {
+			if _, err := c.EchoU(); err != nil {
+				log.Printf("GTP-U Echo failed: %v", err)
+			}
+		})
+	}
+	if cfg.GTPUKeepalive > 0 {
+		go c.every(cfg.GTPUKeepalive, c.keepaliveSessions)
+	}
+	sweepEvery := txnSweepEvery
+	if cfg.T3 > 0 {
+		sweepEvery = min(sweepEvery, cfg.T3)
+	}
+	go c.every(sweepEvery, c.sweepTxns)
+	if cfg.StatsEvery > 0 {
+		go c.every(cfg.StatsEvery, func() { log.Print(c.tr.st.tick(c.reg.inflight())) })
+	}
+	return c, nil
+}
+
+// checkNodeIP warns when the node IP advertised in our F-TEIDs isn't the
+// address our packets leave from: the PGW would then send its requests
+// somewhere we don't listen. With cfg.Strict the mismatch is an error.
+func checkNodeIP(cfg Config, laddr, raddr *net.UDPAddr) error {
+	src, err := egressIP(laddr, raddr)
+	if err != nil {
+		log.Printf("node-ip check skipped: no route to %s: %v", raddr, err)
+		return nil
+	}
+	node, flag := cfg.NodeIP, "-node-ip"
+	if src.To4() == nil {
+		// IPv6 transport: the F-TEIDs' IPv6 address is what the peer
+		// should use. A link-local source (zoned -local/-remote) can't
+		// match a global node address, which is expected.
+		if cfg.NodeIP6 == nil {
+			log.Printf("WARNING: IPv6 path to %s but no -node-ip6: the F-TEIDs carry only IPv4 %s", raddr, cfg.NodeIP)
+			return nil
+		}
+		if src.IsLinkLocalUnicast() {
+			return nil
+		}
+		node, flag = cfg.NodeIP6, "-node-ip6"
+	}
+	if src.Equal(node) {
+		return nil
+	}
+	msg := fmt.Sprintf("node-ip %s differs from the source address %s used towards %s; the PGW will send to %s (check %s / -local)", node, src, raddr, node, flag)
+	if cfg.Strict {
+		return errors.New(msg)
+	}
+	log.Printf("WARNING: %s", msg)
+	return nil
+}
+
+// every runs fn each interval until the client is closed. fn runs on the
+// ticker's goroutine, so a slow run delays the next one rather than
+// overlapping it.
+func (c *Client) every(interval time.Duration, fn func()) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			fn()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// LocalAddr returns the address the GTP-C socket is bound to.
+func (c *Client) LocalAddr() net.Addr { return c.tr.LocalAddr() }
+
+// Sessions returns the sessions currently established.
+func (c *Client) Sessions() []*Session { return c.sessions.all() }
+
+// Report logs the run report: traffic counters and failed transactions, and
+// the user-plane echo results when the GTP-U path check is on. It also ends
+// the flow record of every session still up and writes Config.MetricsFile.
+func (c *Client) Report() {
+	for _, sess := range c.sessions.all() {
+		c.endSession(sess, "run-end")
+	}
+	c.tr.report()
+	if top := c.tr.st.topCauses(5); top != "" {
+		log.Printf("run report: top rejection causes: %s", top)
+	}
+	c.groups.report()
+	c.slaReport()
+	c.warmupReport()
+	c.dupReport()
+	if c.impaired() {
+		c.netem.report()
+	}
+	for _, pp := range c.paths.all() {
+		log.Printf("run report: peer %s", pp)
+	}
+	if n := c.reg.swept.Load(); n > 0 {
+		log.Printf("run report: stale transactions swept: %d", n)
+	}
+	if c.integrity != nil {
+		checked, unverified, n := c.integrityTotals()
+		log.Printf("run report: integrity check: %d datagram(s) checked, %d mismatch(es), %d without a record", checked, n, unverified)
+	}
+	if n := c.reg.late.Load(); n > 0 {
+		log.Printf("run report: late responses: %d (sessions they accepted deleted again: %d)", n, c.lateDeleted.Load())
+	}
+	if w, n := c.seq.wraps.Load(), c.seq.collisions.Load(); w+n > 0 {
+		log.Printf("run report: sequence wraps: %d, collisions with pending transactions: %d", w, n)
+	}
+	if c.u != nil {
+		c.u.report()
+	}
+	if c.tun != nil {
+		c.tun.report()
+	}
+	if c.state != nil {
+		if err := c.saveState(); err != nil {
+			log.Printf("state-file: %v", err)
+		} else {
+			log.Printf("run report: %d session(s) saved to %s", len(c.sessions.all()), c.state.path)
+		}
+	}
+	if c.junit != nil {
+		if err := c.junit.write(c.cfg.JUnit); err != nil {
+			log.Printf("junit: %v", err)
+		} else {
+			log.Printf("run report: JUnit XML written to %s", c.cfg.JUnit)
+		}
+	}
+	if c.cfg.MetricsFile != "" {
+		if err := c.WriteMetricsFile(c.cfg.MetricsFile); err != nil {
+			log.Printf("metrics-file: %v", err)
+		} else {
+			log.Printf("run report: metrics written to %s", c.cfg.MetricsFile)
+		}
+	}
+}
+
+// Close stops the background goroutines and closes the socket and the
+// output files. Only the first call does anything, so a deferred Close
+// after an explicit one is harmless.
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() { err = c.close() })
+	return err
+}
+
+func (c *Client) close() error {
+	close(c.done)
+	if c.ips != nil {
+		c.ips.Close()
+	}
+	if c.flows != nil {
+		c.flows.Close()
+	}
+	if c.db != nil {
+		c.db.Close()
+	}
+	if c.tun != nil {
+		c.tun.dev.Close()
+	}
+	if c.u != nil {
+		c.u.Close()
+	}
+	return c.tr.Close()
+}
+
+// setSockopts applies the configured DSCP, don't-fragment and buffer
+// sizes to conn.
+func setSockopts(conn *net.UDPConn, cfg Config, v6 bool) error {
+	if cfg.DSCP >= 0 {
+		if err := setDSCP(conn, cfg.DSCP, v6); err != nil {
+			return fmt.Errorf("set dscp: %w", err)
+		}
+	}
+	if cfg.DF {
+		if err := setDontFragment(conn, v6); err != nil {
+			return fmt.Errorf("set df: %w", err)
+		}
+	}
+	if cfg.RcvBuf > 0 || cfg.SndBuf > 0 {
+		if err := setBuffers(conn, cfg.RcvBuf, cfg.SndBuf); err != nil {
+			return fmt.Errorf("set socket buffers: %w", err)
+		}
+	}
+	return nil
+}
+
+// Echo sends an EchoRequest and waits for the EchoResponse, returning the RTT.
+func (c *Client) Echo() (time.Duration, error) { return c.echo(c.tr.remote()) }
+
+// echo is Echo to the PGW to.
+func (c *Client) echo(to *net.UDPAddr) (time.Duration, error) {
+	seq := c.seq.next()
+	req := gtpv2msg.NewEchoRequest(seq, c.echoIEs()...)
+
+	c.msgLogf("tx EchoReq seq=%d -> %s", seq, to)
+	m, rtt, err := c.transactTo(req, to)
+	if err != nil {
+		return 0, err
+	}
+	if m.MessageType() != gtpv2msg.MsgTypeEchoResponse {
+		c.tr.st.countErr(TxnParse)
+		return 0, &TxnError{Kind: TxnParse, MsgType: req.MessageType(), Seq: seq, Peer: to, Err: fmt.Errorf("unexpected %s", m.MessageTypeName())}
+	}
+	c.msgLogf("Echo succeeded seq=%d rtt=%s", seq, rtt)
+	return rtt, nil
+}
+
+// WaitPath sends Echos until the peer answers one, for at most timeout, so a
+// run against an unreachable peer fails up front instead of with a string of
+// CSR timeouts. Each Echo is retransmitted per T3/N3 as usual.
+func (c *Client) WaitPath(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := c.Echo()
+		if err == nil {
+			return nil
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			return fmt.Errorf("path to %s not up after %s: %w", c.tr.remote(), timeout, err)
+		}
+		log.Printf("wait-path: %v; retrying", err)
+		// A timed-out Echo has already waited; an immediate failure (e.g. ICMP
+		// port unreachable on a connected socket) has not.
+		var te *TxnError
+		if !errors.As(err, &te) || te.Kind != TxnTimeout {
+			time.Sleep(min(time.Second, left))
+		}
+	}
+}
+
+// seqAllocator hands out 24-bit GTPv2 sequence numbers. It starts at a
+// random point so restarts don't reuse the previous run's numbers. wraps
+// counts passes through 0, collisions the sends that found their number
+// still taken by a pending transaction.
+type seqAllocator struct {
+	n          atomic.Uint32
+	wraps      atomic.Uint64
+	collisions atomic.Uint64
+}
+
+func (a *seqAllocator) init() { a.n.Store(randUint32()) }
+
+func (a *seqAllocator) next() uint32 {
+	seq := a.n.Add(1) & 0x00ffffff
+	if seq == 0 {
+		a.wraps.Add(1)
+		log.Printf("sequence number wrapped past 0x%06x", 0x00ffffff)
+	}
+	return seq
+}
+
+// seeded replaces crypto/rand in randUint32 after SetSeed.
+var seeded struct {
+	sync.Mutex
+	r *mrand.Rand
+}
+
+// SetSeed makes TEIDs, sequence starts and random IMSIs come from a
+// math/rand source seeded with seed, so a run can be replayed exactly. It is
+// meant for tests only: the values are predictable. Call it before creating
+// clients.
+func SetSeed(seed uint64) {
+	seeded.Lock()
+	seeded.r = mrand.New(mrand.NewPCG(seed, 0))
+	seeded.Unlock()
+}
+
+func randUint32() uint32 {
+	var v uint32
+	seeded.Lock()
+	r := seeded.r
+	if r != nil {
+		v = r.Uint32()
+	}
+	seeded.Unlock()
+	if r == nil {
+		var b [4]byte
+		_, _ = rand.Read(b[:])
+		v = binary.BigEndian.Uint32(b[:])
+	}
+	if v == 0 {
+		return 1
+	}
+	return v
+}
@@ -0,0 +1,72 @@
+package sim
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestCloseTwice checks a second Close, e.g. a deferred one after an
+// explicit Close before os.Exit, does nothing.
+func TestCloseTwice(t *testing.T) {
+	sgw, pgw := selfTest(t, DefaultConfig())
+	for range 2 {
+		if err := sgw.Close(); err != nil {
+			t.Errorf("sgw Close: %v", err)
+		}
+		pgw.Close()
+	}
+}
+
+// TestEveryNoOverlap checks a callback slower than its interval is not run
+// again while it is still running.
+func TestEveryNoOverlap(t *testing.T) {
+	c := &Client{done: make(chan struct{})}
+	var running, most, runs atomic.Int32
+	go c.every(time.Millisecond, func() {
+		n := running.Add(1)
+		if n > most.Load() {
+			most.Store(n)
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+		runs.Add(1)
+	})
+	time.Sleep(50 * time.Millisecond)
+	close(c.done)
+	if runs.Load() == 0 {
+		t.Fatal("fn never ran")
+	}
+	if m := most.Load(); m > 1 {
+		t.Errorf("%d runs of fn overlapped, want 1 at a time", m)
+	}
+}
+
+// TestConnectResponder checks -connect does not narrow a responder to its
+// -remote: it binds without one, and with one still answers other peers.
+func TestConnectResponder(t *testing.T) {
+	for _, remote := range []string{"", "127.0.0.1:9"} {
+		cfg := DefaultConfig()
+		cfg.Local, cfg.Remote, cfg.EchoEvery = "127.0.0.1:0", remote, 0
+		cfg.Respond, cfg.Connected = true, true
+		pgw, err := NewClient(cfg)
+		if err != nil {
+			t.Fatalf("remote %q: NewClient: %v", remote, err)
+		}
+		defer pgw.Close()
+		if mode := pgw.tr.mode(); mode != "unconnected" {
+			t.Errorf("remote %q: socket %s, want unconnected", remote, mode)
+		}
+
+		scfg := DefaultConfig()
+		scfg.Local, scfg.Remote, scfg.EchoEvery = "127.0.0.1:0", pgw.LocalAddr().String(), 0
+		sgw, err := NewClient(scfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sgw.Close()
+		if _, err := sgw.Echo(); err != nil {
+			t.Errorf("remote %q: Echo from another peer: %v", remote, err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package sim
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// closedLoopEvery is how often a closed-loop run logs its concurrency.
+const closedLoopEvery = time.Second
+
+// closedLoop is the Config.ClosedLoop launcher's window: how many
+// CreateSessions may be outstanding, one at the start and growing linearly
+// to target over ramp, and how many actually were, time-weighted, for the
+// progress lines and the summary.
+type closedLoop struct {
+	target int
+	ramp   time.Duration
+	start  time.Time
+
+	mu       sync.Mutex
+	inflight int
+	changed  time.Time // when inflight last changed
+	reached  time.Duration
+	// current report interval and the whole run: in-flight × seconds,
+	// peak in-flight, completed CreateSessions
+	winStart                time.Time
+	winArea, area           float64
+	winPeak, peak           int
+	winCompleted, completed int
+}
+
+func newClosedLoop(target int, ramp time.Duration) *closedLoop {
+	now := time.Now()
+	return &closedLoop{target: target, ramp: ramp, start: now, changed: now, winStart: now, reached: -1}
+}
+
+// limit is the window at now.
+func (l *closedLoop) limit(now time.Time) int {
+	e := now.Sub(l.start)
+	if l.ramp <= 0 || e >= l.ramp || l.target <= 1 {
+		return l.target
+	}
+	return 1 + int(float64(l.target-1)*float64(e)/float64(l.ramp))
+}
+
+// nextRaise is how long until the window grows next; once at target, only
+// a completion frees a slot, so it waits for a while.
+func (l *closedLoop) nextRaise(now time.Time) time.Duration {
+	if l.limit(now) >= l.target {
+		return time.Minute
+	}
+	step := l.ramp / time.Duration(l.target-1)
+	e := now.Sub(l.start)
+	return step*(e/step+1) - e
+}
+
+// account adds the time since the last change at the old in-flight count;
+// callers hold l.mu.
+func (l *closedLoop) account(now time.Time) {
+	d := now.Sub(l.changed).Seconds()
+	l.winArea += d * float64(l.inflight)
+	l.area += d * float64(l.inflight)
+	l.changed = now
+}
+
+// full reports whether the window has no room for another CreateSession.
+func (l *closedLoop) full() bool {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inflight >= l.limit(now)
+}
+
+func (l *closedLoop) begin() {
+	now := time.Now()
+	l.mu.Lock()
+	l.account(now)
+	l.inflight++
+	l.winPeak, l.peak = max(l.winPeak, l.inflight), max(l.peak, l.inflight)
+	if l.reached < 0 && l.inflight >= l.target {
+		l.reached = now.Sub(l.start)
+	}
+	l.mu.Unlock()
+}
+
+func (l *closedLoop) end() {
+	now := time.Now()
+	l.mu.Lock()
+	l.account(now)
+	l.inflight--
+	l.winCompleted++
+	l.completed++
+	l.mu.Unlock()
+}
+
+// tick renders the interval since the previous tick and starts the next,
+// e.g. "closed-loop t=3s: window 4/10, in-flight avg 3.9 peak 4, 212 completed (212.0/s)".
+func (l *closedLoop) tick() string {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.account(now)
+	d := now.Sub(l.winStart).Seconds()
+	var avg, rate float64
+	if d > 0 {
+		avg, rate = l.winArea/d, float64(l.winCompleted)/d
+	}
+	s := fmt.Sprintf("closed-loop t=%s: window %d/%d, in-flight avg %.1f peak %d, %d completed (%.1f/s)",
+		now.Sub(l.start).Round(time.Second), l.limit(now), l.target, avg, l.winPeak, l.winCompleted, rate)
+	l.winStart, l.winArea, l.winPeak, l.winCompleted = now, 0, l.inflight, 0
+	return s
+}
+
+// String summarizes the whole run.
+func (l *closedLoop) String() string {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.account(now)
+	d := now.Sub(l.start)
+	var avg float64
+	if d > 0 {
+		avg = l.area / d.Seconds()
+	}
+	s := fmt.Sprintf("target concurrency %d over a %s ramp, ", l.target, l.ramp)
+	if l.reached >= 0 {
+		s += fmt.Sprintf("reached after %s", l.reached.Round(time.Millisecond))
+	} else {
+		s += "never reached"
+	}
+	return s + fmt.Sprintf("; in-flight avg %.1f peak %d, %d completed in %s (%.1f/s)",
+		avg, l.peak, l.completed, d.Round(time.Millisecond), float64(l.completed)/d.Seconds())
+}
+
+// createClosedLoop runs create for each of cfgs closed-loop: a
+// CreateSession starts only when one of the window's slots is free, so
+// with the window at 1 each CSR waits for the previous answer, and the
+// offered load follows what the peer sustains instead of a schedule. The
+// achieved concurrency is logged every closedLoopEvery.
+func (c *Client) createClosedLoop(cfgs []Config, create func(int, Config)) {
+	l := newClosedLoop(c.cfg.ClosedLoop, c.cfg.ClosedLoopRamp)
+	freed := make(chan struct{}, len(cfgs))
+	stop := make(chan struct{})
+	go func() {
+		t := time.NewTicker(closedLoopEvery)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				log.Print(l.tick())
+			case <-stop:
+				return
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for k, cfg := range cfgs {
+		c.backoff.wait(cfg.APN)
+		for l.full() {
+			select {
+			case <-freed:
+			case <-time.After(l.nextRaise(time.Now())):
+			}
+		}
+		l.begin()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			create(k, cfg)
+			l.end()
+			freed <- struct{}{}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	log.Printf("CreateSessions closed-loop: %s", l)
+}
@@ -0,0 +1,261 @@
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// CompareAnswer is how one peer answered the CSR of Compare.
+type CompareAnswer struct {
+	Peer  string
+	Cause uint8 // 0 without a usable CSRsp
+	RTT   time.Duration
+	Err   error // no answer, or it didn't parse
+	ies   []ieJSON
+}
+
+func (a CompareAnswer) String() string {
+	if a.Err != nil {
+		return fmt.Sprintf("%s: %v", a.Peer, a.Err)
+	}
+	return fmt.Sprintf("%s: cause %s rtt=%s", a.Peer, causeName(a.Cause), a.RTT)
+}
+
+// IEDiff is one leaf of the decoded CSRsps (see flattenIEs) that is not the
+// same at both peers; A or B is empty where that peer's answer lacks it.
+type IEDiff struct {
+	Path      string
+	A, B      string
+	Allocated bool // a value the peer allocates (TEID, address, Charging ID), bound to differ
+}
+
+func (d IEDiff) String() string {
+	var s string
+	switch {
+	case d.A == "":
+		s = fmt.Sprintf("only B: %s = %s", d.Path, d.B)
+	case d.B == "":
+		s = fmt.Sprintf("only A: %s = %s", d.Path, d.A)
+	default:
+		s = fmt.Sprintf("differs: %s: A=%s B=%s", d.Path, d.A, d.B)
+	}
+	if d.Allocated {
+		s += " (allocated)"
+	}
+	return s
+}
+
+// CompareResult is the outcome of Compare.
+type CompareResult struct {
+	A, B  CompareAnswer
+	Same  int // leaves equal at both peers
+	Diffs []IEDiff
+}
+
+// Behavioral reports whether the peers differ in more than the values
+// they allocate.
+func (r *CompareResult) Behavioral() bool {
+	for _, d := range r.Diffs {
+		if !d.Allocated {
+			return true
+		}
+	}
+	return r.A.Cause != r.B.Cause
+}
+
+// Summary renders r for the log, one line per difference and a verdict.
+func (r *CompareResult) Summary() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "A %s\nB %s\n", r.A, r.B)
+	allocated := 0
+	for _, d := range r.Diffs {
+		sb.WriteString(d.String() + "\n")
+		if d.Allocated {
+			allocated++
+		}
+	}
+	fmt.Fprintf(&sb, "%d IE values identical, %d differ (%d of them allocated values): ", r.Same, len(r.Diffs), allocated)
+	if r.Behavioral() {
+		sb.WriteString("the peers behave differently")
+	} else {
+		sb.WriteString("the peers behave the same")
+	}
+	return sb.String()
+}
+
+// Compare sends one CreateSessionRequest, byte for byte the same, to the
+// configured peer (A) and to remote (B) at once, decodes both answers IE by
+// IE as -decode-json does and lists where they differ. A session either
+// peer accepted is deleted again. B is reached from a socket of its own on
+// the -local host.
+func (c *Client) Compare(remote string) (*CompareResult, error) {
+	ocfg := c.cfg
+	host, _, err := net.SplitHostPort(c.cfg.Local)
+	if err != nil {
+		return nil, fmt.Errorf("compare: local %q: %w", c.cfg.Local, err)
+	}
+	ocfg.Local, ocfg.Remote, ocfg.FD = net.JoinHostPort(host, "0"), remote, -1
+	ocfg.Respond, ocfg.EchoEvery, ocfg.StatsEvery = false, 0, 0
+	ocfg.GTPUEcho, ocfg.GTPURemote, ocfg.GTPUKeepalive, ocfg.TUN = 0, "", 0, ""
+	ocfg.IPOut, ocfg.FlowOut, ocfg.DB, ocfg.JUnit, ocfg.MetricsFile, ocfg.DecodeJSON = "", "", "", "", "", false
+	ocfg.StateFile = ""
+	ocfg.SrcPorts = 1
+	other, err := NewClient(ocfg)
+	if err != nil {
+		return nil, fmt.Errorf("compare: client for %s: %w", remote, err)
+	}
+	defer other.Close()
+
+	b, err := c.newCSR(c.cfg)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("compare: tx CSR seq=%d imsi=%s to A=%s and B=%s", b.req.Sequence(), c.cfg.IMSI, c.tr.remote(), other.tr.remote())
+	res := &CompareResult{}
+	var wg sync.WaitGroup
+	for _, p := range []struct {
+		cl  *Client
+		ans *CompareAnswer
+	}{{c, &res.A}, {other, &res.B}} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			*p.ans = p.cl.compareSend(b)
+		}()
+	}
+	wg.Wait()
+	if res.A.Err != nil && res.B.Err != nil {
+		return res, fmt.Errorf("compare: neither peer answered usably: A %v; B %v", res.A.Err, res.B.Err)
+	}
+	res.Same, res.Diffs = diffIEs(flattenIEs(res.A.ies), flattenIEs(res.B.ies))
+	return res, nil
+}
+
+// compareSend sends b's CSR to c's peer and, if the peer accepted it,
+// deletes the session again.
+func (c *Client) compareSend(b *csr) CompareAnswer {
+	ans := CompareAnswer{Peer: c.tr.remote().String()}
+	m, rtt, err := c.transact(b.req)
+	ans.RTT = rtt
+	if err != nil {
+		ans.Err = err
+		return ans
+	}
+	resp, ok := m.(*gtpv2msg.CreateSessionResponse)
+	if !ok {
+		ans.Err = fmt.Errorf("answered with %s", msgName(m.MessageType()))
+		return ans
+	}
+	if resp.Cause != nil {
+		ans.Cause, _ = resp.Cause.Cause()
+	}
+	if ans.ies, err = messageIEs(m); err != nil {
+		ans.Err = fmt.Errorf("decode CSRsp: %w", err)
+	}
+	if resp.Cause == nil || !causeAccepted(resp.Cause) {
+		return ans
+	}
+	sess := &Session{IMSI: c.cfg.IMSI, EBI: c.cfg.EBI, LocalCTEID: b.localCTeid, LocalUTEID: b.localUTeid, APN: c.cfg.APN, Start: time.Now()}
+	if resp.SenderFTEIDC != nil {
+		sess.RemoteCTEID, _ = resp.SenderFTEIDC.TEID()
+	}
+	if _, err := c.deleteSession(sess); err != nil {
+		log.Printf("compare: DeleteSession at %s: %v", ans.Peer, err)
+	}
+	return ans
+}
+
+// flattenIEs renders decoded IEs as leaf paths and values, e.g.
+// "BearerContext.F-TEID:2.teid" = "3735928559". A path segment is the IE's
+// -log-ies name, its instance after a colon when not 0, and #n for the n-th
+// (n >= 2) IE of that name and instance at its level; F-TEID fields are
+// leaves of their own.
+func flattenIEs(ies []ieJSON) map[string]string {
+	out := make(map[string]string)
+	var walk func(prefix string, ies []ieJSON)
+	walk = func(prefix string, ies []ieJSON) {
+		seen := make(map[string]int)
+		for _, ie := range ies {
+			name, ok := ieShortNames[ie.Type]
+			if !ok {
+				name = ie.Name
+			}
+			if ie.Instance != 0 {
+				name = fmt.Sprintf("%s:%d", name, ie.Instance)
+			}
+			if seen[name]++; seen[name] > 1 {
+				name = fmt.Sprintf("%s#%d", name, seen[name])
+			}
+			path := prefix + name
+			switch v := ie.Value.(type) {
+			case nil:
+				if len(ie.IEs) > 0 {
+					walk(path+".", ie.IEs)
+				} else {
+					out[path] = "raw:" + ie.Raw
+				}
+			case map[string]any:
+				for k, f := range v {
+					out[path+"."+k] = jsonString(f)
+				}
+			default:
+				out[path] = jsonString(v)
+			}
+		}
+	}
+	walk("", ies)
+	return out
+}
+
+func jsonString(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// allocatedLeaf reports whether a leaf holds a value the peer allocates,
+// which differs between peers however alike they behave.
+func allocatedLeaf(path string) bool {
+	last := path[strings.LastIndex(path, ".")+1:]
+	last, _, _ = strings.Cut(last, "#")
+	name, _, _ := strings.Cut(last, ":")
+	switch name {
+	case "teid", "ipv4", "ipv6", "PAA", "ChargingID", "Recovery":
+		return true
+	}
+	return false
+}
+
+// diffIEs compares two flattened answers, differences in path order.
+func diffIEs(a, b map[string]string) (same int, diffs []IEDiff) {
+	paths := make(map[string]bool, len(a)+len(b))
+	for p := range a {
+		paths[p] = true
+	}
+	for p := range b {
+		paths[p] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+	for _, p := range sorted {
+		if a[p] == b[p] {
+			same++
+			continue
+		}
+		diffs = append(diffs, IEDiff{Path: p, A: a[p], B: b[p], Allocated: allocatedLeaf(p)})
+	}
+	return same, diffs
+}
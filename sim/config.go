@@ -0,0 +1,418 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+// Config drives a Client. The zero value is not usable; start from
+// DefaultConfig and set at least Remote.
+type Config struct {
+	Local  string // local bind ip:port
+	Remote string // PGW ip:port; optional with Respond
+	// Peers, instead of Local and Remote, are several PGWs, each reached
+	// from its own local address (see Peer); sessions go to them in turn.
+	Peers    []Peer
+	NodeIP   net.IP // SGW IPv4 put inside the F-TEIDs
+	NodeIP6  net.IP // SGW global IPv6 also put inside the F-TEIDs; nil for IPv4 only
+	IMSI     string
+	MNCLen   int    // digits of the MNC in IMSI (2 or 3), for the PLMN in ULI and CSG IEs
+	MSISDN   string // omitted when empty
+	NoMSISDN bool   // leave MSISDN out whatever it holds (e.g. emergency attach)
+	APN      string
+	PDNType  string // ipv4|ipv6|ipv4v6
+	RATType  uint8
+	EBI      uint8
+	Bearers  []uint8 // EBIs of all bearers to create (must include EBI); empty means just EBI
+	QCI      uint8   // Bearer Level QoS of the bearers in the CSR
+	ARP      uint8   // ARP priority level (1..15) in that Bearer Level QoS
+	// BearerFlags, when non-zero, adds a Bearer Flags IE with this octet
+	// to each bearer context of the CSR (see ParseBearerFlags).
+	BearerFlags uint8
+	// SPLMNRateUL/DL, unless -1, send a Serving PLMN Rate Control IE with
+	// these limits (NAS data PDUs per 6 minutes, see checkSPLMNRate) in the
+	// CSR, for CIoT.
+	SPLMNRateUL, SPLMNRateDL int
+	// CSGID, unless -1, sends a User CSG Information IE for a femtocell:
+	// this CSG in the IMSI's PLMN with access mode CSGMode (0 closed, 1
+	// hybrid; see ParseCSGMode) and, in a hybrid cell, membership
+	// CSGMember (see checkCSG).
+	CSGID     int
+	CSGMode   uint8
+	CSGMember bool
+
+	// CSIDs, when set, are sent in an SGW FQ-CSID IE with node ID CSIDNode
+	// (default NodeIP); see checkFQCSID.
+	CSIDs    []uint16
+	CSIDNode string
+
+	// TEIDFixed puts FixedTEID in the header of ModifyBearer and
+	// DeleteSession requests instead of the PGW's control TEID, to check the
+	// peer rejects unknown TEIDs (see ParseTEIDSource).
+	TEIDFixed bool
+	FixedTEID uint32
+
+	// EPCO, when set, is sent as an Extended PCO IE, with the EPCOSI
+	// indication, in the CreateSessionRequest (see ParseEPCO).
+	EPCO []EPCOContainer
+
+	// RawIEs are appended as-is to the CreateSessionRequest (see ParseRawIE).
+	RawIEs []*gtpv2ie.IE
+	// Omit drops the named mandatory CSR IEs (see ParseOmit) for negative tests.
+	Omit map[string]bool
+	// BadInstance, when set, sends one of those IEs at a wrong instance.
+	BadInstance *BadInstance
+	// Sessions > 1 creates that many sessions, each with a random MSIN
+	// under IMSI's PLMN.
+	Sessions int
+	// APNs, when set, gives every subscriber one PDN connection per APN
+	// (default bearers EBI, EBI+1, ...); it replaces APN.
+	APNs []string
+	// Group labels the sessions for the per-group run report lines; empty
+	// groups them by APN. Subscriber rows may set their own.
+	Group string
+	// Warmup is how long after a load starts (CreateSessions, FindMaxRate)
+	// its CreateSessions and RTTs stay out of the statistics; 0 is none.
+	Warmup time.Duration
+	// Failures are the CreateSession failure limits CheckFailures enforces.
+	Failures FailureLimits
+	// Rate > 0 sends the CreateSessionRequests at that many per second,
+	// open-loop, instead of waiting for each answer.
+	Rate float64
+	// ClosedLoop > 0 keeps up to that many CreateSessions outstanding
+	// instead, starting from one and widening linearly to it over
+	// ClosedLoopRamp (see createClosedLoop); it excludes Rate.
+	ClosedLoop     int
+	ClosedLoopRamp time.Duration
+	// Burst sends all the CreateSessionRequests at once instead, as fast
+	// as they can go out, and logs how long the peer takes to answer them
+	// (see createBurst); it excludes Rate and ClosedLoop.
+	Burst bool
+	// Subscribers, when set, replace Sessions: one session per row, each
+	// with the row's identity, APN and PDN type (see LoadSubscribers).
+	Subscribers []Subscriber
+
+	EchoEvery     time.Duration // periodic EchoRequest; 0 disables
+	EchoRespDelay time.Duration // hold back EchoResponse to exercise the peer's echo timer
+	IgnoreEchoReq bool          // never answer EchoRequests (silent path)
+	Recovery      uint8         // restart counter in the Recovery IE of our Echos
+	NodeFeatures  int           // Sending Node Features octet in our Echos (see ParseNodeFeatures); -1 omits the IE
+	Timeout       time.Duration // response wait per transaction
+	T3            time.Duration // retransmit an unanswered request after T3; 0 disables
+	N3            int           // max retransmissions per request
+	AdaptiveT3    bool          // derive T3 from measured RTTs (T3 until enough samples)
+	StatsEvery    time.Duration // periodic stats line; 0 disables
+	RxWorkers     int
+	MaxInflight   int    // outstanding transactions before sends block; 0 is unlimited
+	BadLength     int    // added to every request's header length field (negative tests); 0 is off
+	SendBatch     int    // coalesce up to this many queued datagrams per sendmmsg (linux); 0 is off
+	WriteRetries  int    // extra attempts for a send failing with a transient error
+	RebindTries   int    // re-listen attempts on a socket broken by a fatal read error; 0 gives it up
+	DupSend       int    // send every request this many times back to back (peer dedup stress test); <= 1 is once
+	IPOut         string // append IMSI + PAA of accepted sessions here
+	FlowOut       string // session flow records: a file, or "udp:host:port"
+	DB            string // SQLite database of session rows and events, see sqlDB
+	JUnit         string // JUnit XML of the sessions and scenario steps, written by Report
+	StateFile     string // session store saved here periodically and by Report, and restored by NewClient
+	MetricsFile   string // OpenMetrics textfile written by Report (node_exporter textfile collector)
+	DSCP          int    // -1 leaves the socket default
+	DF            bool
+	RcvBuf        int           // SO_RCVBUF to request, bytes; 0 leaves the OS default
+	SndBuf        int           // SO_SNDBUF to request, bytes; 0 leaves the OS default
+	SrcPorts      int           // spread requests over this many local UDP ports; <= 1 uses only the bound one
+	RotateSrcPort bool          // retransmit from the next source port instead of the original's (negative test)
+	FD            int           // use this inherited, already bound UDP socket instead of Local; -1 binds Local
+	Connected     bool          // DialUDP to remote and use Write/Read (single peer only)
+	SpoofSrc      net.IP        // send GTP-C from a raw socket with this IPv4 source (root); nil uses the UDP socket
+	RxLoss        float64       // drop this fraction (0..1) of received datagrams (resilience tests); 0 is off
+	RxDelay       time.Duration // hold every received datagram this long before handling it
+	RxJitter      time.Duration // plus a random extra delay up to this
+	FollowPeer    bool          // send to wherever responses come from (NAT rewriting the port)
+	Debug         bool
+	DecodeJSON    bool // print each received message as one JSON object on stdout
+	LogIEs        bool // log the IE names of each received message, e.g. "CSRsp: Cause, F-TEID, PAA, BearerContext{...}"
+	SessionLog    bool // one line per session lifecycle (see logSession) instead of the per-message lines
+
+	// DSCPMap marks the datagrams of these message types with their DSCP
+	// instead of DSCP (linux; see ParseDSCPMap).
+	DSCPMap map[uint8]int
+	// TEIDField forces the header of requests of these message types to
+	// carry a TEID field (true) or none (false), whatever the type calls
+	// for, to probe how strictly the peer parses headers (see
+	// ParseTEIDField).
+	TEIDField map[uint8]bool
+	// IntegrityCheck hashes every datagram sent and checks what the other
+	// end receives, as is and parsed and marshaled again, against it. Only
+	// NewSelfTest pairs the two ends.
+	IntegrityCheck bool
+
+	// Received-message log filter by type (see ParseMsgTypes): with LogOnly
+	// set only those types are logged; LogExcept types never are.
+	LogOnly, LogExcept map[uint8]bool
+	Strict             bool // fail instead of warn on setup mismatches (node IP vs egress)
+
+	// RequireRespIEs are IEs an accepted CSRsp must carry (see
+	// ParseRequiredIEs); a CSRsp missing one fails its CreateSession.
+	RequireRespIEs []*Assertion
+
+	// GTP-U path check: Echo every GTPUEcho (0 disables) towards GTPURemote,
+	// default the -remote host on port 2152, from GTPULocal (default ephemeral).
+	GTPUEcho   time.Duration
+	GTPURemote string
+	GTPULocal  string
+	// TUN, when set, names the TUN interface StartTUN creates for the UE
+	// data path; the GTP-U socket then listens on the GTP-U port.
+	TUN string
+	// GTPUKeepalive echoes each session's PGW S5/S8-U address (port as for
+	// GTPURemote) every interval and tracks per-session path health.
+	GTPUKeepalive time.Duration
+
+	// Respond makes the client also play the PGW: incoming CSR/MBR/DSR are
+	// answered (accepted) instead of just logged.
+	Respond        bool
+	APNRestriction int   // APN Restriction value (0..4) in CSRsp; -1 omits the IE
+	RespCause      uint8 // reject every CSR with this cause (64..239); 0 accepts
+	DDNFailure     uint8 // answer a DDN with this cause in a DDN Failure Indication; 0 is off
+	// RespPiggybackCBR piggybacks a CreateBearerRequest for a dedicated
+	// bearer on every accepted CSRsp (see newPiggybackCBR).
+	RespPiggybackCBR bool
+
+	// Change Notification contents; CNRAT 0 means RATType.
+	CNRAT uint8
+	CNTAC uint16
+	CNECI uint32
+
+	// Trace Information IE (trace activation); TraceIP == nil disables it.
+	TraceMCC, TraceMNC string
+	TraceID            uint32
+	TraceDepth         uint8
+	TraceIP            net.IP
+
+	// Bearer Resource Command contents (see BearerResourceCommand): the
+	// requested Flow QoS and a Traffic Aggregate Description from ParseTAD.
+	BRCQoS FlowQoS
+	BRCTAD *gtpv2ie.IE
+
+	// PiggybackCBRsp answers a CreateBearerRequest piggybacked on a CSRsp
+	// with the CreateBearerResponse piggybacked on an MBR, as the MME sends
+	// it at attach, instead of on its own.
+	PiggybackCBRsp bool
+}
+
+// DefaultConfig returns the defaults the command line starts from.
+func DefaultConfig() Config {
+	return Config{
+		Local:          "0.0.0.0:2123",
+		NodeIP:         net.IPv4(127, 0, 0, 1),
+		IMSI:           "001010123456789",
+		MNCLen:         2,
+		MSISDN:         "919999999999",
+		APN:            "internet",
+		PDNType:        "ipv4",
+		RATType:        6,
+		EBI:            5,
+		QCI:            9,
+		ARP:            9,
+		Sessions:       1,
+		FD:             -1,
+		EchoEvery:      10 * time.Second,
+		Recovery:       1,
+		NodeFeatures:   -1,
+		Timeout:        5 * time.Second,
+		RxWorkers:      1,
+		RebindTries:    10,
+		DSCP:           -1,
+		APNRestriction: -1,
+		SPLMNRateUL:    -1,
+		SPLMNRateDL:    -1,
+		CSGID:          -1,
+		CNTAC:          1,
+		CNECI:          1,
+		Failures:       FailureLimits{MaxFailures: -1, MaxFailureRate: -1},
+	}
+}
+
+func (c *Config) validate() error {
+	if c.Remote == "" && !c.Respond && len(c.Peers) == 0 {
+		return errors.New("missing remote")
+	}
+	if err := c.checkPeers(); err != nil {
+		return err
+	}
+	if _, err := pdnTypeValue(c.PDNType); err != nil {
+		return err
+	}
+	if c.RespCause != 0 && (c.RespCause < 64 || c.RespCause > 239) {
+		return fmt.Errorf("resp cause %d is not a rejection cause (64..239)", c.RespCause)
+	}
+	if err := checkSPLMNRate(c.SPLMNRateUL, c.SPLMNRateDL); err != nil {
+		return err
+	}
+	if c.MNCLen != 2 && c.MNCLen != 3 {
+		return fmt.Errorf("MNC length %d must be 2 or 3", c.MNCLen)
+	}
+	if err := checkCSG(c.CSGID, c.CSGMode, c.CSGMember); err != nil {
+		return err
+	}
+	if c.StateFile != "" && c.Respond {
+		return errors.New("state file is for the initiator; the responder keeps no sessions of its own")
+	}
+	if c.APNRestriction < -1 || c.APNRestriction > 4 {
+		return fmt.Errorf("apn restriction %d must be 0..4 (or -1)", c.APNRestriction)
+	}
+	if c.NodeIP.To4() == nil {
+		return fmt.Errorf("invalid node IP %v (must be IPv4)", c.NodeIP)
+	}
+	if c.NodeIP6 != nil {
+		switch ip := c.NodeIP6; {
+		case ip.To4() != nil || len(ip) != net.IPv6len:
+			return fmt.Errorf("invalid node IPv6 %v (must be IPv6)", ip)
+		case ip.IsLinkLocalUnicast() || ip.IsUnspecified() || ip.IsMulticast():
+			return fmt.Errorf("node IPv6 %v can't go in an F-TEID: the peer needs a global address (zones and link-local addresses belong in -local/-remote)", ip)
+		}
+	}
+	if c.NodeFeatures < -1 || c.NodeFeatures > 255 {
+		return fmt.Errorf("node features %d must be 0..255 (or -1)", c.NodeFeatures)
+	}
+	if c.DSCP < -1 || c.DSCP > 63 {
+		return fmt.Errorf("dscp %d must be 0..63 (or -1)", c.DSCP)
+	}
+	for t, dscp := range c.DSCPMap {
+		if dscp < 0 || dscp > 63 {
+			return fmt.Errorf("dscp %d for %s must be 0..63", dscp, msgName(t))
+		}
+	}
+	if c.RcvBuf < 0 || c.SndBuf < 0 {
+		return errors.New("socket buffer sizes must be >= 0")
+	}
+	if c.Sessions < 1 && !(c.Sessions == 0 && c.StateFile != "") {
+		// 0 is for follow-ups on the sessions of a state file only.
+		return errors.New("sessions must be >= 1 (or 0 with a state file)")
+	}
+	if c.Sessions > 1 && len(c.IMSI) < 8 {
+		// Leave at least three random MSIN digits to draw from.
+		return fmt.Errorf("imsi %q too short to derive %d session IMSIs", c.IMSI, c.Sessions)
+	}
+	if c.FollowPeer && c.Connected {
+		return errors.New("follow-peer needs an unconnected socket (drop -connect)")
+	}
+	if c.T3 < 0 || c.N3 < 0 {
+		return errors.New("t3 and n3 must be >= 0")
+	}
+	if c.AdaptiveT3 && c.T3 == 0 {
+		return errors.New("adaptive t3 needs a fixed t3 to start from")
+	}
+	if c.SpoofSrc != nil {
+		if c.SpoofSrc.To4() == nil {
+			return fmt.Errorf("spoof source %v must be IPv4", c.SpoofSrc)
+		}
+		if c.Connected || c.SendBatch > 0 {
+			return errors.New("spoof-src cannot be combined with connect or send-batch")
+		}
+	}
+	if c.FD >= 0 && c.Connected {
+		return errors.New("fd and connect cannot be combined")
+	}
+	if c.DupSend < 0 {
+		return errors.New("dup-send must be >= 0")
+	}
+	if c.IntegrityCheck && c.BadLength != 0 {
+		return errors.New("integrity check and bad length cannot be combined: every skewed request would be reported as corrupted")
+	}
+	if c.RebindTries < 0 {
+		return errors.New("rebind attempts must be >= 0")
+	}
+	if c.WriteRetries < 0 {
+		return errors.New("write retries must be >= 0")
+	}
+	if c.Rate < 0 {
+		return errors.New("rate must be >= 0")
+	}
+	if c.ClosedLoop < 0 || c.ClosedLoopRamp < 0 {
+		return errors.New("closed-loop concurrency and ramp must be >= 0")
+	}
+	if c.ClosedLoop > 0 && c.Rate > 0 {
+		return errors.New("closed-loop and rate cannot be combined")
+	}
+	if c.Burst && (c.Rate > 0 || c.ClosedLoop > 0) {
+		return errors.New("burst cannot be combined with rate or closed-loop")
+	}
+	if c.SendBatch < 0 {
+		return errors.New("send batch must be >= 0")
+	}
+	if c.MaxInflight < 0 {
+		return errors.New("max inflight must be >= 0")
+	}
+	if c.RxWorkers < 1 {
+		return errors.New("rx workers must be >= 1")
+	}
+	if c.CNECI > 0x0fffffff {
+		return fmt.Errorf("change notification ECI 0x%x exceeds 28 bits", c.CNECI)
+	}
+	if c.TraceIP != nil && c.TraceMCC == "" {
+		return errors.New("trace IP set without a trace reference")
+	}
+	if c.BRCTAD != nil {
+		if err := c.BRCQoS.check(); err != nil {
+			return err
+		}
+	}
+	if len(c.CSIDs) > 0 {
+		node := c.CSIDNode
+		if node == "" {
+			node = c.NodeIP.String()
+		}
+		if err := checkFQCSID(node, c.CSIDs); err != nil {
+			return err
+		}
+	}
+	if n := len(c.APNs); n > 0 {
+		if len(c.Bearers) > 0 {
+			return errors.New("apns and bearers cannot be combined")
+		}
+		seen := make(map[string]bool, n)
+		for _, apn := range c.APNs {
+			if seen[apn] {
+				return fmt.Errorf("apn %q listed twice", apn)
+			}
+			seen[apn] = true
+		}
+		if int(c.EBI)+n-1 > 15 {
+			return fmt.Errorf("%d apns need EBIs %d..%d, beyond 15", n, c.EBI, int(c.EBI)+n-1)
+		}
+	}
+	if c.SrcPorts > 1 && (c.Connected || c.FD >= 0 || c.SpoofSrc != nil || c.SendBatch > 0) {
+		return errors.New("src-ports cannot be combined with connected, fd, spoof-src or send-batch")
+	}
+	if c.RotateSrcPort && c.SrcPorts < 2 {
+		return errors.New("rotate-src-port needs src-ports >= 2")
+	}
+	if c.RxLoss < 0 || c.RxLoss > 1 {
+		return fmt.Errorf("rx loss %g must be 0..1", c.RxLoss)
+	}
+	if c.RxDelay < 0 || c.RxJitter < 0 {
+		return errors.New("rx delay and jitter must be >= 0")
+	}
+	if c.Warmup < 0 {
+		return fmt.Errorf("warmup %s must be >= 0", c.Warmup)
+	}
+	if c.Failures.MaxFailureRate > 1 {
+		return fmt.Errorf("max failure rate %g is above 1", c.Failures.MaxFailureRate)
+	}
+	if c.Failures.SLA < 0 {
+		return fmt.Errorf("sla %s must be >= 0", c.Failures.SLA)
+	}
+	if r := c.Failures.MaxSLARate; r < 0 || r > 1 {
+		return fmt.Errorf("max sla violation rate %g is not in 0..1", r)
+	}
+	if len(c.Bearers) > 0 {
+		if err := checkBearerEBIs(c.EBI, c.Bearers); err != nil {
+			return err
+		}
+	}
+	return validateIMSI(c.IMSI)
+}
@@ -0,0 +1,74 @@
+package sim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// consoleHelp lists the console commands.
+const consoleHelp = `commands:
+  resend SEQ     send request SEQ again, byte for byte
+  resend [last]  send the last request sent again
+  sent [N]       list the sequence numbers of the last N (10) requests sent
+  help           this text`
+
+// Console reads commands from r, one per line, until it is exhausted, for
+// poking at the peer while the sessions are held (see consoleHelp).
+func (c *Client) Console(r io.Reader) {
+	in := bufio.NewScanner(r)
+	for in.Scan() {
+		f := strings.Fields(in.Text())
+		if len(f) == 0 {
+			continue
+		}
+		if err := c.consoleCmd(f[0], f[1:]); err != nil {
+			log.Printf("console: %v", err)
+		}
+	}
+}
+
+func (c *Client) consoleCmd(cmd string, args []string) error {
+	arg := func(def uint64) (uint64, error) {
+		if len(args) == 0 {
+			return def, nil
+		}
+		if len(args) > 1 {
+			return 0, fmt.Errorf("%s: takes at most one argument", cmd)
+		}
+		v, err := strconv.ParseUint(args[0], 0, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %q is not a number", cmd, args[0])
+		}
+		return v, nil
+	}
+	switch cmd {
+	case "resend":
+		// Not a default SEQ of 0: 0 is a sequence number like any other.
+		if len(args) == 0 || len(args) == 1 && args[0] == "last" {
+			return c.ResendLast()
+		}
+		seq, err := arg(0)
+		if err != nil {
+			return err
+		}
+		return c.Resend(uint32(seq))
+	case "sent":
+		n, err := arg(10)
+		if err != nil {
+			return err
+		}
+		for _, seq := range c.sent.recent(int(n)) {
+			r, _ := c.sent.get(seq)
+			log.Printf("sent seq=%d %s (%d B)", seq, msgName(r.b[1]), len(r.b))
+		}
+		return nil
+	case "help":
+		log.Print(consoleHelp)
+		return nil
+	}
+	return fmt.Errorf("unknown command %q (try help)", cmd)
+}
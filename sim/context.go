@@ -0,0 +1,172 @@
+package sim
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	gtp "github.com/wmnsk/go-gtp"
+	gtpv2 "github.com/wmnsk/go-gtp/gtpv2"
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// Complete Request Message types (TS 29.274 8.46).
+const (
+	completeAttachRequest = 0
+	completeTAURequest    = 1
+)
+
+// UEContext is what a ContextResponse told us about a UE.
+type UEContext struct {
+	IMSI           string
+	MMContext      *MMContext // nil when the response carried none
+	PDNConnections []PDNConnection
+	PeerTEID       uint32 // the old MME's S10 control TEID, from its Sender F-TEID
+}
+
+// PDNConnection summarises one PDN Connection IE of a ContextResponse.
+type PDNConnection struct {
+	APN  string
+	EBIs []uint8 // of its bearer contexts
+}
+
+// ParseTAURequest decodes the hex NAS TAU Request for the Complete TAU
+// Request Message IE of a ContextRequest.
+func ParseTAURequest(s string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("tau request %q: want non-empty hex", s)
+	}
+	return b, nil
+}
+
+// RequestContext plays the new MME of an inter-MME TAU (S10): it asks the
+// peer, the old MME, for the context of the UE known by guti with a
+// ContextRequest (carrying tau as the Complete TAU Request Message, if set),
+// decodes the ContextResponse and confirms it with a ContextAcknowledge on
+// the response's sequence number.
+func (c *Client) RequestContext(guti GUTI, tau []byte) (*UEContext, error) {
+	cfg := c.cfg
+	localTEID := randUint32()
+	seq := c.seq.next()
+	ies := []*gtpv2ie.IE{
+		gtpv2ie.NewGUTI(guti.MCC, guti.MNC, guti.MMEGI, guti.MMEC, guti.MTMSI),
+		newNodeFTEID(cfg, gtpv2.IFTypeS10MMEGTPC, localTEID).WithInstance(0),
+		gtpv2ie.NewRATType(cfg.RATType),
+	}
+	if len(tau) > 0 {
+		ies = append(ies, gtpv2ie.New(gtpv2ie.CompleteRequestMessage, 0, append([]byte{completeTAURequest}, tau...)))
+	}
+	req := gtpv2msg.NewContextRequest(0, seq, ies...)
+
+	c.msgLogf("tx ContextReq seq=%d guti=%s localCTeid=0x%08x tau=%dB -> %s", seq, guti, localTEID, len(tau), c.tr.remote())
+	m, rtt, err := c.transact(req)
+	if err != nil {
+		return nil, err
+	}
+	var causeIE *gtpv2ie.IE
+	resp, _ := m.(*gtpv2msg.ContextResponse)
+	if resp != nil {
+		causeIE = resp.Cause
+	}
+	cause, err := c.checkResponse(req, m, gtpv2msg.MsgTypeContextResponse, causeIE)
+	if err != nil {
+		return nil, err
+	}
+
+	uc := &UEContext{}
+	if resp.IMSI != nil {
+		uc.IMSI = tbcdDigits(resp.IMSI.Payload)
+	}
+	// go-gtp files the MM Context under AdditionalIEs (its nil check in
+	// ContextResponse parsing is inverted), so look there too.
+	mm := resp.UEMMContext
+	for _, i := range resp.AdditionalIEs {
+		if mm == nil && isMMContext(i.Type) {
+			mm = i
+		}
+	}
+	if mm != nil {
+		if uc.MMContext, err = mmContext(mm); err != nil {
+			log.Printf("ContextRsp: %v", err)
+		}
+	}
+	if resp.SenderFTEID != nil {
+		uc.PeerTEID, _ = resp.SenderFTEID.TEID()
+	}
+	for _, pdn := range resp.UEPDNConnections {
+		uc.PDNConnections = append(uc.PDNConnections, pdnConnection(pdn))
+	}
+	c.msgLogf("ContextReq succeeded seq=%d rtt=%s cause=%d imsi=%s peer teid=0x%08x", seq, rtt, cause, uc.IMSI, uc.PeerTEID)
+	if mm := uc.MMContext; mm != nil {
+		log.Printf("ContextRsp %s: security mode=%d ksi=%d vectors=%d quadruplets=%d",
+			mm.Name, mm.SecurityMode, mm.KSI, mm.Vectors, mm.Quadruplets)
+	}
+	for _, p := range uc.PDNConnections {
+		log.Printf("ContextRsp PDN connection: apn=%s ebis=%v", p.APN, p.EBIs)
+	}
+
+	ack := gtpv2msg.NewContextAcknowledge(uc.PeerTEID, seq,
+		gtpv2ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil))
+	b, err := gtp.Marshal(ack)
+	if err != nil {
+		c.tr.st.countErr(TxnParse)
+		return uc, &TxnError{Kind: TxnParse, MsgType: ack.MessageType(), Seq: seq, Peer: c.tr.remote(), Err: err}
+	}
+	if err := c.tr.send(b); err != nil {
+		c.tr.st.countErr(TxnTransport)
+		return uc, &TxnError{Kind: TxnTransport, MsgType: ack.MessageType(), Seq: seq, Peer: c.tr.remote(), Err: err}
+	}
+	c.msgLogf("tx ContextAck seq=%d teid=0x%08x cause=%d", seq, uc.PeerTEID, gtpv2.CauseRequestAccepted)
+	return uc, nil
+}
+
+// pdnConnection pulls the APN and bearer EBIs out of a PDN Connection IE.
+func pdnConnection(pdn *gtpv2ie.IE) PDNConnection {
+	var p PDNConnection
+	for _, i := range pdn.ChildIEs {
+		switch i.Type {
+		case gtpv2ie.AccessPointName:
+			var err error
+			if p.APN, err = decodeAPN(i.Payload); err != nil {
+				log.Printf("ContextRsp PDN connection: %v", err)
+			}
+		case gtpv2ie.BearerContext:
+			p.EBIs = append(p.EBIs, bearerEBI(i))
+		}
+	}
+	return p
+}
+
+// answerContextReq plays the old MME: every ContextRequest is answered with
+// the configured subscriber (IMSI, APN, default bearer) and a minimal EPS
+// security MM context. The ContextAcknowledge that follows is just logged.
+func (c *Client) answerContextReq(req *gtpv2msg.ContextRequest, peer *net.UDPAddr) {
+	cfg := c.cfg
+	var newMME uint32
+	if req.AddressAndTEIDForCPlane != nil {
+		newMME, _ = req.AddressAndTEIDForCPlane.TEID()
+	}
+	imsi, err := newIMSI(cfg.IMSI)
+	if err != nil {
+		log.Printf("responder: ContextReq: %v", err)
+		return
+	}
+	oldMME := randUint32()
+	c.reply(gtpv2msg.NewContextResponse(newMME, req.Sequence(),
+		gtpv2ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
+		imsi,
+		// EPS security context, security mode 4 (EPS), KSI 0, no vectors.
+		gtpv2ie.New(gtpv2ie.MMContextEPSSecurityContextQuadrupletsAndQuintuplets, 0, []byte{0x80, 0, 0, 0, 0}),
+		gtpv2ie.NewGroupedIE(gtpv2ie.PDNConnection,
+			gtpv2ie.NewAccessPointName(cfg.APN),
+			gtpv2ie.NewEPSBearerID(cfg.EBI), // LBI
+			gtpv2ie.NewBearerContext(gtpv2ie.NewEPSBearerID(cfg.EBI)),
+		),
+		newNodeFTEID(cfg, gtpv2.IFTypeS10MMEGTPC, oldMME).WithInstance(0),
+	), peer)
+	c.rxLogf(req.MessageType(), "rx ContextReq from %s seq=%d -> ContextRsp imsi=%s teid=0x%08x", peer, req.Sequence(), cfg.IMSI, oldMME)
+}
@@ -0,0 +1,104 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+// maxCSGID is the largest CSG ID, which has 27 bits (TS 23.003 4.7).
+const maxCSGID = 1<<27 - 1
+
+// csgModeNames are the User CSG Information access modes (TS 29.274
+// 8.75) by value; 2 and 3 are reserved.
+var csgModeNames = []string{"closed", "hybrid"}
+
+// ParseCSGMode parses a -csg-mode value: closed or hybrid (any case).
+func ParseCSGMode(s string) (uint8, error) {
+	for v, n := range csgModeNames {
+		if strings.EqualFold(n, strings.TrimSpace(s)) {
+			return uint8(v), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown CSG access mode %q (want closed or hybrid)", s)
+}
+
+// checkCSG validates the User CSG Information settings: a CSG ID of
+// 0..2^27-1 (or -1, no IE) and an assigned access mode. A UE in a closed
+// cell is always a member, so CSGMember is only for hybrid cells.
+func checkCSG(id int, mode uint8, member bool) error {
+	if id == -1 {
+		return nil
+	}
+	if id < 0 || id > maxCSGID {
+		return fmt.Errorf("CSG ID %d must be 0..%d (27 bits)", id, maxCSGID)
+	}
+	if int(mode) >= len(csgModeNames) {
+		return fmt.Errorf("CSG access mode %d is reserved", mode)
+	}
+	if member && mode == 0 {
+		return errors.New("CSG membership only applies to hybrid access mode; in a closed cell the UE is always a member")
+	}
+	return nil
+}
+
+// newUserCSGInformation builds the CSR's User CSG Information IE: the CSG
+// in the IMSI's PLMN, its access mode and the CSG Membership Indication,
+// set in a closed cell and as configured in a hybrid one. LCSG is 0.
+func newUserCSGInformation(cfg Config) *gtpv2ie.IE {
+	mcc, mnc := plmnFromIMSI(cfg.IMSI, cfg.MNCLen)
+	var cmi uint8
+	if cfg.CSGMode == 0 || cfg.CSGMember {
+		cmi = 1
+	}
+	return gtpv2ie.NewUserCSGInformation(mcc, mnc, uint32(cfg.CSGID), cfg.CSGMode, 0, cmi)
+}
+
+// uciString renders a User CSG Information IE, e.g. "001-01 csg-id=0x0000abc
+// hybrid member".
+func uciString(i *gtpv2ie.IE) string {
+	f, err := i.UserCSGInformation()
+	if err != nil {
+		return fmt.Sprintf("malformed User CSG Information: %v", err)
+	}
+	mode := fmt.Sprintf("mode%d", f.AccessMode)
+	if int(f.AccessMode) < len(csgModeNames) {
+		mode = csgModeNames[f.AccessMode]
+	}
+	s := fmt.Sprintf("%s-%s csg-id=0x%07x %s", f.MCC, f.MNC, f.CSGID, mode)
+	if f.Flags&0x01 != 0 {
+		s += " member"
+	} else {
+		s += " non-member"
+	}
+	if f.Flags&0x02 != 0 {
+		s += " leaving"
+	}
+	return s
+}
+
+// csgIRANames are the CSG Information Reporting Action flags (TS 29.274
+// 8.76), bit 1 first: report changes of the UE's CSG cell, of its hybrid
+// cell as a subscribed member and as an unsubscribed one.
+var csgIRANames = []string{"UCICSG", "UCISHC", "UCIUHC"}
+
+// csgIRAString renders a CSG Information Reporting Action IE, e.g.
+// "UCICSG,UCISHC (0x03)", or "none" when it stops the reporting.
+func csgIRAString(i *gtpv2ie.IE) string {
+	if len(i.Payload) < 1 {
+		return "malformed CSG Information Reporting Action: empty"
+	}
+	v := i.Payload[0]
+	var names []string
+	for k, n := range csgIRANames {
+		if v&(1<<k) != 0 {
+			names = append(names, n)
+		}
+	}
+	if len(names) == 0 {
+		names = append(names, "none")
+	}
+	return fmt.Sprintf("%s (0x%02x)", strings.Join(names, ","), v)
+}
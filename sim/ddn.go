@@ -0,0 +1,115 @@
+package sim
+
+import (
+	"fmt"
+	"net"
+
+	gtpv2 "github.com/wmnsk/go-gtp/gtpv2"
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// Originating Node values (TS 29.274 8.34) of a DDN Failure Indication.
+var ddnNodeNames = map[uint8]string{0: "MME", 1: "SGSN"}
+
+// ddnNodeMME is the Originating Node the responder puts in its failure
+// indications.
+const ddnNodeMME uint8 = 0
+
+// DownlinkDataNotification tells the MME, as the SGW would on S11, that
+// downlink data is buffered for sess's default bearer, and checks the
+// DownlinkDataNotificationAcknowledge cause. If paging then fails the MME
+// sends a DownlinkDataNotificationFailureIndication, which is logged when
+// it arrives.
+func (c *Client) DownlinkDataNotification(sess *Session) error {
+	seq := c.seq.next()
+	imsiIE, err := newIMSI(sess.IMSI)
+	if err != nil {
+		return err
+	}
+	req := gtpv2msg.NewDownlinkDataNotification(sess.RemoteCTEID, seq,
+		gtpv2ie.NewEPSBearerID(sess.EBI),
+		gtpv2ie.NewAllocationRetensionPriority(0, c.cfg.ARP, 0),
+		imsiIE,
+	)
+
+	c.msgLogf("tx DDN seq=%d teid=0x%08x imsi=%s ebi=%d", seq, sess.RemoteCTEID, sess.IMSI, sess.EBI)
+	m, rtt, err := c.transactTo(req, c.peerOf(sess))
+	if err != nil {
+		sess.record("DDN", 0, err)
+		return err
+	}
+	var causeIE *gtpv2ie.IE
+	if resp, ok := m.(*gtpv2msg.DownlinkDataNotificationAcknowledge); ok {
+		causeIE = resp.Cause
+	}
+	cause, err := c.checkResponse(req, m, gtpv2msg.MsgTypeDownlinkDataNotificationAcknowledge, causeIE)
+	sess.record("DDN", cause, err)
+	if err != nil {
+		return err
+	}
+	c.msgLogf("DDN succeeded seq=%d rtt=%s cause=%d", seq, rtt, cause)
+	return nil
+}
+
+// rxDDNFailure logs a DownlinkDataNotificationFailureIndication: paging for
+// one of our sessions failed. It has no response.
+func (c *Client) rxDDNFailure(m *gtpv2msg.DownlinkDataNotificationFailureIndication, peer *net.UDPAddr) {
+	cause, node, imsi := "missing", "-", "-"
+	if m.Cause != nil {
+		if v, err := m.Cause.Cause(); err == nil {
+			cause = causeName(v)
+		}
+	}
+	if m.OriginatingNode != nil {
+		if v, err := m.OriginatingNode.NodeType(); err == nil {
+			node = ddnNodeNames[v]
+			if node == "" {
+				node = fmt.Sprintf("%d", v)
+			}
+		}
+	}
+	if m.IMSI != nil {
+		imsi, _ = m.IMSI.IMSI()
+	}
+	sess := "unknown session"
+	if s := c.sessions.get(m.TEID()); s != nil {
+		sess = "session imsi=" + s.IMSI
+	}
+	c.rxLogf(m.MessageType(), "rx DDNFailureIndication from %s teid=0x%08x seq=%d (%s): cause %s, originating node %s, imsi %s",
+		peer, m.TEID(), m.Sequence(), sess, cause, node, imsi)
+}
+
+// newDDNFailureIndication builds a DownlinkDataNotificationFailureIndication
+// from the MME with cause, for the SGW's TEID.
+func newDDNFailureIndication(teid, seq uint32, cause uint8, imsi *gtpv2ie.IE) *gtpv2msg.DownlinkDataNotificationFailureIndication {
+	return gtpv2msg.NewDownlinkDataNotificationFailureIndication(teid, seq,
+		gtpv2ie.NewCause(cause, 0, 0, 0, nil),
+		gtpv2ie.NewNodeType(ddnNodeMME),
+		imsi,
+	)
+}
+
+// answerDDN plays the MME: it acknowledges a DownlinkDataNotification for a
+// session we created and, with Config.DDNFailure set, follows up with a
+// DownlinkDataNotificationFailureIndication with that cause, as if paging
+// the UE had failed.
+func (c *Client) answerDDN(req *gtpv2msg.DownlinkDataNotification, peer *net.UDPAddr) {
+	sgw, ok := c.rs.sgwTEID(req.TEID(), false)
+	if !ok {
+		c.reply(gtpv2msg.NewDownlinkDataNotificationAcknowledge(0, req.Sequence(),
+			gtpv2ie.NewCause(gtpv2.CauseContextNotFound, 0, 0, 0, nil)), peer)
+		c.rxLogf(req.MessageType(), "rx DDN from %s teid=0x%08x seq=%d -> DDN Ack context not found", peer, req.TEID(), req.Sequence())
+		return
+	}
+	c.reply(gtpv2msg.NewDownlinkDataNotificationAcknowledge(sgw, req.Sequence(),
+		gtpv2ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil)), peer)
+	if c.cfg.DDNFailure == 0 {
+		c.rxLogf(req.MessageType(), "rx DDN from %s teid=0x%08x seq=%d -> DDN Ack accepted", peer, req.TEID(), req.Sequence())
+		return
+	}
+	seq := c.seq.next()
+	c.reply(newDDNFailureIndication(sgw, seq, c.cfg.DDNFailure, req.IMSI), peer)
+	c.rxLogf(req.MessageType(), "rx DDN from %s teid=0x%08x seq=%d -> DDN Ack accepted, then DDNFailureIndication seq=%d cause %s",
+		peer, req.TEID(), req.Sequence(), seq, causeName(c.cfg.DDNFailure))
+}
@@ -0,0 +1,212 @@
+package sim
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// msgJSON is one received message in -decode-json output.
+type msgJSON struct {
+	Time  string   `json:"time"`
+	Peer  string   `json:"peer"`
+	Type  uint8    `json:"type"`
+	Name  string   `json:"name"`
+	TEID  *uint32  `json:"teid,omitempty"` // absent when the header carries none
+	Seq   uint32   `json:"seq"`
+	IEs   []ieJSON `json:"ies"`
+	Error string   `json:"error,omitempty"`
+}
+
+// ieJSON is one IE; Value holds the decoded form for the IEs we know, Raw
+// the payload hex otherwise. Grouped IEs list their children instead.
+type ieJSON struct {
+	Type     uint8    `json:"type"`
+	Name     string   `json:"name"`
+	Instance uint8    `json:"instance"`
+	Value    any      `json:"value,omitempty"`
+	Raw      string   `json:"raw,omitempty"`
+	IEs      []ieJSON `json:"ies,omitempty"`
+}
+
+// jsonDecoder writes one JSON object per received GTPv2 packet. Writes are
+// serialized since several rx workers may decode at once.
+type jsonDecoder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newJSONDecoder(w io.Writer) *jsonDecoder {
+	return &jsonDecoder{enc: json.NewEncoder(w)}
+}
+
+func (d *jsonDecoder) write(pkt []byte, peer *net.UDPAddr) {
+	out := msgJSON{Time: time.Now().Format(time.RFC3339Nano), Peer: peer.String(), IEs: []ieJSON{}}
+	h, err := gtpv2msg.ParseHeader(pkt)
+	if err != nil {
+		out.Error = err.Error()
+	} else {
+		out.Type, out.Name, out.Seq = h.MessageType(), msgName(h.MessageType()), h.Sequence()
+		if h.HasTEID() {
+			teid := h.TEID
+			out.TEID = &teid
+		}
+		ies, err := gtpv2ie.ParseMultiIEs(h.Payload)
+		if err != nil {
+			out.Error = err.Error()
+		}
+		for _, i := range ies {
+			out.IEs = append(out.IEs, ieToJSON(i))
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_ = d.enc.Encode(out)
+}
+
+func ieToJSON(i *gtpv2ie.IE) ieJSON {
+	j := ieJSON{Type: i.Type, Name: i.Name(), Instance: i.Instance()}
+	if i.IsGrouped() {
+		for _, c := range i.ChildIEs {
+			j.IEs = append(j.IEs, ieToJSON(c))
+		}
+		return j
+	}
+	if v, ok := ieValue(i); ok {
+		j.Value = v
+	} else {
+		j.Raw = hex.EncodeToString(i.Payload)
+	}
+	return j
+}
+
+// ieValue decodes the IEs this tool deals with; ok is false for the rest.
+func ieValue(i *gtpv2ie.IE) (v any, ok bool) {
+	var err error
+	switch i.Type {
+	case gtpv2ie.IMSI, gtpv2ie.MSISDN:
+		v = tbcdDigits(i.Payload)
+	case gtpv2ie.Cause:
+		v, err = i.Cause()
+	case gtpv2ie.RATType:
+		v, err = i.RATType()
+	case gtpv2ie.Recovery:
+		v, err = i.Recovery()
+	case gtpv2ie.BearerFlags:
+		var f uint8
+		if f, err = i.BearerFlags(); err == nil {
+			v = bearerFlagsString(f)
+		}
+	case gtpv2ie.NodeFeatures:
+		var f uint8
+		if f, err = i.NodeFeatures(); err == nil {
+			v = nodeFeaturesString(f)
+		}
+	case gtpv2ie.EPSBearerID:
+		v, err = i.EPSBearerID()
+	case gtpv2ie.PDNType:
+		v, err = i.PDNType()
+	case gtpv2ie.ChargingID:
+		v, err = i.ChargingID()
+	case gtpv2ie.APNRestriction:
+		v, err = i.APNRestriction()
+	case gtpv2ie.ServingPLMNRateControl:
+		if _, _, err = servingPLMNRateControl(i); err == nil {
+			v = splmnRateString(i)
+		}
+	case gtpv2ie.UserCSGInformation:
+		if _, err = i.UserCSGInformation(); err == nil {
+			v = uciString(i)
+		}
+	case gtpv2ie.CSGInformationReportingAction:
+		v = csgIRAString(i)
+	case gtpv2ie.AccessPointName:
+		v, err = decodeAPN(i.Payload)
+	case gtpv2ie.PDNAddressAllocation:
+		v = paaString(i)
+	case gtpv2ie.FullyQualifiedCSID:
+		v = fqcsidString(i)
+	case gtpv2ie.Indication:
+		v = indicationFlags(i)
+	case gtpv2ie.FullyQualifiedTEID:
+		f := map[string]any{}
+		if f["interface"], err = i.InterfaceType(); err != nil {
+			break
+		}
+		if f["teid"], err = i.TEID(); err != nil {
+			break
+		}
+		if ip, err := i.IPv4(); err == nil && ip != nil {
+			f["ipv4"] = ip.String()
+		}
+		if ip, err := i.IPv6(); err == nil && ip != nil {
+			f["ipv6"] = ip.String()
+		}
+		v = f
+	default:
+		return nil, false
+	}
+	return v, err == nil
+}
+
+// ieShortNames are the names -log-ies uses for IEs whose library names are
+// long; the rest keep those.
+var ieShortNames = map[uint8]string{
+	gtpv2ie.FullyQualifiedTEID:                   "F-TEID",
+	gtpv2ie.PDNAddressAllocation:                 "PAA",
+	gtpv2ie.EPSBearerID:                          "EBI",
+	gtpv2ie.AccessPointName:                      "APN",
+	gtpv2ie.AggregateMaximumBitRate:              "AMBR",
+	gtpv2ie.ProtocolConfigurationOptions:         "PCO",
+	gtpv2ie.ExtendedProtocolConfigurationOptions: "ePCO",
+	gtpv2ie.UserLocationInformation:              "ULI",
+	gtpv2ie.FullyQualifiedCSID:                   "FQ-CSID",
+	gtpv2ie.ProcedureTransactionID:               "PTI",
+	gtpv2ie.BearerTFT:                            "TFT",
+	gtpv2ie.UserCSGInformation:                   "UCI",
+}
+
+// ieList renders ies by name, with the instance after a colon when it is
+// not 0 and the children of grouped IEs in braces.
+func ieList(ies []*gtpv2ie.IE) string {
+	parts := make([]string, len(ies))
+	for k, i := range ies {
+		name, ok := ieShortNames[i.Type]
+		if !ok {
+			name = i.Name()
+		}
+		if inst := i.Instance(); inst != 0 {
+			name = fmt.Sprintf("%s:%d", name, inst)
+		}
+		if i.IsGrouped() {
+			name += "{" + ieList(i.ChildIEs) + "}"
+		}
+		parts[k] = name
+	}
+	return strings.Join(parts, ", ")
+}
+
+// logIEs logs which IEs a received message carries (Config.LogIEs): a
+// middle ground between the one-line rx logs and -decode-json. Datagrams
+// that don't parse are left to the normal handling to report.
+func (c *Client) logIEs(pkt []byte, peer *net.UDPAddr) {
+	h, err := gtpv2msg.ParseHeader(pkt)
+	if err != nil {
+		return
+	}
+	ies, err := gtpv2ie.ParseMultiIEs(h.Payload)
+	list := ieList(ies)
+	if err != nil {
+		list += fmt.Sprintf(" (then unparseable: %v)", err)
+	}
+	c.rxLogf(h.MessageType(), "rx %s from %s seq=%d IEs: %s", msgName(h.MessageType()), peer, h.Sequence(), list)
+}
@@ -0,0 +1,125 @@
+package sim
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// dupSettle is how long after a Config.DupSend transaction has ended the
+// answers to its copies still count.
+const dupSettle = time.Second
+
+// dupProbe is one request sent as several identical copies.
+type dupProbe struct {
+	reqType uint8
+	copies  int
+	first   []byte // the first answer, as received
+	answers int
+	differ  int // answers not byte for byte the first one
+}
+
+// dupSends tracks the requests Config.DupSend sends several times: how
+// many answers each copy drew and whether they were identical, which shows
+// how the peer's retransmission handling copes.
+type dupSends struct {
+	mu                                               sync.Mutex
+	m                                                map[uint32]*dupProbe
+	requests, datagrams, answers, differ, unanswered int
+}
+
+func (d *dupSends) start(seq uint32, reqType uint8, copies int) {
+	d.mu.Lock()
+	if d.m == nil {
+		d.m = make(map[uint32]*dupProbe)
+	}
+	d.m[seq] = &dupProbe{reqType: reqType, copies: copies}
+	d.mu.Unlock()
+}
+
+// observe counts pkt, parsed as m, if it answers a tracked request: GTPv2
+// response types follow their request's.
+func (d *dupSends) observe(pkt []byte, m gtpv2msg.Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p := d.m[m.Sequence()]
+	if p == nil || m.MessageType() != p.reqType+1 {
+		return
+	}
+	p.answers++
+	switch {
+	case p.first == nil:
+		p.first = slices.Clone(pkt)
+	case !bytes.Equal(p.first, pkt):
+		p.differ++
+	}
+}
+
+// finish stops tracking seq and returns what its copies drew.
+func (d *dupSends) finish(seq uint32) *dupProbe {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p := d.m[seq]
+	delete(d.m, seq)
+	if p == nil {
+		return nil
+	}
+	d.requests++
+	d.datagrams += p.copies
+	d.answers += p.answers
+	d.differ += p.differ
+	if p.answers == 0 {
+		d.unanswered++
+	}
+	return p
+}
+
+// sendDups sends the extra Config.DupSend-1 copies of the request b, seq,
+// to the original's PGW to, right behind it from the same socket.
+func (c *Client) sendDups(src int, b []byte, to *net.UDPAddr, seq uint32, reqType uint8) {
+	for range c.cfg.DupSend - 1 {
+		if err := c.tr.sendFrom(src, b, to); err != nil {
+			log.Printf("dup-send %s seq=%d: %v", msgName(reqType), seq, err)
+		}
+	}
+}
+
+// endDups logs, dupSettle after its transaction ended, how many answers
+// the copies of seq drew.
+func (c *Client) endDups(seq uint32) {
+	time.AfterFunc(dupSettle, func() {
+		p := c.dups.finish(seq)
+		if p == nil {
+			return
+		}
+		verdict := "all identical"
+		switch {
+		case p.answers == 0:
+			verdict = "none"
+		case p.differ > 0:
+			verdict = fmt.Sprintf("%d differ from the first", p.differ)
+		}
+		c.msgLogf("dup-send: %s seq=%d sent %d times: %d answers (%s)", msgName(p.reqType), seq, p.copies, p.answers, verdict)
+	})
+}
+
+// dupReport is the run report line on Config.DupSend.
+func (c *Client) dupReport() {
+	if c.cfg.DupSend <= 1 {
+		return
+	}
+	d := &c.dups
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.requests == 0 {
+		return
+	}
+	log.Printf("run report: dup-send: %d requests sent %d times each (%d datagrams): %d answers (%.2f per request), %d differing from the first answer to their request, %d requests unanswered",
+		d.requests, c.cfg.DupSend, d.datagrams, d.answers, float64(d.answers)/float64(d.requests), d.differ, d.unanswered)
+}
@@ -0,0 +1,186 @@
+package sim
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+// ePCO container identifiers (TS 24.008 10.5.6.3) this tool names in logs
+// and the responder answers.
+const (
+	epcoPCSCFv6 uint16 = 0x0001
+	epcoDNSv6   uint16 = 0x0003
+	epcoPCSCFv4 uint16 = 0x000c
+	epcoDNSv4   uint16 = 0x000d
+	epcoMTUv4   uint16 = 0x0010
+)
+
+var epcoNames = map[uint16]string{
+	0xc021:      "LCP",
+	0xc023:      "PAP",
+	0xc223:      "CHAP",
+	0x8021:      "IPCP",
+	epcoPCSCFv6: "P-CSCF-IPv6",
+	0x0002:      "IM-CN-flag",
+	epcoDNSv6:   "DNS-IPv6",
+	0x000a:      "IP-via-NAS",
+	0x000b:      "IPv4-via-DHCP",
+	epcoPCSCFv4: "P-CSCF-IPv4",
+	epcoDNSv4:   "DNS-IPv4",
+	0x000e:      "MSISDN",
+	epcoMTUv4:   "IPv4-MTU",
+	0x0015:      "non-IP-MTU",
+	0x0016:      "APN-rate-control",
+	0x0017:      "PS-data-off",
+	0x001a:      "PDU-session-ID",
+}
+
+// EPCOContainer is one configuration protocol option or additional
+// parameter of an Extended PCO IE. Unlike PCO, ePCO containers carry a
+// 16-bit length, so their contents may exceed 255 octets.
+type EPCOContainer struct {
+	ID       uint16
+	Contents []byte
+}
+
+func (c EPCOContainer) String() string {
+	name, ok := epcoNames[c.ID]
+	if !ok {
+		name = fmt.Sprintf("0x%04x", c.ID)
+	}
+	switch {
+	case len(c.Contents) == 0:
+		return name
+	case (c.ID == epcoDNSv4 || c.ID == epcoPCSCFv4) && len(c.Contents) == 4,
+		(c.ID == epcoDNSv6 || c.ID == epcoPCSCFv6) && len(c.Contents) == 16:
+		return name + "=" + net.IP(c.Contents).String()
+	case c.ID == epcoMTUv4 && len(c.Contents) == 2:
+		return fmt.Sprintf("%s=%d", name, binary.BigEndian.Uint16(c.Contents))
+	}
+	return name + "=" + hex.EncodeToString(c.Contents)
+}
+
+// checkEPCOID rejects container IDs outside the ranges TS 24.008 assigns:
+// the PPP protocol IDs, the 3GPP additional parameters 0001H..0040H and the
+// operator-specific FF00H..FFFFH.
+func checkEPCOID(id uint16) error {
+	switch {
+	case id == 0xc021 || id == 0xc023 || id == 0xc223 || id == 0x8021:
+	case id >= 0x0001 && id <= 0x0040:
+	case id >= 0xff00:
+	default:
+		return fmt.Errorf("container ID 0x%04x is not a PPP protocol, 3GPP (0001..0040) or operator-specific (ff00..ffff) ID", id)
+	}
+	return nil
+}
+
+// ParseEPCO parses comma-separated ePCO containers, each a hex container ID
+// with optional hex contents, "id[:hex]", e.g. "000d,0010,ff00:cafe"
+// (requests for DNS IPv4 and the IPv4 MTU, plus an operator container).
+func ParseEPCO(s string) ([]EPCOContainer, error) {
+	var out []EPCOContainer
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		idStr, contents, _ := strings.Cut(f, ":")
+		id, err := strconv.ParseUint(strings.TrimPrefix(idStr, "0x"), 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("epco container %q: ID must be 4 hex digits", f)
+		}
+		if err := checkEPCOID(uint16(id)); err != nil {
+			return nil, fmt.Errorf("epco container %q: %w", f, err)
+		}
+		b, err := hex.DecodeString(contents)
+		if err != nil {
+			return nil, fmt.Errorf("epco container %q: %w", f, err)
+		}
+		out = append(out, EPCOContainer{ID: uint16(id), Contents: b})
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("epco %q: no containers", s)
+	}
+	if n := epcoLen(out); n > 0xffff {
+		return nil, fmt.Errorf("epco: %d octets exceed the IE's 65535", n)
+	}
+	return out, nil
+}
+
+func epcoLen(cs []EPCOContainer) int {
+	n := 1
+	for _, c := range cs {
+		n += 4 + len(c.Contents)
+	}
+	return n
+}
+
+// newEPCO builds an Extended PCO IE (TS 29.274 8.128, contents as TS 24.301
+// 9.9.4.26): the ext bit with configuration protocol 0 (PPP), then the
+// containers with 16-bit lengths.
+func newEPCO(cs []EPCOContainer) *gtpv2ie.IE {
+	b := make([]byte, 1, epcoLen(cs))
+	b[0] = 0x80
+	for _, c := range cs {
+		b = binary.BigEndian.AppendUint16(b, c.ID)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(c.Contents)))
+		b = append(b, c.Contents...)
+	}
+	return gtpv2ie.New(gtpv2ie.ExtendedProtocolConfigurationOptions, 0, b)
+}
+
+// parseEPCO decodes the containers of a received Extended PCO IE.
+func parseEPCO(b []byte) ([]EPCOContainer, error) {
+	if len(b) < 1 {
+		return nil, fmt.Errorf("ePCO: empty")
+	}
+	if b[0]&0x80 == 0 {
+		return nil, fmt.Errorf("ePCO: ext bit not set in octet 0x%02x", b[0])
+	}
+	var out []EPCOContainer
+	for off := 1; off < len(b); {
+		if len(b)-off < 4 {
+			return out, fmt.Errorf("ePCO: truncated container header at offset %d", off)
+		}
+		id := binary.BigEndian.Uint16(b[off:])
+		n := int(binary.BigEndian.Uint16(b[off+2:]))
+		off += 4
+		if len(b)-off < n {
+			return out, fmt.Errorf("ePCO: container 0x%04x length %d overruns the IE", id, n)
+		}
+		out = append(out, EPCOContainer{ID: id, Contents: append([]byte(nil), b[off:off+n]...)})
+		off += n
+	}
+	return out, nil
+}
+
+// epcoString renders containers for the log.
+func epcoString(cs []EPCOContainer) string {
+	s := make([]string, len(cs))
+	for k, c := range cs {
+		s[k] = c.String()
+	}
+	return strings.Join(s, " ")
+}
+
+// answerEPCO is the responder's reply to the requested containers: its
+// node IP as DNS and P-CSCF server and a 1400 octet IPv4 MTU. Containers it
+// has nothing for are left out, as a PGW would.
+func answerEPCO(req []EPCOContainer, nodeIP net.IP) []EPCOContainer {
+	var out []EPCOContainer
+	for _, c := range req {
+		switch c.ID {
+		case epcoDNSv4, epcoPCSCFv4:
+			out = append(out, EPCOContainer{ID: c.ID, Contents: nodeIP.To4()})
+		case epcoMTUv4:
+			out = append(out, EPCOContainer{ID: c.ID, Contents: []byte{0x05, 0x78}})
+		}
+	}
+	return out
+}
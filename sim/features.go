@@ -0,0 +1,92 @@
+package sim
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+// nodeFeatureNames are the Node Features IE flags (TS 29.274 8.83), bit 1
+// first.
+var nodeFeatureNames = []string{"PRN", "MABR", "NTSR", "CIOT", "S1UN", "ETH", "MTEDT"}
+
+// ParseNodeFeatures parses a -node-features value: a comma-separated list
+// of feature names (PRN, MABR, NTSR, CIOT, S1UN, ETH, MTEDT; any case) or
+// the octet as a number, e.g. 0x03.
+func ParseNodeFeatures(s string) (uint8, error) {
+	if v, err := strconv.ParseUint(s, 0, 8); err == nil {
+		return uint8(v), nil
+	}
+	var out uint8
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		found := false
+		for i, n := range nodeFeatureNames {
+			if strings.EqualFold(n, f) {
+				out, found = out|1<<i, true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown node feature %q (want a number or one of %s)", f, strings.Join(nodeFeatureNames, ","))
+		}
+	}
+	return out, nil
+}
+
+// nodeFeaturesString renders a Node Features octet, e.g. "PRN,MABR (0x03)";
+// unassigned bits show as bitN.
+func nodeFeaturesString(v uint8) string {
+	var names []string
+	for i := range 8 {
+		if v&(1<<i) == 0 {
+			continue
+		}
+		if i < len(nodeFeatureNames) {
+			names = append(names, nodeFeatureNames[i])
+		} else {
+			names = append(names, fmt.Sprintf("bit%d", i+1))
+		}
+	}
+	if len(names) == 0 {
+		names = append(names, "none")
+	}
+	return fmt.Sprintf("%s (0x%02x)", strings.Join(names, ","), v)
+}
+
+// echoIEs are the Recovery and, with Config.NodeFeatures set, Sending Node
+// Features IEs of our EchoRequests and EchoResponses.
+func (c *Client) echoIEs() []*gtpv2ie.IE {
+	ies := []*gtpv2ie.IE{gtpv2ie.NewRecovery(c.cfg.Recovery)}
+	if c.cfg.NodeFeatures >= 0 {
+		ies = append(ies, gtpv2ie.NewNodeFeatures(uint8(c.cfg.NodeFeatures)))
+	}
+	return ies
+}
+
+// observeNodeFeatures logs the Sending Node Features IE of an Echo from
+// peer if it is new or differs from the peer's previous one, as kept by the
+// peer's path, rather than on every Echo.
+func (c *Client) observeNodeFeatures(i *gtpv2ie.IE, peer *net.UDPAddr) {
+	if i == nil {
+		return
+	}
+	v, err := i.NodeFeatures()
+	if err != nil {
+		log.Printf("WARNING: Echo from %s: malformed Node Features IE: %v", peer, err)
+		return
+	}
+	prev, seen := c.paths.get(peer).observeFeatures(v)
+	switch {
+	case !seen:
+		log.Printf("peer %s node features: %s", peer, nodeFeaturesString(v))
+	case prev != v:
+		log.Printf("peer %s node features changed: %s -> %s", peer, nodeFeaturesString(prev), nodeFeaturesString(v))
+	}
+}
@@ -0,0 +1,218 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FindMaxOptions configures FindMaxRate. Zero Growth and Resolution take
+// the defaults below.
+type FindMaxOptions struct {
+	Start      float64       // first offered rate, CSRs per second
+	Limit      float64       // never offer more than this
+	Step       time.Duration // how long each rate is offered
+	Growth     float64       // rate factor between steps while every step passes
+	MinSuccess float64       // accepted/sent ratio a rate must reach (0..1)
+	MaxP95     time.Duration // CSR latency p95 a rate must stay under; 0 ignores latency
+	Resolution float64       // stop once failing/passing rate is within 1+Resolution
+}
+
+const (
+	findMaxGrowth     = 1.5
+	findMaxResolution = 0.05
+	findMaxMaxSteps   = 30
+)
+
+// FindMaxStep is one offered rate and how the peer coped with it.
+type FindMaxStep struct {
+	Rate     float64 // offered
+	Achieved float64 // what the pacer actually managed
+	Sent     int
+	Accepted int
+	P95      time.Duration // over the answered CSRs
+	Pass     bool
+	Reason   string // why it failed; empty on a step that was not judged
+}
+
+func (s FindMaxStep) String() string {
+	verdict := "pass"
+	switch {
+	case !s.Pass && s.Reason == "":
+		verdict = "not judged"
+	case !s.Pass:
+		verdict = "FAIL: " + s.Reason
+	}
+	return fmt.Sprintf("rate=%.1f/s (achieved %.1f/s) accepted=%d/%d (%.1f%%) p95=%s %s",
+		s.Rate, s.Achieved, s.Accepted, s.Sent, 100*float64(s.Accepted)/float64(s.Sent), s.P95, verdict)
+}
+
+// FindMaxResult is the outcome of FindMaxRate.
+type FindMaxResult struct {
+	Max           float64      // highest passing rate; 0 if none passed
+	ClientLimited bool         // stopped because this host couldn't offer the rate
+	Warmup        *FindMaxStep // the Config.Warmup phase at the start rate, not judged
+	Steps         []FindMaxStep
+}
+
+// Summary renders r for the log, one line per step and a verdict.
+func (r *FindMaxResult) Summary() string {
+	var sb strings.Builder
+	if r.Warmup != nil {
+		fmt.Fprintf(&sb, "warmup (excluded): %s\n", r.Warmup)
+	}
+	for i, s := range r.Steps {
+		fmt.Fprintf(&sb, "step %d: %s\n", i+1, s)
+	}
+	switch {
+	case r.Max == 0:
+		sb.WriteString("no rate passed")
+	case r.ClientLimited:
+		fmt.Fprintf(&sb, "max sustainable rate: >= %.1f CSR/s (limited by this host, not the peer)", r.Max)
+	default:
+		fmt.Fprintf(&sb, "max sustainable rate: %.1f CSR/s", r.Max)
+	}
+	return sb.String()
+}
+
+// FindMaxRate looks for the highest CreateSession rate the peer sustains:
+// each step offers one rate open-loop for opts.Step, deleting the accepted
+// sessions afterwards, and passes if the success ratio and latency p95 stay
+// within the thresholds. The rate grows by opts.Growth while steps pass,
+// then is bisected between the last passing and the first failing rate
+// (or halved while nothing has passed yet) until they are within
+// opts.Resolution of each other.
+func (c *Client) FindMaxRate(opts FindMaxOptions) (*FindMaxResult, error) {
+	if opts.Growth == 0 {
+		opts.Growth = findMaxGrowth
+	}
+	if opts.Resolution == 0 {
+		opts.Resolution = findMaxResolution
+	}
+	switch {
+	case opts.Start <= 0 || opts.Limit < opts.Start:
+		return nil, errors.New("find-max: need 0 < start <= limit")
+	case opts.Step <= 0:
+		return nil, errors.New("find-max: step duration must be > 0")
+	case opts.Growth <= 1:
+		return nil, errors.New("find-max: growth must be > 1")
+	case opts.MinSuccess < 0 || opts.MinSuccess > 1:
+		return nil, errors.New("find-max: success ratio must be 0..1")
+	}
+
+	res := &FindMaxResult{}
+	if c.cfg.Warmup > 0 {
+		// Warm the path and the peer up at the start rate first; the
+		// step is reported but not judged.
+		c.startWarmup()
+		wopts := opts
+		wopts.Step = c.cfg.Warmup
+		st := c.findMaxStep(opts.Start, wopts)
+		st.Pass, st.Reason = false, ""
+		res.Warmup = &st
+		log.Printf("find-max warmup (excluded): %s", st)
+	}
+	var pass, fail float64
+	rate := opts.Start
+	for len(res.Steps) < findMaxMaxSteps {
+		st := c.findMaxStep(rate, opts)
+		res.Steps = append(res.Steps, st)
+		log.Printf("find-max step %d: %s", len(res.Steps), st)
+		if st.Pass {
+			pass, res.Max = rate, rate
+		} else {
+			fail = rate
+		}
+		// The peer can't be blamed for a rate we never offered.
+		if st.Achieved < 0.9*st.Rate {
+			res.ClientLimited = true
+			log.Printf("find-max: this host only achieved %.1f of %.1f CSR/s; stopping", st.Achieved, st.Rate)
+			break
+		}
+		switch {
+		case fail == 0:
+			if rate >= opts.Limit {
+				log.Printf("find-max: reached the %.1f/s limit without a failure", opts.Limit)
+				return res, nil
+			}
+			rate = min(rate*opts.Growth, opts.Limit)
+		case pass == 0:
+			rate /= 2
+			if rate < 0.1 {
+				return res, nil
+			}
+		default:
+			if fail <= pass*(1+opts.Resolution) {
+				return res, nil
+			}
+			rate = (pass + fail) / 2
+		}
+	}
+	return res, nil
+}
+
+// findMaxStep offers rate for opts.Step and cleans up the sessions it made.
+func (c *Client) findMaxStep(rate float64, opts FindMaxOptions) FindMaxStep {
+	n := max(int(rate*opts.Step.Seconds()), 1)
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		lat      []time.Duration
+		accepted []*Session
+	)
+	p := newPacer(rate)
+	for _, imsi := range sessionIMSIs(c.cfg.IMSI, n) {
+		cfg := c.cfg
+		cfg.IMSI = imsi
+		p.wait()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t0 := time.Now()
+			sess, _, err := c.createSession(cfg)
+			d := time.Since(t0)
+			var te *TxnError
+			answered := err == nil || errors.As(err, &te) && te.Kind == TxnRejected
+			mu.Lock()
+			defer mu.Unlock()
+			if answered {
+				lat = append(lat, d)
+			}
+			if sess != nil {
+				accepted = append(accepted, sess)
+			}
+		}()
+	}
+	wg.Wait()
+	st := FindMaxStep{Rate: rate, Sent: n, Accepted: len(accepted), P95: percentile(lat, 0.95)}
+	if st.Achieved = p.achieved(); n == 1 {
+		st.Achieved = rate // a single send has no rate
+	}
+	ratio := float64(st.Accepted) / float64(n)
+	switch {
+	case ratio < opts.MinSuccess:
+		st.Reason = fmt.Sprintf("success %.1f%% < %.1f%%", 100*ratio, 100*opts.MinSuccess)
+	case opts.MaxP95 > 0 && st.P95 > opts.MaxP95:
+		st.Reason = fmt.Sprintf("p95 %s > %s", st.P95, opts.MaxP95)
+	default:
+		st.Pass = true
+	}
+
+	// Clean up at the same rate so the teardown doesn't skew the next step.
+	dp := newPacer(rate)
+	for _, sess := range accepted {
+		dp.wait()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.DeleteSession(sess); err != nil {
+				log.Printf("find-max: cleanup of %s failed: %v", sess, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return st
+}
@@ -0,0 +1,87 @@
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// flowRecord is one session's lifecycle in -flow-out, loosely after an
+// IPFIX flow record. Control-plane only, so there is no byte count.
+type flowRecord struct {
+	Start      string  `json:"start"`
+	End        string  `json:"end"`
+	DurationMS int64   `json:"duration_ms"`
+	IMSI       string  `json:"imsi"`
+	APN        string  `json:"apn"`
+	Group      string  `json:"group"`
+	UEIP       string  `json:"ue_ip"`
+	ChargingID uint32  `json:"charging_id"`
+	Bytes      *uint64 `json:"bytes"` // always null: no user-plane counting
+	EndCause   string  `json:"end_cause"`
+}
+
+// flowOut writes flow records as JSON lines to a file, or as one datagram
+// per record to a UDP collector ("udp:host:port").
+type flowOut struct {
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+func openFlowOut(target string) (*flowOut, error) {
+	if addr, ok := strings.CutPrefix(target, "udp:"); ok {
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return &flowOut{w: conn}, nil
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &flowOut{w: f}, nil
+}
+
+// write records the end of sess, e.g. endCause "cause=16" or "run-end".
+func (o *flowOut) write(sess *Session, endCause string) error {
+	end := time.Now()
+	b, err := json.Marshal(flowRecord{
+		Start:      sess.Start.Format(time.RFC3339Nano),
+		End:        end.Format(time.RFC3339Nano),
+		DurationMS: end.Sub(sess.Start).Milliseconds(),
+		IMSI:       sess.IMSI,
+		APN:        sess.APN,
+		Group:      sess.Group,
+		UEIP:       sess.PAA,
+		ChargingID: sess.ChargingID,
+		EndCause:   endCause,
+	})
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, err := o.w.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("flow record imsi=%s: %w", sess.IMSI, err)
+	}
+	return nil
+}
+
+func (o *flowOut) Close() error { return o.w.Close() }
+
+// endFlow writes sess's flow record if -flow-out is set.
+func (c *Client) endFlow(sess *Session, endCause string) {
+	if c.flows == nil {
+		return
+	}
+	if err := c.flows.write(sess, endCause); err != nil {
+		log.Printf("flow-out: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package sim
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// sessionGroup is the label a session's statistics are reported under:
+// Config.Group, or else its APN.
+func sessionGroup(cfg Config) string {
+	if cfg.Group != "" {
+		return cfg.Group
+	}
+	return cfg.APN
+}
+
+// groupTally is one session group's CreateSessions.
+type groupTally struct {
+	tried, accepted int
+	lat             []time.Duration // answered CSRs, accepted or rejected
+}
+
+// groupStats breaks the run's CreateSessions down by session group, so one
+// misbehaving APN among several stands out. Like csrOutcomes it leaves the
+// warmup out.
+type groupStats struct {
+	mu       sync.Mutex
+	m        map[string]*groupTally
+	labelled bool // some group came from Config.Group rather than the APN
+}
+
+func (g *groupStats) count(cfg Config, rtt time.Duration, err error) {
+	name := sessionGroup(cfg)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.m == nil {
+		g.m = make(map[string]*groupTally)
+	}
+	t := g.m[name]
+	if t == nil {
+		t = &groupTally{}
+		g.m[name] = t
+	}
+	g.labelled = g.labelled || cfg.Group != ""
+	t.tried++
+	if err == nil {
+		t.accepted++
+	}
+	if (csrTiming{err: err}).answered() {
+		t.lat = append(t.lat, rtt)
+	}
+}
+
+// report logs a line per group, by name. A run with a single unlabelled
+// group has nothing to break down and logs none.
+func (g *groupStats) report() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.m) == 0 || len(g.m) == 1 && !g.labelled {
+		return
+	}
+	names := make([]string, 0, len(g.m))
+	for name := range g.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t := g.m[name]
+		log.Printf("run report: group %s: CreateSessions accepted=%d/%d (%.1f%%) p50=%s p95=%s",
+			name, t.accepted, t.tried, 100*float64(t.accepted)/float64(t.tried), percentile(t.lat, 0.5), percentile(t.lat, 0.95))
+	}
+}
@@ -0,0 +1,274 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gtpv1ie "github.com/wmnsk/go-gtp/gtpv1/ie"
+	gtpv1msg "github.com/wmnsk/go-gtp/gtpv1/message"
+)
+
+// GTPUPort is the registered GTP-U UDP port (TS 29.281).
+const GTPUPort = 2152
+
+// gtpuPath is a GTPv1-U socket used to check the user-plane path with Echo,
+// independently of the control plane and of any G-PDU forwarding. Its RTTs
+// are kept apart from the GTP-C stats.
+type gtpuPath struct {
+	conn  *net.UDPConn
+	raddr *net.UDPAddr
+	seq   atomic.Uint32
+
+	mu      sync.Mutex
+	pending map[uint16]chan time.Time
+
+	ok, lost       atomic.Uint64
+	rttSum, rttMax atomic.Int64
+
+	gpdu atomic.Pointer[tunnel] // receives G-PDUs with -tun; nil drops them
+
+	// Per-session user-plane health for the keepalive, by local C-TEID.
+	hmu    sync.Mutex
+	health map[uint32]*pathHealth
+}
+
+// pathHealth tracks keepalive echoes towards one session's PGW U-plane.
+type pathHealth struct {
+	imsi     string
+	peer     *net.UDPAddr
+	ok, lost int
+	down     bool // last echo went unanswered
+}
+
+// gtpuAddrs works out the GTP-U endpoints: explicit ones from cfg, else the
+// control-plane hosts on the GTP-U port (ephemeral locally; peers answer
+// Echo to the request's source port).
+func gtpuAddrs(cfg Config) (laddr, raddr *net.UDPAddr, err error) {
+	remote := cfg.GTPURemote
+	if remote == "" {
+		host, _, err := net.SplitHostPort(cfg.Remote)
+		if err != nil {
+			return nil, nil, fmt.Errorf("gtp-u remote from %q: %w", cfg.Remote, err)
+		}
+		remote = net.JoinHostPort(host, strconv.Itoa(GTPUPort))
+	}
+	if raddr, err = net.ResolveUDPAddr("udp", remote); err != nil {
+		return nil, nil, fmt.Errorf("resolve gtp-u remote: %w", err)
+	}
+	local := cfg.GTPULocal
+	if local == "" {
+		// With -tun the PGW sends G-PDUs to our F-TEID address on the
+		// GTP-U port, so listen there.
+		port := "0"
+		if cfg.TUN != "" {
+			port = strconv.Itoa(GTPUPort)
+		}
+		host, _, _ := net.SplitHostPort(cfg.Local)
+		local = net.JoinHostPort(host, port)
+	}
+	if laddr, err = net.ResolveUDPAddr("udp", local); err != nil {
+		return nil, nil, fmt.Errorf("resolve gtp-u local: %w", err)
+	}
+	return laddr, raddr, nil
+}
+
+func newGTPUPath(laddr, raddr *net.UDPAddr) (*gtpuPath, error) {
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	p := &gtpuPath{conn: conn, raddr: raddr, pending: make(map[uint16]chan time.Time), health: make(map[uint32]*pathHealth)}
+	p.seq.Store(randUint32())
+	go p.rxLoop()
+	return p, nil
+}
+
+func (p *gtpuPath) Close() error { return p.conn.Close() }
+
+// rxLoop answers the peer's Echo Requests and hands Echo Responses to the
+// waiting echo call, and G-PDUs to the TUN device if there is one. Anything
+// else (Error Indications, ...) is ignored.
+func (p *gtpuPath) rxLoop() {
+	buf := make([]byte, 2048)
+	for {
+		n, peer, err := p.conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("gtp-u rx err: %v", err)
+			continue
+		}
+		now := time.Now()
+		if n > 1 && buf[1] == gtpv1msg.MsgTypeTPDU {
+			if t := p.gpdu.Load(); t != nil {
+				if h, err := gtpv1msg.ParseHeader(buf[:n]); err == nil {
+					t.downlink(h.TEID, h.Payload)
+				}
+			}
+			continue
+		}
+		m, err := gtpv1msg.Parse(buf[:n])
+		if err != nil {
+			continue
+		}
+		switch m.MessageType() {
+		case gtpv1msg.MsgTypeEchoRequest:
+			b, err := gtpv1msg.Marshal(gtpv1msg.NewEchoResponse(m.Sequence(), gtpv1ie.NewRecovery(0)))
+			if err == nil {
+				_, _ = p.conn.WriteToUDP(b, peer)
+			}
+			log.Printf("gtp-u rx EchoReq from %s -> EchoResp (seq=%d)", peer, m.Sequence())
+		case gtpv1msg.MsgTypeEchoResponse:
+			p.mu.Lock()
+			ch, ok := p.pending[m.Sequence()]
+			delete(p.pending, m.Sequence())
+			p.mu.Unlock()
+			if ok {
+				ch <- now
+			}
+		}
+	}
+}
+
+// echo sends a GTP-U Echo Request to the configured peer and accounts the
+// result in the path totals.
+func (p *gtpuPath) echo(timeout time.Duration) (uint16, time.Duration, error) {
+	seq, rtt, err := p.echoTo(p.raddr, timeout)
+	if err != nil {
+		p.lost.Add(1)
+		return seq, 0, err
+	}
+	p.ok.Add(1)
+	p.rttSum.Add(int64(rtt))
+	for {
+		max := p.rttMax.Load()
+		if int64(rtt) <= max || p.rttMax.CompareAndSwap(max, int64(rtt)) {
+			break
+		}
+	}
+	return seq, rtt, nil
+}
+
+// echoTo sends a GTP-U Echo Request to peer and waits up to timeout for the
+// response with the same sequence number.
+func (p *gtpuPath) echoTo(peer *net.UDPAddr, timeout time.Duration) (uint16, time.Duration, error) {
+	seq := uint16(p.seq.Add(1))
+	b, err := gtpv1msg.Marshal(gtpv1msg.NewEchoRequest(seq))
+	if err != nil {
+		return seq, 0, err
+	}
+	ch := make(chan time.Time, 1)
+	p.mu.Lock()
+	p.pending[seq] = ch
+	p.mu.Unlock()
+	cancel := func() {
+		p.mu.Lock()
+		delete(p.pending, seq)
+		p.mu.Unlock()
+	}
+
+	start := time.Now()
+	if _, err := p.conn.WriteToUDP(b, peer); err != nil {
+		cancel()
+		return seq, 0, err
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	select {
+	case at := <-ch:
+		return seq, at.Sub(start), nil
+	case <-deadline.C:
+		cancel()
+		return seq, 0, fmt.Errorf("no GTP-U EchoResp within %s", timeout)
+	}
+}
+
+// keepalive echoes sess's user-plane peer and records the outcome, logging
+// only when the path goes down or comes back.
+func (p *gtpuPath) keepalive(sess *Session, port int, timeout time.Duration) {
+	peer := &net.UDPAddr{IP: sess.userPeer(), Port: port}
+	_, rtt, err := p.echoTo(peer, timeout)
+
+	p.hmu.Lock()
+	defer p.hmu.Unlock()
+	h := p.health[sess.LocalCTEID]
+	if h == nil {
+		h = &pathHealth{imsi: sess.IMSI, peer: peer}
+		p.health[sess.LocalCTEID] = h
+	}
+	switch {
+	case err != nil:
+		h.lost++
+		if !h.down {
+			log.Printf("GTP-U keepalive: imsi=%s path %s down: %v", sess.IMSI, peer, err)
+		}
+		h.down = true
+	default:
+		h.ok++
+		if h.down {
+			log.Printf("GTP-U keepalive: imsi=%s path %s back up (rtt=%s)", sess.IMSI, peer, rtt)
+		}
+		h.down = false
+	}
+}
+
+func (p *gtpuPath) report() {
+	ok := p.ok.Load()
+	avg := time.Duration(0)
+	if ok > 0 {
+		avg = time.Duration(p.rttSum.Load() / int64(ok))
+	}
+	if ok+p.lost.Load() > 0 {
+		log.Printf("run report: user plane %s: echo ok=%d lost=%d rtt avg=%s max=%s",
+			p.raddr, ok, p.lost.Load(), avg, time.Duration(p.rttMax.Load()))
+	}
+
+	p.hmu.Lock()
+	defer p.hmu.Unlock()
+	teids := make([]uint32, 0, len(p.health))
+	for teid := range p.health {
+		teids = append(teids, teid)
+	}
+	sort.Slice(teids, func(i, j int) bool { return teids[i] < teids[j] })
+	for _, teid := range teids {
+		h := p.health[teid]
+		state := "up"
+		if h.down {
+			state = "DOWN"
+		}
+		log.Printf("run report: user plane imsi=%s %s: keepalive ok=%d lost=%d %s", h.imsi, h.peer, h.ok, h.lost, state)
+	}
+}
+
+// keepaliveSessions echoes the user-plane peer of every live session that
+// has one.
+func (c *Client) keepaliveSessions() {
+	for _, sess := range c.sessions.all() {
+		if sess.userPeer() == nil {
+			continue
+		}
+		go c.u.keepalive(sess, c.u.raddr.Port, c.cfg.Timeout)
+	}
+}
+
+// EchoU checks the user-plane path with a GTP-U Echo Request, returning the
+// RTT. It needs Config.GTPUEcho or GTPURemote so the GTP-U socket exists.
+func (c *Client) EchoU() (time.Duration, error) {
+	if c.u == nil {
+		return 0, errors.New("gtp-u path not enabled")
+	}
+	seq, rtt, err := c.u.echo(c.cfg.Timeout)
+	if err != nil {
+		return 0, err
+	}
+	c.msgLogf("GTP-U Echo succeeded seq=%d rtt=%s peer=%s", seq, rtt, c.u.raddr)
+	return rtt, nil
+}
@@ -0,0 +1,150 @@
+package sim
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// GUTI identifies a UE towards its old MME in an IdentificationRequest.
+type GUTI struct {
+	MCC, MNC string
+	MMEGI    uint16
+	MMEC     uint8
+	MTMSI    uint32
+}
+
+// ParseGUTI parses "MCC-MNC-MMEGI-MMEC-MTMSI", the last three in hex, e.g.
+// "001-01-8001-01-c0ffee01".
+func ParseGUTI(s string) (GUTI, error) {
+	var g GUTI
+	f := strings.Split(s, "-")
+	if len(f) != 5 {
+		return g, fmt.Errorf("guti %q: want MCC-MNC-MMEGI-MMEC-MTMSI", s)
+	}
+	if !allDigits(f[0]) || len(f[0]) != 3 || !allDigits(f[1]) || (len(f[1]) != 2 && len(f[1]) != 3) {
+		return g, fmt.Errorf("guti %q: bad MCC/MNC", s)
+	}
+	g.MCC, g.MNC = f[0], f[1]
+	mmegi, err := strconv.ParseUint(f[2], 16, 16)
+	if err != nil {
+		return g, fmt.Errorf("guti %q: MMEGI must be 4 hex digits", s)
+	}
+	mmec, err := strconv.ParseUint(f[3], 16, 8)
+	if err != nil {
+		return g, fmt.Errorf("guti %q: MMEC must be 2 hex digits", s)
+	}
+	mtmsi, err := strconv.ParseUint(f[4], 16, 32)
+	if err != nil {
+		return g, fmt.Errorf("guti %q: M-TMSI must be 8 hex digits", s)
+	}
+	g.MMEGI, g.MMEC, g.MTMSI = uint16(mmegi), uint8(mmec), uint32(mtmsi)
+	return g, nil
+}
+
+func (g GUTI) String() string {
+	return fmt.Sprintf("%s-%s-%04x-%02x-%08x", g.MCC, g.MNC, g.MMEGI, g.MMEC, g.MTMSI)
+}
+
+func allDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Identity is what an IdentificationResponse told us about a UE.
+type Identity struct {
+	IMSI      string
+	MMContext *MMContext // nil when the response carried none
+}
+
+// MMContext summarises the MM Context IE (TS 29.274 8.38) of an
+// IdentificationResponse: which kind it is and how many authentication
+// vectors it carries. The vectors themselves are not decoded.
+type MMContext struct {
+	Type         uint8
+	Name         string
+	SecurityMode uint8
+	KSI          uint8
+	Vectors      int // triplets or quintuplets, by Type
+	Quadruplets  int
+}
+
+// mmContext decodes the common leading octets of an MM Context IE: security
+// mode and KSI in the first, the vector counts in the second.
+func mmContext(i *gtpv2ie.IE) (*MMContext, error) {
+	if len(i.Payload) < 2 {
+		return nil, fmt.Errorf("%s: %d bytes, too short", i.Name(), len(i.Payload))
+	}
+	mm := &MMContext{
+		Type:         i.Type,
+		Name:         i.Name(),
+		SecurityMode: i.Payload[0] >> 5,
+		KSI:          i.Payload[0] & 0x07,
+		Vectors:      int(i.Payload[1] >> 5),
+	}
+	switch i.Type {
+	case gtpv2ie.MMContextEPSSecurityContextQuadrupletsAndQuintuplets,
+		gtpv2ie.MMContextUMTSKeyQuadrupletsAndQuintuplets:
+		mm.Quadruplets = int(i.Payload[1]>>2) & 0x07
+	}
+	return mm, nil
+}
+
+func isMMContext(t uint8) bool {
+	return t >= gtpv2ie.MMContextGSMKeyAndTriplets && t <= gtpv2ie.MMContextUMTSKeyQuadrupletsAndQuintuplets
+}
+
+// Identify asks the peer MME/SGSN (S3/S10/S16) for the IMSI and MM context
+// of the UE known by guti, with an IdentificationRequest, and decodes the
+// IdentificationResponse.
+func (c *Client) Identify(guti GUTI) (*Identity, error) {
+	seq := c.seq.next()
+	req := gtpv2msg.NewGeneric(gtpv2msg.MsgTypeIdentificationRequest, 0, seq,
+		gtpv2ie.NewGUTI(guti.MCC, guti.MNC, guti.MMEGI, guti.MMEC, guti.MTMSI),
+	)
+
+	c.msgLogf("tx IdentificationReq seq=%d guti=%s -> %s", seq, guti, c.tr.remote())
+	m, rtt, err := c.transact(req)
+	if err != nil {
+		return nil, err
+	}
+	var causeIE *gtpv2ie.IE
+	resp, _ := m.(*gtpv2msg.Generic)
+	if resp != nil {
+		for _, i := range resp.IEs {
+			if i.Type == gtpv2ie.Cause {
+				causeIE = i
+			}
+		}
+	}
+	cause, err := c.checkResponse(req, m, gtpv2msg.MsgTypeIdentificationResponse, causeIE)
+	if err != nil {
+		return nil, err
+	}
+
+	id := &Identity{}
+	for _, i := range resp.IEs {
+		switch {
+		case i.Type == gtpv2ie.IMSI:
+			id.IMSI = tbcdDigits(i.Payload)
+		case isMMContext(i.Type):
+			if id.MMContext, err = mmContext(i); err != nil {
+				log.Printf("IdentificationRsp: %v", err)
+			}
+		}
+	}
+	c.msgLogf("Identification succeeded seq=%d rtt=%s cause=%d imsi=%s", seq, rtt, cause, id.IMSI)
+	if mm := id.MMContext; mm != nil {
+		log.Printf("IdentificationRsp %s: security mode=%d ksi=%d vectors=%d quadruplets=%d",
+			mm.Name, mm.SecurityMode, mm.KSI, mm.Vectors, mm.Quadruplets)
+	}
+	return id, nil
+}
@@ -0,0 +1,491 @@
+package sim
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	gtpv2 "github.com/wmnsk/go-gtp/gtpv2"
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+// validateIMSI checks the IMSI is 6..15 decimal digits (3GPP TS 23.003).
+// Odd lengths are fine: TBCD encoding pads the last octet with a 0xF nibble.
+func validateIMSI(imsi string) error {
+	if len(imsi) < 6 || len(imsi) > 15 {
+		return fmt.Errorf("imsi %q must be 6..15 digits (got %d)", imsi, len(imsi))
+	}
+	if strings.Trim(imsi, "0123456789") != "" {
+		return fmt.Errorf("imsi %q must contain digits only", imsi)
+	}
+	return nil
+}
+
+// newIMSI builds the IMSI IE and verifies the TBCD encoding round-trips,
+// including the 0xF filler nibble for odd-length IMSIs.
+func newIMSI(imsi string) (*gtpv2ie.IE, error) {
+	i := gtpv2ie.NewIMSI(imsi)
+	if i == nil {
+		return nil, fmt.Errorf("imsi %q: TBCD encoding failed", imsi)
+	}
+	if got := tbcdDigits(i.Payload); got != imsi {
+		return nil, fmt.Errorf("imsi %q: TBCD round-trip mismatch (decoded %q, bytes % x)", imsi, got, i.Payload)
+	}
+	if len(imsi)%2 == 1 && i.Payload[len(i.Payload)-1]>>4 != 0xf {
+		return nil, fmt.Errorf("imsi %q: missing 0xF filler nibble (bytes % x)", imsi, i.Payload)
+	}
+	return i, nil
+}
+
+// tbcdDigits decodes TBCD bytes (low nibble first), stopping at a 0xF filler.
+func tbcdDigits(b []byte) string {
+	var sb strings.Builder
+	for _, o := range b {
+		for _, d := range []byte{o & 0x0f, o >> 4} {
+			if d == 0xf {
+				return sb.String()
+			}
+			sb.WriteByte('0' + d)
+		}
+	}
+	return sb.String()
+}
+
+// sessionIMSIs returns n distinct IMSIs for a multi-session run. They keep
+// base's PLMN (first five digits) and length but get random MSINs; a single
+// session just uses base.
+func sessionIMSIs(base string, n int) []string {
+	if n == 0 {
+		return nil
+	}
+	if n == 1 {
+		return []string{base}
+	}
+	seen := make(map[string]bool, n)
+	out := make([]string, 0, n)
+	b := []byte(base)
+	for len(out) < n {
+		for i := 5; i < len(b); i++ {
+			b[i] = '0' + byte(randUint32()%10)
+		}
+		if imsi := string(b); !seen[imsi] {
+			seen[imsi] = true
+			out = append(out, imsi)
+		}
+	}
+	return out
+}
+
+// ParseBearers parses a comma-separated list of EBIs, e.g. "5,6,7".
+func ParseBearers(s string) ([]uint8, error) {
+	var out []uint8
+	for _, f := range strings.Split(s, ",") {
+		v, err := strconv.ParseUint(strings.TrimSpace(f), 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("bad EBI %q", f)
+		}
+		out = append(out, uint8(v))
+	}
+	return out, nil
+}
+
+// checkBearerEBIs verifies the bearers of one CreateSessionRequest: every EBI
+// in 5..15 (TS 24.007; 0..4 are reserved), no EBI twice, and the linked
+// (default) EBI among them.
+func checkBearerEBIs(lbi uint8, ebis []uint8) error {
+	seen := make(map[uint8]bool, len(ebis))
+	for _, ebi := range ebis {
+		if ebi < 5 || ebi > 15 {
+			return fmt.Errorf("EBI %d out of range 5..15", ebi)
+		}
+		if seen[ebi] {
+			return fmt.Errorf("EBI %d used by more than one bearer", ebi)
+		}
+		seen[ebi] = true
+	}
+	if !seen[lbi] {
+		return fmt.Errorf("default bearer EBI %d not among bearers %v", lbi, ebis)
+	}
+	return nil
+}
+
+// ParseRawIE builds a generic IE from "type:instance:hexbytes", e.g.
+// "255:0:0001abcd", for IEs the library has no constructor for.
+func ParseRawIE(s string) (*gtpv2ie.IE, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("raw IE %q: want type:instance:hexbytes", s)
+	}
+	typ, err := strconv.ParseUint(parts[0], 0, 8)
+	if err != nil || typ == 0 {
+		return nil, fmt.Errorf("raw IE %q: type must be 1..255", s)
+	}
+	ins, err := strconv.ParseUint(parts[1], 0, 4)
+	if err != nil {
+		return nil, fmt.Errorf("raw IE %q: instance must be 0..15", s)
+	}
+	payload, err := hex.DecodeString(strings.TrimPrefix(parts[2], "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("raw IE %q: %w", s, err)
+	}
+	if len(payload) > 0xffff {
+		return nil, fmt.Errorf("raw IE %q: payload longer than 65535 bytes", s)
+	}
+	return gtpv2ie.New(uint8(typ), uint8(ins), payload), nil
+}
+
+// ParseCSIDs parses a comma-separated list of 16-bit PDN connection set
+// identifiers, e.g. "1,0x2a".
+func ParseCSIDs(s string) ([]uint16, error) {
+	var out []uint16
+	for _, f := range strings.Split(s, ",") {
+		v, err := strconv.ParseUint(strings.TrimSpace(f), 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("bad CSID %q", f)
+		}
+		out = append(out, uint16(v))
+	}
+	return out, nil
+}
+
+// checkFQCSID verifies what goes into an FQ-CSID IE (TS 29.274 8.62): the
+// node ID is an IPv4 or IPv6 address, or 8 hex digits for the "other" type
+// (20-bit MCC/MNC + 12-bit ID), and there are 1..15 CSIDs.
+func checkFQCSID(node string, csids []uint16) error {
+	if n := len(csids); n < 1 || n > 15 {
+		return fmt.Errorf("fq-csid needs 1..15 CSIDs, got %d", n)
+	}
+	if net.ParseIP(node) != nil {
+		return nil
+	}
+	if b, err := hex.DecodeString(node); err != nil || len(b) != 4 {
+		return fmt.Errorf("fq-csid node ID %q: want an IPv4/IPv6 address or 8 hex digits", node)
+	}
+	return nil
+}
+
+// fqcsidString renders an FQ-CSID IE as "node:csid,csid".
+func fqcsidString(i *gtpv2ie.IE) string {
+	f, err := i.FullyQualifiedCSID()
+	if err != nil {
+		return fmt.Sprintf("malformed (%v)", err)
+	}
+	node := hex.EncodeToString(f.NodeID)
+	if f.NodeIDType <= 1 {
+		node = net.IP(f.NodeID).String()
+	}
+	ids := make([]string, len(f.CSIDs))
+	for k, v := range f.CSIDs {
+		ids[k] = strconv.Itoa(int(v))
+	}
+	return node + ":" + strings.Join(ids, ",")
+}
+
+// omittableIEs are the CreateSessionRequest IEs -omit can drop.
+var omittableIEs = []string{"apn", "imsi", "rat", "fteid", "pdn", "bearer"}
+
+// ParseOmit parses a comma-separated list of IE names to leave out of the
+// CreateSessionRequest.
+func ParseOmit(s string) (map[string]bool, error) {
+	out := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		known := false
+		for _, o := range omittableIEs {
+			known = known || o == name
+		}
+		if !known {
+			return nil, fmt.Errorf("cannot omit %q (want %s)", name, strings.Join(omittableIEs, "|"))
+		}
+		out[name] = true
+	}
+	return out, nil
+}
+
+// BadInstance sends one CreateSessionRequest IE at an instance the peer
+// shouldn't accept, to check it validates instances (see ParseBadInstance).
+type BadInstance struct {
+	IE       string // an omittableIEs name, or "random" for a different one per CSR
+	Instance int    // instance to send; -1 picks a random wrong one per CSR
+}
+
+// ParseBadInstance parses a -bad-instance value, "NAME[:N]": NAME one of the
+// -omit IE names or "random", N the instance (0..15) to send it at instead
+// of its own, default a random other one.
+func ParseBadInstance(s string) (*BadInstance, error) {
+	name, n, hasN := strings.Cut(strings.ToLower(strings.TrimSpace(s)), ":")
+	b := &BadInstance{IE: name, Instance: -1}
+	if name != "random" && !slices.Contains(omittableIEs, name) {
+		return nil, fmt.Errorf("bad instance %q: IE must be random or one of %s", s, strings.Join(omittableIEs, "|"))
+	}
+	if hasN {
+		v, err := strconv.ParseUint(n, 0, 4)
+		if err != nil {
+			return nil, fmt.Errorf("bad instance %q: instance must be 0..15", s)
+		}
+		b.Instance = int(v)
+	}
+	return b, nil
+}
+
+// pick returns the IE to corrupt for one CSR.
+func (b *BadInstance) pick() string {
+	if b.IE == "random" {
+		return omittableIEs[randUint32()%uint32(len(omittableIEs))]
+	}
+	return b.IE
+}
+
+// instance returns the instance to send instead of correct.
+func (b *BadInstance) instance(correct uint8) uint8 {
+	if b.Instance >= 0 {
+		return uint8(b.Instance)
+	}
+	return (correct + 1 + uint8(randUint32()%15)) & 0x0f
+}
+
+// Session trace depth values (3GPP TS 32.422).
+var traceDepths = map[string]uint8{
+	"minimum":           0,
+	"medium":            1,
+	"maximum":           2,
+	"minimum-no-vendor": 3,
+	"medium-no-vendor":  4,
+	"maximum-no-vendor": 5,
+}
+
+// ParseTEIDSource parses a -teid-source value: "assigned" (the PGW's control
+// TEID) or "fixed:TEID" with TEID in hex (0x optional), e.g. "fixed:0xdead".
+func ParseTEIDSource(s string) (fixed bool, teid uint32, err error) {
+	if s == "assigned" {
+		return false, 0, nil
+	}
+	v, ok := strings.CutPrefix(s, "fixed:")
+	if !ok {
+		return false, 0, fmt.Errorf("teid source %q: want assigned or fixed:0xNNNN", s)
+	}
+	n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(v), "0x"), 16, 32)
+	if err != nil {
+		return false, 0, fmt.Errorf("teid source %q: teid must be 32-bit hex", s)
+	}
+	return true, uint32(n), nil
+}
+
+// maxAPNLen is the longest APN encoding allowed (TS 23.003 9.1).
+const maxAPNLen = 100
+
+// decodeAPN decodes an APN IE payload (length-prefixed DNS labels, TS 23.003
+// 9.1) into its dotted form, operator identifier included, e.g.
+// "internet.mnc001.mcc001.gprs". Unlike the library it checks every label
+// length against the payload, so an off-by-one in the peer's encoding is an
+// error, and it re-encodes the result to make sure it round-trips.
+func decodeAPN(b []byte) (string, error) {
+	if len(b) == 0 {
+		return "", errors.New("apn: empty")
+	}
+	if len(b) > maxAPNLen {
+		return "", fmt.Errorf("apn: %d octets, more than %d", len(b), maxAPNLen)
+	}
+	labels := make([]string, 0, 4)
+	for off := 0; off < len(b); {
+		n := int(b[off])
+		switch {
+		case n == 0 || n > 63:
+			return "", fmt.Errorf("apn: label length %d at octet %d (want 1..63)", n, off)
+		case off+1+n > len(b):
+			return "", fmt.Errorf("apn: label length %d at octet %d overruns the %d-octet payload", n, off, len(b))
+		}
+		label := b[off+1 : off+1+n]
+		for _, ch := range label {
+			if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-') {
+				return "", fmt.Errorf("apn: label %q has a character outside a-z, 0-9, '-'", label)
+			}
+		}
+		labels = append(labels, string(label))
+		off += 1 + n
+	}
+	apn := strings.Join(labels, ".")
+	if got := gtpv2ie.NewAccessPointName(apn).Payload; !bytes.Equal(got, b) {
+		return "", fmt.Errorf("apn %q: re-encodes as % x, received % x", apn, got, b)
+	}
+	return apn, nil
+}
+
+// ParseTraceDepth maps a trace depth name (minimum, medium, maximum, with an
+// optional "-no-vendor" suffix) to its encoded value.
+func ParseTraceDepth(s string) (uint8, error) {
+	d, ok := traceDepths[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown trace depth %q", s)
+	}
+	return d, nil
+}
+
+// ParseTraceRef parses a trace reference "MCC-MNC-TRACEID", TRACEID being a
+// 24-bit value in hex (e.g. "001-01-00abcd").
+func ParseTraceRef(s string) (mcc, mnc string, traceID uint32, err error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 3 {
+		return "", "", 0, fmt.Errorf("trace ref %q: want MCC-MNC-TRACEID", s)
+	}
+	mcc, mnc = parts[0], parts[1]
+	if len(mcc) != 3 || strings.Trim(mcc, "0123456789") != "" {
+		return "", "", 0, fmt.Errorf("trace ref %q: MCC must be 3 digits", s)
+	}
+	if (len(mnc) != 2 && len(mnc) != 3) || strings.Trim(mnc, "0123456789") != "" {
+		return "", "", 0, fmt.Errorf("trace ref %q: MNC must be 2 or 3 digits", s)
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(parts[2], "0x"), 16, 32)
+	if err != nil || id > 0xffffff {
+		return "", "", 0, fmt.Errorf("trace ref %q: trace ID must be 24-bit hex", s)
+	}
+	return mcc, mnc, uint32(id), nil
+}
+
+// newTraceInformation builds a Trace Information IE (TS 29.274 8.31). The
+// library only has Trace Reference, so the remaining fields are appended by
+// hand: all triggering events, NE types and interfaces are enabled, so the
+// peer traces everything for the given depth and sends it to tceIP.
+func newTraceInformation(mcc, mnc string, traceID uint32, depth uint8, tceIP net.IP) (*gtpv2ie.IE, error) {
+	ref := gtpv2ie.NewTraceReference(mcc, mnc, traceID)
+	if ref == nil {
+		return nil, fmt.Errorf("trace reference %s-%s-%06x: encoding failed", mcc, mnc, traceID)
+	}
+	if v4 := tceIP.To4(); v4 != nil {
+		tceIP = v4
+	}
+
+	b := make([]byte, 0, 6+9+2+1+12+len(tceIP))
+	b = append(b, ref.Payload...)
+	b = append(b, bytes.Repeat([]byte{0xff}, 9)...)  // triggering events
+	b = append(b, 0xff, 0xff)                        // list of NE types
+	b = append(b, depth)                             // session trace depth
+	b = append(b, bytes.Repeat([]byte{0xff}, 12)...) // list of interfaces
+	b = append(b, tceIP...)                          // trace collection entity
+	return gtpv2ie.New(gtpv2ie.TraceInformation, 0, b), nil
+}
+
+// newNodeFTEID builds an F-TEID for one of our interfaces: Config.NodeIP
+// and, when set, Config.NodeIP6. A net.IP has no zone, so a zoned local or
+// remote address (link-local transport) never leaks into the F-TEID.
+func newNodeFTEID(cfg Config, ifType uint8, teid uint32) *gtpv2ie.IE {
+	return gtpv2ie.NewFullyQualifiedTEIDNetIP(ifType, teid, cfg.NodeIP.To4(), cfg.NodeIP6)
+}
+
+// pdnTypeValue maps a PDN type name to its PDN Type IE value (TS 29.274
+// 8.34).
+func pdnTypeValue(s string) (uint8, error) {
+	switch strings.ToLower(s) {
+	case "ipv4":
+		return 1, nil
+	case "ipv6":
+		return 2, nil
+	case "ipv4v6":
+		return 3, nil
+	}
+	return 0, fmt.Errorf("pdn type %q: want ipv4|ipv6|ipv4v6", s)
+}
+
+// paaAddrs are the addresses of a PAA IE (TS 29.274 8.14).
+type paaAddrs struct {
+	pdnType uint8
+	v4      net.IP     // nil unless ipv4 or ipv4v6
+	v6      *net.IPNet // the prefix, host bits cleared; nil unless ipv6 or ipv4v6
+	iid     net.IP     // the interface identifier sent with the prefix; nil if zero
+}
+
+// parsePAA decodes a PAA IE's payload. For ipv4v6 it carries the IPv6
+// prefix length, the prefix with the interface identifier (16 octets) and
+// then the IPv4 address.
+func parsePAA(b []byte) (paaAddrs, error) {
+	if len(b) < 1 {
+		return paaAddrs{}, errors.New("empty PAA")
+	}
+	a := paaAddrs{pdnType: b[0] & 0x07}
+	need := map[uint8]int{1: 5, 2: 18, 3: 22}[a.pdnType]
+	if len(b) < need {
+		return a, fmt.Errorf("PDN type %d PAA needs %d octets, got %d", a.pdnType, need, len(b))
+	}
+	if a.pdnType == 2 || a.pdnType == 3 {
+		plen := int(b[1])
+		if plen > 128 {
+			return a, fmt.Errorf("IPv6 prefix length %d", plen)
+		}
+		ip := net.IP(slices.Clone(b[2:18]))
+		mask := net.CIDRMask(plen, 128)
+		a.v6 = &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+		iid := make(net.IP, 16)
+		for k := range iid {
+			iid[k] = ip[k] &^ mask[k]
+		}
+		if !iid.IsUnspecified() {
+			a.iid = iid
+		}
+	}
+	switch a.pdnType {
+	case 1:
+		a.v4 = net.IP(slices.Clone(b[1:5]))
+	case 3:
+		a.v4 = net.IP(slices.Clone(b[18:22]))
+	}
+	return a, nil
+}
+
+// paaString renders a PAA IE as "v4", "prefix/len" or "v4,prefix/len".
+func paaString(i *gtpv2ie.IE) string {
+	a, err := parsePAA(i.Payload)
+	if err != nil {
+		return fmt.Sprintf("invalid(%v)", err)
+	}
+	var parts []string
+	if a.v4 != nil {
+		parts = append(parts, a.v4.String())
+	}
+	if a.v6 != nil {
+		parts = append(parts, a.v6.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// String spells out the addresses for the CSRsp log, e.g. "ipv4v6: ipv4
+// 10.45.0.2, ipv6 prefix 2001:db8::/64 (interface id ::1)".
+func (a paaAddrs) String() string {
+	var parts []string
+	if a.v4 != nil {
+		parts = append(parts, "ipv4 "+a.v4.String())
+	}
+	if a.v6 != nil {
+		s := "ipv6 prefix " + a.v6.String()
+		if a.iid != nil {
+			s += " (interface id " + a.iid.String() + ")"
+		}
+		parts = append(parts, s)
+	}
+	switch a.pdnType {
+	case 1, 2:
+		return parts[0]
+	case 3:
+		return "ipv4v6: " + strings.Join(parts, ", ")
+	case 4:
+		return "non-ip"
+	}
+	return fmt.Sprintf("pdn type %d", a.pdnType)
+}
+
+// causeAccepted reports whether a Cause IE carries one of the "request
+// accepted" values (16..19, TS 29.274 table 8.4-1).
+func causeAccepted(i *gtpv2ie.IE) bool {
+	if i == nil {
+		return false
+	}
+	c, err := i.Cause()
+	return err == nil && c >= gtpv2.CauseRequestAccepted && c <= gtpv2.CauseNewPDNTypeDueToSingleAddressBearerOnly
+}
@@ -0,0 +1,179 @@
+package sim
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	gtpv2 "github.com/wmnsk/go-gtp/gtpv2"
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+func TestNewIMSI(t *testing.T) {
+	for _, tc := range []struct {
+		imsi string
+		want []byte // TBCD, low nibble first
+	}{
+		{"001010123456789", []byte{0x00, 0x01, 0x01, 0x21, 0x43, 0x65, 0x87, 0xf9}},
+		{"00101012345678", []byte{0x00, 0x01, 0x01, 0x21, 0x43, 0x65, 0x87}},
+		{"310150", []byte{0x13, 0x10, 0x05}},
+	} {
+		i, err := newIMSI(tc.imsi)
+		if err != nil {
+			t.Errorf("newIMSI(%q): %v", tc.imsi, err)
+			continue
+		}
+		if !bytes.Equal(i.Payload, tc.want) {
+			t.Errorf("newIMSI(%q) = % x, want % x", tc.imsi, i.Payload, tc.want)
+		}
+		if odd := len(tc.imsi)%2 == 1; odd != (i.Payload[len(i.Payload)-1]>>4 == 0xf) {
+			t.Errorf("newIMSI(%q) = % x: filler nibble present is %t, want %t", tc.imsi, i.Payload, !odd, odd)
+		}
+		if got := tbcdDigits(i.Payload); got != tc.imsi {
+			t.Errorf("tbcdDigits(% x) = %q, want %q", i.Payload, got, tc.imsi)
+		}
+	}
+
+	for _, imsi := range []string{"00101012345678a", "0010-0123"} {
+		if err := validateIMSI(imsi); err == nil {
+			t.Errorf("validateIMSI(%q) accepted non-digits", imsi)
+		}
+		if _, err := newIMSI(imsi); err == nil {
+			t.Errorf("newIMSI(%q) accepted non-digits", imsi)
+		}
+	}
+}
+
+func TestDecodeAPN(t *testing.T) {
+	for _, apn := range []string{"internet", "ims", "internet.mnc001.mcc001.gprs", "my-apn.example.mnc015.mcc234.gprs"} {
+		b := gtpv2ie.NewAccessPointName(apn).Payload
+		got, err := decodeAPN(b)
+		if err != nil || got != apn {
+			t.Errorf("decodeAPN(% x) = %q, %v; want %q", b, got, err, apn)
+		}
+	}
+
+	long := append([]byte{64}, bytes.Repeat([]byte{'a'}, 64)...)
+	for _, tc := range []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"label length one too long", []byte{9, 'i', 'n', 't', 'e', 'r', 'n', 'e', 't'}},
+		{"label length one too short", []byte{7, 'i', 'n', 't', 'e', 'r', 'n', 'e', 't'}},
+		{"zero-length label", []byte{3, 'i', 'm', 's', 0, 4, 'g', 'p', 'r', 's'}},
+		{"label over 63 octets", long},
+		{"bad character", []byte{3, 'i', '_', 's'}},
+		{"over 100 octets", bytes.Repeat(append([]byte{9}, "abcdefghi"...), 11)},
+	} {
+		if got, err := decodeAPN(tc.b); err == nil {
+			t.Errorf("%s: decodeAPN(% x) = %q, want an error", tc.name, tc.b, got)
+		}
+	}
+}
+
+func TestParsePAA(t *testing.T) {
+	v6 := net.ParseIP("2001:db8:0:1::5") // prefix 2001:db8:0:1::/64, interface id ::5
+	dual := append([]byte{3, 64}, v6...)
+	dual = append(dual, 10, 45, 0, 2)
+	for _, tc := range []struct {
+		name     string
+		b        []byte
+		pdnType  uint8
+		v4, pfx  string
+		iid      string
+		wantText string
+	}{
+		{"ipv4", []byte{1, 10, 45, 0, 7}, 1, "10.45.0.7", "", "", "ipv4 10.45.0.7"},
+		{"ipv6", append([]byte{2, 64}, v6...), 2, "", "2001:db8:0:1::/64", "::5",
+			"ipv6 prefix 2001:db8:0:1::/64 (interface id ::5)"},
+		{"ipv6 /128, no interface id", append([]byte{2, 128}, v6...), 2, "", "2001:db8:0:1::5/128", "",
+			"ipv6 prefix 2001:db8:0:1::5/128"},
+		{"ipv4v6", dual, 3, "10.45.0.2", "2001:db8:0:1::/64", "::5",
+			"ipv4v6: ipv4 10.45.0.2, ipv6 prefix 2001:db8:0:1::/64 (interface id ::5)"},
+	} {
+		a, err := parsePAA(tc.b)
+		if err != nil {
+			t.Errorf("%s: parsePAA: %v", tc.name, err)
+			continue
+		}
+		if a.pdnType != tc.pdnType {
+			t.Errorf("%s: pdn type %d, want %d", tc.name, a.pdnType, tc.pdnType)
+		}
+		if got := ipString(a.v4); got != tc.v4 {
+			t.Errorf("%s: v4 %q, want %q", tc.name, got, tc.v4)
+		}
+		var pfx string
+		if a.v6 != nil {
+			pfx = a.v6.String()
+		}
+		if pfx != tc.pfx {
+			t.Errorf("%s: prefix %q, want %q", tc.name, pfx, tc.pfx)
+		}
+		if got := ipString(a.iid); got != tc.iid {
+			t.Errorf("%s: interface id %q, want %q", tc.name, got, tc.iid)
+		}
+		if got := a.String(); got != tc.wantText {
+			t.Errorf("%s: %q, want %q", tc.name, got, tc.wantText)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"short ipv4", []byte{1, 10, 45, 0}},
+		{"short ipv6", append([]byte{2, 64}, v6[:15]...)},
+		{"ipv4v6 without the v4", dual[:21]},
+		{"ipv6 prefix length over 128", append([]byte{2, 129}, v6...)},
+	} {
+		if _, err := parsePAA(tc.b); err == nil {
+			t.Errorf("%s: parsePAA(% x) succeeded, want an error", tc.name, tc.b)
+		}
+	}
+}
+
+func TestNodeFTEIDLinkLocal(t *testing.T) {
+	global := net.ParseIP("2001:db8::10")
+
+	// A link-local transport: the zone stays in -remote, the F-TEID
+	// carries the global node address, V6 flag set, 16 bytes and no zone.
+	cfg := DefaultConfig()
+	cfg.Remote = "[fe80::1%eth0]:2123"
+	cfg.Local = "[fe80::2%eth0]:2123"
+	cfg.NodeIP6 = global
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validate with a zoned remote: %v", err)
+	}
+	if raddr, err := net.ResolveUDPAddr("udp", cfg.Remote); err != nil || raddr.Zone != "eth0" {
+		t.Fatalf("resolve %s = %v, %v; want zone eth0", cfg.Remote, raddr, err)
+	}
+	i := newNodeFTEID(cfg, gtpv2.IFTypeS5S8SGWGTPC, 0x1234)
+	if len(i.Payload) != 1+4+4+net.IPv6len {
+		t.Fatalf("F-TEID % x: %d octets, want %d (flags, TEID, v4, v6)", i.Payload, len(i.Payload), 1+4+4+net.IPv6len)
+	}
+	if f := i.Payload[0]; f&0x80 == 0 || f&0x40 == 0 {
+		t.Errorf("F-TEID flags 0x%02x: want V4 and V6", f)
+	}
+	if v6 := net.IP(i.Payload[9:]); !v6.Equal(global) {
+		t.Errorf("F-TEID IPv6 %v, want %v", v6, global)
+	}
+	if got, err := i.IPv6(); err != nil || !got.Equal(global) || strings.Contains(got.String(), "%") {
+		t.Errorf("F-TEID IPv6() = %v, %v; want %v without a zone", got, err, global)
+	}
+
+	// Without -node-ip6 the F-TEID stays IPv4 only.
+	cfg.NodeIP6 = nil
+	if f := newNodeFTEID(cfg, gtpv2.IFTypeS5S8SGWGTPC, 0x1234).Payload[0]; f&0x40 != 0 {
+		t.Errorf("F-TEID flags 0x%02x without -node-ip6: want no V6", f)
+	}
+
+	for _, ip := range []string{"fe80::1", "::", "ff02::1", "10.0.0.1"} {
+		cfg.NodeIP6 = net.ParseIP(ip)
+		if err := cfg.validate(); err == nil {
+			t.Errorf("validate accepted node IPv6 %s", ip)
+		}
+	}
+}
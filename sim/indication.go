@@ -0,0 +1,88 @@
+package sim
+
+import (
+	"log"
+	"strings"
+
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+// indicationBits names the Indication IE flags per octet, bit 8 first
+// (3GPP TS 29.274 8.12). Empty names are spare bits.
+var indicationBits = [][8]string{
+	{"DAF", "DTF", "HI", "DFI", "OI", "ISRSI", "ISRAI", "SGWCI"},
+	{"SQCI", "UIMSI", "CFSI", "CRSI", "PS", "PT", "SI", "MSV"},
+	{"RetLoc", "PBIC", "SRNI", "S6AF", "S4AF", "MBMDT", "ISRAU", "CCRSI"},
+	{"CPRAI", "ARRL", "PPOFF", "PPON", "PPSI", "CSFBI", "CLII", "CPSR"},
+	{"NSI", "UASI", "DTCI", "BDWI", "PSCI", "PCRI", "AOSI", "AOPI"},
+	{"ROAAI", "EPCOSI", "CPOPCI", "PMTMSI", "S11TF", "PNSI", "UNACCSI", "WPMSI"},
+	{"5GSNN26", "REPREFI", "5GSIWK", "EEVRSI", "LTEMUI", "LTEMPI", "ENBCRSI", "TSPCMI"},
+	{"CSRMFI", "MTEDTN", "MTEDTA", "N5GNMI", "5GCNRS", "5GCNRI", "5SRHOI", "ETHPDN"},
+	{"", "", "", "", "", "", "", "EMCI"},
+}
+
+// indicationFollowUps are flags a PGW may set in the CSRsp that change how
+// the rest of the session should be driven.
+var indicationFollowUps = map[string]string{
+	"PPSI":   "PGW supports PDN charging pause",
+	"PPON":   "PDN charging pause enabled",
+	"PPOFF":  "PDN charging pause disabled",
+	"CPOPCI": "control plane only PDN connection (no user plane)",
+	"EPCOSI": "ePCO supported",
+	"PSCI":   "PGW supports PCO change reporting",
+	"DTF":    "direct tunnel in use",
+	"SI":     "scope indication",
+}
+
+// indicationFlags returns the names of the flags set in an Indication IE.
+func indicationFlags(i *gtpv2ie.IE) []string {
+	var set []string
+	for o, b := range i.Payload {
+		if o >= len(indicationBits) {
+			break
+		}
+		for bit := 0; bit < 8; bit++ {
+			if b&(0x80>>bit) != 0 && indicationBits[o][bit] != "" {
+				set = append(set, indicationBits[o][bit])
+			}
+		}
+	}
+	return set
+}
+
+// newIndication builds an Indication IE with the named flags set, only as
+// many octets long as the last flag needs.
+func newIndication(flags ...string) *gtpv2ie.IE {
+	var b []byte
+	for _, f := range flags {
+		for o, names := range indicationBits {
+			for bit, n := range names {
+				if n == f {
+					for len(b) <= o {
+						b = append(b, 0)
+					}
+					b[o] |= 0x80 >> bit
+				}
+			}
+		}
+	}
+	return gtpv2ie.New(gtpv2ie.Indication, 0, b)
+}
+
+// logIndication logs the flags set in a received Indication IE and any that
+// call for follow-up behaviour. A nil IE is ignored.
+func logIndication(msg string, i *gtpv2ie.IE) {
+	if i == nil {
+		return
+	}
+	flags := indicationFlags(i)
+	if len(flags) == 0 {
+		return
+	}
+	log.Printf("%s Indication: %s", msg, strings.Join(flags, ","))
+	for _, f := range flags {
+		if note, ok := indicationFollowUps[f]; ok {
+			log.Printf("%s Indication %s: %s", msg, f, note)
+		}
+	}
+}
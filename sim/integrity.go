@@ -0,0 +1,240 @@
+package sim
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gtp "github.com/wmnsk/go-gtp"
+)
+
+// integrityKeep is how long a sent datagram's digest waits for the other
+// end to check it.
+const integrityKeep = time.Minute
+
+// integrityDiffRows caps the 16-byte rows a mismatch's diff shows.
+const integrityDiffRows = 8
+
+type integritySent struct {
+	sum [sha256.Size]byte
+	b   []byte
+	at  time.Time
+}
+
+// integrityCheck is one end of the selftest's integrity check
+// (Config.IntegrityCheck). It records a SHA-256 digest and a copy of each
+// datagram its transport sends, and checks the datagrams it receives
+// against what peer, the other end, recorded.
+type integrityCheck struct {
+	mu   sync.Mutex
+	sent map[uint64]integritySent // by message type<<32 | sequence
+
+	peer atomic.Pointer[integrityCheck] // set by NewSelfTest
+
+	checked, unverified, mismatches atomic.Uint64
+}
+
+func newIntegrityCheck() *integrityCheck {
+	return &integrityCheck{sent: make(map[uint64]integritySent)}
+}
+
+// integrityKey identifies a GTPv2 datagram by its (first) message's type
+// and sequence number; ok is false for anything else.
+func integrityKey(b []byte) (k uint64, ok bool) {
+	if len(b) < 8 || b[0]>>5 != 2 {
+		return 0, false
+	}
+	return uint64(b[1])<<32 | uint64(rawSeq(b)), true
+}
+
+// record is the transport's tap: it runs before each datagram goes out, so
+// the other end never sees one it has no record of. A retransmission
+// replaces the record with the same bytes.
+func (ic *integrityCheck) record(b []byte) {
+	k, ok := integrityKey(b)
+	if !ok {
+		return
+	}
+	e := integritySent{sum: sha256.Sum256(b), b: slices.Clone(b), at: time.Now()}
+	ic.mu.Lock()
+	ic.sent[k] = e
+	ic.mu.Unlock()
+}
+
+func (ic *integrityCheck) lookup(b []byte) (integritySent, bool) {
+	k, ok := integrityKey(b)
+	if !ok {
+		return integritySent{}, false
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	e, ok := ic.sent[k]
+	return e, ok
+}
+
+// prune drops the records older than integrityKeep; the sweep calls it.
+func (ic *integrityCheck) prune() {
+	cutoff := time.Now().Add(-integrityKeep)
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	for k, e := range ic.sent {
+		if e.at.Before(cutoff) {
+			delete(ic.sent, k)
+		}
+	}
+}
+
+// verify checks a datagram from the other end against its record: the
+// bytes must hash as sent (wire), and so must the messages parsed from them
+// and marshaled again (round trip), which catches a parse or marshal that
+// loses or changes anything. A datagram the other end has no record of is
+// only counted. A mismatch is logged with a byte-level diff.
+func (ic *integrityCheck) verify(pkt []byte, from *net.UDPAddr) {
+	other := ic.peer.Load()
+	if other == nil {
+		return
+	}
+	e, ok := other.lookup(pkt)
+	if !ok {
+		ic.unverified.Add(1)
+		return
+	}
+	ic.checked.Add(1)
+	name, seq := msgName(pkt[1]), rawSeq(pkt)
+	if sha256.Sum256(pkt) != e.sum {
+		ic.fail(name, seq, from, "on the wire", e, pkt)
+		return
+	}
+	re, err := reencode(pkt)
+	if err != nil {
+		ic.mismatches.Add(1)
+		log.Printf("INTEGRITY FAILURE: %s seq=%d from %s does not parse back: %v", name, seq, from, err)
+		return
+	}
+	if sha256.Sum256(re) != e.sum {
+		ic.fail(name, seq, from, "after parse and marshal", e, re)
+	}
+}
+
+func (ic *integrityCheck) fail(name string, seq uint32, from *net.UDPAddr, where string, e integritySent, got []byte) {
+	ic.mismatches.Add(1)
+	sum := sha256.Sum256(got)
+	log.Printf("INTEGRITY FAILURE: %s seq=%d from %s differs %s: sent %d bytes sha256=%x..., got %d bytes sha256=%x...",
+		name, seq, from, where, len(e.b), e.sum[:8], len(got), sum[:8])
+	for _, l := range byteDiff(e.b, got) {
+		log.Printf("INTEGRITY FAILURE:   %s", l)
+	}
+}
+
+// reencode parses each message of a datagram, piggybacked ones included,
+// and marshals it again.
+func reencode(pkt []byte) ([]byte, error) {
+	var out []byte
+	for len(pkt) > 0 {
+		first, next := splitPiggyback(pkt)
+		m, err := gtp.Parse(first)
+		if err != nil {
+			return nil, err
+		}
+		b, err := gtp.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+		pkt = next
+	}
+	return out, nil
+}
+
+// byteDiff renders the 16-byte rows where want and got differ as hex, the
+// sent row over the received one and a caret under each differing byte;
+// "--" is a byte past the end of one of them. At most integrityDiffRows
+// rows are shown, e.g.
+//
+//	0010 sent 00 00 05 00 01 00 fe 57 00 03 00 01 00 00 47 00
+//	0010 got  00 00 05 00 01 00 fe 57 00 03 00 02 00 00 47 00
+//	                                           ^^
+func byteDiff(want, got []byte) []string {
+	var out []string
+	rows := 0
+	for off := 0; off < max(len(want), len(got)); off += 16 {
+		var w, g, mark strings.Builder
+		differs := false
+		for i := off; i < off+16 && i < max(len(want), len(got)); i++ {
+			wb, gb := "--", "--"
+			if i < len(want) {
+				wb = fmt.Sprintf("%02x", want[i])
+			}
+			if i < len(got) {
+				gb = fmt.Sprintf("%02x", got[i])
+			}
+			m := "  "
+			if wb != gb {
+				m, differs = "^^", true
+			}
+			w.WriteString(" " + wb)
+			g.WriteString(" " + gb)
+			mark.WriteString(" " + m)
+		}
+		if !differs {
+			continue
+		}
+		if rows++; rows > integrityDiffRows {
+			out = append(out, "... more differing rows left out")
+			break
+		}
+		out = append(out,
+			fmt.Sprintf("%04x sent%s", off, w.String()),
+			fmt.Sprintf("%04x got %s", off, g.String()),
+			"         "+strings.TrimRight(mark.String(), " "))
+	}
+	return out
+}
+
+// IntegrityError reports a selftest in which -integrity-check found
+// messages that did not arrive, or parse and marshal again, as sent.
+type IntegrityError struct {
+	Checked, Mismatches uint64
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("integrity check: %d of %d message(s) were not received as sent", e.Mismatches, e.Checked)
+}
+
+// integrityTotals sums the counts of both ends of the selftest.
+func (c *Client) integrityTotals() (checked, unverified, mismatches uint64) {
+	for _, ic := range []*integrityCheck{c.integrity, c.integrity.peer.Load()} {
+		if ic != nil {
+			checked += ic.checked.Load()
+			unverified += ic.unverified.Load()
+			mismatches += ic.mismatches.Load()
+		}
+	}
+	return checked, unverified, mismatches
+}
+
+// CheckIntegrity returns an *IntegrityError if Config.IntegrityCheck found
+// a mismatch at either end of the selftest.
+func (c *Client) CheckIntegrity() error {
+	if c.integrity == nil {
+		return nil
+	}
+	if checked, _, n := c.integrityTotals(); n > 0 {
+		return &IntegrityError{Checked: checked, Mismatches: n}
+	}
+	return nil
+}
+
+// pairIntegrity lets the two ends of a selftest check each other's
+// datagrams.
+func pairIntegrity(sgw, pgw *Client) {
+	sgw.integrity.peer.Store(pgw.integrity)
+	pgw.integrity.peer.Store(sgw.integrity)
+	log.Printf("integrity check: every datagram between SGW and PGW is hashed when sent and checked when received")
+}
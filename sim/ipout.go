@@ -0,0 +1,46 @@
+package sim
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+// ipOut appends "imsi,ipv4,ipv6/prefix" lines for sessions the PGW accepted,
+// so external traffic generators can pick up the assigned UE addresses.
+type ipOut struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func openIPOut(path string) (*ipOut, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &ipOut{f: f}, nil
+}
+
+// write records the PAA of one session. Either address column may be empty.
+func (o *ipOut) write(imsi string, paa *gtpv2ie.IE) error {
+	a, err := parsePAA(paa.Payload)
+	if err != nil {
+		return fmt.Errorf("parse paa: %w", err)
+	}
+	var v4, v6 string
+	if a.v4 != nil {
+		v4 = a.v4.String()
+	}
+	if a.v6 != nil {
+		v6 = a.v6.String()
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, err = fmt.Fprintf(o.f, "%s,%s,%s\n", imsi, v4, v6)
+	return err
+}
+
+func (o *ipOut) Close() error { return o.f.Close() }
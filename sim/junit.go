@@ -0,0 +1,212 @@
+package sim
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JUnit XML (the de facto schema CI dashboards read): a testsuites
+// element of testsuite elements of testcase elements.
+type (
+	junitSuites struct {
+		XMLName  xml.Name      `xml:"testsuites"`
+		Name     string        `xml:"name,attr"`
+		Tests    int           `xml:"tests,attr"`
+		Failures int           `xml:"failures,attr"`
+		Skipped  int           `xml:"skipped,attr"`
+		Time     string        `xml:"time,attr"`
+		Suites   []*junitSuite `xml:"testsuite"`
+	}
+	junitSuite struct {
+		Name      string      `xml:"name,attr"`
+		Tests     int         `xml:"tests,attr"`
+		Failures  int         `xml:"failures,attr"`
+		Skipped   int         `xml:"skipped,attr"`
+		Time      string      `xml:"time,attr"`
+		Timestamp string      `xml:"timestamp,attr"`
+		Cases     []junitCase `xml:"testcase"`
+	}
+	junitCase struct {
+		Classname string        `xml:"classname,attr"`
+		Name      string        `xml:"name,attr"`
+		Time      string        `xml:"time,attr"`
+		Failure   *junitFailure `xml:"failure,omitempty"`
+		Skipped   *junitSkipped `xml:"skipped,omitempty"`
+		SystemOut string        `xml:"system-out,omitempty"`
+
+		took time.Duration
+	}
+	junitFailure struct {
+		Message string `xml:"message,attr"`
+		Type    string `xml:"type,attr"`
+		Text    string `xml:",chardata"`
+	}
+	junitSkipped struct {
+		Message string `xml:"message,attr"`
+	}
+)
+
+// junitReport collects the run's test cases for Config.JUnit: one per
+// session when it ends, one per failed CreateSession and one per scenario
+// step, each suite in the order it was first used.
+type junitReport struct {
+	mu     sync.Mutex
+	start  time.Time
+	suites []*junitSuite
+}
+
+func newJUnitReport() *junitReport {
+	return &junitReport{start: time.Now()}
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// failureType names what kind of failure err is, e.g. "timeout" or
+// "rejected".
+func failureType(err error) string {
+	var te *TxnError
+	if errors.As(err, &te) {
+		return te.Kind.String()
+	}
+	return "error"
+}
+
+// add appends c to suite, which starts now if it is new.
+func (r *junitReport) add(suite string, c junitCase) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var s *junitSuite
+	for _, x := range r.suites {
+		if x.Name == suite {
+			s = x
+		}
+	}
+	if s == nil {
+		s = &junitSuite{Name: suite, Timestamp: time.Now().UTC().Format("2006-01-02T15:04:05")}
+		r.suites = append(r.suites, s)
+	}
+	c.Time = junitSeconds(c.took)
+	s.Cases = append(s.Cases, c)
+}
+
+// write renders the report to path, atomically as WriteMetricsFile does.
+func (r *junitReport) write(path string) error {
+	r.mu.Lock()
+	all := &junitSuites{Name: "gtp-sim", Time: junitSeconds(time.Since(r.start)), Suites: r.suites}
+	for _, s := range r.suites {
+		s.Tests, s.Failures, s.Skipped = len(s.Cases), 0, 0
+		var took time.Duration
+		for _, c := range s.Cases {
+			took += c.took
+			if c.Failure != nil {
+				s.Failures++
+			}
+			if c.Skipped != nil {
+				s.Skipped++
+			}
+		}
+		s.Time = junitSeconds(took)
+		all.Tests += s.Tests
+		all.Failures += s.Failures
+		all.Skipped += s.Skipped
+	}
+	b, err := xml.MarshalIndent(all, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // no-op once renamed
+	if _, err := f.WriteString(xml.Header + string(b) + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// junitSession adds sess's test case if -junit is set: it fails if one of
+// its procedures did, with their errors as the failure text.
+func (c *Client) junitSession(sess *Session, endCause string) {
+	if c.junit == nil {
+		return
+	}
+	sess.mu.Lock()
+	steps, errs := strings.Join(sess.steps, ","), strings.Join(sess.errs, "\n")
+	failed := sess.failed
+	sess.mu.Unlock()
+	tc := junitCase{
+		Classname: "sessions." + sess.Group,
+		Name:      fmt.Sprintf("imsi=%s apn=%s ebi=%d", sess.IMSI, sess.APN, sess.EBI),
+		took:      time.Since(sess.Start),
+		SystemOut: fmt.Sprintf("steps=%s ip=%s charging-id=%d end=%s", steps, sess.PAA, sess.ChargingID, endCause),
+	}
+	if failed {
+		tc.Failure = &junitFailure{Message: "steps " + steps, Type: "procedure", Text: errs}
+	}
+	c.junit.add("sessions", tc)
+}
+
+// junitSessionFailed adds the failing test case of a CreateSession that
+// never became a session.
+func (c *Client) junitSessionFailed(cfg Config, cause uint8, err error, took time.Duration) {
+	if c.junit == nil {
+		return
+	}
+	c.junit.add("sessions", junitCase{
+		Classname: "sessions." + sessionGroup(cfg),
+		Name:      fmt.Sprintf("imsi=%s apn=%s ebi=%d", cfg.IMSI, cfg.APN, cfg.EBI),
+		took:      took,
+		Failure:   &junitFailure{Message: "CSR=" + stepOutcome(cause, err), Type: failureType(err), Text: err.Error()},
+	})
+}
+
+// scenarioClass is the test case class of sc's steps: "scenario." and its
+// file name without the extension.
+func scenarioClass(sc *Scenario) string {
+	base := filepath.Base(sc.Name)
+	return "scenario." + strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// junitStep adds the test case of scenario step k of sc: passed with a nil
+// err, else failed with it.
+func (c *Client) junitStep(sc *Scenario, k int, took time.Duration, err error) {
+	if c.junit == nil {
+		return
+	}
+	tc := junitCase{Classname: scenarioClass(sc), Name: fmt.Sprintf("%02d %s", k+1, sc.Steps[k]), took: took}
+	if err != nil {
+		tc.Failure = &junitFailure{Message: err.Error(), Type: failureType(err)}
+	}
+	c.junit.add("scenario "+sc.Name, tc)
+}
+
+// junitSkipped adds the steps of sc from k on, which a failure left unrun.
+func (c *Client) junitSkipped(sc *Scenario, k int) {
+	if c.junit == nil {
+		return
+	}
+	for ; k < len(sc.Steps); k++ {
+		c.junit.add("scenario "+sc.Name, junitCase{
+			Classname: scenarioClass(sc), Name: fmt.Sprintf("%02d %s", k+1, sc.Steps[k]),
+			Skipped: &junitSkipped{Message: "not run after an earlier step failed"},
+		})
+	}
+}
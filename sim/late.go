@@ -0,0 +1,65 @@
+package sim
+
+import (
+	"log"
+	"time"
+
+	gtp "github.com/wmnsk/go-gtp"
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// lateResponse is the registry's onLate: it logs an answer that came after
+// its transaction timed out, a sign of a slow peer rather than a broken
+// one. A late CSRsp that accepted the session left it on the peer with
+// nobody using it, so the session is deleted again.
+func (c *Client) lateResponse(m gtpv2msg.Message, req uint8, after time.Duration) {
+	log.Printf("late %s for seq %d, %dms after timeout;%s", msgName(m.MessageType()), m.Sequence(), after.Milliseconds(), respCause(m))
+	if resp, ok := m.(*gtpv2msg.CreateSessionResponse); ok && req == gtpv2msg.MsgTypeCreateSessionRequest {
+		// Not on the receive worker: the DSR waits for its answer.
+		go c.deleteLateSession(resp)
+	}
+}
+
+// deleteLateSession sends a DeleteSessionRequest for the session a late
+// CSRsp accepted. The session was never recorded, so the TEIDs and EBI come
+// from the CSRsp and the IMSI, for the log, from the CSR if the sent log
+// still has it.
+func (c *Client) deleteLateSession(resp *gtpv2msg.CreateSessionResponse) {
+	if resp.Cause == nil || !causeAccepted(resp.Cause) || resp.SenderFTEIDC == nil {
+		return
+	}
+	sess := &Session{IMSI: "?", EBI: c.cfg.EBI, LocalCTEID: resp.TEID()}
+	sess.RemoteCTEID, _ = resp.SenderFTEIDC.TEID()
+	if len(resp.BearerContextsCreated) > 0 {
+		if ebi := bearerEBI(resp.BearerContextsCreated[0]); ebi != 0 {
+			sess.EBI = ebi
+		}
+	}
+	if r, ok := c.sent.get(resp.Sequence()); ok {
+		if m, err := gtp.Parse(r.b); err == nil {
+			if csr, ok := m.(*gtpv2msg.CreateSessionRequest); ok && csr.IMSI != nil {
+				sess.IMSI, _ = csr.IMSI.IMSI()
+			}
+		}
+	}
+
+	seq := c.seq.next()
+	req := gtpv2msg.NewDeleteSessionRequest(headerTEID(c.cfg, sess), seq, gtpv2ie.NewEPSBearerID(sess.EBI))
+	log.Printf("late CSRsp seq=%d accepted imsi=%s ebi=%d: tx DSR seq=%d teid=0x%08x to delete it",
+		resp.Sequence(), sess.IMSI, sess.EBI, seq, sess.RemoteCTEID)
+	m, _, err := c.transactTo(req, c.sentTo(resp.Sequence()))
+	if err == nil {
+		var causeIE *gtpv2ie.IE
+		if dsr, ok := m.(*gtpv2msg.DeleteSessionResponse); ok {
+			causeIE = dsr.Cause
+		}
+		_, err = c.checkResponse(req, m, gtpv2msg.MsgTypeDeleteSessionResponse, causeIE)
+	}
+	if err != nil {
+		log.Printf("late CSRsp seq=%d: DSR failed, the session may be left on the peer: %v", resp.Sequence(), err)
+		return
+	}
+	c.lateDeleted.Add(1)
+	log.Printf("late CSRsp seq=%d: session imsi=%s deleted", resp.Sequence(), sess.IMSI)
+}
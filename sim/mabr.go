@@ -0,0 +1,113 @@
+package sim
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"slices"
+
+	gtpv2 "github.com/wmnsk/go-gtp/gtpv2"
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// ModifyAccessBearers plays the MME on S11: it moves every bearer of sess
+// to a new eNodeB S1-U F-TEID (our node address and the bearer's own TEID)
+// with a ModifyAccessBearersRequest, and checks the response cause. The
+// per-bearer causes and the SGW S1-U F-TEIDs of the bearer contexts
+// modified are logged, as are bearers the SGW marked for removal.
+func (c *Client) ModifyAccessBearers(sess *Session) error {
+	cfg := c.sessCfg(c.cfg, sess)
+	seq := c.seq.next()
+	teid := headerTEID(cfg, sess)
+	sess.mu.Lock()
+	ebis := slices.Sorted(maps.Keys(sess.Bearers))
+	ies := make([]*gtpv2ie.IE, 0, len(ebis))
+	for _, ebi := range ebis {
+		ies = append(ies, gtpv2ie.NewBearerContext(
+			gtpv2ie.NewEPSBearerID(ebi),
+			newNodeFTEID(cfg, gtpv2.IFTypeS1UeNodeBGTPU, sess.Bearers[ebi].LocalUTEID),
+		))
+	}
+	sess.mu.Unlock()
+	req := gtpv2msg.NewModifyAccessBearersRequest(teid, seq, ies...)
+
+	c.msgLogf("tx ModifyAccessBearersReq seq=%d teid=0x%08x imsi=%s ebis=%v", seq, teid, sess.IMSI, ebis)
+	m, rtt, err := c.transactTo(req, c.peerOf(sess))
+	if err != nil {
+		sess.record("MABR", 0, err)
+		return err
+	}
+	var causeIE *gtpv2ie.IE
+	resp, _ := m.(*gtpv2msg.ModifyAccessBearersResponse)
+	if resp != nil {
+		causeIE = resp.Cause
+	}
+	cause, err := c.checkResponse(req, m, gtpv2msg.MsgTypeModifyAccessBearersResponse, causeIE)
+	sess.record("MABR", cause, err)
+	if err != nil {
+		return err
+	}
+	c.msgLogf("ModifyAccessBearers succeeded seq=%d rtt=%s cause=%s", seq, rtt, causeName(cause))
+	for _, bc := range resp.BearerContextsModified {
+		logAccessBearer("modified", bc)
+	}
+	for _, bc := range resp.BearerContextsMarkedForRemoval {
+		logAccessBearer("marked for removal", bc)
+	}
+	return nil
+}
+
+// logAccessBearer logs one bearer context of a ModifyAccessBearersResponse:
+// its cause and, if present, the SGW S1-U F-TEID.
+func logAccessBearer(what string, bc *gtpv2ie.IE) {
+	cause, fteid := "missing", "none"
+	for _, ie := range bc.ChildIEs {
+		switch ie.Type {
+		case gtpv2ie.Cause:
+			if v, err := ie.Cause(); err == nil {
+				cause = causeName(v)
+			}
+		case gtpv2ie.FullyQualifiedTEID:
+			if t, err := ie.InterfaceType(); err != nil || t != gtpv2.IFTypeS1USGWGTPU {
+				continue
+			}
+			teid, _ := ie.TEID()
+			ip, _ := ie.IPAddress()
+			fteid = fmt.Sprintf("teid=0x%08x ip=%s", teid, ip)
+		}
+	}
+	log.Printf("ModifyAccessBearersRsp ebi=%d %s: cause %s, SGW S1-U %s", bearerEBI(bc), what, cause, fteid)
+}
+
+// answerMABR plays the SGW: it accepts a ModifyAccessBearersRequest for a
+// session we created, answering each bearer to be modified with an S1-U
+// SGW F-TEID on our node address and each bearer to be removed as marked
+// for removal.
+func (c *Client) answerMABR(req *gtpv2msg.ModifyAccessBearersRequest, peer *net.UDPAddr) {
+	sgw, ok := c.rs.sgwTEID(req.TEID(), false)
+	if !ok {
+		c.reply(gtpv2msg.NewModifyAccessBearersResponse(0, req.Sequence(),
+			gtpv2ie.NewCause(gtpv2.CauseContextNotFound, 0, 0, 0, nil)), peer)
+		c.rxLogf(req.MessageType(), "rx ModifyAccessBearersReq from %s teid=0x%08x seq=%d -> context not found", peer, req.TEID(), req.Sequence())
+		return
+	}
+	ies := []*gtpv2ie.IE{gtpv2ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil)}
+	for _, bc := range req.BearerContextsToBeModified {
+		ies = append(ies, gtpv2ie.NewBearerContext(
+			gtpv2ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
+			gtpv2ie.NewEPSBearerID(bearerEBI(bc)),
+			newNodeFTEID(c.cfg, gtpv2.IFTypeS1USGWGTPU, randUint32()),
+		))
+	}
+	for _, bc := range req.BearerContextsToBeRemoved {
+		ies = append(ies, gtpv2ie.NewBearerContext(
+			gtpv2ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
+			gtpv2ie.NewEPSBearerID(bearerEBI(bc)),
+		).WithInstance(1))
+	}
+	c.reply(gtpv2msg.NewModifyAccessBearersResponse(sgw, req.Sequence(), ies...), peer)
+	c.rxLogf(req.MessageType(), "rx ModifyAccessBearersReq from %s teid=0x%08x seq=%d -> accepted, %d bearer(s) modified, %d marked for removal",
+		peer, req.TEID(), req.Sequence(), len(req.BearerContextsToBeModified), len(req.BearerContextsToBeRemoved))
+}
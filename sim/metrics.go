@@ -0,0 +1,116 @@
+package sim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+// openMetricsType is the Content-Type of the /metrics exposition.
+const openMetricsType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// rttQuantiles are the quantiles of the RTT summary, taken over the rolling
+// latency window.
+var rttQuantiles = []float64{0.5, 0.9, 0.95, 0.99}
+
+// WriteMetrics writes the run's counters, gauges and RTT summary to w in
+// the OpenMetrics text format. /metrics and the -metrics-file textfile both
+// come from here, so they always carry the same metrics.
+func (c *Client) WriteMetrics(w io.Writer) error {
+	st := c.tr.st
+	st.mu.Lock()
+	tx, rx, errs, causes := maps.Clone(st.txTotal), maps.Clone(st.rxTotal), maps.Clone(st.errs), maps.Clone(st.causes)
+	lat := append([]time.Duration(nil), st.lat...)
+	rttSum, rttCount := st.rttSum, st.rttCount
+	st.mu.Unlock()
+	status := c.Status()
+
+	b := bufio.NewWriter(w)
+	family := func(name, typ, help string) {
+		fmt.Fprintf(b, "# TYPE %s %s\n# HELP %s %s\n", name, typ, name, help)
+	}
+	byType := func(name, help string, m map[uint8]uint64) {
+		family(name, "counter", help)
+		types := make([]int, 0, len(m))
+		for t := range m {
+			types = append(types, int(t))
+		}
+		sort.Ints(types)
+		for _, t := range types {
+			fmt.Fprintf(b, "%s_total{type=%q} %d\n", name, msgName(uint8(t)), m[uint8(t)])
+		}
+	}
+
+	byType("gtpsim_messages_sent", "GTPv2-C messages sent, by type.", tx)
+	byType("gtpsim_messages_received", "GTPv2-C messages received, by type.", rx)
+
+	family("gtpsim_transaction_failures", "counter", "Failed transactions, by kind.")
+	for k := TxnTimeout; k <= TxnTransport; k++ {
+		fmt.Fprintf(b, "gtpsim_transaction_failures_total{kind=%q} %d\n", k, errs[k])
+	}
+	family("gtpsim_rejections", "counter", "Requests rejected by the peer, by cause value.")
+	for _, v := range slices.Sorted(maps.Keys(causes)) {
+		fmt.Fprintf(b, "gtpsim_rejections_total{cause=\"%d\"} %d\n", v, causes[v])
+	}
+	family("gtpsim_retransmits", "counter", "Requests sent again after T3 expired.")
+	fmt.Fprintf(b, "gtpsim_retransmits_total %d\n", c.tr.retransmits.Load())
+	family("gtpsim_transactions_swept", "counter", "Stale transactions evicted from the registry.")
+	fmt.Fprintf(b, "gtpsim_transactions_swept_total %d\n", c.reg.swept.Load())
+	family("gtpsim_late_responses", "counter", "Responses that arrived after their transaction timed out.")
+	fmt.Fprintf(b, "gtpsim_late_responses_total %d\n", c.reg.late.Load())
+	family("gtpsim_write_retries", "counter", "Sends retried after a transient socket error.")
+	fmt.Fprintf(b, "gtpsim_write_retries_total %d\n", c.tr.writeRetries.Load())
+
+	family("gtpsim_rtt_seconds", "summary", "Request to response round-trip time; quantiles over the recent window.")
+	fmt.Fprintf(b, "# UNIT gtpsim_rtt_seconds seconds\n")
+	for _, q := range rttQuantiles {
+		fmt.Fprintf(b, "gtpsim_rtt_seconds{quantile=\"%g\"} %g\n", q, percentile(lat, q).Seconds())
+	}
+	fmt.Fprintf(b, "gtpsim_rtt_seconds_sum %g\ngtpsim_rtt_seconds_count %d\n", rttSum.Seconds(), rttCount)
+
+	family("gtpsim_sessions", "gauge", "Sessions currently established.")
+	fmt.Fprintf(b, "gtpsim_sessions %d\n", status.Sessions)
+	family("gtpsim_inflight", "gauge", "Transactions awaiting a response.")
+	fmt.Fprintf(b, "gtpsim_inflight %d\n", status.Inflight)
+	family("gtpsim_path_up", "gauge", "1 while the peer answered recently (see /healthz).")
+	fmt.Fprintf(b, "gtpsim_path_up %d\n", boolGauge(status.PathUp))
+
+	fmt.Fprintf(b, "# EOF\n")
+	return b.Flush()
+}
+
+// WriteMetricsFile writes the metrics to path for the node_exporter textfile
+// collector. The file is written next to path and renamed into place, so the
+// collector never reads a partial file.
+func (c *Client) WriteMetricsFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // no-op once renamed
+	if err := c.WriteMetrics(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func boolGauge(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
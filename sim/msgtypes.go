@@ -0,0 +1,88 @@
+package sim
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// msgNames are the short names used in logs and stats lines.
+var msgNames = map[uint8]string{
+	gtpv2msg.MsgTypeEchoRequest:                               "EchoReq",
+	gtpv2msg.MsgTypeEchoResponse:                              "EchoResp",
+	gtpv2msg.MsgTypeVersionNotSupportedIndication:             "VersionNotSupported",
+	gtpv2msg.MsgTypeCreateSessionRequest:                      "CSR",
+	gtpv2msg.MsgTypeCreateSessionResponse:                     "CSRsp",
+	gtpv2msg.MsgTypeModifyBearerRequest:                       "MBR",
+	gtpv2msg.MsgTypeModifyBearerResponse:                      "MBRsp",
+	gtpv2msg.MsgTypeDeleteSessionRequest:                      "DSR",
+	gtpv2msg.MsgTypeDeleteSessionResponse:                     "DSRsp",
+	gtpv2msg.MsgTypeChangeNotificationRequest:                 "ChangeNotificationReq",
+	gtpv2msg.MsgTypeChangeNotificationResponse:                "ChangeNotificationRsp",
+	gtpv2msg.MsgTypeSuspendNotification:                       "SuspendNotification",
+	gtpv2msg.MsgTypeSuspendAcknowledge:                        "SuspendAck",
+	gtpv2msg.MsgTypeResumeNotification:                        "ResumeNotification",
+	gtpv2msg.MsgTypeResumeAcknowledge:                         "ResumeAck",
+	gtpv2msg.MsgTypeDeletePDNConnectionSetRequest:             "DeletePDNConnectionSetReq",
+	gtpv2msg.MsgTypeDeletePDNConnectionSetResponse:            "DeletePDNConnectionSetRsp",
+	gtpv2msg.MsgTypeIdentificationRequest:                     "IdentificationReq",
+	gtpv2msg.MsgTypeIdentificationResponse:                    "IdentificationRsp",
+	gtpv2msg.MsgTypeContextRequest:                            "ContextReq",
+	gtpv2msg.MsgTypeContextResponse:                           "ContextRsp",
+	gtpv2msg.MsgTypeContextAcknowledge:                        "ContextAck",
+	gtpv2msg.MsgTypeBearerResourceCommand:                     "BearerResourceCommand",
+	gtpv2msg.MsgTypeBearerResourceFailureIndication:           "BearerResourceFailureIndication",
+	gtpv2msg.MsgTypeCreateBearerRequest:                       "CBReq",
+	gtpv2msg.MsgTypeCreateBearerResponse:                      "CBRsp",
+	gtpv2msg.MsgTypeUpdateBearerRequest:                       "UBReq",
+	gtpv2msg.MsgTypeUpdateBearerResponse:                      "UBRsp",
+	gtpv2msg.MsgTypeDeleteBearerRequest:                       "DBReq",
+	gtpv2msg.MsgTypeDeleteBearerResponse:                      "DBRsp",
+	gtpv2msg.MsgTypeDownlinkDataNotification:                  "DDN",
+	gtpv2msg.MsgTypeDownlinkDataNotificationAcknowledge:       "DDNAck",
+	gtpv2msg.MsgTypeDownlinkDataNotificationFailureIndication: "DDNFailureIndication",
+	gtpv2msg.MsgTypeModifyAccessBearersRequest:                "ModifyAccessBearersReq",
+	gtpv2msg.MsgTypeModifyAccessBearersResponse:               "ModifyAccessBearersRsp",
+}
+
+// msgName returns the short name of a GTPv2 message type, or its number.
+func msgName(t uint8) string {
+	if n, ok := msgNames[t]; ok {
+		return n
+	}
+	return fmt.Sprintf("type%d", t)
+}
+
+// ParseMsgTypes parses a comma-separated list of message types given by
+// short name (as in logs, case-insensitive) or number.
+func ParseMsgTypes(s string) (map[uint8]bool, error) {
+	out := make(map[uint8]bool)
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if v, err := strconv.ParseUint(f, 10, 8); err == nil {
+			out[uint8(v)] = true
+			continue
+		}
+		found := false
+		for t, n := range msgNames {
+			if strings.EqualFold(n, f) {
+				out[t], found = true, true
+			}
+		}
+		if !found {
+			names := make([]string, 0, len(msgNames))
+			for _, n := range msgNames {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown message type %q (want a number or one of %s)", f, strings.Join(names, ","))
+		}
+	}
+	return out, nil
+}
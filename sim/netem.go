@@ -0,0 +1,67 @@
+package sim
+
+import (
+	"encoding/binary"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// rxImpair emulates a lossy, slow network on the receive path (Config.RxLoss,
+// RxDelay, RxJitter), so the retransmission and timeout handling can be
+// exercised without touching the real one.
+type rxImpair struct {
+	dropped, delayed atomic.Uint64
+}
+
+// impaired reports whether received datagrams go through impair.
+func (c *Client) impaired() bool {
+	return c.cfg.RxLoss > 0 || c.cfg.RxDelay > 0 || c.cfg.RxJitter > 0
+}
+
+// impair drops p with probability RxLoss, or hands it to handlePacket after
+// RxDelay plus up to RxJitter. Delayed datagrams skip the rx workers, so
+// jitter may reorder them as a real network would.
+func (c *Client) impair(p rxPacket) {
+	name, seq := msgName(p.pkt[1]), rawSeq(p.pkt)
+	if c.cfg.RxLoss > 0 && randFloat() < c.cfg.RxLoss {
+		c.netem.dropped.Add(1)
+		log.Printf("rx-loss: dropped %s seq=%d from %s", name, seq, p.peer)
+		return
+	}
+	d := c.cfg.RxDelay
+	if c.cfg.RxJitter > 0 {
+		d += time.Duration(randFloat() * float64(c.cfg.RxJitter))
+	}
+	c.netem.delayed.Add(1)
+	log.Printf("rx-delay: holding %s seq=%d from %s for %s", name, seq, p.peer, d.Round(time.Microsecond))
+	time.AfterFunc(d, func() {
+		select {
+		case <-c.done:
+		default:
+			c.handlePacket(p.pkt, p.peer)
+		}
+	})
+}
+
+func (r *rxImpair) report() {
+	log.Printf("run report: rx impairment: dropped %d, delayed %d", r.dropped.Load(), r.delayed.Load())
+}
+
+// rawSeq returns the sequence number of a GTPv2 datagram, 0 if it is too
+// short to have one.
+func rawSeq(b []byte) uint32 {
+	off := 4
+	if len(b) > 0 && b[0]&0x08 != 0 {
+		off = 8 // TEID present
+	}
+	if len(b) < off+3 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b[off:off+4]) >> 8
+}
+
+// randFloat returns a number in [0, 1) from randUint32's source.
+func randFloat() float64 {
+	return float64(randUint32()) / (1 << 32)
+}
@@ -0,0 +1,72 @@
+package sim
+
+import (
+	"fmt"
+	"time"
+)
+
+// pacer schedules sends open-loop: the n-th send is due at start + n/rate,
+// independent of how long earlier sends took, so a slow response doesn't
+// lower the offered load the way a ticker restarted after each send would.
+// A send that is already more than one interval late when its turn comes is
+// still made (the schedule catches up) but counted as an overrun.
+type pacer struct {
+	rate     float64
+	interval time.Duration
+	start    time.Time
+	last     time.Time
+	n        int
+	overruns int
+	held     time.Duration // total pause from hold
+}
+
+func newPacer(rate float64) *pacer {
+	return &pacer{rate: rate, interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// wait blocks until the next send is due.
+func (p *pacer) wait() {
+	if p.n == 0 {
+		p.start = time.Now()
+	}
+	due := p.start.Add(time.Duration(p.n) * p.interval)
+	p.n++
+	if late := time.Since(due); late > p.interval {
+		p.overruns++
+	} else if late < 0 {
+		time.Sleep(-late)
+	}
+	p.last = time.Now()
+}
+
+// hold pauses the schedule until t (a peer back-off): the sends due in the
+// meantime move back by the pause instead of bursting out after it.
+func (p *pacer) hold(t time.Time) {
+	d := time.Until(t)
+	if d <= 0 {
+		return
+	}
+	time.Sleep(d)
+	if p.n > 0 {
+		p.start = p.start.Add(d)
+	}
+	p.held += d
+}
+
+// achieved returns the rate the sends so far were actually made at, or 0
+// before there are two of them.
+func (p *pacer) achieved() float64 {
+	if d := p.last.Sub(p.start).Seconds(); p.n > 1 && d > 0 {
+		return float64(p.n-1) / d
+	}
+	return 0
+}
+
+// String reports offered against achieved rate over the sends made so far.
+func (p *pacer) String() string {
+	s := fmt.Sprintf("offered %.1f/s, achieved %.1f/s over %d sends, overruns %d", p.rate, p.achieved(), p.n, p.overruns)
+	if p.held > 0 {
+		s += fmt.Sprintf(", held %s for peer back-off", p.held.Round(time.Millisecond))
+	}
+	return s
+}
@@ -0,0 +1,208 @@
+package sim
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// peerPath is the GTP-C path to one peer, kept for the whole run and
+// shared by every transaction with it: the peer's restart counter and node
+// features as last seen, and its last answer and RTT. A path with fresh
+// state needs no Echo to show it is alive, so the periodic Echo is skipped
+// while other transactions keep it current.
+type peerPath struct {
+	peer string
+
+	mu           sync.Mutex
+	recovery     int // restart counter from the peer's Recovery IEs; -1 until seen
+	restarts     int // times it changed
+	features     int // Node Features from the peer's Echos; -1 until seen
+	lastResp     time.Time
+	lastRTT      time.Duration
+	lastTraffic  time.Time // last answer to a request other than Echo
+	echoes       int       // periodic Echos sent and skipped
+	echoSkipped  int
+	transactions int
+}
+
+// peerPaths holds the path to each peer by address, created on first use.
+type peerPaths struct {
+	mu sync.Mutex
+	m  map[string]*peerPath
+}
+
+func (p *peerPaths) get(peer net.Addr) *peerPath {
+	key := peer.String()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pp := p.m[key]
+	if pp == nil {
+		if p.m == nil {
+			p.m = make(map[string]*peerPath)
+		}
+		pp = &peerPath{peer: key, recovery: -1, features: -1}
+		p.m[key] = pp
+	}
+	return pp
+}
+
+// all returns the paths sorted by peer.
+func (p *peerPaths) all() []*peerPath {
+	p.mu.Lock()
+	out := make([]*peerPath, 0, len(p.m))
+	for _, pp := range p.m {
+		out = append(out, pp)
+	}
+	p.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].peer < out[j].peer })
+	return out
+}
+
+// answered records resp, the peer's answer to one of our requests.
+func (pp *peerPath) answered(resp gtpv2msg.Message, rtt time.Duration) {
+	now := time.Now()
+	pp.mu.Lock()
+	pp.lastResp, pp.lastRTT = now, rtt
+	if resp.MessageType() != gtpv2msg.MsgTypeEchoResponse {
+		pp.lastTraffic = now
+	}
+	pp.transactions++
+	pp.mu.Unlock()
+	pp.observeRecovery(recoveryIE(resp))
+}
+
+// echoDue reports whether the periodic Echo should go out: not when the
+// peer answered another request within the last interval, which shows the
+// path is up as well as an Echo would.
+func (pp *peerPath) echoDue(interval time.Duration) bool {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if !pp.lastTraffic.IsZero() && time.Since(pp.lastTraffic) < interval {
+		pp.echoSkipped++
+		return false
+	}
+	pp.echoes++
+	return true
+}
+
+// observeRecovery notes the peer's restart counter from a Recovery IE and
+// logs when it changes: the peer restarted and lost its sessions.
+func (pp *peerPath) observeRecovery(i *gtpv2ie.IE) {
+	if i == nil {
+		return
+	}
+	v, err := i.Recovery()
+	if err != nil {
+		log.Printf("WARNING: peer %s: malformed Recovery IE: %v", pp.peer, err)
+		return
+	}
+	pp.mu.Lock()
+	prev := pp.recovery
+	pp.recovery = int(v)
+	if prev >= 0 && prev != int(v) {
+		pp.restarts++
+	}
+	pp.mu.Unlock()
+	switch {
+	case prev < 0:
+		log.Printf("peer %s recovery (restart counter): %d", pp.peer, v)
+	case prev != int(v):
+		log.Printf("WARNING: peer %s restarted: recovery %d -> %d", pp.peer, prev, v)
+	}
+}
+
+// restoreRecovery seeds the peer's restart counter with the one a state
+// file saved, unless one has been seen already.
+func (pp *peerPath) restoreRecovery(v uint8) {
+	pp.mu.Lock()
+	if pp.recovery < 0 {
+		pp.recovery = int(v)
+	}
+	pp.mu.Unlock()
+}
+
+// observeFeatures notes the Node Features of an Echo from the peer,
+// reporting the previous value and whether there was one.
+func (pp *peerPath) observeFeatures(v uint8) (prev uint8, seen bool) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	prev, seen = uint8(pp.features), pp.features >= 0
+	pp.features = int(v)
+	return prev, seen
+}
+
+// recoveryIE is the Recovery IE of the messages that carry one in answer
+// to our requests; nil for others.
+func recoveryIE(m gtpv2msg.Message) *gtpv2ie.IE {
+	switch r := m.(type) {
+	case *gtpv2msg.EchoRequest:
+		return r.Recovery
+	case *gtpv2msg.EchoResponse:
+		return r.Recovery
+	case *gtpv2msg.CreateSessionResponse:
+		return r.Recovery
+	case *gtpv2msg.ModifyBearerResponse:
+		return r.Recovery
+	case *gtpv2msg.DeleteSessionResponse:
+		return r.Recovery
+	}
+	return nil
+}
+
+// PeerState is a peer's entry in /status.
+type PeerState struct {
+	Peer         string     `json:"peer"`
+	Recovery     *uint8     `json:"recovery,omitempty"`
+	Restarts     int        `json:"restarts"`
+	NodeFeatures string     `json:"node_features,omitempty"`
+	LastResponse *time.Time `json:"last_response,omitempty"`
+	LastRTTMs    float64    `json:"last_rtt_ms"`
+	Transactions int        `json:"transactions"`
+	Echoes       int        `json:"echoes"`
+	EchoSkipped  int        `json:"echoes_skipped"`
+}
+
+func (pp *peerPath) state() PeerState {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	st := PeerState{
+		Peer:         pp.peer,
+		Restarts:     pp.restarts,
+		LastRTTMs:    float64(pp.lastRTT) / float64(time.Millisecond),
+		Transactions: pp.transactions,
+		Echoes:       pp.echoes,
+		EchoSkipped:  pp.echoSkipped,
+	}
+	if pp.recovery >= 0 {
+		v := uint8(pp.recovery)
+		st.Recovery = &v
+	}
+	if pp.features >= 0 {
+		st.NodeFeatures = nodeFeaturesString(uint8(pp.features))
+	}
+	if t := pp.lastResp; !t.IsZero() {
+		st.LastResponse = &t
+	}
+	return st
+}
+
+// String renders the path for the run report, e.g. "10.10.10.20:2123:
+// recovery 3, 0 restarts, 1200 transactions, last rtt 1.2ms, 2 Echos (58
+// skipped)".
+func (pp *peerPath) String() string {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	rec := "recovery unknown"
+	if pp.recovery >= 0 {
+		rec = fmt.Sprintf("recovery %d", pp.recovery)
+	}
+	return fmt.Sprintf("%s: %s, %d restarts, %d transactions, last rtt %s, %d Echos (%d skipped)",
+		pp.peer, rec, pp.restarts, pp.transactions, pp.lastRTT, pp.echoes, pp.echoSkipped)
+}
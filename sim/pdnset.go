@@ -0,0 +1,71 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+
+	gtpv2 "github.com/wmnsk/go-gtp/gtpv2"
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// DeletePDNConnectionSet asks the peer to drop every PDN connection tied to
+// our FQ-CSID (Config.CSIDs / CSIDNode), as after a partial SGW failure
+// (TS 23.007). Once accepted, each local session created with one of those
+// CSIDs is probed with a ModifyBearerRequest, which the peer should now
+// answer with "context not found"; the returned counts say how many of them
+// the peer really removed.
+func (c *Client) DeletePDNConnectionSet() (removed, total int, err error) {
+	cfg := c.cfg
+	if len(cfg.CSIDs) == 0 {
+		return 0, 0, errors.New("delete pdn connection set needs -csid")
+	}
+	node := cfg.CSIDNode
+	if node == "" {
+		node = cfg.NodeIP.String()
+	}
+	seq := c.seq.next()
+	req := gtpv2msg.NewDeletePDNConnectionSetRequest(0, seq,
+		gtpv2ie.NewFullyQualifiedCSID(node, cfg.CSIDs...).WithInstance(1), // SGW FQ-CSID
+	)
+
+	c.msgLogf("tx DeletePDNConnectionSetReq seq=%d sgw-fq-csid=%s:%v -> %s", seq, node, cfg.CSIDs, c.tr.remote())
+	m, rtt, err := c.transact(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	var causeIE *gtpv2ie.IE
+	if resp, ok := m.(*gtpv2msg.DeletePDNConnectionSetResponse); ok {
+		causeIE = resp.Cause
+	}
+	cause, err := c.checkResponse(req, m, gtpv2msg.MsgTypeDeletePDNConnectionSetResponse, causeIE)
+	if err != nil {
+		return 0, 0, err
+	}
+	c.msgLogf("DeletePDNConnectionSet succeeded seq=%d rtt=%s cause=%d", seq, rtt, cause)
+
+	for _, sess := range c.sessions.all() {
+		if !slices.ContainsFunc(sess.CSIDs, func(id uint16) bool { return slices.Contains(cfg.CSIDs, id) }) {
+			continue
+		}
+		total++
+		cause, err := c.modifyBearer(cfg, sess)
+		var te *TxnError
+		switch {
+		case err == nil:
+			log.Printf("DeletePDNConnectionSet: %s still known to the peer (MBR cause %d)", sess, cause)
+			continue
+		case errors.As(err, &te) && te.Kind == TxnRejected && te.Cause == gtpv2.CauseContextNotFound:
+			removed++
+		default:
+			log.Printf("DeletePDNConnectionSet: probing %s: %v", sess, err)
+			continue
+		}
+		c.sessions.remove(sess.LocalCTEID)
+		c.endSession(sess, fmt.Sprintf("pdn-set-deleted cause=%d", gtpv2.CauseContextNotFound))
+	}
+	log.Printf("DeletePDNConnectionSet: peer removed %d/%d sessions with CSIDs %v", removed, total, cfg.CSIDs)
+	return removed, total, nil
+}
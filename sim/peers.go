@@ -0,0 +1,172 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+)
+
+// Peer is one PGW of a run with several (Config.Peers) and the local
+// address its GTP-C socket is bound to, for PGWs that expect the SGW in
+// different source subnets.
+type Peer struct {
+	Remote string // PGW ip:port
+	Local  string // local bind ip:port of the socket to it
+	// NodeIP is the SGW IPv4 in the F-TEIDs of the sessions on this PGW;
+	// nil uses Local's address, or Config.NodeIP if Local has none.
+	NodeIP net.IP
+}
+
+// ParsePeer parses a -peer value: remote=ip:port,local=ip:port and
+// optionally node-ip=ip, e.g. "remote=10.20.1.20:2123,local=10.20.1.11:2123".
+func ParsePeer(s string) (Peer, error) {
+	var p Peer
+	for _, f := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(f), "=")
+		if !ok {
+			return Peer{}, fmt.Errorf("peer %q: want key=value, got %q", s, f)
+		}
+		switch k {
+		case "remote":
+			p.Remote = v
+		case "local":
+			p.Local = v
+		case "node-ip":
+			if p.NodeIP = net.ParseIP(v).To4(); p.NodeIP == nil {
+				return Peer{}, fmt.Errorf("peer %q: node-ip %q must be IPv4", s, v)
+			}
+		default:
+			return Peer{}, fmt.Errorf("peer %q: unknown key %q (want remote, local, node-ip)", s, k)
+		}
+	}
+	if p.Remote == "" || p.Local == "" {
+		return Peer{}, fmt.Errorf("peer %q: needs both remote= and local=", s)
+	}
+	return p, nil
+}
+
+// checkPeers validates Config.Peers against the rest of c. A run with peers
+// has one unconnected socket per PGW and spreads its sessions over them, so
+// the single-remote socket modes don't apply.
+func (c *Config) checkPeers() error {
+	if len(c.Peers) == 0 {
+		return nil
+	}
+	if c.Remote != "" {
+		return errors.New("peers replace the remote; give every PGW as a peer")
+	}
+	if c.Respond {
+		return errors.New("peers are for the initiator; the responder answers any peer")
+	}
+	if c.Connected || c.FD >= 0 || c.SpoofSrc != nil || c.SendBatch > 0 || c.SrcPorts > 1 || c.FollowPeer || c.StateFile != "" {
+		return errors.New("peers cannot be combined with connect, fd, spoof-src, send-batch, src-ports, follow-peer or a state file")
+	}
+	seen := make(map[string]bool, len(c.Peers))
+	for _, p := range c.Peers {
+		if p.Remote == "" || p.Local == "" {
+			return fmt.Errorf("peer %+v: needs both a remote and a local address", p)
+		}
+		if seen[p.Remote] {
+			return fmt.Errorf("peer %s given twice", p.Remote)
+		}
+		seen[p.Remote] = true
+		if p.NodeIP != nil && p.NodeIP.To4() == nil {
+			return fmt.Errorf("peer %s: node IP %v must be IPv4", p.Remote, p.NodeIP)
+		}
+	}
+	return nil
+}
+
+// peerSet is the PGWs of Config.Peers, resolved, with the node address for
+// the F-TEIDs of each. New sessions go to them in turn; the zero value
+// means a single remote.
+type peerSet struct {
+	addrs []*net.UDPAddr
+	nodes []net.IP
+	next  atomic.Uint32
+}
+
+// openPeers resolves Config.Peers. The first PGW gets the main socket,
+// already bound to its Local; every other one gets a socket of its own
+// bound to its Local. The transport sends each PGW's datagrams out of its
+// socket, and the receive loop reads them all.
+func (c *Client) openPeers() error {
+	for i, p := range c.cfg.Peers {
+		laddr, err := net.ResolveUDPAddr("udp", p.Local)
+		if err != nil {
+			return fmt.Errorf("peer %s: resolve local: %w", p.Remote, err)
+		}
+		raddr, err := net.ResolveUDPAddr("udp", p.Remote)
+		if err != nil {
+			return fmt.Errorf("peer %s: resolve remote: %w", p.Remote, err)
+		}
+		node := p.NodeIP
+		if node == nil {
+			if node = laddr.IP.To4(); node == nil || node.IsUnspecified() {
+				node = c.cfg.NodeIP
+			}
+		}
+		cfg := c.cfg
+		cfg.NodeIP = node
+		if err := checkNodeIP(cfg, laddr, raddr); err != nil {
+			return err
+		}
+		if i == 0 {
+			c.tr.route(raddr, 0)
+		} else if err := c.tr.addPeer(laddr, raddr); err != nil {
+			return fmt.Errorf("peer %s: listen %s: %w", p.Remote, p.Local, err)
+		}
+		c.peers.addrs = append(c.peers.addrs, raddr)
+		c.peers.nodes = append(c.peers.nodes, node)
+		log.Printf("peer %s: local=%s node-ip=%s", raddr, c.tr.srcConn(c.tr.srcFor(0, raddr)).LocalAddr(), node)
+	}
+	return nil
+}
+
+// pick returns the PGW for the next session and its node address, or nil
+// without peers.
+func (ps *peerSet) pick() (*net.UDPAddr, net.IP) {
+	if len(ps.addrs) == 0 {
+		return nil, nil
+	}
+	i := int((ps.next.Add(1) - 1) % uint32(len(ps.addrs)))
+	return ps.addrs[i], ps.nodes[i]
+}
+
+// node returns the node address for sessions on peer, or def if peer is
+// not one of the set.
+func (ps *peerSet) node(peer *net.UDPAddr, def net.IP) net.IP {
+	for i, a := range ps.addrs {
+		if peer != nil && a.String() == peer.String() {
+			return ps.nodes[i]
+		}
+	}
+	return def
+}
+
+// remotes returns the PGWs the client talks to: every peer, or the remote.
+func (c *Client) remotes() []*net.UDPAddr {
+	if len(c.peers.addrs) > 0 {
+		return c.peers.addrs
+	}
+	return []*net.UDPAddr{c.tr.remote()}
+}
+
+// peerOf returns the PGW requests on sess go to: the peer it was created on,
+// or the remote.
+func (c *Client) peerOf(sess *Session) *net.UDPAddr {
+	if sess.Peer != nil {
+		return sess.Peer
+	}
+	return c.tr.remote()
+}
+
+// sessCfg returns cfg for a request on sess, with the node address of the
+// peer sess is on in its F-TEIDs.
+func (c *Client) sessCfg(cfg Config, sess *Session) Config {
+	cfg.NodeIP = c.peers.node(sess.Peer, cfg.NodeIP)
+	return cfg
+}
@@ -0,0 +1,117 @@
+package sim
+
+import (
+	"net"
+	"testing"
+
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// TestPeers runs sessions against two PGWs, each reached from its own local
+// address, and checks every request goes to the session's PGW from the
+// socket bound for it, with that address in the F-TEIDs.
+func TestPeers(t *testing.T) {
+	var pgws [2]*Client
+	for i := range pgws {
+		cfg := DefaultConfig()
+		cfg.Local, cfg.EchoEvery, cfg.Respond = "127.0.0.1:0", 0, true
+		pgw, err := NewClient(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { pgw.Close() })
+		pgws[i] = pgw
+	}
+	locals := []string{"127.0.0.1", "127.0.0.2"}
+
+	cfg := DefaultConfig()
+	cfg.Local, cfg.EchoEvery, cfg.Sessions = "", 0, 4
+	for i, pgw := range pgws {
+		cfg.Peers = append(cfg.Peers, Peer{Remote: pgw.LocalAddr().String(), Local: net.JoinHostPort(locals[i], "0")})
+	}
+	sgw, err := NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sgw.Close() })
+	var wire wireLog
+	sgw.tr.tap = wire.tap
+
+	sessions, err := sgw.CreateSessions()
+	if err != nil || len(sessions) != cfg.Sessions {
+		t.Fatalf("CreateSessions: %d of %d sessions, %v", len(sessions), cfg.Sessions, err)
+	}
+	onPeer := make(map[string]int)
+	byTEID := make(map[uint32]*Session)
+	for _, sess := range sessions {
+		if sess.Peer == nil {
+			t.Fatalf("session %s has no peer", sess)
+		}
+		onPeer[sess.Peer.String()]++
+		byTEID[sess.LocalCTEID] = sess
+	}
+	for i, pgw := range pgws {
+		if n := onPeer[pgw.LocalAddr().String()]; n != 2 {
+			t.Errorf("pgw %d: %d sessions, want 2", i, n)
+		}
+		if n := len(pgw.rs.peers); n != 2 {
+			t.Errorf("pgw %d holds %d sessions, want 2", i, n)
+		}
+	}
+
+	for _, m := range wire.all() {
+		csr, ok := m.(*gtpv2msg.CreateSessionRequest)
+		if !ok {
+			continue
+		}
+		teid, _ := csr.SenderFTEIDC.TEID()
+		ip, _ := csr.SenderFTEIDC.IPv4()
+		sess := byTEID[teid]
+		if sess == nil {
+			continue
+		}
+		want := locals[0]
+		if sess.Peer.String() == pgws[1].LocalAddr().String() {
+			want = locals[1]
+		}
+		if ip.String() != want {
+			t.Errorf("CSR seq=%d to %s: F-TEID node %s, want %s", csr.Sequence(), sess.Peer, ip, want)
+		}
+	}
+
+	for _, sess := range sessions {
+		if err := sgw.ModifyBearer(sess); err != nil {
+			t.Errorf("ModifyBearer on %s: %v", sess.Peer, err)
+		}
+		if err := sgw.DeleteSession(sess); err != nil {
+			t.Errorf("DeleteSession on %s: %v", sess.Peer, err)
+		}
+	}
+	for i, pgw := range pgws {
+		if n := len(pgw.rs.peers); n != 0 {
+			t.Errorf("pgw %d holds %d sessions after deleting them all", i, n)
+		}
+	}
+
+	// Each PGW sees the SGW at the address of its peer's socket only, with
+	// back to back requests too.
+	for i, to := range sgw.remotes() {
+		for range 2 {
+			if _, err := sgw.echo(to); err != nil {
+				t.Fatalf("Echo to %s: %v", to, err)
+			}
+		}
+		paths := pgws[i].paths.all()
+		if len(paths) != 1 {
+			t.Fatalf("pgw %d: %d paths, want 1", i, len(paths))
+		}
+		if host, _, _ := net.SplitHostPort(paths[0].peer); host != locals[i] {
+			t.Errorf("pgw %d: SGW seen at %s, want %s", i, paths[0].peer, locals[i])
+		}
+	}
+
+	cfg.Remote = pgws[0].LocalAddr().String()
+	if err := cfg.validate(); err == nil {
+		t.Error("validate accepted peers with a remote")
+	}
+}
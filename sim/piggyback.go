@@ -0,0 +1,142 @@
+package sim
+
+import (
+	"encoding/binary"
+	"log"
+	"net"
+	"sync"
+
+	gtp "github.com/wmnsk/go-gtp"
+	gtpv2 "github.com/wmnsk/go-gtp/gtpv2"
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// piggybacked is a message with another one carried behind it in the same
+// datagram (P flag, TS 29.274 5.5.1): a CSRsp with a CreateBearerRequest,
+// or an MBR with the CreateBearerResponse to it.
+type piggybacked struct {
+	gtpv2msg.Message
+	next gtpv2msg.Message
+}
+
+func (p piggybacked) MarshalLen() int {
+	return p.Message.MarshalLen() + p.next.MarshalLen()
+}
+
+func (p piggybacked) MarshalTo(b []byte) error {
+	n := p.Message.MarshalLen()
+	if err := p.Message.MarshalTo(b[:n]); err != nil {
+		return err
+	}
+	b[0] |= 0x10
+	return p.next.MarshalTo(b[n:])
+}
+
+// splitPiggyback splits a datagram whose first message has the P flag set
+// into that message and the one behind it; next is nil for anything else.
+func splitPiggyback(pkt []byte) (first, next []byte) {
+	if len(pkt) < 4 || pkt[0]>>5 != 2 || pkt[0]&0x10 == 0 {
+		return pkt, nil
+	}
+	n := 4 + int(binary.BigEndian.Uint16(pkt[2:4]))
+	if n >= len(pkt) {
+		return pkt, nil
+	}
+	return pkt[:n], pkt[n:]
+}
+
+// piggyCBRs holds CreateBearerRequests that arrived piggybacked on a CSRsp,
+// by the CSR's sequence, until sendCSR has recorded the session they are
+// for.
+type piggyCBRs struct {
+	mu sync.Mutex
+	m  map[uint32]*gtpv2msg.CreateBearerRequest
+}
+
+func (p *piggyCBRs) put(seq uint32, cbr *gtpv2msg.CreateBearerRequest) {
+	p.mu.Lock()
+	if p.m == nil {
+		p.m = make(map[uint32]*gtpv2msg.CreateBearerRequest)
+	}
+	p.m[seq] = cbr
+	p.mu.Unlock()
+}
+
+func (p *piggyCBRs) take(seq uint32) *gtpv2msg.CreateBearerRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cbr := p.m[seq]
+	delete(p.m, seq)
+	return cbr
+}
+
+// handlePiggybacked handles both messages of a piggybacked datagram, the
+// first one first. A CreateBearerRequest behind a CSRsp is parked for the
+// CSR's sender before the CSRsp is delivered, so sendCSR answers it once
+// the session exists; any other trailing message is handled on its own.
+func (c *Client) handlePiggybacked(first, next []byte, peer *net.UDPAddr) {
+	if h, err := gtpv2msg.ParseHeader(first); err == nil && h.MessageType() == gtpv2msg.MsgTypeCreateSessionResponse {
+		if m, err := gtp.Parse(next); err == nil {
+			if cbr, ok := m.(*gtpv2msg.CreateBearerRequest); ok {
+				if c.dec != nil {
+					c.dec.write(next, peer)
+				}
+				if c.cfg.LogIEs {
+					c.logIEs(next, peer)
+				}
+				c.piggy.put(h.Sequence(), cbr)
+				c.rxLogf(gtpv2msg.MsgTypeCreateBearerRequest, "rx CBReq from %s teid=0x%08x seq=%d piggybacked on CSRsp seq=%d",
+					peer, cbr.TEID(), cbr.Sequence(), h.Sequence())
+				c.handlePacket(first, peer)
+				return
+			}
+		}
+	}
+	c.handlePacket(first, peer)
+	c.handlePacket(next, peer)
+}
+
+// answerPiggybackedCBR accepts the CreateBearerRequest that came
+// piggybacked on the CSRsp to CSR seq, if there was one. The
+// CreateBearerResponse goes on its own, or with Config.PiggybackCBRsp
+// piggybacked on a ModifyBearerRequest for sess as the MME would send it at
+// attach.
+func (c *Client) answerPiggybackedCBR(cfg Config, sess *Session, seq uint32) {
+	cbr := c.piggy.take(seq)
+	if cbr == nil {
+		return
+	}
+	log.Printf("CSRsp seq=%d carried a piggybacked CBReq seq=%d (%d bearer contexts)", seq, cbr.Sequence(), len(cbr.BearerContexts))
+	if !cfg.PiggybackCBRsp {
+		c.acceptCreateBearer(sess, cbr)
+		return
+	}
+	resp, out := c.createBearerResponse(sess, cbr)
+	cause, _ := resp.Cause.Cause()
+	log.Printf("CBRsp seq=%d cause=%d goes piggybacked on an MBR", cbr.Sequence(), cause)
+	logDedicatedBearers(out)
+	if _, err := c.modifyBearerWith(cfg, sess, resp); err != nil {
+		log.Printf("MBR with piggybacked CBRsp seq=%d failed: %v", cbr.Sequence(), err)
+	}
+}
+
+// piggybackTFT is the packet filter of the responder's piggybacked
+// dedicated bearer: SIP, as for an IMS voice bearer set up at attach.
+var piggybackTFT, _ = ParseTAD("proto=17,rport=5060")
+
+// newPiggybackCBR is the responder's CreateBearerRequest piggybacked on an
+// accepted CSRsp (Config.RespPiggybackCBR): one QCI 1 dedicated bearer,
+// 128 kbps MBR and 64 kbps GBR each way, linked to the default bearer ebi.
+func newPiggybackCBR(cfg Config, sgw, seq uint32, ebi uint8) *gtpv2msg.CreateBearerRequest {
+	return gtpv2msg.NewCreateBearerRequest(sgw, seq,
+		gtpv2ie.NewEPSBearerID(ebi),
+		gtpv2ie.NewBearerContext(
+			gtpv2ie.NewEPSBearerID(0),
+			gtpv2ie.New(gtpv2ie.BearerTFT, 0, piggybackTFT.Payload),
+			newNodeFTEID(cfg, gtpv2.IFTypeS5S8PGWGTPU, randUint32()).WithInstance(1),
+			gtpv2ie.NewBearerQoS(0, 2, 0, 1, 128, 128, 64, 64),
+			gtpv2ie.NewChargingID(randUint32()),
+		),
+	)
+}
@@ -0,0 +1,74 @@
+package sim
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+// minSPLMNRate is the lowest Serving PLMN Rate Control limit a network may
+// set (TS 24.301 9.9.4.28): fewer than 10 NAS data PDUs per 6 minutes.
+const minSPLMNRate = 10
+
+// checkSPLMNRate validates a -splmn-rate-ul/-dl pair: both -1 (no IE) or
+// both set, each 10..65535 NAS data PDUs per 6 minutes (65535 meaning no
+// limit).
+func checkSPLMNRate(ul, dl int) error {
+	switch {
+	case ul == -1 && dl == -1:
+		return nil
+	case ul == -1 || dl == -1:
+		return errors.New("serving PLMN rate control needs both the uplink and the downlink limit")
+	}
+	for _, v := range []int{ul, dl} {
+		if v < minSPLMNRate || v > 0xffff {
+			return fmt.Errorf("serving PLMN rate limit %d must be %d..65535", v, minSPLMNRate)
+		}
+	}
+	return nil
+}
+
+// newServingPLMNRateControl builds a Serving PLMN Rate Control IE (TS 29.274
+// 8.115): the uplink and downlink limits, two octets each.
+func newServingPLMNRateControl(ul, dl uint16) *gtpv2ie.IE {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint16(b, ul)
+	binary.BigEndian.PutUint16(b[2:], dl)
+	return gtpv2ie.New(gtpv2ie.ServingPLMNRateControl, 0, b)
+}
+
+// servingPLMNRateControl decodes a Serving PLMN Rate Control IE.
+func servingPLMNRateControl(i *gtpv2ie.IE) (ul, dl uint16, err error) {
+	if len(i.Payload) < 4 {
+		return 0, 0, fmt.Errorf("serving PLMN rate control: %d octets, want 4", len(i.Payload))
+	}
+	return binary.BigEndian.Uint16(i.Payload), binary.BigEndian.Uint16(i.Payload[2:]), nil
+}
+
+// splmnRateString renders a Serving PLMN Rate Control IE, e.g.
+// "ul=100 dl=200 per 6 min"; 65535 is shown as unlimited.
+func splmnRateString(i *gtpv2ie.IE) string {
+	ul, dl, err := servingPLMNRateControl(i)
+	if err != nil {
+		return err.Error()
+	}
+	limit := func(v uint16) string {
+		if v == 0xffff {
+			return "unlimited"
+		}
+		return fmt.Sprint(v)
+	}
+	return fmt.Sprintf("ul=%s dl=%s per 6 min", limit(ul), limit(dl))
+}
+
+// findIE returns the first of ies with type typ, or nil.
+func findIE(ies []*gtpv2ie.IE, typ uint8) *gtpv2ie.IE {
+	for _, i := range ies {
+		if i.Type == typ {
+			return i
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package sim
+
+import (
+	"errors"
+	"log"
+	"net"
+	"syscall"
+	"time"
+)
+
+// Rebind backoff: the first retry after rebindBackoff, doubling up to
+// maxRebindBackoff.
+const (
+	rebindBackoff    = 100 * time.Millisecond
+	maxRebindBackoff = 10 * time.Second
+)
+
+// fatalSocketErr reports whether a read error means the socket itself is
+// broken (its address or interface went away, the descriptor is gone)
+// rather than one datagram's trouble. ICMP errors reported on a connected
+// socket and momentary shortages are not fatal; the next read may succeed.
+func fatalSocketErr(err error) bool {
+	if transientWriteErr(err) {
+		return false
+	}
+	for _, e := range []syscall.Errno{syscall.ECONNREFUSED, syscall.EHOSTUNREACH, syscall.ENETUNREACH, syscall.EHOSTDOWN, syscall.EMSGSIZE} {
+		if errors.Is(err, e) {
+			return false
+		}
+	}
+	return true
+}
+
+// rebind replaces socket src after the fatal read error cause: it closes
+// it and listens on the same local address again, up to
+// Config.RebindTries times with backoff. It reports whether the socket
+// is usable again; false means the client was closed or the attempts ran
+// out, and the socket is given up. Requests in flight meanwhile fail or
+// are retransmitted as usual, and the periodic Echo carries on by itself.
+func (c *Client) rebind(src int, cause error) bool {
+	if c.cfg.RebindTries <= 0 {
+		log.Printf("rx err: %v; socket %s is broken, -rebind-attempts is 0: giving it up", cause, c.tr.srcConn(src).LocalAddr())
+		return false
+	}
+	laddr := c.tr.srcConn(src).LocalAddr()
+	log.Printf("rx err: %v; rebinding socket %s", cause, laddr)
+	wait := rebindBackoff
+	for attempt := 1; attempt <= c.cfg.RebindTries; attempt++ {
+		conn, err := c.tr.rebind(src)
+		if err == nil {
+			log.Printf("rebind: socket %s listening again (attempt %d)", conn.LocalAddr(), attempt)
+			return true
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return false
+		}
+		log.Printf("rebind %s: attempt %d/%d: %v", laddr, attempt, c.cfg.RebindTries, err)
+		select {
+		case <-time.After(wait):
+		case <-c.done:
+			return false
+		}
+		wait = min(2*wait, maxRebindBackoff)
+	}
+	log.Printf("ERROR: rebind %s: giving up after %d attempts; nothing more is received on it", laddr, c.cfg.RebindTries)
+	return false
+}
@@ -0,0 +1,150 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	gtp "github.com/wmnsk/go-gtp"
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// sentKeep is how many sent requests Resend can go back to.
+const sentKeep = 1024
+
+// sentLog keeps the marshaled bytes of the last sentKeep requests by
+// sequence number, as they went on the wire (after -bad-length and the
+// like), and the socket and PGW they went out of and to, so Resend repeats
+// them verbatim.
+type sentLog struct {
+	mu    sync.Mutex
+	m     map[uint32]sentReq
+	order []uint32 // oldest first
+}
+
+type sentReq struct {
+	b   []byte
+	src int // see transport.pickSrc
+	to  *net.UDPAddr
+}
+
+func (l *sentLog) store(seq uint32, b []byte, src int, to *net.UDPAddr) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.m == nil {
+		l.m = make(map[uint32]sentReq, sentKeep)
+	}
+	if _, ok := l.m[seq]; !ok {
+		if len(l.order) == sentKeep {
+			delete(l.m, l.order[0])
+			l.order = l.order[1:]
+		}
+		l.order = append(l.order, seq)
+	}
+	l.m[seq] = sentReq{b: b, src: src, to: to}
+}
+
+func (l *sentLog) get(seq uint32) (sentReq, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r, ok := l.m[seq]
+	return r, ok
+}
+
+// sentTo returns the PGW the request seq went to, or the remote if it is
+// no longer in the log.
+func (c *Client) sentTo(seq uint32) *net.UDPAddr {
+	if r, ok := c.sent.get(seq); ok {
+		return r.to
+	}
+	return c.tr.remote()
+}
+
+// recent returns the sequence numbers of the last n requests, newest first.
+func (l *sentLog) recent(n int) []uint32 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n = min(n, len(l.order))
+	out := make([]uint32, n)
+	for k := range out {
+		out[k] = l.order[len(l.order)-1-k]
+	}
+	return out
+}
+
+// ResendLast resends the last request sent, see Resend.
+func (c *Client) ResendLast() error {
+	last := c.sent.recent(1)
+	if len(last) == 0 {
+		return errors.New("resend: no request sent yet")
+	}
+	return c.Resend(last[0])
+}
+
+// Resend sends the request with sequence seq again, byte for byte and from
+// the same source port, and reports what the peer answered within the
+// timeout. A conforming peer treats it as a retransmission and repeats its
+// earlier response without acting on the request again. The resend is not
+// counted as a transaction; if the original is still pending it gets the
+// answer and Resend reports none.
+func (c *Client) Resend(seq uint32) error {
+	r, ok := c.sent.get(seq)
+	if !ok {
+		return fmt.Errorf("resend: seq=%d not among the last %d requests sent", seq, sentKeep)
+	}
+	b, name := r.b, msgName(r.b[1])
+	ch, fresh := c.reg.register(seq, r.b[1], c.cfg.Timeout+txnSweepSlack)
+	if fresh {
+		defer c.reg.cancel(seq)
+	}
+	start := time.Now()
+	if err := c.tr.sendFrom(r.src, b, r.to); err != nil {
+		return fmt.Errorf("resend %s seq=%d: %w", name, seq, err)
+	}
+	log.Printf("resend %s seq=%d (%d B) to %s", name, seq, len(b), r.to)
+	if !fresh {
+		log.Printf("resend seq=%d: original still pending, its waiter gets the answer", seq)
+		return nil
+	}
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return fmt.Errorf("resend %s seq=%d: swept without a response", name, seq)
+		}
+		log.Printf("resend %s seq=%d: peer answered %s%s after %s", name, seq,
+			resp.MessageTypeName(), respCause(resp), time.Since(start).Round(time.Microsecond))
+		return nil
+	case <-time.After(c.cfg.Timeout):
+		log.Printf("resend %s seq=%d: no answer within %s", name, seq, c.cfg.Timeout)
+		return nil
+	}
+}
+
+// respCause renders the top-level Cause of a response for the log, if it
+// has one.
+func respCause(m gtpv2msg.Message) string {
+	b, err := gtp.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	h, err := gtpv2msg.ParseHeader(b)
+	if err != nil {
+		return ""
+	}
+	ies, err := gtpv2ie.ParseMultiIEs(h.Payload)
+	if err != nil {
+		return ""
+	}
+	for _, i := range ies {
+		if i.Type == gtpv2ie.Cause && i.Instance() == 0 {
+			if v, err := i.Cause(); err == nil {
+				return " cause " + causeName(v)
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,42 @@
+package sim
+
+import (
+	"slices"
+	"testing"
+
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// TestResendSeqZero checks sequence number 0, which the allocator hands out
+// after a wrap, can be resent by number, and that "resend" alone and
+// "resend last" resend the last request.
+func TestResendSeqZero(t *testing.T) {
+	sgw, _ := selfTest(t, DefaultConfig())
+	var wire wireLog
+	sgw.tr.tap = wire.tap
+
+	if _, _, err := sgw.transact(gtpv2msg.NewEchoRequest(0, sgw.echoIEs()...)); err != nil {
+		t.Fatalf("Echo seq=0: %v", err)
+	}
+	if _, err := sgw.Echo(); err != nil {
+		t.Fatalf("Echo: %v", err)
+	}
+	last := sgw.sent.recent(1)[0]
+
+	if err := sgw.Resend(0); err != nil {
+		t.Errorf("Resend(0): %v", err)
+	}
+	for _, args := range [][]string{nil, {"last"}} {
+		if err := sgw.consoleCmd("resend", args); err != nil {
+			t.Errorf("resend %q: %v", args, err)
+		}
+	}
+
+	var seqs []uint32
+	for _, m := range wire.all() {
+		seqs = append(seqs, m.Sequence())
+	}
+	if want := []uint32{0, last, 0, last, last}; !slices.Equal(seqs, want) {
+		t.Errorf("sent seqs %v, want %v", seqs, want)
+	}
+}
@@ -0,0 +1,305 @@
+package sim
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	gtp "github.com/wmnsk/go-gtp"
+	gtpv2 "github.com/wmnsk/go-gtp/gtpv2"
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// respPAABase is the first UE address handed out in responder mode
+// (10.45.0.2); later sessions get the following addresses.
+const respPAABase = 0x0a2d0002
+
+// respPAA6 is the /48 the responder's IPv6 /64 prefixes are cut from; the
+// session counter goes in the fourth group and the interface identifier is
+// ::1.
+var respPAA6 = net.ParseIP("2001:db8:2d::")
+
+// responder answers SGW-initiated requests when the Client plays the PGW
+// (Config.Respond). It keeps just enough state to answer follow-up requests:
+// our PGW control TEID -> the SGW's control TEID.
+type responder struct {
+	mu    sync.Mutex
+	peers map[uint32]uint32
+	csids map[uint32][]uint16 // SGW CSIDs from the CSR, by our TEID
+	ips   atomic.Uint32
+}
+
+func newResponder() *responder {
+	return &responder{peers: make(map[uint32]uint32), csids: make(map[uint32][]uint16)}
+}
+
+// allocIP returns the next session's IPv4 address and IPv6 address (/64
+// prefix plus interface identifier).
+func (r *responder) allocIP() (net.IP, net.IP) {
+	n := r.ips.Add(1) - 1
+	v4 := make(net.IP, 4)
+	binary.BigEndian.PutUint32(v4, respPAABase+n)
+	v6 := slices.Clone(respPAA6)
+	binary.BigEndian.PutUint16(v6[6:8], uint16(n))
+	v6[15] = 1
+	return v4, v6
+}
+
+// sgwTEID looks up the SGW TEID for our TEID; forget drops the mapping too.
+func (r *responder) sgwTEID(pgw uint32, forget bool) (uint32, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sgw, ok := r.peers[pgw]
+	if forget {
+		delete(r.peers, pgw)
+		delete(r.csids, pgw)
+	}
+	return sgw, ok
+}
+
+// reply marshals and sends resp to peer.
+func (c *Client) reply(resp gtpv2msg.Message, peer *net.UDPAddr) {
+	b, err := gtp.Marshal(resp)
+	if err != nil {
+		log.Printf("responder: marshal %s: %v", resp.MessageTypeName(), err)
+		return
+	}
+	if err := c.tr.sendTo(b, peer); err != nil {
+		log.Printf("responder: send %s to %s: %v", resp.MessageTypeName(), peer, err)
+	}
+}
+
+// bearerEBI returns the EBI inside a bearer context, or 0.
+func bearerEBI(bc *gtpv2ie.IE) uint8 {
+	for _, ie := range bc.ChildIEs {
+		if ie.Type == gtpv2ie.EPSBearerID {
+			v, _ := ie.EPSBearerID()
+			return v
+		}
+	}
+	return 0
+}
+
+// answerCSR accepts every CreateSessionRequest: it allocates PGW TEIDs and
+// the next IPv4 UE address and returns them in the CreateSessionResponse.
+// With Config.RespCause set it rejects every one instead, with just that
+// Cause.
+func (c *Client) answerCSR(req *gtpv2msg.CreateSessionRequest, peer *net.UDPAddr) {
+	cfg, rs := c.cfg, c.rs
+	var sgw uint32
+	if req.SenderFTEIDC != nil {
+		sgw, _ = req.SenderFTEIDC.TEID()
+	}
+	var imsi string
+	if req.IMSI != nil {
+		imsi, _ = req.IMSI.IMSI()
+	}
+	apn := "-"
+	if req.APN != nil {
+		var err error
+		if apn, err = decodeAPN(req.APN.Payload); err != nil {
+			log.Printf("responder: CSR seq=%d: %v", req.Sequence(), err)
+			apn = "invalid"
+		}
+	}
+	if cfg.RespCause != 0 {
+		c.reply(gtpv2msg.NewCreateSessionResponse(sgw, req.Sequence(),
+			gtpv2ie.NewCause(cfg.RespCause, 0, 0, 0, nil)), peer)
+		c.rxLogf(req.MessageType(), "rx CSR from %s imsi=%s apn=%s seq=%d -> CSRsp rejected cause %s", peer, imsi, apn, req.Sequence(), causeName(cfg.RespCause))
+		return
+	}
+
+	// The default bearer keeps the requested EBI and Bearer Flags.
+	ebi := cfg.EBI
+	var bearerFlags *gtpv2ie.IE
+	if len(req.BearerContextsToBeCreated) > 0 {
+		if v := bearerEBI(req.BearerContextsToBeCreated[0]); v != 0 {
+			ebi = v
+		}
+		if f, err := req.BearerContextsToBeCreated[0].BearerFlags(); err == nil {
+			bearerFlags = gtpv2ie.New(gtpv2ie.BearerFlags, 0, []byte{f})
+		}
+	}
+
+	pgwC, pgwU := randUint32(), randUint32()
+	v4, v6 := rs.allocIP()
+	// The PAA follows the requested PDN type: IPv4, an IPv6 /64 or both.
+	var pdn uint8 = 1
+	if req.PDNType != nil {
+		pdn, _ = req.PDNType.PDNType()
+	}
+	paa := gtpv2ie.NewPDNAddressAllocationNetIP(v4, 0)
+	switch pdn {
+	case 2:
+		paa = gtpv2ie.NewPDNAddressAllocationNetIP(v6, 64)
+	case 3:
+		paa = gtpv2ie.NewPDNAddressAllocationDualNetIP(v4, v6, 64)
+	}
+	ue := paaString(paa)
+	ies := []*gtpv2ie.IE{
+		gtpv2ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
+		newNodeFTEID(cfg, gtpv2.IFTypeS5S8PGWGTPC, pgwC).WithInstance(0),
+		paa,
+		gtpv2ie.NewBearerContext(
+			gtpv2ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
+			gtpv2ie.NewEPSBearerID(ebi),
+			newNodeFTEID(cfg, gtpv2.IFTypeS5S8PGWGTPU, pgwU).WithInstance(2),
+			gtpv2ie.NewChargingID(randUint32()),
+			bearerFlags,
+		),
+	}
+	if cfg.APNRestriction >= 0 {
+		ies = append(ies, gtpv2ie.NewAPNRestriction(uint8(cfg.APNRestriction)))
+	}
+	// Accept the MME's rate control as it is.
+	if i := req.ServingPLMNRateControl; i != nil {
+		ies = append(ies, gtpv2ie.New(gtpv2ie.ServingPLMNRateControl, 0, i.Payload))
+	}
+	// A UE in a CSG cell: ask to hear when it changes cells (UCICSG).
+	if req.UCI != nil {
+		ies = append(ies, gtpv2ie.New(gtpv2ie.CSGInformationReportingAction, 0, []byte{0x01}))
+	}
+	if req.EPCO != nil {
+		cs, err := parseEPCO(req.EPCO.Payload)
+		if err != nil {
+			log.Printf("responder: CSR seq=%d: %v", req.Sequence(), err)
+		}
+		if ans := answerEPCO(cs, cfg.NodeIP); len(ans) > 0 {
+			ies = append(ies, newEPCO(ans))
+		}
+	}
+
+	rs.mu.Lock()
+	rs.peers[pgwC] = sgw
+	if req.SGWFQCSID != nil {
+		rs.csids[pgwC], _ = req.SGWFQCSID.CSIDs()
+	}
+	rs.mu.Unlock()
+
+	var resp gtpv2msg.Message = gtpv2msg.NewCreateSessionResponse(sgw, req.Sequence(), ies...)
+	if cfg.RespPiggybackCBR {
+		cbr := newPiggybackCBR(cfg, sgw, c.seq.next(), ebi)
+		resp = piggybacked{resp, cbr}
+		ue += fmt.Sprintf(", piggybacked CBReq seq=%d", cbr.Sequence())
+	}
+	c.reply(resp, peer)
+	c.rxLogf(req.MessageType(), "rx CSR from %s imsi=%s apn=%s seq=%d -> CSRsp accepted teid=0x%08x paa=%s", peer, imsi, apn, req.Sequence(), pgwC, ue)
+}
+
+// answerMBR accepts a ModifyBearerRequest for a session we created.
+func (c *Client) answerMBR(req *gtpv2msg.ModifyBearerRequest, peer *net.UDPAddr) {
+	sgw, ok := c.rs.sgwTEID(req.TEID(), false)
+	if !ok {
+		c.reply(gtpv2msg.NewModifyBearerResponse(0, req.Sequence(),
+			gtpv2ie.NewCause(gtpv2.CauseContextNotFound, 0, 0, 0, nil)), peer)
+		c.rxLogf(req.MessageType(), "rx MBR from %s teid=0x%08x seq=%d -> MBRsp context not found", peer, req.TEID(), req.Sequence())
+		return
+	}
+	ies := []*gtpv2ie.IE{gtpv2ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil)}
+	for _, bc := range req.BearerContextsToBeModified {
+		ies = append(ies, gtpv2ie.NewBearerContext(
+			gtpv2ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
+			gtpv2ie.NewEPSBearerID(bearerEBI(bc)),
+		))
+	}
+	c.reply(gtpv2msg.NewModifyBearerResponse(sgw, req.Sequence(), ies...), peer)
+	c.rxLogf(req.MessageType(), "rx MBR from %s teid=0x%08x seq=%d -> MBRsp accepted", peer, req.TEID(), req.Sequence())
+}
+
+// answerDSR accepts a DeleteSessionRequest and forgets the session.
+func (c *Client) answerDSR(req *gtpv2msg.DeleteSessionRequest, peer *net.UDPAddr) {
+	sgw, ok := c.rs.sgwTEID(req.TEID(), true)
+	cause := gtpv2.CauseRequestAccepted
+	if !ok {
+		cause = gtpv2.CauseContextNotFound
+	}
+	c.reply(gtpv2msg.NewDeleteSessionResponse(sgw, req.Sequence(),
+		gtpv2ie.NewCause(cause, 0, 0, 0, nil)), peer)
+	c.rxLogf(req.MessageType(), "rx DSR from %s teid=0x%08x seq=%d -> DSRsp cause=%d", peer, req.TEID(), req.Sequence(), cause)
+}
+
+// answerDPCS drops every session the SGW created under one of the CSIDs in
+// its FQ-CSID and accepts the DeletePDNConnectionSetRequest.
+func (c *Client) answerDPCS(req *gtpv2msg.DeletePDNConnectionSetRequest, peer *net.UDPAddr) {
+	var ids []uint16
+	if req.SGWFQCSID != nil {
+		ids, _ = req.SGWFQCSID.CSIDs()
+	}
+	rs, n := c.rs, 0
+	rs.mu.Lock()
+	for pgw, sessIDs := range rs.csids {
+		if slices.ContainsFunc(sessIDs, func(id uint16) bool { return slices.Contains(ids, id) }) {
+			delete(rs.peers, pgw)
+			delete(rs.csids, pgw)
+			n++
+		}
+	}
+	rs.mu.Unlock()
+	c.reply(gtpv2msg.NewDeletePDNConnectionSetResponse(0, req.Sequence(),
+		gtpv2ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil)), peer)
+	c.rxLogf(req.MessageType(), "rx DeletePDNConnectionSetReq from %s csids=%v seq=%d -> %d session(s) deleted", peer, ids, req.Sequence(), n)
+}
+
+// answerBRC grants a BearerResourceCommand with a CreateBearerRequest on the
+// command's sequence: one bearer with the requested Flow QoS as its Bearer
+// QoS and the TAD as its TFT. An unknown session gets a
+// BearerResourceFailureIndication instead.
+func (c *Client) answerBRC(req gtpv2msg.Message, peer *net.UDPAddr) {
+	sgw, ok := c.rs.sgwTEID(req.TEID(), false)
+	var lbi, pti *gtpv2ie.IE
+	var qos *gtpv2ie.FlowQoSFields
+	var tad []byte
+	if g, isGeneric := req.(*gtpv2msg.Generic); isGeneric {
+		for _, i := range g.IEs {
+			switch i.Type {
+			case gtpv2ie.EPSBearerID:
+				lbi = i
+			case gtpv2ie.ProcedureTransactionID:
+				pti = i
+			case gtpv2ie.FlowQoS:
+				qos, _ = i.FlowQoS()
+			case gtpv2ie.TrafficAggregateDescription:
+				tad = i.Payload
+			}
+		}
+	}
+	var cause uint8
+	switch {
+	case !ok:
+		cause = gtpv2.CauseContextNotFound
+	case lbi == nil || pti == nil || qos == nil || tad == nil:
+		cause = gtpv2.CauseMandatoryIEMissing
+	}
+	if cause != 0 {
+		ies := []*gtpv2ie.IE{gtpv2ie.NewCause(cause, 0, 0, 0, nil)}
+		if lbi != nil {
+			ies = append(ies, lbi)
+		}
+		if pti != nil {
+			ies = append(ies, pti)
+		}
+		c.reply(gtpv2msg.NewGeneric(gtpv2msg.MsgTypeBearerResourceFailureIndication, sgw, req.Sequence(), ies...), peer)
+		c.rxLogf(req.MessageType(), "rx BearerResourceCommand from %s teid=0x%08x seq=%d -> BearerResourceFailureIndication cause=%d", peer, req.TEID(), req.Sequence(), cause)
+		return
+	}
+
+	pgwU := randUint32()
+	c.reply(gtpv2msg.NewCreateBearerRequest(sgw, req.Sequence(),
+		pti,
+		lbi,
+		gtpv2ie.NewBearerContext(
+			gtpv2ie.NewEPSBearerID(0),
+			gtpv2ie.New(gtpv2ie.BearerTFT, 0, tad),
+			newNodeFTEID(c.cfg, gtpv2.IFTypeS5S8PGWGTPU, pgwU).WithInstance(1),
+			gtpv2ie.NewBearerQoS(0, 2, 0, qos.QCI, qos.MaximumBitRateForUplink, qos.MaximumBitRateForDownlink,
+				qos.GuaranteedBitRateForUplink, qos.GuaranteedBitRateForDownlink),
+			gtpv2ie.NewChargingID(randUint32()),
+		),
+	), peer)
+	c.rxLogf(req.MessageType(), "rx BearerResourceCommand from %s teid=0x%08x seq=%d qci=%d -> CBReq", peer, req.TEID(), req.Sequence(), qos.QCI)
+}
@@ -0,0 +1,53 @@
+package sim
+
+import (
+	"sync"
+	"time"
+)
+
+// rtoMinSamples is how many RTT samples the adaptive T3 waits for before it
+// replaces the fixed value.
+const rtoMinSamples = 4
+
+// rtoMin and rtoMax clamp the adaptive T3.
+const (
+	rtoMin = 50 * time.Millisecond
+	rtoMax = 10 * time.Second
+)
+
+// rttEstimator derives a retransmission timeout from measured round trips
+// the way TCP does (RFC 6298): SRTT + 4*RTTVAR.
+type rttEstimator struct {
+	mu     sync.Mutex
+	srtt   time.Duration
+	rttvar time.Duration
+	n      int
+}
+
+// sample feeds one RTT. Callers only pass RTTs of transactions answered
+// without a retransmission, since the others are ambiguous (Karn).
+func (e *rttEstimator) sample(rtt time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.n == 0 {
+		e.srtt, e.rttvar = rtt, rtt/2
+	} else {
+		d := e.srtt - rtt
+		if d < 0 {
+			d = -d
+		}
+		e.rttvar = (3*e.rttvar + d) / 4
+		e.srtt = (7*e.srtt + rtt) / 8
+	}
+	e.n++
+}
+
+// rto returns the adaptive timeout, or fixed until enough samples exist.
+func (e *rttEstimator) rto(fixed time.Duration) time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.n < rtoMinSamples {
+		return fixed
+	}
+	return min(max(e.srtt+4*e.rttvar, rtoMin), rtoMax)
+}
@@ -0,0 +1,286 @@
+package sim
+
+import (
+	"errors"
+	"hash/fnv"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	gtp "github.com/wmnsk/go-gtp"
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// rxQueueLen is the per-worker backlog of raw packets before the reader blocks.
+const rxQueueLen = 1024
+
+type rxPacket struct {
+	pkt  []byte
+	peer *net.UDPAddr
+}
+
+// rxLoop reads datagrams from every socket and hands them to RxWorkers
+// handler goroutines. Packets are sharded by peer address, so each peer's
+// messages are handled in arrival order by a single worker.
+func (c *Client) rxLoop() {
+	workers := make([]chan rxPacket, c.cfg.RxWorkers)
+	for i := range workers {
+		workers[i] = make(chan rxPacket, rxQueueLen)
+		go func(in <-chan rxPacket) {
+			for p := range in {
+				c.handlePacket(p.pkt, p.peer)
+			}
+		}(workers[i])
+	}
+	defer func() {
+		for _, w := range workers {
+			close(w)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for src := range len(c.tr.srcs) + 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.readSocket(src, workers)
+		}()
+	}
+	wg.Wait()
+}
+
+// readSocket feeds the datagrams of socket src to the workers until it is
+// closed. A socket broken by a fatal error is rebound (see rebind).
+func (c *Client) readSocket(src int, workers []chan rxPacket) {
+	buf := make([]byte, 8192)
+	for {
+		n, peer, err := c.tr.recv(src, buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			if !fatalSocketErr(err) {
+				log.Printf("rx err: %v", err)
+				continue
+			}
+			if !c.rebind(src, err) {
+				return
+			}
+			continue
+		}
+		pkt := make([]byte, n)
+		copy(pkt, buf[:n])
+		if c.integrity != nil {
+			c.integrity.verify(pkt, peer)
+		}
+
+		w := 0
+		if len(workers) > 1 {
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(peer.String()))
+			w = int(h.Sum32() % uint32(len(workers)))
+		}
+		p := rxPacket{pkt: pkt, peer: peer}
+		if c.impaired() && n > 1 {
+			c.impair(p)
+			continue
+		}
+		workers[w] <- p
+	}
+}
+
+// rxLogf logs a line about a received message of type t, unless the
+// LogOnly/LogExcept filters leave that type out or SessionLog is set.
+// Handling is unaffected.
+func (c *Client) rxLogf(t uint8, format string, args ...any) {
+	if c.cfg.SessionLog || len(c.cfg.LogOnly) > 0 && !c.cfg.LogOnly[t] || c.cfg.LogExcept[t] {
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// msgLogf logs a per-message line, a request sent or a procedure's
+// outcome, unless SessionLog replaces those with one line per session.
+func (c *Client) msgLogf(format string, args ...any) {
+	if c.cfg.SessionLog {
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// handlePacket parses one datagram and acts on it: respond EchoReq, deliver
+// responses to the waiting sender, log others.
+func (c *Client) handlePacket(pkt []byte, peer *net.UDPAddr) {
+	tr, reg := c.tr, c.reg
+	if first, next := splitPiggyback(pkt); next != nil {
+		c.handlePiggybacked(first, next, peer)
+		return
+	}
+	if c.dec != nil {
+		c.dec.write(pkt, peer)
+	}
+	if c.cfg.LogIEs {
+		c.logIEs(pkt, peer)
+	}
+
+	// Parse any GTP message
+	m, err := gtp.Parse(pkt)
+	if err != nil {
+		return
+	}
+
+	v2m, ok := m.(gtpv2msg.Message)
+	if !ok {
+		return
+	}
+	c.observeProbe(v2m)
+	if c.cfg.DupSend > 1 {
+		c.dups.observe(pkt, v2m)
+	}
+
+	switch v2m.MessageType() {
+	case gtpv2msg.MsgTypeEchoRequest:
+		er := v2m.(*gtpv2msg.EchoRequest)
+		c.observeNodeFeatures(er.SendingNodeFeatures, peer)
+		c.paths.get(peer).observeRecovery(er.Recovery)
+		if c.cfg.IgnoreEchoReq {
+			c.rxLogf(v2m.MessageType(), "rx EchoReq from %s (seq=%d) -> ignored, no EchoResp", peer.String(), er.Sequence())
+			return
+		}
+		resp := gtpv2msg.NewEchoResponse(0, c.echoIEs()...)
+		resp.SetSequenceNumber(er.Sequence())
+		b, err := gtp.Marshal(resp)
+		if err != nil {
+			return
+		}
+		if c.cfg.EchoRespDelay > 0 {
+			// Answer from a timer goroutine so the worker keeps handling packets.
+			time.AfterFunc(c.cfg.EchoRespDelay, func() {
+				if err := tr.sendTo(b, peer); err != nil {
+					log.Printf("tx EchoResp to %s: %v", peer, err)
+				}
+			})
+			c.rxLogf(v2m.MessageType(), "rx EchoReq from %s -> EchoResp in %s (seq=%d)", peer.String(), c.cfg.EchoRespDelay, er.Sequence())
+			return
+		}
+		if err := tr.sendTo(b, peer); err != nil {
+			log.Printf("tx EchoResp to %s: %v", peer, err)
+			return
+		}
+		c.rxLogf(v2m.MessageType(), "rx EchoReq from %s -> EchoResp (seq=%d)", peer.String(), er.Sequence())
+
+	case gtpv2msg.MsgTypeEchoResponse:
+		if er, ok := v2m.(*gtpv2msg.EchoResponse); ok {
+			c.observeNodeFeatures(er.SendingNodeFeatures, peer)
+		}
+		if reg.deliver(v2m) {
+			tr.learn(peer)
+		}
+		c.rxLogf(v2m.MessageType(), "rx EchoResp from %s seq=%d", peer.String(), v2m.Sequence())
+
+	case gtpv2msg.MsgTypeCreateSessionResponse:
+		if reg.deliver(v2m) {
+			tr.learn(peer)
+		}
+		c.rxLogf(v2m.MessageType(), "rx CSRsp from %s teid=0x%08x seq=%d", peer.String(), v2m.TEID(), v2m.Sequence())
+
+	case gtpv2msg.MsgTypeModifyBearerResponse,
+		gtpv2msg.MsgTypeDeleteSessionResponse,
+		gtpv2msg.MsgTypeChangeNotificationResponse,
+		gtpv2msg.MsgTypeSuspendAcknowledge,
+		gtpv2msg.MsgTypeResumeAcknowledge,
+		gtpv2msg.MsgTypeIdentificationResponse,
+		gtpv2msg.MsgTypeContextResponse,
+		gtpv2msg.MsgTypeDeletePDNConnectionSetResponse,
+		gtpv2msg.MsgTypeDownlinkDataNotificationAcknowledge,
+		gtpv2msg.MsgTypeModifyAccessBearersResponse:
+		if reg.deliver(v2m) {
+			tr.learn(peer)
+		}
+		c.rxLogf(v2m.MessageType(), "rx %s from %s teid=0x%08x seq=%d", msgName(v2m.MessageType()), peer.String(), v2m.TEID(), v2m.Sequence())
+
+	case gtpv2msg.MsgTypeContextRequest:
+		if c.rs == nil {
+			c.rxLogf(v2m.MessageType(), "rx ContextReq from %s seq=%d (not responding, see -respond)", peer.String(), v2m.Sequence())
+			return
+		}
+		c.answerContextReq(v2m.(*gtpv2msg.ContextRequest), peer)
+
+	case gtpv2msg.MsgTypeContextAcknowledge:
+		// Closes a ContextRequest/Response we answered; nothing waits for it.
+		var cause uint8
+		if ack := v2m.(*gtpv2msg.ContextAcknowledge); ack.Cause != nil {
+			cause, _ = ack.Cause.Cause()
+		}
+		c.rxLogf(v2m.MessageType(), "rx ContextAck from %s teid=0x%08x seq=%d cause=%d", peer.String(), v2m.TEID(), v2m.Sequence(), cause)
+
+	case gtpv2msg.MsgTypeCreateBearerRequest,
+		gtpv2msg.MsgTypeBearerResourceFailureIndication:
+		// Triggered by our BearerResourceCommand, whose sequence they carry.
+		if reg.deliver(v2m) {
+			tr.learn(peer)
+			c.rxLogf(v2m.MessageType(), "rx %s from %s teid=0x%08x seq=%d", msgName(v2m.MessageType()), peer.String(), v2m.TEID(), v2m.Sequence())
+			return
+		}
+		c.rxLogf(v2m.MessageType(), "rx %s from %s teid=0x%08x seq=%d (no BearerResourceCommand pending, ignored)", msgName(v2m.MessageType()), peer.String(), v2m.TEID(), v2m.Sequence())
+
+	case gtpv2msg.MsgTypeBearerResourceCommand:
+		if c.rs == nil {
+			c.rxLogf(v2m.MessageType(), "rx BearerResourceCommand from %s teid=0x%08x seq=%d (not responding, see -respond)", peer.String(), v2m.TEID(), v2m.Sequence())
+			return
+		}
+		c.answerBRC(v2m, peer)
+
+	case gtpv2msg.MsgTypeCreateBearerResponse:
+		c.rxLogf(v2m.MessageType(), "rx CBRsp from %s teid=0x%08x seq=%d", peer.String(), v2m.TEID(), v2m.Sequence())
+
+	case gtpv2msg.MsgTypeCreateSessionRequest:
+		if c.rs == nil {
+			c.rxLogf(v2m.MessageType(), "rx CSR from %s seq=%d (not responding, see -respond)", peer.String(), v2m.Sequence())
+			return
+		}
+		c.answerCSR(v2m.(*gtpv2msg.CreateSessionRequest), peer)
+
+	case gtpv2msg.MsgTypeModifyBearerRequest:
+		if c.rs == nil {
+			c.rxLogf(v2m.MessageType(), "rx MBR from %s teid=0x%08x seq=%d (not responding, see -respond)", peer.String(), v2m.TEID(), v2m.Sequence())
+			return
+		}
+		c.answerMBR(v2m.(*gtpv2msg.ModifyBearerRequest), peer)
+
+	case gtpv2msg.MsgTypeDeleteSessionRequest:
+		if c.rs == nil {
+			c.rxLogf(v2m.MessageType(), "rx DSR from %s teid=0x%08x seq=%d (not responding, see -respond)", peer.String(), v2m.TEID(), v2m.Sequence())
+			return
+		}
+		c.answerDSR(v2m.(*gtpv2msg.DeleteSessionRequest), peer)
+
+	case gtpv2msg.MsgTypeDeletePDNConnectionSetRequest:
+		if c.rs == nil {
+			c.rxLogf(v2m.MessageType(), "rx DeletePDNConnectionSetReq from %s seq=%d (not responding, see -respond)", peer.String(), v2m.Sequence())
+			return
+		}
+		c.answerDPCS(v2m.(*gtpv2msg.DeletePDNConnectionSetRequest), peer)
+
+	case gtpv2msg.MsgTypeDownlinkDataNotification:
+		if c.rs == nil {
+			c.rxLogf(v2m.MessageType(), "rx DDN from %s teid=0x%08x seq=%d (not responding, see -respond)", peer.String(), v2m.TEID(), v2m.Sequence())
+			return
+		}
+		c.answerDDN(v2m.(*gtpv2msg.DownlinkDataNotification), peer)
+
+	case gtpv2msg.MsgTypeModifyAccessBearersRequest:
+		if c.rs == nil {
+			c.rxLogf(v2m.MessageType(), "rx ModifyAccessBearersReq from %s teid=0x%08x seq=%d (not responding, see -respond)", peer.String(), v2m.TEID(), v2m.Sequence())
+			return
+		}
+		c.answerMABR(v2m.(*gtpv2msg.ModifyAccessBearersRequest), peer)
+
+	case gtpv2msg.MsgTypeDownlinkDataNotificationFailureIndication:
+		c.rxDDNFailure(v2m.(*gtpv2msg.DownlinkDataNotificationFailureIndication), peer)
+
+	default:
+		c.rxLogf(v2m.MessageType(), "rx msgType=%d from %s teid=0x%08x seq=%d", v2m.MessageType(), peer.String(), v2m.TEID(), v2m.Sequence())
+	}
+}
@@ -0,0 +1,102 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ScanResult is the outcome of an IMSI scan.
+type ScanResult struct {
+	Accepted []string
+	Rejected map[uint8][]string // by cause
+	Failed   map[string]error   // no usable answer (timeout, parse, transport)
+}
+
+// Summary renders r for the log, one line per outcome.
+func (r *ScanResult) Summary() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "accepted (%d): %s", len(r.Accepted), strings.Join(r.Accepted, " "))
+	causes := make([]int, 0, len(r.Rejected))
+	for v := range r.Rejected {
+		causes = append(causes, int(v))
+	}
+	sort.Ints(causes)
+	for _, v := range causes {
+		imsis := r.Rejected[uint8(v)]
+		fmt.Fprintf(&sb, "\nrejected cause=%d (%d): %s", v, len(imsis), strings.Join(imsis, " "))
+	}
+	if len(r.Failed) > 0 {
+		imsis := make([]string, 0, len(r.Failed))
+		for imsi := range r.Failed {
+			imsis = append(imsis, imsi)
+		}
+		sort.Strings(imsis)
+		fmt.Fprintf(&sb, "\nno answer (%d): %s", len(imsis), strings.Join(imsis, " "))
+	}
+	return sb.String()
+}
+
+// imsiRange returns count consecutive IMSIs starting at start, keeping its
+// length (leading zeros included).
+func imsiRange(start string, count int) ([]string, error) {
+	if err := validateIMSI(start); err != nil {
+		return nil, err
+	}
+	if count < 1 {
+		return nil, errors.New("imsi range count must be >= 1")
+	}
+	n, _ := strconv.ParseUint(start, 10, 64) // at most 15 digits
+	out := make([]string, 0, count)
+	for i := uint64(0); i < uint64(count); i++ {
+		s := fmt.Sprintf("%0*d", len(start), n+i)
+		if len(s) > len(start) {
+			return nil, fmt.Errorf("imsi range %s+%d overflows %d digits", start, count, len(start))
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// ScanIMSIs probes count IMSIs from start with one CreateSessionRequest each,
+// at most rate per second, and sorts them by the PGW's answer. Accepted
+// sessions are deleted straight away so the probe leaves nothing behind.
+func (c *Client) ScanIMSIs(start string, count int, rate float64) (*ScanResult, error) {
+	imsis, err := imsiRange(start, count)
+	if err != nil {
+		return nil, err
+	}
+	if rate <= 0 {
+		return nil, errors.New("scan rate must be > 0")
+	}
+	pace := newPacer(rate)
+
+	res := &ScanResult{Rejected: make(map[uint8][]string), Failed: make(map[string]error)}
+	for i, imsi := range imsis {
+		pace.hold(c.backoff.until(c.cfg.APN))
+		pace.wait()
+		cfg := c.cfg
+		cfg.IMSI = imsi
+		sess, cause, err := c.createSession(cfg)
+		var te *TxnError
+		switch {
+		case err == nil:
+			log.Printf("scan %d/%d imsi=%s accepted (cause %d)", i+1, len(imsis), imsi, cause)
+			res.Accepted = append(res.Accepted, imsi)
+			if err := c.DeleteSession(sess); err != nil {
+				log.Printf("scan: cleanup of imsi=%s failed: %v", imsi, err)
+			}
+		case errors.As(err, &te) && te.Kind == TxnRejected:
+			log.Printf("scan %d/%d imsi=%s rejected (cause %d)", i+1, len(imsis), imsi, cause)
+			res.Rejected[cause] = append(res.Rejected[cause], imsi)
+		default:
+			log.Printf("scan %d/%d imsi=%s failed: %v", i+1, len(imsis), imsi, err)
+			res.Failed[imsi] = err
+		}
+	}
+	log.Printf("scan rate: %s", pace)
+	return res, nil
+}
@@ -0,0 +1,357 @@
+package sim
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// A scenario is a line-oriented script of procedures run in order against
+// the peer. Blank lines and text after '#' are ignored. Each line is one step:
+//
+//	create <ref> [key=value ...]         CreateSessionRequest; ref names the session
+//	modify <ref> [key=value ...]         ModifyBearerRequest for ref
+//	change-notify <ref> [key=value ...]  ChangeNotificationRequest for ref
+//	delete <ref>                         DeleteSessionRequest for ref
+//	suspend <ref>                        SuspendNotification for ref
+//	resume <ref>                         ResumeNotification for ref
+//	echo                                 EchoRequest
+//	wait <duration>                      sleep, e.g. wait 500ms
+//	assert-cause <n>                     the last procedure's response cause must be n
+//	assert <selector> <op> [value]       check an IE of the last procedure's response
+//
+// key=value pairs override the client configuration for that step only; see
+// applyOverride for the keys, and Assertion for the assert syntax. A rejected
+// procedure does not stop the run when the next step asserts on its response;
+// any other failure does.
+
+// Scenario is a parsed scenario script.
+type Scenario struct {
+	Name  string
+	Steps []Step
+}
+
+// Step is one scenario line.
+type Step struct {
+	Line   int
+	Op     string
+	Ref    string      // session reference for the session procedures
+	Set    [][2]string // key=value overrides, in script order
+	Wait   time.Duration
+	Cause  uint8
+	Assert *Assertion
+}
+
+// stepResponse is the response type each procedure step waits for.
+var stepResponse = map[string]uint8{
+	"create":        gtpv2msg.MsgTypeCreateSessionResponse,
+	"modify":        gtpv2msg.MsgTypeModifyBearerResponse,
+	"change-notify": gtpv2msg.MsgTypeChangeNotificationResponse,
+	"delete":        gtpv2msg.MsgTypeDeleteSessionResponse,
+	"suspend":       gtpv2msg.MsgTypeSuspendAcknowledge,
+	"resume":        gtpv2msg.MsgTypeResumeAcknowledge,
+	"echo":          gtpv2msg.MsgTypeEchoResponse,
+}
+
+func (s Step) String() string {
+	switch s.Op {
+	case "wait":
+		return fmt.Sprintf("line %d: wait %s", s.Line, s.Wait)
+	case "assert":
+		return fmt.Sprintf("line %d: assert %s", s.Line, s.Assert)
+	}
+	return strings.TrimSpace(fmt.Sprintf("line %d: %s %s", s.Line, s.Op, s.Ref))
+}
+
+// LoadScenario reads and parses a scenario file.
+func LoadScenario(path string) (*Scenario, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := &Scenario{Name: path}
+	in := bufio.NewScanner(f)
+	for n := 1; in.Scan(); n++ {
+		line := in.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		st, err := parseStep(fields)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		st.Line = n
+		sc.Steps = append(sc.Steps, st)
+	}
+	if err := in.Err(); err != nil {
+		return nil, err
+	}
+	if len(sc.Steps) == 0 {
+		return nil, fmt.Errorf("%s: no steps", path)
+	}
+	return sc, nil
+}
+
+func parseStep(fields []string) (Step, error) {
+	st := Step{Op: fields[0]}
+	args := fields[1:]
+	switch st.Op {
+	case "create", "modify", "change-notify":
+		if len(args) == 0 {
+			return st, fmt.Errorf("%s needs a session reference", st.Op)
+		}
+		st.Ref, args = args[0], args[1:]
+		for _, a := range args {
+			k, v, ok := strings.Cut(a, "=")
+			if !ok || k == "" {
+				return st, fmt.Errorf("bad override %q (want key=value)", a)
+			}
+			// Catch typos at load time rather than halfway through a run.
+			if err := applyOverride(&Config{}, k, v); err != nil {
+				return st, err
+			}
+			st.Set = append(st.Set, [2]string{k, v})
+		}
+	case "delete", "suspend", "resume":
+		if len(args) != 1 {
+			return st, fmt.Errorf("%s takes just a session reference", st.Op)
+		}
+		st.Ref = args[0]
+	case "echo":
+		if len(args) != 0 {
+			return st, errors.New("echo takes no arguments")
+		}
+	case "wait":
+		if len(args) != 1 {
+			return st, errors.New("wait needs a duration")
+		}
+		d, err := time.ParseDuration(args[0])
+		if err != nil || d < 0 {
+			return st, fmt.Errorf("bad wait duration %q", args[0])
+		}
+		st.Wait = d
+	case "assert-cause":
+		if len(args) != 1 {
+			return st, errors.New("assert-cause needs a cause value")
+		}
+		v, err := strconv.ParseUint(args[0], 10, 8)
+		if err != nil {
+			return st, fmt.Errorf("bad cause %q", args[0])
+		}
+		st.Cause = uint8(v)
+	case "assert":
+		a, err := ParseAssertion(args)
+		if err != nil {
+			return st, err
+		}
+		st.Assert = a
+	default:
+		return st, fmt.Errorf("unknown step %q", st.Op)
+	}
+	return st, nil
+}
+
+// Set applies one key=value override to c, with the keys and checks of
+// scenario step overrides (see applyOverride); main's -set uses it.
+func (c *Config) Set(kv string) error {
+	k, v, ok := strings.Cut(kv, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("bad override %q (want key=value)", kv)
+	}
+	return applyOverride(c, k, v)
+}
+
+// applyOverride sets one per-step configuration key on cfg.
+func applyOverride(cfg *Config, key, value string) error {
+	u8 := func() (uint8, error) {
+		v, err := strconv.ParseUint(value, 0, 8)
+		if err != nil {
+			return 0, fmt.Errorf("%s=%q: must be 0..255", key, value)
+		}
+		return uint8(v), nil
+	}
+	var err error
+	switch key {
+	case "imsi":
+		if err = validateIMSI(value); err == nil {
+			cfg.IMSI = value
+		}
+	case "msisdn":
+		cfg.MSISDN = value
+	case "apn":
+		cfg.APN = value
+	case "pdn":
+		if _, err = pdnTypeValue(value); err == nil {
+			cfg.PDNType = value
+		}
+	case "rat":
+		cfg.RATType, err = u8()
+	case "ebi":
+		cfg.EBI, err = u8()
+	case "qci":
+		cfg.QCI, err = u8()
+	case "arp":
+		var v uint64
+		if v, err = strconv.ParseUint(value, 0, 8); err != nil || v < 1 || v > 15 {
+			err = fmt.Errorf("arp=%q: must be 1..15", value)
+		}
+		cfg.ARP = uint8(v)
+	case "omit":
+		cfg.Omit, err = ParseOmit(value)
+	case "bad-instance":
+		cfg.BadInstance, err = ParseBadInstance(value)
+	case "cn-rat":
+		cfg.CNRAT, err = u8()
+	case "tac":
+		var v uint64
+		if v, err = strconv.ParseUint(value, 0, 16); err != nil {
+			err = fmt.Errorf("tac=%q: must be 0..65535", value)
+		}
+		cfg.CNTAC = uint16(v)
+	case "eci":
+		var v uint64
+		if v, err = strconv.ParseUint(value, 0, 28); err != nil {
+			err = fmt.Errorf("eci=%q: must fit in 28 bits", value)
+		}
+		cfg.CNECI = uint32(v)
+	default:
+		err = fmt.Errorf("unknown override key %q", key)
+	}
+	return err
+}
+
+// RunScenario executes sc step by step and returns the first failure: a
+// procedure error not covered by a following assert-cause, or a cause
+// assertion that didn't hold.
+func (c *Client) RunScenario(sc *Scenario) error {
+	// Sessions restored from a state file keep the references an earlier
+	// run's scenario gave them.
+	refs := make(map[string]*Session)
+	for _, sess := range c.restored {
+		if sess.Ref != "" && c.sessions.get(sess.LocalCTEID) == sess {
+			refs[sess.Ref] = sess
+		}
+	}
+	var (
+		lastCause uint8
+		lastErr   error // procedure failure pending an assert-cause
+		last      Step
+		lastK     int // last's index and how long it took, for -junit
+		lastTook  time.Duration
+	)
+	// A rejected procedure's -junit test case waits for the step after it:
+	// it passes if that asserts on the rejection.
+	settle := func(err error) {
+		if lastErr != nil {
+			c.junitStep(sc, lastK, lastTook, err)
+		}
+	}
+	for k, st := range sc.Steps {
+		t0 := time.Now()
+		fail := func(err error) error {
+			settle(lastErr)
+			c.junitStep(sc, k, time.Since(t0), err)
+			c.junitSkipped(sc, k+1)
+			return err
+		}
+		pass := func() {
+			settle(nil)
+			c.junitStep(sc, k, time.Since(t0), nil)
+		}
+		if st.Op == "assert-cause" {
+			if lastCause != st.Cause {
+				return fail(fmt.Errorf("%s: %s: want cause %d, got %d after %s", sc.Name, st, st.Cause, lastCause, last))
+			}
+			log.Printf("scenario %s: cause %d as expected", st, lastCause)
+			pass()
+			lastErr = nil
+			continue
+		}
+		if st.Op == "assert" {
+			resp := c.resps.get(stepResponse[last.Op])
+			if resp == nil {
+				return fail(fmt.Errorf("%s: %s: no response to %s to check", sc.Name, st, last))
+			}
+			if err := st.Assert.Check(resp); err != nil {
+				return fail(fmt.Errorf("%s: %s: %w", sc.Name, st, err))
+			}
+			log.Printf("scenario %s: ok", st)
+			pass()
+			lastErr = nil
+			continue
+		}
+		if st.Op == "wait" {
+			log.Printf("scenario %s", st)
+			time.Sleep(st.Wait)
+			c.junitStep(sc, k, time.Since(t0), nil)
+			continue
+		}
+		if lastErr != nil {
+			settle(lastErr)
+			c.junitSkipped(sc, k)
+			return fmt.Errorf("%s: %s: %w", sc.Name, last, lastErr)
+		}
+
+		cfg := c.cfg
+		for _, kv := range st.Set {
+			_ = applyOverride(&cfg, kv[0], kv[1]) // validated by LoadScenario
+		}
+		sess := refs[st.Ref]
+		if st.Ref != "" && st.Op != "create" && sess == nil {
+			return fail(fmt.Errorf("%s: %s: no session %q", sc.Name, st, st.Ref))
+		}
+
+		log.Printf("scenario %s", st)
+		var err error
+		lastCause = 0
+		c.resps.forget(stepResponse[st.Op])
+		switch st.Op {
+		case "create":
+			if sess, lastCause, err = c.createSession(cfg); sess != nil {
+				sess.Ref = st.Ref
+				refs[st.Ref] = sess
+			}
+		case "modify":
+			lastCause, err = c.modifyBearer(cfg, sess)
+		case "change-notify":
+			lastCause, err = c.changeNotification(cfg, sess)
+		case "delete":
+			lastCause, err = c.deleteSession(sess)
+			delete(refs, st.Ref)
+		case "suspend":
+			lastCause, err = c.suspendNotification(sess)
+		case "resume":
+			lastCause, err = c.resumeNotification(sess)
+		case "echo":
+			_, err = c.Echo()
+		}
+		if err != nil {
+			var te *TxnError
+			if !errors.As(err, &te) || te.Kind != TxnRejected {
+				return fail(fmt.Errorf("%s: %s: %w", sc.Name, st, err))
+			}
+		}
+		if err == nil {
+			c.junitStep(sc, k, time.Since(t0), nil)
+		}
+		lastErr, last, lastK, lastTook = err, st, k, time.Since(t0)
+	}
+	if lastErr != nil {
+		settle(lastErr)
+		return fmt.Errorf("%s: %s: %w", sc.Name, last, lastErr)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// NewSelfTest starts an in-process PGW responder on pgwLocal and an SGW
+// initiator Client from cfg pointed at it, so the tool can be exercised
+// without a real gateway. An empty pgwLocal picks a free port on the SGW's
+// host. Close both clients when done.
+func NewSelfTest(cfg Config, pgwLocal string) (sgw, pgw *Client, err error) {
+	if len(cfg.Peers) > 0 {
+		return nil, nil, errors.New("selftest: the in-process PGW is the only peer; drop -peer")
+	}
+	if pgwLocal == "" {
+		host, _, err := net.SplitHostPort(cfg.Local)
+		if err != nil {
+			return nil, nil, fmt.Errorf("selftest: local %q: %w", cfg.Local, err)
+		}
+		pgwLocal = net.JoinHostPort(host, "0")
+	}
+	if err := checkDistinctEndpoints(cfg.Local, pgwLocal); err != nil {
+		return nil, nil, err
+	}
+
+	pcfg := cfg
+	pcfg.Local, pcfg.Remote = pgwLocal, ""
+	pcfg.Respond, pcfg.EchoEvery, pcfg.StatsEvery = true, 0, 0
+	pcfg.GTPUEcho, pcfg.GTPURemote, pcfg.GTPUKeepalive, pcfg.TUN = 0, "", 0, ""
+	pcfg.IPOut, pcfg.DecodeJSON, pcfg.StateFile = "", false, ""
+	pcfg.SrcPorts, pcfg.RotateSrcPort, pcfg.Connected = 1, false, false
+	pcfg.RxLoss, pcfg.RxDelay, pcfg.RxJitter = 0, 0, 0
+	if pgw, err = NewClient(pcfg); err != nil {
+		return nil, nil, fmt.Errorf("selftest pgw: %w", err)
+	}
+
+	// The PGW address may have had port 0; aim at the port actually bound.
+	_, port, _ := net.SplitHostPort(pgw.LocalAddr().String())
+	host, _, _ := net.SplitHostPort(pgwLocal)
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	cfg.Remote = net.JoinHostPort(host, port)
+	cfg.Respond = false
+	if sgw, err = NewClient(cfg); err != nil {
+		pgw.Close()
+		return nil, nil, fmt.Errorf("selftest sgw: %w", err)
+	}
+	if cfg.IntegrityCheck {
+		pairIntegrity(sgw, pgw)
+	}
+	return sgw, pgw, nil
+}
+
+// checkDistinctEndpoints rejects SGW and PGW sockets that would share a
+// port on overlapping addresses: the bind fails or, with address reuse, both
+// sides read each other's packets.
+func checkDistinctEndpoints(sgwLocal, pgwLocal string) error {
+	sh, sp, err := net.SplitHostPort(sgwLocal)
+	if err != nil {
+		return fmt.Errorf("selftest: sgw local %q: %w", sgwLocal, err)
+	}
+	ph, pp, err := net.SplitHostPort(pgwLocal)
+	if err != nil {
+		return fmt.Errorf("selftest: pgw local %q: %w", pgwLocal, err)
+	}
+	if n, _ := strconv.Atoi(sp); n == 0 || sp != pp {
+		return nil
+	}
+	sip, pip := net.ParseIP(sh), net.ParseIP(ph)
+	anyIP := func(ip net.IP) bool { return ip == nil || ip.IsUnspecified() }
+	if anyIP(sip) || anyIP(pip) || sip.Equal(pip) {
+		return fmt.Errorf("selftest: SGW (%s) and PGW (%s) would share port %s; use different ports or leave -selftest-pgw empty to pick a free one", sgwLocal, pgwLocal, sp)
+	}
+	return nil
+}
@@ -0,0 +1,775 @@
+package sim
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	gtpv2 "github.com/wmnsk/go-gtp/gtpv2"
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// Session is an established PDN connection as seen from the SGW side.
+type Session struct {
+	IMSI        string
+	EBI         uint8
+	LocalCTEID  uint32 // our S5/S8 SGW GTP-C TEID
+	RemoteCTEID uint32 // PGW S5/S8 GTP-C TEID from the CSRsp sender F-TEID
+	LocalUTEID  uint32 // our S5/S8-U SGW TEID for the default bearer
+	RemoteUTEID uint32 // PGW S5/S8-U TEID for the default bearer, from the CSRsp
+	RemoteUIP   net.IP // PGW S5/S8-U address; nil if the CSRsp carried none
+	PAA         string // assigned UE address(es), see paaString
+	APN         string
+	Group       string       // label the run report breaks statistics down by, see sessionGroup
+	Start       time.Time    // when the CSRsp accepted the session
+	CSIDs       []uint16     // SGW CSIDs sent in the CSR, if any
+	ChargingID  uint32       // default bearer's Charging ID from the CSRsp, for matching CDRs; 0 if none
+	Ref         string       // scenario reference the session was created under, if any
+	Peer        *net.UDPAddr // PGW of Config.Peers the session is on; nil: the remote
+
+	// Bearers holds every bearer of the session by EBI, the default one
+	// included. A ModifyBearerResponse may move the PGW side; mu guards the
+	// user-plane fields against such updates.
+	mu      sync.Mutex
+	Bearers map[uint8]*Bearer
+
+	steps  []string // procedures run on the session and their outcomes, see record
+	failed bool     // one of them failed
+	errs   []string // how the failed ones failed, e.g. "MBR: ..."
+	db     *sqlDB   // -db database the steps go to; nil if none
+}
+
+// Bearer is the user-plane endpoints of one EPS bearer.
+type Bearer struct {
+	EBI         uint8
+	LocalUTEID  uint32 // our S5/S8-U SGW TEID
+	RemoteUTEID uint32 // PGW S5/S8-U TEID; 0 until the PGW names one
+	RemoteUIP   net.IP
+	Flags       uint8  // Bearer Flags the PGW sent (see bearerFlagNames); 0 if none
+	ChargingID  uint32 // Charging ID the PGW sent; 0 if none
+}
+
+// userPeer returns the PGW user-plane address of the default bearer.
+func (s *Session) userPeer() net.IP {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.RemoteUIP
+}
+
+// updateBearers records the PGW S5/S8-U F-TEIDs found in a response's
+// bearer contexts and returns the bearers that carried one.
+func (s *Session) updateBearers(bcs []*gtpv2ie.IE) []Bearer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Bearer
+	for _, bc := range bcs {
+		b := s.Bearers[bearerEBI(bc)]
+		if b == nil {
+			continue
+		}
+		updated := false
+		for _, ie := range bc.ChildIEs {
+			switch ie.Type {
+			case gtpv2ie.BearerFlags:
+				b.Flags, _ = ie.BearerFlags()
+			case gtpv2ie.ChargingID:
+				b.ChargingID, _ = ie.ChargingID()
+			case gtpv2ie.FullyQualifiedTEID:
+				if t, err := ie.InterfaceType(); err != nil || t != gtpv2.IFTypeS5S8PGWGTPU {
+					continue
+				}
+				b.RemoteUTEID, _ = ie.TEID()
+				b.RemoteUIP, _ = ie.IPv4()
+				if b.EBI == s.EBI {
+					s.RemoteUTEID, s.RemoteUIP = b.RemoteUTEID, b.RemoteUIP
+				}
+				updated = true
+			}
+		}
+		if updated {
+			out = append(out, *b)
+		}
+	}
+	return out
+}
+
+// sessionStore indexes live sessions by our local control TEID.
+type sessionStore struct {
+	mu sync.Mutex
+	m  map[uint32]*Session
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{m: make(map[uint32]*Session)}
+}
+
+func (s *sessionStore) add(sess *Session) {
+	s.mu.Lock()
+	s.m[sess.LocalCTEID] = sess
+	s.mu.Unlock()
+}
+
+func (s *sessionStore) remove(teid uint32) {
+	s.mu.Lock()
+	delete(s.m, teid)
+	s.mu.Unlock()
+}
+
+func (s *sessionStore) get(teid uint32) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.m[teid]
+}
+
+func (s *sessionStore) all() []*Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]*Session, 0, len(s.m))
+	for _, sess := range s.m {
+		out = append(out, sess)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LocalCTEID < out[j].LocalCTEID })
+	return out
+}
+
+// plmnFromIMSI splits the home PLMN off an IMSI whose MNC has mncLen (2
+// or 3) digits; the IMSI alone doesn't tell (TS 23.003 2.2).
+func plmnFromIMSI(imsi string, mncLen int) (mcc, mnc string) {
+	return imsi[:3], imsi[3 : 3+mncLen]
+}
+
+// CreateSession sends a CreateSessionRequest for the configured subscriber
+// and, once accepted, records the new session.
+func (c *Client) CreateSession() (*Session, error) {
+	sess, _, err := c.createSession(c.cfg)
+	return sess, err
+}
+
+// createSession is CreateSession with per-call settings; it also returns the
+// response cause (0 if there was no usable response). Every call counts
+// towards the failure thresholds (see CheckFailures).
+func (c *Client) createSession(cfg Config) (*Session, uint8, error) {
+	t0 := time.Now()
+	sess, cause, err := c.sendCSR(cfg)
+	if err != nil {
+		c.logSessionFailed(cfg, cause, err, time.Since(t0))
+		c.junitSessionFailed(cfg, cause, err, time.Since(t0))
+		if c.db != nil {
+			c.db.failed(cfg, cause, err, time.Since(t0))
+		}
+	}
+	warm := c.warming(t0)
+	c.csrs.count(err, warm)
+	if !warm {
+		c.groups.count(cfg, time.Since(t0), err)
+	}
+	return sess, cause, err
+}
+
+// csr is a CreateSessionRequest built by newCSR, with what its sender needs
+// to record the session it creates.
+type csr struct {
+	req                    *gtpv2msg.CreateSessionRequest
+	localCTeid, localUTeid uint32
+	ebis                   []uint8
+	bearers                map[uint8]*Bearer
+	pdnVal                 uint8
+	badIE                  string // the -bad-instance IE, sent at badInst
+	badInst                uint8
+}
+
+// newCSR builds the CreateSessionRequest for cfg with a fresh sequence
+// number and TEIDs.
+func (c *Client) newCSR(cfg Config) (*csr, error) {
+	seq := c.seq.next()
+
+	// Sender F-TEID for CP (S5/S8 SGW GTP-C)
+	localCTeid := randUint32()
+	senderFTEID := newNodeFTEID(cfg, gtpv2.IFTypeS5S8SGWGTPC, localCTeid)
+	senderFTEID.SetInstance(0)
+
+	// PDN Type
+	pdnVal, err := pdnTypeValue(cfg.PDNType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bearer Contexts (to be created) — instance 0, each with our S5/S8-U
+	// F-TEID (instance 2) so a later ModifyBearer has a user plane to refer
+	// to. Without -bearers there is just the default bearer.
+	ebis := cfg.Bearers
+	if len(ebis) == 0 {
+		ebis = []uint8{cfg.EBI}
+	}
+	if err := checkBearerEBIs(cfg.EBI, ebis); err != nil {
+		return nil, err
+	}
+	var (
+		localUTeid uint32
+		bearerCtxs []*gtpv2ie.IE
+		bearers    = make(map[uint8]*Bearer, len(ebis))
+	)
+	for _, ebi := range ebis {
+		uTeid := randUint32()
+		if ebi == cfg.EBI {
+			localUTeid = uTeid
+		}
+		bearers[ebi] = &Bearer{EBI: ebi, LocalUTEID: uTeid}
+		bearerQoS := gtpv2ie.NewBearerQoS(0, cfg.ARP, 0, cfg.QCI, 0, 0, 0, 0)
+		children := []*gtpv2ie.IE{
+			gtpv2ie.NewEPSBearerID(ebi),
+			newNodeFTEID(cfg, gtpv2.IFTypeS5S8SGWGTPU, uTeid).WithInstance(2),
+			bearerQoS,
+		}
+		if cfg.BearerFlags != 0 {
+			children = append(children, gtpv2ie.New(gtpv2ie.BearerFlags, 0, []byte{cfg.BearerFlags}))
+		}
+		bearerCtx := gtpv2ie.NewBearerContext(children...)
+		bearerCtx.SetInstance(0)
+		bearerCtxs = append(bearerCtxs, bearerCtx)
+	}
+
+	imsiIE, err := newIMSI(cfg.IMSI)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Debug {
+		log.Printf("debug: IMSI %s (%d digits) -> TBCD % x", cfg.IMSI, len(cfg.IMSI), imsiIE.Payload)
+	}
+
+	// Mandatory IEs by -omit name; omitted ones are dropped on purpose for
+	// negative tests, as is the instance of the -bad-instance one.
+	var (
+		ies     []*gtpv2ie.IE
+		badIE   string
+		badInst uint8
+	)
+	if cfg.BadInstance != nil {
+		badIE = cfg.BadInstance.pick()
+	}
+	for _, n := range []struct {
+		name string
+		ies  []*gtpv2ie.IE
+	}{
+		{"imsi", []*gtpv2ie.IE{imsiIE}},
+		{"apn", []*gtpv2ie.IE{gtpv2ie.NewAccessPointName(cfg.APN)}},
+		{"rat", []*gtpv2ie.IE{gtpv2ie.NewRATType(cfg.RATType)}},
+		{"pdn", []*gtpv2ie.IE{gtpv2ie.NewPDNType(pdnVal)}},
+		{"fteid", []*gtpv2ie.IE{senderFTEID}},
+		{"bearer", bearerCtxs},
+	} {
+		if cfg.Omit[n.name] {
+			log.Printf("CSR: omitting %s IE", n.name)
+			continue
+		}
+		if n.name == badIE {
+			for _, ie := range n.ies {
+				was := ie.Instance()
+				badInst = cfg.BadInstance.instance(was)
+				ie.SetInstance(badInst)
+				log.Printf("CSR: sending %s IE at instance %d instead of %d", n.name, badInst, was)
+			}
+		}
+		ies = append(ies, n.ies...)
+	}
+	switch {
+	case cfg.NoMSISDN:
+		if cfg.Debug {
+			log.Printf("debug: MSISDN IE suppressed (no-msisdn)")
+		}
+	case cfg.MSISDN != "":
+		ies = append(ies, gtpv2ie.NewMSISDN(cfg.MSISDN))
+	}
+	if cfg.TraceIP != nil {
+		ti, err := newTraceInformation(cfg.TraceMCC, cfg.TraceMNC, cfg.TraceID, cfg.TraceDepth, cfg.TraceIP)
+		if err != nil {
+			return nil, err
+		}
+		ies = append(ies, ti)
+	}
+
+	if len(cfg.CSIDs) > 0 {
+		node := cfg.CSIDNode
+		if node == "" {
+			node = cfg.NodeIP.String()
+		}
+		ies = append(ies, gtpv2ie.NewFullyQualifiedCSID(node, cfg.CSIDs...).WithInstance(1)) // SGW FQ-CSID
+	}
+
+	if cfg.SPLMNRateUL >= 0 {
+		ies = append(ies, newServingPLMNRateControl(uint16(cfg.SPLMNRateUL), uint16(cfg.SPLMNRateDL)))
+	}
+	if cfg.CSGID >= 0 {
+		ies = append(ies, newUserCSGInformation(cfg))
+	}
+
+	if len(cfg.EPCO) > 0 {
+		ies = append(ies, newEPCO(cfg.EPCO), newIndication("EPCOSI"))
+		log.Printf("CSR ePCO: %s", epcoString(cfg.EPCO))
+	}
+
+	for _, raw := range cfg.RawIEs {
+		if cfg.Debug {
+			log.Printf("debug: raw IE type=%d instance=%d % x", raw.Type, raw.Instance(), raw.Payload)
+		}
+		ies = append(ies, raw)
+	}
+
+	// Your version requires (teid, seq, ies...)
+	req := gtpv2msg.NewCreateSessionRequest(0, seq, ies...)
+	return &csr{req: req, localCTeid: localCTeid, localUTeid: localUTeid, ebis: ebis, bearers: bearers,
+		pdnVal: pdnVal, badIE: badIE, badInst: badInst}, nil
+}
+
+// sendCSR builds and sends one CreateSessionRequest and records the session
+// the response accepts.
+func (c *Client) sendCSR(cfg Config) (*Session, uint8, error) {
+	peer, node := c.peers.pick()
+	to := c.tr.remote()
+	if peer != nil {
+		cfg.NodeIP, to = node, peer
+	}
+	b, err := c.newCSR(cfg)
+	if err != nil {
+		return nil, 0, err
+	}
+	req, seq, localCTeid, localUTeid := b.req, b.req.Sequence(), b.localCTeid, b.localUTeid
+	ebis, bearers, pdnVal, badIE, badInst := b.ebis, b.bearers, b.pdnVal, b.badIE, b.badInst
+
+	c.msgLogf("tx CSR seq=%d localCTeid=0x%08x imsi=%s -> %s", seq, localCTeid, cfg.IMSI, to)
+	defer c.piggy.take(seq) // a CBReq piggybacked on a CSRsp we didn't accept
+	m, rtt, err := c.transactTo(req, to)
+	if err != nil {
+		if badIE != "" {
+			log.Printf("bad-instance: no answer to the CSR with the %s IE at instance %d: %v", badIE, badInst, err)
+		}
+		return nil, 0, err
+	}
+	c.checkSLA(seq, cfg.IMSI, rtt)
+	resp, _ := m.(*gtpv2msg.CreateSessionResponse)
+	var causeIE *gtpv2ie.IE
+	if resp != nil {
+		causeIE = resp.Cause
+	}
+	cause, err := c.checkResponse(req, m, gtpv2msg.MsgTypeCreateSessionResponse, causeIE)
+	if badIE != "" {
+		if err == nil {
+			log.Printf("bad-instance: peer ACCEPTED the %s IE at instance %d (cause %d): instances not validated", badIE, badInst, cause)
+		} else {
+			log.Printf("bad-instance: peer rejected the %s IE at instance %d with cause %d", badIE, badInst, cause)
+		}
+	}
+	if err != nil {
+		if resp != nil {
+			c.backoff.congestion(cfg.APN, cause, resp.PGWBackOffTime)
+		}
+		return nil, cause, err
+	}
+
+	c.msgLogf("CSR succeeded seq=%d rtt=%s (resp teid=0x%08x). Next: DeleteSession / ModifyBearer.", seq, rtt, resp.TEID())
+	logIndication("CSRsp", resp.IndicationFlags)
+	if resp.APNRestriction != nil {
+		if v, err := resp.APNRestriction.APNRestriction(); err == nil {
+			log.Printf("CSRsp APN Restriction: %d", v)
+		}
+	}
+
+	if resp.EPCO != nil {
+		cs, err := parseEPCO(resp.EPCO.Payload)
+		if err != nil {
+			log.Printf("CSRsp %v", err)
+		}
+		log.Printf("CSRsp ePCO: %s", epcoString(cs))
+	}
+
+	if i := findIE(resp.AdditionalIEs, gtpv2ie.ServingPLMNRateControl); i != nil {
+		log.Printf("CSRsp Serving PLMN Rate Control: %s", splmnRateString(i))
+	}
+	if i := findIE(resp.AdditionalIEs, gtpv2ie.UserCSGInformation); i != nil {
+		log.Printf("CSRsp User CSG Information: %s", uciString(i))
+	}
+	if resp.CSGInformationReportingAction != nil {
+		log.Printf("CSRsp CSG Information Reporting Action: %s", csgIRAString(resp.CSGInformationReportingAction))
+	}
+
+	if resp.PGWFQCSID != nil {
+		log.Printf("CSRsp PGW FQ-CSID: %s", fqcsidString(resp.PGWFQCSID))
+	}
+	if resp.SGWFQCSID != nil {
+		log.Printf("CSRsp SGW FQ-CSID: %s", fqcsidString(resp.SGWFQCSID))
+	}
+
+	sess := &Session{IMSI: cfg.IMSI, EBI: cfg.EBI, LocalCTEID: localCTeid, LocalUTEID: localUTeid, APN: cfg.APN, Group: sessionGroup(cfg), Start: time.Now(), Bearers: bearers, CSIDs: cfg.CSIDs, Peer: peer}
+	sess.record("CSR", cause, nil)
+	if resp.SenderFTEIDC != nil {
+		sess.RemoteCTEID, _ = resp.SenderFTEIDC.TEID()
+	}
+	if err := c.checkRequiredIEs(cfg.RequireRespIEs, req, m); err != nil {
+		// The PGW did create the session; don't leave it behind.
+		if _, derr := c.deleteSession(sess); derr != nil {
+			log.Printf("require-resp-ie: DeleteSession after the incomplete CSRsp: %v", derr)
+		}
+		return nil, cause, err
+	}
+	sess.updateBearers(resp.BearerContextsCreated)
+	for _, ebi := range ebis {
+		if b := sess.Bearers[ebi]; b.Flags != 0 || cfg.BearerFlags != 0 {
+			c.msgLogf("CSRsp bearer ebi=%d Bearer Flags: %s", ebi, bearerFlagsString(b.Flags))
+		}
+	}
+	if b := sess.Bearers[cfg.EBI]; b.ChargingID != 0 {
+		sess.ChargingID = b.ChargingID
+		c.msgLogf("CSRsp imsi=%s apn=%s charging-id=%d (0x%08x)", cfg.IMSI, cfg.APN, b.ChargingID, b.ChargingID)
+	} else {
+		c.msgLogf("CSRsp imsi=%s apn=%s: no Charging ID in the default bearer context", cfg.IMSI, cfg.APN)
+	}
+	if resp.PAA != nil {
+		sess.PAA = paaString(resp.PAA)
+		if a, err := parsePAA(resp.PAA.Payload); err != nil {
+			log.Printf("CSRsp PAA: invalid: %v", err)
+		} else {
+			c.msgLogf("CSRsp PAA: %s", a)
+		}
+		if got := resp.PAA.Payload; len(got) > 0 && got[0]&0x07 != pdnVal {
+			log.Printf("CSRsp PAA: PDN type %d differs from the requested %s (cause %d)", got[0]&0x07, cfg.PDNType, cause)
+		}
+		if c.ips != nil {
+			if err := c.ips.write(cfg.IMSI, resp.PAA); err != nil {
+				log.Printf("ip-out: %v", err)
+			}
+		}
+	}
+	if c.db != nil {
+		sess.db = c.db
+		c.db.created(sess, cause, rtt)
+	}
+	c.sessions.add(sess)
+	c.answerPiggybackedCBR(cfg, sess, seq)
+	return sess, cause, nil
+}
+
+// CreateSessions creates the configured number of sessions, or one per
+// configured subscriber, one after another; with APNs, one PDN connection
+// per APN for each of them. Each session's IMSI is fixed here and kept in
+// its Session, so every later procedure on it carries the same identity. It
+// returns the sessions that were accepted and the last failure, if any. With
+// Config.Rate the requests are paced open-loop instead of one at a time, and
+// with Config.ClosedLoop up to that many are kept outstanding.
+// Either way, an APN the PGW backed off (see backoffs) gets no requests until
+// its Back-off Time has passed.
+func (c *Client) CreateSessions() ([]*Session, error) {
+	var (
+		out     []*Session
+		lastErr error
+	)
+	cfgs := make([]Config, 0, c.cfg.Sessions)
+	if len(c.cfg.Subscribers) > 0 {
+		for _, s := range c.cfg.Subscribers {
+			cfgs = append(cfgs, s.apply(c.cfg))
+		}
+	} else {
+		for _, imsi := range sessionIMSIs(c.cfg.IMSI, c.cfg.Sessions) {
+			cfg := c.cfg
+			cfg.IMSI = imsi
+			cfgs = append(cfgs, cfg)
+		}
+	}
+	if len(c.cfg.APNs) > 0 {
+		cfgs = perAPN(cfgs, c.cfg.APNs)
+	}
+	// Without a rate each CSR waits for the previous answer; with one, they
+	// go out open-loop on the pacer's schedule and overlap. Closed-loop, a
+	// widening window of them overlaps; in a burst, all of them do.
+	sessions := make([]*Session, len(cfgs))
+	errs := make([]error, len(cfgs))
+	timings := make([]csrTiming, len(cfgs))
+	create := func(k int, cfg Config) {
+		t0 := time.Now()
+		sessions[k], _, errs[k] = c.createSession(cfg)
+		timings[k] = csrTiming{at: t0, rtt: time.Since(t0), err: errs[k]}
+	}
+	c.startWarmup()
+	if c.cfg.Rate > 0 {
+		p := newPacer(c.cfg.Rate)
+		var wg sync.WaitGroup
+		for k, cfg := range cfgs {
+			if t := c.backoff.until(cfg.APN); !t.IsZero() {
+				log.Printf("apn=%s backed off by the PGW: holding the schedule %s", cfg.APN, time.Until(t).Round(time.Millisecond))
+				p.hold(t)
+			}
+			p.wait()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				create(k, cfg)
+			}()
+		}
+		wg.Wait()
+		log.Printf("CreateSessions rate: %s", p)
+	} else if c.cfg.ClosedLoop > 0 {
+		c.createClosedLoop(cfgs, create)
+	} else if c.cfg.Burst {
+		c.createBurst(cfgs, create, timings)
+	} else {
+		for k, cfg := range cfgs {
+			c.backoff.wait(cfg.APN)
+			create(k, cfg)
+		}
+	}
+	if c.cfg.Warmup > 0 {
+		c.logLoadSummary(timings)
+	}
+
+	tried, accepted := make(map[string]int), make(map[string]int)
+	for k, cfg := range cfgs {
+		tried[cfg.APN]++
+		if err := errs[k]; err != nil {
+			c.msgLogf("CreateSession imsi=%s apn=%s failed: %v", cfg.IMSI, cfg.APN, err)
+			lastErr = err
+			continue
+		}
+		accepted[cfg.APN]++
+		out = append(out, sessions[k])
+	}
+	for _, apn := range c.cfg.APNs {
+		log.Printf("CreateSessions apn=%s: %d/%d accepted (%.0f%%)", apn, accepted[apn], tried[apn], 100*float64(accepted[apn])/float64(tried[apn]))
+	}
+	return out, lastErr
+}
+
+// perAPN expands each subscriber's settings into one per APN. The PDN
+// connections of one UE need distinct default bearers, so the k-th APN gets
+// EBI+k.
+func perAPN(cfgs []Config, apns []string) []Config {
+	out := make([]Config, 0, len(cfgs)*len(apns))
+	for _, cfg := range cfgs {
+		for k, apn := range apns {
+			c := cfg
+			c.APN, c.EBI = apn, cfg.EBI+uint8(k)
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// headerTEID returns the GTP-C header TEID for a follow-up request on sess:
+// the PGW's assigned control TEID, or cfg.FixedTEID with TEIDFixed set.
+func headerTEID(cfg Config, sess *Session) uint32 {
+	if cfg.TEIDFixed {
+		return cfg.FixedTEID
+	}
+	return sess.RemoteCTEID
+}
+
+// ModifyBearer sends a ModifyBearerRequest for sess's default bearer,
+// re-announcing our S5/S8-U F-TEID and the configured RAT type.
+func (c *Client) ModifyBearer(sess *Session) error {
+	_, err := c.modifyBearer(c.cfg, sess)
+	return err
+}
+
+func (c *Client) modifyBearer(cfg Config, sess *Session) (uint8, error) {
+	return c.modifyBearerWith(cfg, sess, nil)
+}
+
+// modifyBearerWith sends the MBR with cbrsp, if not nil, piggybacked on it.
+func (c *Client) modifyBearerWith(cfg Config, sess *Session, cbrsp *gtpv2msg.CreateBearerResponse) (cause uint8, err error) {
+	defer func() { sess.record("MBR", cause, err) }()
+	cfg = c.sessCfg(cfg, sess)
+	seq := c.seq.next()
+	teid := headerTEID(cfg, sess)
+	var req gtpv2msg.Message = gtpv2msg.NewModifyBearerRequest(teid, seq,
+		gtpv2ie.NewRATType(cfg.RATType),
+		gtpv2ie.NewBearerContext(
+			gtpv2ie.NewEPSBearerID(sess.EBI),
+			newNodeFTEID(cfg, gtpv2.IFTypeS5S8SGWGTPU, sess.LocalUTEID).WithInstance(1),
+		),
+	)
+
+	if cbrsp != nil {
+		req = piggybacked{req, cbrsp}
+		c.msgLogf("tx MBR seq=%d teid=0x%08x imsi=%s ebi=%d with piggybacked CBRsp seq=%d", seq, teid, sess.IMSI, sess.EBI, cbrsp.Sequence())
+	} else {
+		c.msgLogf("tx MBR seq=%d teid=0x%08x imsi=%s ebi=%d", seq, teid, sess.IMSI, sess.EBI)
+	}
+	m, rtt, err := c.transactTo(req, c.peerOf(sess))
+	if err != nil {
+		return 0, err
+	}
+	var causeIE *gtpv2ie.IE
+	resp, _ := m.(*gtpv2msg.ModifyBearerResponse)
+	if resp != nil {
+		causeIE = resp.Cause
+	}
+	cause, err = c.checkResponse(req, m, gtpv2msg.MsgTypeModifyBearerResponse, causeIE)
+	if err != nil {
+		return cause, err
+	}
+	c.msgLogf("MBR succeeded seq=%d rtt=%s cause=%d", seq, rtt, cause)
+	for _, b := range sess.updateBearers(resp.BearerContextsModified) {
+		log.Printf("MBRsp ebi=%d: PGW S5/S8-U teid=0x%08x ip=%s", b.EBI, b.RemoteUTEID, b.RemoteUIP)
+	}
+	return cause, nil
+}
+
+// DeleteSession tears sess down with a DeleteSessionRequest. The session is
+// forgotten once the PGW has answered, whatever the cause.
+func (c *Client) DeleteSession(sess *Session) error {
+	_, err := c.deleteSession(sess)
+	return err
+}
+
+func (c *Client) deleteSession(sess *Session) (uint8, error) {
+	seq := c.seq.next()
+	teid := headerTEID(c.cfg, sess)
+	req := gtpv2msg.NewDeleteSessionRequest(teid, seq,
+		gtpv2ie.NewEPSBearerID(sess.EBI),
+	)
+
+	c.msgLogf("tx DSR seq=%d teid=0x%08x imsi=%s ebi=%d", seq, teid, sess.IMSI, sess.EBI)
+	m, rtt, err := c.transactTo(req, c.peerOf(sess))
+	if err != nil {
+		sess.record("DSR", 0, err)
+		return 0, err
+	}
+	c.sessions.remove(sess.LocalCTEID)
+	var causeIE *gtpv2ie.IE
+	if resp, ok := m.(*gtpv2msg.DeleteSessionResponse); ok {
+		causeIE = resp.Cause
+	}
+	cause, err := c.checkResponse(req, m, gtpv2msg.MsgTypeDeleteSessionResponse, causeIE)
+	sess.record("DSR", cause, err)
+	c.endSession(sess, fmt.Sprintf("cause=%d", cause))
+	if err != nil {
+		return cause, err
+	}
+	c.msgLogf("DSR succeeded seq=%d rtt=%s cause=%d", seq, rtt, cause)
+	return cause, nil
+}
+
+// ChangeNotification reports a RAT/location change for sess to the PGW
+// and checks the ChangeNotificationResponse cause.
+func (c *Client) ChangeNotification(sess *Session) error {
+	_, err := c.changeNotification(c.cfg, sess)
+	return err
+}
+
+func (c *Client) changeNotification(cfg Config, sess *Session) (cause uint8, err error) {
+	defer func() { sess.record("ChangeNotification", cause, err) }()
+	seq := c.seq.next()
+	mcc, mnc := plmnFromIMSI(sess.IMSI, cfg.MNCLen)
+
+	imsiIE, err := newIMSI(sess.IMSI)
+	if err != nil {
+		return 0, err
+	}
+	uli := gtpv2ie.NewUserLocationInformationStruct(
+		nil, nil, nil,
+		gtpv2ie.NewTAI(mcc, mnc, cfg.CNTAC),
+		gtpv2ie.NewECGI(mcc, mnc, cfg.CNECI),
+		nil, nil, nil,
+	)
+	req := gtpv2msg.NewChangeNotificationRequest(sess.RemoteCTEID, seq,
+		imsiIE,
+		gtpv2ie.NewRATType(cfg.CNRAT),
+		uli,
+		gtpv2ie.NewEPSBearerID(sess.EBI),
+	)
+
+	c.msgLogf("tx ChangeNotificationReq seq=%d teid=0x%08x imsi=%s rat=%d tac=%d eci=%d", seq, sess.RemoteCTEID, sess.IMSI, cfg.CNRAT, cfg.CNTAC, cfg.CNECI)
+	m, rtt, err := c.transactTo(req, c.peerOf(sess))
+	if err != nil {
+		return 0, err
+	}
+	var causeIE *gtpv2ie.IE
+	if resp, ok := m.(*gtpv2msg.ChangeNotificationResponse); ok {
+		causeIE = resp.Cause
+	}
+	cause, err = c.checkResponse(req, m, gtpv2msg.MsgTypeChangeNotificationResponse, causeIE)
+	if err != nil {
+		return cause, err
+	}
+	c.msgLogf("ChangeNotification succeeded seq=%d rtt=%s cause=%d", seq, rtt, cause)
+	return cause, nil
+}
+
+// SuspendNotification tells the PGW the UE's bearers are suspended (e.g.
+// during CS fallback without DTM) and checks the SuspendAcknowledge cause.
+func (c *Client) SuspendNotification(sess *Session) error {
+	_, err := c.suspendNotification(sess)
+	return err
+}
+
+func (c *Client) suspendNotification(sess *Session) (cause uint8, err error) {
+	defer func() { sess.record("Suspend", cause, err) }()
+	seq := c.seq.next()
+	imsiIE, err := newIMSI(sess.IMSI)
+	if err != nil {
+		return 0, err
+	}
+	req := gtpv2msg.NewSuspendNotification(sess.RemoteCTEID, seq,
+		imsiIE,
+		gtpv2ie.NewEPSBearerID(sess.EBI), // LBI
+	)
+
+	c.msgLogf("tx SuspendNotification seq=%d teid=0x%08x imsi=%s lbi=%d", seq, sess.RemoteCTEID, sess.IMSI, sess.EBI)
+	m, rtt, err := c.transactTo(req, c.peerOf(sess))
+	if err != nil {
+		return 0, err
+	}
+	var causeIE *gtpv2ie.IE
+	if resp, ok := m.(*gtpv2msg.SuspendAcknowledge); ok {
+		causeIE = resp.Cause
+	}
+	cause, err = c.checkResponse(req, m, gtpv2msg.MsgTypeSuspendAcknowledge, causeIE)
+	if err != nil {
+		return cause, err
+	}
+	c.msgLogf("SuspendNotification succeeded seq=%d rtt=%s cause=%d", seq, rtt, cause)
+	return cause, nil
+}
+
+// ResumeNotification resumes sess's suspended bearers and checks the
+// ResumeAcknowledge cause.
+func (c *Client) ResumeNotification(sess *Session) error {
+	_, err := c.resumeNotification(sess)
+	return err
+}
+
+func (c *Client) resumeNotification(sess *Session) (cause uint8, err error) {
+	defer func() { sess.record("Resume", cause, err) }()
+	seq := c.seq.next()
+	imsiIE, err := newIMSI(sess.IMSI)
+	if err != nil {
+		return 0, err
+	}
+	req := gtpv2msg.NewResumeNotification(sess.RemoteCTEID, seq,
+		imsiIE,
+		gtpv2ie.NewEPSBearerID(sess.EBI), // LBI
+	)
+
+	c.msgLogf("tx ResumeNotification seq=%d teid=0x%08x imsi=%s lbi=%d", seq, sess.RemoteCTEID, sess.IMSI, sess.EBI)
+	m, rtt, err := c.transactTo(req, c.peerOf(sess))
+	if err != nil {
+		return 0, err
+	}
+	var causeIE *gtpv2ie.IE
+	if resp, ok := m.(*gtpv2msg.ResumeAcknowledge); ok {
+		causeIE = resp.Cause
+	}
+	cause, err = c.checkResponse(req, m, gtpv2msg.MsgTypeResumeAcknowledge, causeIE)
+	if err != nil {
+		return cause, err
+	}
+	c.msgLogf("ResumeNotification succeeded seq=%d rtt=%s cause=%d", seq, rtt, cause)
+	return cause, nil
+}
+
+// String renders sess for logs.
+func (s *Session) String() string {
+	return fmt.Sprintf("imsi=%s apn=%s ebi=%d local-c=0x%08x remote-c=0x%08x paa=%s charging-id=%d", s.IMSI, s.APN, s.EBI, s.LocalCTEID, s.RemoteCTEID, s.PAA, s.ChargingID)
+}
@@ -0,0 +1,198 @@
+package sim
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	gtp "github.com/wmnsk/go-gtp"
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// selfTest starts NewSelfTest on loopback with free ports and no Echo.
+func selfTest(t *testing.T, cfg Config) (sgw, pgw *Client) {
+	t.Helper()
+	cfg.Local, cfg.EchoEvery = "127.0.0.1:0", 0
+	sgw, pgw, err := NewSelfTest(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sgw.Close()
+		pgw.Close()
+	})
+	return sgw, pgw
+}
+
+// wireLog collects the GTPv2 messages a transport sends.
+type wireLog struct {
+	mu   sync.Mutex
+	msgs []gtpv2msg.Message
+}
+
+func (w *wireLog) tap(b []byte) {
+	m, err := gtp.Parse(b)
+	if err != nil {
+		return
+	}
+	w.mu.Lock()
+	if v2, ok := m.(gtpv2msg.Message); ok {
+		w.msgs = append(w.msgs, v2)
+	}
+	w.mu.Unlock()
+}
+
+func (w *wireLog) all() []gtpv2msg.Message {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return slices.Clone(w.msgs)
+}
+
+// TestSessionIMSIs creates, modifies and deletes n sessions against the
+// selftest PGW and checks each keeps its own IMSI, in the store and on the
+// wire: the CSR carries it, the MBR and DSR go to the PGW TEID its CSRsp
+// assigned.
+func TestSessionIMSIs(t *testing.T) {
+	const n = 8
+	cfg := DefaultConfig()
+	cfg.Sessions = n
+	sgw, _ := selfTest(t, cfg)
+	var wire wireLog
+	sgw.tr.tap = wire.tap
+
+	sessions, err := sgw.CreateSessions()
+	if err != nil || len(sessions) != n {
+		t.Fatalf("CreateSessions: %d of %d sessions, %v", len(sessions), n, err)
+	}
+	imsis := make(map[string]*Session, n) // by IMSI
+	byTEID := make(map[uint32]string, n)  // IMSI by the PGW's control TEID
+	for _, sess := range sessions {
+		if err := validateIMSI(sess.IMSI); err != nil {
+			t.Errorf("session imsi: %v", err)
+		}
+		if imsis[sess.IMSI] != nil {
+			t.Fatalf("imsi %s used by two sessions", sess.IMSI)
+		}
+		imsis[sess.IMSI] = sess
+		byTEID[sess.RemoteCTEID] = sess.IMSI
+	}
+	if got := len(sgw.Sessions()); got != n {
+		t.Fatalf("store holds %d sessions, want %d", got, n)
+	}
+
+	for _, sess := range sessions {
+		imsi := sess.IMSI
+		if err := sgw.ModifyBearer(sess); err != nil {
+			t.Errorf("ModifyBearer imsi=%s: %v", imsi, err)
+		}
+		if stored := sgw.sessions.get(sess.LocalCTEID); stored != sess || stored.IMSI != imsi {
+			t.Errorf("after MBR the store has %+v for imsi %s", stored, imsi)
+		}
+		if err := sgw.DeleteSession(sess); err != nil {
+			t.Errorf("DeleteSession imsi=%s: %v", imsi, err)
+		}
+		if sess.IMSI != imsi {
+			t.Errorf("session imsi changed from %s to %s", imsi, sess.IMSI)
+		}
+	}
+	if got := len(sgw.Sessions()); got != 0 {
+		t.Errorf("store holds %d sessions after deleting them all", got)
+	}
+
+	count := make(map[string]map[uint8]int) // requests per IMSI and type
+	for _, m := range wire.all() {
+		var imsi string
+		switch req := m.(type) {
+		case *gtpv2msg.CreateSessionRequest:
+			if req.IMSI == nil {
+				t.Errorf("CSR seq=%d without IMSI", req.Sequence())
+				continue
+			}
+			imsi, _ = req.IMSI.IMSI()
+			if imsis[imsi] == nil {
+				t.Errorf("CSR seq=%d carries imsi %s of no session", req.Sequence(), imsi)
+				continue
+			}
+		case *gtpv2msg.ModifyBearerRequest, *gtpv2msg.DeleteSessionRequest:
+			var ok bool
+			if imsi, ok = byTEID[m.TEID()]; !ok {
+				t.Errorf("%s seq=%d to TEID 0x%08x of no session", msgName(m.MessageType()), m.Sequence(), m.TEID())
+				continue
+			}
+		default:
+			continue
+		}
+		if count[imsi] == nil {
+			count[imsi] = make(map[uint8]int)
+		}
+		count[imsi][m.MessageType()]++
+	}
+	for imsi := range imsis {
+		for _, typ := range []uint8{gtpv2msg.MsgTypeCreateSessionRequest, gtpv2msg.MsgTypeModifyBearerRequest, gtpv2msg.MsgTypeDeleteSessionRequest} {
+			if got := count[imsi][typ]; got != 1 {
+				t.Errorf("imsi %s: %d %s on the wire, want 1", imsi, got, msgName(typ))
+			}
+		}
+	}
+}
+
+// TestMNCLen checks the PLMN taken off the IMSI for the User CSG
+// Information and the Change Notification ULI honours MNCLen: IMSI
+// 310150123456789 is 310-15 with 2 MNC digits and 310-150 with 3.
+func TestMNCLen(t *testing.T) {
+	for _, mncLen := range []int{2, 3} {
+		cfg := DefaultConfig()
+		cfg.IMSI, cfg.MNCLen, cfg.CSGID = "310150123456789", mncLen, 0xabc
+		cfg.Timeout = 100 * time.Millisecond // the selftest PGW leaves ChangeNotificationReqs unanswered
+		sgw, _ := selfTest(t, cfg)
+		var wire wireLog
+		sgw.tr.tap = wire.tap
+
+		sess, err := sgw.CreateSession()
+		if err != nil {
+			t.Fatalf("mnc-len %d: CreateSession: %v", mncLen, err)
+		}
+		sgw.ChangeNotification(sess)
+
+		want := "310-" + "150"[:mncLen]
+		var csr, cn bool
+		for _, m := range wire.all() {
+			switch req := m.(type) {
+			case *gtpv2msg.CreateSessionRequest:
+				f, err := req.UCI.UserCSGInformation()
+				if err != nil {
+					t.Fatalf("mnc-len %d: CSR User CSG Information: %v", mncLen, err)
+				}
+				if got := f.MCC + "-" + f.MNC; got != want {
+					t.Errorf("mnc-len %d: CSR User CSG Information PLMN %s, want %s", mncLen, got, want)
+				}
+				csr = true
+			case *gtpv2msg.ChangeNotificationRequest:
+				f, err := req.ULI.UserLocationInformation()
+				if err != nil {
+					t.Fatalf("mnc-len %d: ChangeNotificationReq ULI: %v", mncLen, err)
+				}
+				if got := f.TAI.MCC + "-" + f.TAI.MNC; got != want {
+					t.Errorf("mnc-len %d: ULI TAI PLMN %s, want %s", mncLen, got, want)
+				}
+				if got := f.ECGI.MCC + "-" + f.ECGI.MNC; got != want {
+					t.Errorf("mnc-len %d: ULI ECGI PLMN %s, want %s", mncLen, got, want)
+				}
+				cn = true
+			}
+		}
+		if !csr || !cn {
+			t.Errorf("mnc-len %d: saw CSR %t, ChangeNotificationReq %t; want both", mncLen, csr, cn)
+		}
+	}
+
+	cfg := DefaultConfig()
+	cfg.Remote = "127.0.0.1:2123"
+	for _, n := range []int{0, 1, 4} {
+		cfg.MNCLen = n
+		if err := cfg.validate(); err == nil {
+			t.Errorf("validate accepted MNC length %d", n)
+		}
+	}
+}
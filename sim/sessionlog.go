@@ -0,0 +1,77 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// stepOutcome renders a procedure's result for the session log: its cause
+// value when the peer answered (accepting or rejecting), else the kind of
+// failure, e.g. "timeout".
+func stepOutcome(cause uint8, err error) string {
+	var te *TxnError
+	switch {
+	case err == nil, errors.As(err, &te) && te.Kind == TxnRejected:
+		return fmt.Sprintf("%d", cause)
+	case te != nil:
+		return te.Kind.String()
+	}
+	return "error"
+}
+
+// record notes the outcome of procedure step on s, e.g. "MBR=16", for its
+// session log line.
+func (s *Session) record(step string, cause uint8, err error) {
+	out := step + "=" + stepOutcome(cause, err)
+	s.mu.Lock()
+	s.steps = append(s.steps, out)
+	if err != nil {
+		s.failed = true
+		s.errs = append(s.errs, step+": "+err.Error())
+	}
+	s.mu.Unlock()
+	if s.db != nil {
+		s.db.step(s, step, stepOutcome(cause, err))
+	}
+}
+
+// endSession closes sess's lifecycle: its flow record, its -db row,
+// its -junit test case and, with Config.SessionLog, its session line.
+func (c *Client) endSession(sess *Session, endCause string) {
+	c.endFlow(sess, endCause)
+	c.junitSession(sess, endCause)
+	if sess.db != nil {
+		sess.db.ended(sess, endCause)
+	}
+	c.logSession(sess, endCause)
+}
+
+// logSession writes the one structured line -session-log gives each
+// session once it ends: identity, result, UE address, lifetime, the
+// procedures it went through with their causes, and how it ended.
+func (c *Client) logSession(sess *Session, endCause string) {
+	if !c.cfg.SessionLog {
+		return
+	}
+	sess.mu.Lock()
+	steps, result := strings.Join(sess.steps, ","), "ok"
+	if sess.failed {
+		result = "failed"
+	}
+	sess.mu.Unlock()
+	log.Printf("session imsi=%s apn=%s result=%s ip=%s charging-id=%d duration=%s steps=%s end=%q",
+		sess.IMSI, sess.APN, result, sess.PAA, sess.ChargingID, time.Since(sess.Start).Round(time.Millisecond), steps, endCause)
+}
+
+// logSessionFailed is the session line of a CreateSession that never
+// became a session.
+func (c *Client) logSessionFailed(cfg Config, cause uint8, err error, took time.Duration) {
+	if !c.cfg.SessionLog {
+		return
+	}
+	log.Printf("session imsi=%s apn=%s result=failed ip=- charging-id=- duration=%s steps=CSR=%s end=%q",
+		cfg.IMSI, cfg.APN, took.Round(time.Millisecond), stepOutcome(cause, err), err.Error())
+}
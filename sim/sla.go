@@ -0,0 +1,116 @@
+package sim
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// slaSlowest is how many of the slowest CSRsps the SLA report names.
+const slaSlowest = 5
+
+// slaSample is one answered CreateSession and how long its CSRsp took.
+type slaSample struct {
+	seq  uint32
+	imsi string
+	rtt  time.Duration
+}
+
+func (s slaSample) String() string {
+	return fmt.Sprintf("seq=%d imsi=%s %s", s.seq, s.imsi, s.rtt.Round(time.Microsecond))
+}
+
+// slaTracker checks CSRsp RTTs against FailureLimits.SLA: it counts the
+// answered CreateSessions, those over the SLA, and keeps the slowest.
+type slaTracker struct {
+	mu                   sync.Mutex
+	answered, violations int
+	slowest              []slaSample // slowest first
+}
+
+// observe records s and reports whether it broke the sla.
+func (t *slaTracker) observe(s slaSample, sla time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.answered++
+	k, _ := slices.BinarySearchFunc(t.slowest, s, func(a, b slaSample) int { return int(b.rtt - a.rtt) })
+	if k < slaSlowest {
+		t.slowest = slices.Insert(t.slowest, k, s)
+		t.slowest = t.slowest[:min(len(t.slowest), slaSlowest)]
+	}
+	if s.rtt <= sla {
+		return false
+	}
+	t.violations++
+	return true
+}
+
+func (t *slaTracker) slowestString() string {
+	parts := make([]string, len(t.slowest))
+	for k, s := range t.slowest {
+		parts[k] = s.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// checkSLA records the CSRsp to CSR seq, received rtt after it was sent,
+// unless Config.Warmup leaves it out.
+func (c *Client) checkSLA(seq uint32, imsi string, rtt time.Duration) {
+	sla := c.cfg.Failures.SLA
+	if sla <= 0 || c.warming(time.Now().Add(-rtt)) {
+		return
+	}
+	if c.sla.observe(slaSample{seq: seq, imsi: imsi, rtt: rtt}, sla) {
+		log.Printf("WARNING: CSRsp seq=%d imsi=%s took %s, over the %s SLA", seq, imsi, rtt.Round(time.Microsecond), sla)
+	}
+}
+
+// SLAError reports a run whose CSRsps broke the -sla-ms latency bound more
+// often than tolerated.
+type SLAError struct {
+	Answered, Violations int
+	SLA                  time.Duration
+	MaxRate              float64
+	Slowest              string // slowest CSRsps, slowest first
+}
+
+func (e *SLAError) Error() string {
+	return fmt.Sprintf("%d of %d CSRsps (%.1f%%) took longer than the %s SLA (tolerated %g%%); slowest: %s",
+		e.Violations, e.Answered, 100*float64(e.Violations)/float64(e.Answered), e.SLA, 100*e.MaxRate, e.Slowest)
+}
+
+// CheckSLA returns an *SLAError if more than FailureLimits.MaxSLARate of
+// the run's answered CreateSessions took longer than FailureLimits.SLA.
+func (c *Client) CheckSLA() error {
+	lim := c.cfg.Failures
+	if lim.SLA <= 0 {
+		return nil
+	}
+	t := &c.sla
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.violations == 0 || float64(t.violations)/float64(t.answered) <= lim.MaxSLARate {
+		return nil
+	}
+	return &SLAError{Answered: t.answered, Violations: t.violations, SLA: lim.SLA, MaxRate: lim.MaxSLARate, Slowest: t.slowestString()}
+}
+
+// slaReport is the run report line on the SLA.
+func (c *Client) slaReport() {
+	sla := c.cfg.Failures.SLA
+	if sla <= 0 {
+		return
+	}
+	t := &c.sla
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.answered == 0 {
+		log.Printf("run report: SLA %s: no CSRsps", sla)
+		return
+	}
+	log.Printf("run report: SLA %s: %d of %d CSRsps over (%.1f%%); slowest: %s",
+		sla, t.violations, t.answered, 100*float64(t.violations)/float64(t.answered), t.slowestString())
+}
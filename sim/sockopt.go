@@ -0,0 +1,80 @@
+package sim
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// setDSCP marks outgoing packets on conn with the given DSCP (0..63), via
+// IP_TOS for IPv4 transport and IPV6_TCLASS for IPv6.
+func setDSCP(conn *net.UDPConn, dscp int, v6 bool) error {
+	if dscp < 0 || dscp > 63 {
+		return fmt.Errorf("dscp %d out of range 0..63", dscp)
+	}
+	tos := dscp << 2
+	if v6 {
+		return ipv6.NewConn(conn).SetTrafficClass(tos)
+	}
+	return ipv4.NewConn(conn).SetTOS(tos)
+}
+
+// ParseDSCPMap parses a -dscp-map value: comma-separated TYPE=DSCP pairs,
+// TYPE a message type as for ParseMsgTypes (e.g. EchoReq or 1) and DSCP
+// 0..63, e.g. "EchoReq=8,EchoResp=8,CSR=46".
+func ParseDSCPMap(s string) (map[uint8]int, error) {
+	out := make(map[uint8]int)
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		name, v, ok := strings.Cut(f, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q: want TYPE=DSCP", f)
+		}
+		types, err := ParseMsgTypes(name)
+		if err != nil {
+			return nil, err
+		}
+		dscp, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || dscp < 0 || dscp > 63 {
+			return nil, fmt.Errorf("%q: dscp must be 0..63", f)
+		}
+		for t := range types {
+			out[t] = dscp
+		}
+	}
+	return out, nil
+}
+
+// setBuffers requests rcv/snd bytes of socket receive/send buffer (0 leaves
+// one alone) and logs what the kernel actually granted: Linux caps requests
+// at net.core.rmem_max/wmem_max and reports twice the usable size.
+func setBuffers(conn *net.UDPConn, rcv, snd int) error {
+	if rcv > 0 {
+		if err := conn.SetReadBuffer(rcv); err != nil {
+			return fmt.Errorf("rcvbuf %d: %w", rcv, err)
+		}
+	}
+	if snd > 0 {
+		if err := conn.SetWriteBuffer(snd); err != nil {
+			return fmt.Errorf("sndbuf %d: %w", snd, err)
+		}
+	}
+	grcv, gsnd, err := socketBuffers(conn)
+	if err != nil {
+		log.Printf("socket buffers: requested rcvbuf=%d sndbuf=%d; applied sizes unknown: %v", rcv, snd, err)
+		return nil
+	}
+	log.Printf("socket buffers: requested rcvbuf=%d sndbuf=%d, applied rcvbuf=%d sndbuf=%d", rcv, snd, grcv, gsnd)
+	if rcv > 0 && grcv < rcv || snd > 0 && gsnd < snd {
+		log.Printf("WARNING: socket buffers clamped by the OS (raise net.core.rmem_max / net.core.wmem_max)")
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package sim
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// sendmmsgSupported tells whether -send-batch can coalesce writes here.
+const sendmmsgSupported = true
+
+// setDontFragment sets the DF bit on outgoing packets (path MTU discovery
+// "do") so oversize GTP-C datagrams fail instead of being fragmented.
+func setDontFragment(conn *net.UDPConn, v6 bool) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		if v6 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO)
+			return
+		}
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// socketBuffers reads back SO_RCVBUF and SO_SNDBUF as the kernel set them.
+func socketBuffers(conn *net.UDPConn) (rcv, snd int, err error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		if rcv, serr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF); serr != nil {
+			return
+		}
+		snd, serr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return rcv, snd, serr
+}
+
+// socketDrops returns how many datagrams the kernel dropped for conn because
+// its receive buffer was full: the "drops" column of its /proc/net/udp{,6}
+// line, found by the socket's inode.
+func socketDrops(conn *net.UDPConn) (uint64, error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var st syscall.Stat_t
+	var serr error
+	if err := rc.Control(func(fd uintptr) { serr = syscall.Fstat(int(fd), &st) }); err != nil {
+		return 0, err
+	}
+	if serr != nil {
+		return 0, serr
+	}
+	inode := strconv.FormatUint(st.Ino, 10)
+	for _, name := range []string{"/proc/net/udp", "/proc/net/udp6"} {
+		f, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			// sl local rem st tx:rx tr:when retrnsmt uid timeout inode ref pointer drops
+			fs := strings.Fields(sc.Text())
+			if len(fs) >= 13 && fs[9] == inode {
+				f.Close()
+				return strconv.ParseUint(fs[12], 10, 64)
+			}
+		}
+		f.Close()
+	}
+	return 0, fmt.Errorf("socket inode %s not in /proc/net/udp", inode)
+}
+
+// tosControl builds the control message that marks one datagram with tos:
+// IP_TOS for IPv4 transport, IPV6_TCLASS for IPv6.
+func tosControl(tos int, v6 bool) ([]byte, error) {
+	b := make([]byte, syscall.CmsgSpace(4))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level, h.Type = syscall.IPPROTO_IP, syscall.IP_TOS
+	if v6 {
+		h.Level, h.Type = syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS
+	}
+	h.SetLen(syscall.CmsgLen(4))
+	binary.NativeEndian.PutUint32(b[syscall.CmsgLen(0):], uint32(tos))
+	return b, nil
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+
+package sim
+
+import (
+	"errors"
+	"net"
+)
+
+// sendmmsgSupported tells whether -send-batch can coalesce writes here.
+const sendmmsgSupported = false
+
+func setDontFragment(conn *net.UDPConn, v6 bool) error {
+	return errors.New("-df is only supported on linux")
+}
+
+func socketBuffers(conn *net.UDPConn) (rcv, snd int, err error) {
+	return 0, 0, errors.New("reading socket buffer sizes is only supported on linux")
+}
+
+func socketDrops(conn *net.UDPConn) (uint64, error) {
+	return 0, errors.New("socket drop counts are only available on linux")
+}
+
+func tosControl(tos int, v6 bool) ([]byte, error) {
+	return nil, errors.New("-dscp-map is only supported on linux")
+}
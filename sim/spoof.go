@@ -0,0 +1,107 @@
+package sim
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"golang.org/x/net/ipv4"
+)
+
+// spoofer sends GTP-C datagrams through a raw IPv4 socket with hand-built
+// IP and UDP headers, so they leave with a source address that isn't ours
+// (lab tests of source-based routing or anti-spoofing filters). It needs
+// root or CAP_NET_RAW. Receiving still happens on the UDP socket: answers
+// only come back if the lab routes the spoofed address to this host.
+type spoofer struct {
+	rc    *ipv4.RawConn
+	src   net.IP
+	sport int
+	tos   int
+	df    bool
+
+	typeTOS map[uint8]int // TOS by message type, overriding tos (see Config.DSCPMap)
+	id      atomic.Uint32
+}
+
+// newSpoofer opens the raw socket. Protocol 255 (IPPROTO_RAW) makes it
+// send-only, so it doesn't get a copy of every UDP packet the host receives.
+func newSpoofer(src net.IP, sport, dscp int, df bool) (*spoofer, error) {
+	pc, err := net.ListenPacket("ip4:255", "0.0.0.0")
+	if err != nil {
+		return nil, fmt.Errorf("raw socket (needs root or CAP_NET_RAW): %w", err)
+	}
+	rc, err := ipv4.NewRawConn(pc)
+	if err != nil {
+		pc.Close()
+		return nil, fmt.Errorf("raw socket: %w", err)
+	}
+	s := &spoofer{rc: rc, src: src.To4(), sport: sport, df: df}
+	if dscp >= 0 {
+		s.tos = dscp << 2
+	}
+	return s, nil
+}
+
+func (s *spoofer) Close() error { return s.rc.Close() }
+
+// writeTo sends b as the payload of one UDP datagram from src:sport to dst.
+func (s *spoofer) writeTo(b []byte, dst *net.UDPAddr) error {
+	dip := dst.IP.To4()
+	if dip == nil {
+		return fmt.Errorf("spoof-src: %s is not an IPv4 peer", dst)
+	}
+	udp := make([]byte, 8+len(b))
+	binary.BigEndian.PutUint16(udp[0:2], uint16(s.sport))
+	binary.BigEndian.PutUint16(udp[2:4], uint16(dst.Port))
+	binary.BigEndian.PutUint16(udp[4:6], uint16(len(udp)))
+	copy(udp[8:], b)
+	binary.BigEndian.PutUint16(udp[6:8], udpChecksum(s.src, dip, udp))
+
+	tos := s.tos
+	if v, ok := s.typeTOS[b[1]]; ok {
+		tos = v
+	}
+	h := &ipv4.Header{
+		Version:  ipv4.Version,
+		Len:      ipv4.HeaderLen,
+		TOS:      tos,
+		TotalLen: ipv4.HeaderLen + len(udp),
+		ID:       int(s.id.Add(1) & 0xffff),
+		TTL:      64,
+		Protocol: 17, // UDP
+		Src:      s.src,
+		Dst:      dip,
+	}
+	if s.df {
+		h.Flags = ipv4.DontFragment
+	}
+	return s.rc.WriteTo(h, udp, nil)
+}
+
+// udpChecksum computes the UDP checksum of udp (checksum field zero) over
+// the IPv4 pseudo header (RFC 768). A zero result is sent as 0xffff, since
+// zero means "no checksum".
+func udpChecksum(src, dst net.IP, udp []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(b[i])<<8 | uint32(b[i+1])
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(src)
+	add(dst)
+	sum += 17 + uint32(len(udp))
+	add(udp)
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	if c := ^uint16(sum); c != 0 {
+		return c
+	}
+	return 0xffff
+}
@@ -0,0 +1,159 @@
+package sim
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // pure-Go SQLite driver "sqlite", no cgo
+)
+
+// dbSchema is set up on every open; IF NOT EXISTS creates the tables on the
+// first run and lets later runs add to the same database.
+const dbSchema = `CREATE TABLE IF NOT EXISTS sessions (
+  run TEXT NOT NULL,
+  imsi TEXT NOT NULL,
+  apn TEXT,
+  grp TEXT,
+  local_cteid INTEGER,
+  remote_cteid INTEGER,
+  local_uteid INTEGER,
+  remote_uteid INTEGER,
+  ue_ip TEXT,
+  charging_id INTEGER,
+  csr_cause INTEGER,
+  csr_rtt_ms REAL,
+  start TEXT,
+  end TEXT,
+  duration_ms INTEGER,
+  state TEXT NOT NULL,
+  result TEXT NOT NULL,
+  steps TEXT,
+  end_cause TEXT
+);
+CREATE INDEX IF NOT EXISTS sessions_run_teid ON sessions (run, local_cteid);
+CREATE INDEX IF NOT EXISTS sessions_imsi ON sessions (imsi);
+CREATE INDEX IF NOT EXISTS sessions_charging_id ON sessions (charging_id);
+CREATE TABLE IF NOT EXISTS session_events (
+  run TEXT NOT NULL,
+  local_cteid INTEGER,
+  imsi TEXT NOT NULL,
+  at TEXT NOT NULL,
+  step TEXT NOT NULL,
+  outcome TEXT NOT NULL
+);
+`
+
+// sqlDB records each session in a SQLite database (Config.DB): a
+// sessions row when it is created, updated as procedures run on it and
+// when it ends, plus a session_events row per procedure. The driver is
+// pure Go, so the tool still builds without cgo.
+type sqlDB struct {
+	db  *sql.DB
+	run string // this run's ID: its start time
+}
+
+// openSQLDB opens the database at path, creating the file and the
+// schema if missing. WAL and a busy timeout let a reader (the sqlite3
+// shell) look at it while a run writes.
+func openSQLDB(path string) (*sqlDB, error) {
+	q := url.Values{"_pragma": {"journal_mode(WAL)", "synchronous(NORMAL)", "busy_timeout(5000)"}}
+	// An SQLite URI with path escaped, so a '?' or '#' in it stays part of
+	// the file name.
+	dsn := url.URL{Scheme: "file", Opaque: (&url.URL{Path: path}).EscapedPath(), RawQuery: q.Encode()}
+	db, err := sql.Open("sqlite", dsn.String())
+	if err != nil {
+		return nil, err
+	}
+	// One connection: SQLite has a single writer anyway, and the
+	// statements of one session stay in order.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(dbSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqlDB{db: db, run: time.Now().UTC().Format(time.RFC3339Nano)}, nil
+}
+
+func dbTime(t time.Time) string { return t.UTC().Format(time.RFC3339Nano) }
+
+// exec runs the statements in one transaction; a failure is logged, as a
+// database problem must not fail the procedure being recorded.
+func (d *sqlDB) exec(stmts ...dbStmt) {
+	err := func() error {
+		tx, err := d.db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, s := range stmts {
+			if _, err := tx.Exec(s.query, s.args...); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		return tx.Commit()
+	}()
+	if err != nil {
+		log.Printf("db: %v", err)
+	}
+}
+
+type dbStmt struct {
+	query string
+	args  []any
+}
+
+// created inserts the row of a session the CSRsp accepted, with the CSR as
+// its first event.
+func (d *sqlDB) created(sess *Session, cause uint8, rtt time.Duration) {
+	sess.mu.Lock()
+	steps, remoteU := strings.Join(sess.steps, ","), sess.RemoteUTEID
+	sess.mu.Unlock()
+	d.exec(
+		dbStmt{"INSERT INTO sessions (run, imsi, apn, grp, local_cteid, remote_cteid, local_uteid, remote_uteid, ue_ip, charging_id, csr_cause, csr_rtt_ms, start, state, result, steps) " +
+			"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 'active', 'ok', ?)",
+			[]any{d.run, sess.IMSI, sess.APN, sess.Group, sess.LocalCTEID, sess.RemoteCTEID, sess.LocalUTEID, remoteU,
+				sess.PAA, sess.ChargingID, cause, float64(rtt) / float64(time.Millisecond), dbTime(sess.Start), steps}},
+		dbStmt{"INSERT INTO session_events VALUES (?, ?, ?, ?, 'CSR', ?)",
+			[]any{d.run, sess.LocalCTEID, sess.IMSI, dbTime(sess.Start), fmt.Sprint(cause)}},
+	)
+}
+
+// step records a procedure's outcome on sess (see Session.record) and
+// brings its row up to date.
+func (d *sqlDB) step(sess *Session, step, outcome string) {
+	sess.mu.Lock()
+	steps, failed, remoteU := strings.Join(sess.steps, ","), sess.failed, sess.RemoteUTEID
+	sess.mu.Unlock()
+	result := "ok"
+	if failed {
+		result = "failed"
+	}
+	d.exec(
+		dbStmt{"INSERT INTO session_events VALUES (?, ?, ?, ?, ?, ?)",
+			[]any{d.run, sess.LocalCTEID, sess.IMSI, dbTime(time.Now()), step, outcome}},
+		dbStmt{"UPDATE sessions SET remote_uteid = ?, result = ?, steps = ? WHERE run = ? AND local_cteid = ?",
+			[]any{remoteU, result, steps, d.run, sess.LocalCTEID}},
+	)
+}
+
+// ended closes sess's row, e.g. endCause "cause=16" or "run-end".
+func (d *sqlDB) ended(sess *Session, endCause string) {
+	end := time.Now()
+	d.exec(dbStmt{"UPDATE sessions SET state = 'ended', end = ?, duration_ms = ?, end_cause = ? WHERE run = ? AND local_cteid = ?",
+		[]any{dbTime(end), end.Sub(sess.Start).Milliseconds(), endCause, d.run, sess.LocalCTEID}})
+}
+
+// failed inserts the row of a CreateSession that never became a session.
+func (d *sqlDB) failed(cfg Config, cause uint8, err error, took time.Duration) {
+	end := time.Now()
+	d.exec(dbStmt{"INSERT INTO sessions (run, imsi, apn, csr_cause, start, end, duration_ms, state, result, steps, end_cause) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, 'failed', 'failed', ?, ?)",
+		[]any{d.run, cfg.IMSI, cfg.APN, cause, dbTime(end.Add(-took)), dbTime(end), took.Milliseconds(),
+			"CSR=" + stepOutcome(cause, err), err.Error()}})
+}
+
+func (d *sqlDB) Close() error { return d.db.Close() }
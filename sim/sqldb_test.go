@@ -0,0 +1,60 @@
+package sim
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestSQLDB runs two selftests into one -db database: the first creates
+// the file and schema, the second adds its own run's rows.
+func TestSQLDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "runs.db")
+	for range 2 {
+		cfg := DefaultConfig()
+		cfg.Sessions, cfg.DB = 2, path
+		sgw, _ := selfTest(t, cfg)
+		sessions, err := sgw.CreateSessions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, sess := range sessions {
+			if err := sgw.DeleteSession(sess); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var runs, rows, ended, events int
+	if err := db.QueryRow("SELECT count(DISTINCT run), count(*), count(*) FILTER (WHERE state = 'ended' AND end_cause = 'cause=16') FROM sessions").Scan(&runs, &rows, &ended); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("SELECT count(*) FROM session_events").Scan(&events); err != nil {
+		t.Fatal(err)
+	}
+	if runs != 2 || rows != 4 || ended != 4 || events != 8 {
+		t.Errorf("%d runs, %d sessions (%d ended by DSR), %d events; want 2, 4 (4), 8", runs, rows, ended, events)
+	}
+}
+
+// TestSQLDBPath checks a '?', '#' or '%' in the -db path is part of the file
+// name, not the start of the DSN's query or fragment.
+func TestSQLDBPath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "runs?mode=ro#1 %41.db")
+	d, err := openSQLDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+	names, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("open %q: %v; the directory holds %q", path, err, names)
+	}
+}
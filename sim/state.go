@@ -0,0 +1,236 @@
+package sim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// stateSaveEvery is how often the session store goes to Config.StateFile
+// besides on shutdown.
+const stateSaveEvery = 5 * time.Second
+
+// stateVersion is the format of the state file; a file of another version
+// is refused rather than half-understood.
+const stateVersion = 1
+
+// stateDoc is the state file: the sessions this SGW holds with peer, so
+// that a later run, e.g. one standing in for a failed SGW, can pick them up.
+type stateDoc struct {
+	Version  int            `json:"version"`
+	Saved    time.Time      `json:"saved"`
+	Peer     string         `json:"peer"`
+	Recovery *uint8         `json:"peer_recovery,omitempty"` // the peer's restart counter when saved
+	Sessions []savedSession `json:"sessions"`
+}
+
+type savedSession struct {
+	Ref         string        `json:"ref,omitempty"` // scenario reference
+	IMSI        string        `json:"imsi"`
+	APN         string        `json:"apn"`
+	Group       string        `json:"group,omitempty"`
+	EBI         uint8         `json:"ebi"`
+	LocalCTEID  uint32        `json:"local_cteid"`
+	RemoteCTEID uint32        `json:"remote_cteid"`
+	LocalUTEID  uint32        `json:"local_uteid"`
+	RemoteUTEID uint32        `json:"remote_uteid"`
+	RemoteUIP   string        `json:"remote_uip,omitempty"`
+	PAA         string        `json:"ue_ip,omitempty"`
+	ChargingID  uint32        `json:"charging_id,omitempty"`
+	CSIDs       []uint16      `json:"csids,omitempty"`
+	Start       time.Time     `json:"start"`
+	State       string        `json:"state"` // "ok", or "failed" once a procedure on it failed
+	Steps       []string      `json:"steps,omitempty"`
+	Bearers     []savedBearer `json:"bearers"`
+}
+
+type savedBearer struct {
+	EBI         uint8  `json:"ebi"`
+	LocalUTEID  uint32 `json:"local_uteid"`
+	RemoteUTEID uint32 `json:"remote_uteid"`
+	RemoteUIP   string `json:"remote_uip,omitempty"`
+	Flags       uint8  `json:"flags,omitempty"`
+	ChargingID  uint32 `json:"charging_id,omitempty"`
+}
+
+// stateFile writes the session store to Config.StateFile; mu keeps the
+// periodic and the final save from interleaving.
+type stateFile struct {
+	mu   sync.Mutex
+	path string
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+func saveSession(sess *Session) savedSession {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	s := savedSession{
+		Ref: sess.Ref, IMSI: sess.IMSI, APN: sess.APN, Group: sess.Group, EBI: sess.EBI,
+		LocalCTEID: sess.LocalCTEID, RemoteCTEID: sess.RemoteCTEID,
+		LocalUTEID: sess.LocalUTEID, RemoteUTEID: sess.RemoteUTEID, RemoteUIP: ipString(sess.RemoteUIP),
+		PAA: sess.PAA, ChargingID: sess.ChargingID, CSIDs: sess.CSIDs, Start: sess.Start,
+		State: "ok", Steps: sess.steps,
+	}
+	if sess.failed {
+		s.State = "failed"
+	}
+	for _, ebi := range slices.Sorted(maps.Keys(sess.Bearers)) {
+		b := sess.Bearers[ebi]
+		s.Bearers = append(s.Bearers, savedBearer{
+			EBI: b.EBI, LocalUTEID: b.LocalUTEID, RemoteUTEID: b.RemoteUTEID,
+			RemoteUIP: ipString(b.RemoteUIP), Flags: b.Flags, ChargingID: b.ChargingID,
+		})
+	}
+	return s
+}
+
+// saveState writes the live sessions to the state file, atomically as
+// WriteMetricsFile does, so a run killed mid-write leaves the previous one.
+func (c *Client) saveState() error {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	doc := stateDoc{Version: stateVersion, Saved: time.Now(), Peer: c.tr.remote().String(), Sessions: []savedSession{}}
+	if st := c.paths.get(c.tr.remote()).state(); st.Recovery != nil {
+		doc.Recovery = st.Recovery
+	}
+	for _, sess := range c.sessions.all() {
+		doc.Sessions = append(doc.Sessions, saveSession(sess))
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := c.state.path
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // no-op once renamed
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// restoreSession checks a saved session and rebuilds it; an entry that
+// could not be addressed or is inconsistent is refused.
+func restoreSession(s savedSession) (*Session, error) {
+	switch {
+	case len(s.IMSI) < 6 || len(s.IMSI) > 15 || !allDigits(s.IMSI):
+		return nil, fmt.Errorf("imsi %q is not 6..15 digits", s.IMSI)
+	case s.EBI < 5 || s.EBI > 15:
+		return nil, fmt.Errorf("ebi %d is not 5..15", s.EBI)
+	case s.LocalCTEID == 0:
+		return nil, errors.New("no local control TEID")
+	}
+	parseIP := func(v string) (net.IP, error) {
+		if v == "" {
+			return nil, nil
+		}
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, fmt.Errorf("bad address %q", v)
+		}
+		return ip, nil
+	}
+	sess := &Session{
+		Ref: s.Ref, IMSI: s.IMSI, APN: s.APN, Group: s.Group, EBI: s.EBI,
+		LocalCTEID: s.LocalCTEID, RemoteCTEID: s.RemoteCTEID,
+		LocalUTEID: s.LocalUTEID, RemoteUTEID: s.RemoteUTEID,
+		PAA: s.PAA, ChargingID: s.ChargingID, CSIDs: s.CSIDs, Start: s.Start,
+		Bearers: make(map[uint8]*Bearer),
+		steps:   s.Steps, failed: s.State == "failed",
+	}
+	var err error
+	if sess.RemoteUIP, err = parseIP(s.RemoteUIP); err != nil {
+		return nil, err
+	}
+	for _, sb := range s.Bearers {
+		if _, dup := sess.Bearers[sb.EBI]; dup || sb.EBI < 5 || sb.EBI > 15 {
+			return nil, fmt.Errorf("bearer ebi %d is duplicate or not 5..15", sb.EBI)
+		}
+		b := &Bearer{EBI: sb.EBI, LocalUTEID: sb.LocalUTEID, RemoteUTEID: sb.RemoteUTEID, Flags: sb.Flags, ChargingID: sb.ChargingID}
+		if b.RemoteUIP, err = parseIP(sb.RemoteUIP); err != nil {
+			return nil, err
+		}
+		sess.Bearers[b.EBI] = b
+	}
+	if sess.Bearers[sess.EBI] == nil {
+		// The default bearer is the session's own user-plane fields.
+		sess.Bearers[sess.EBI] = &Bearer{EBI: sess.EBI, LocalUTEID: sess.LocalUTEID, RemoteUTEID: sess.RemoteUTEID, RemoteUIP: sess.RemoteUIP, ChargingID: sess.ChargingID}
+	}
+	return sess, nil
+}
+
+// loadState restores the sessions of the state file into the store, for
+// follow-up procedures on sessions an earlier run created. A missing file
+// restores nothing; one that doesn't parse is an error, so it is not
+// overwritten by the next save. Entries that don't check out or repeat a
+// TEID are skipped with a log line each; a file for another peer restores
+// nothing and is not saved over. The peer's saved restart counter seeds
+// its path, so a peer that restarted since is reported as such once it
+// next sends its Recovery.
+func (c *Client) loadState() ([]*Session, error) {
+	b, err := os.ReadFile(c.state.path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("state-file: %s does not exist yet; no sessions restored", c.state.path)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc stateDoc
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.state.path, err)
+	}
+	if doc.Version != stateVersion {
+		return nil, fmt.Errorf("%s: version %d, want %d", c.state.path, doc.Version, stateVersion)
+	}
+	if peer := c.tr.remote().String(); doc.Peer != peer {
+		log.Printf("WARNING: state-file: the %d saved session(s) are with %s, not %s; none restored and %s left as is",
+			len(doc.Sessions), doc.Peer, peer, c.state.path)
+		c.state = nil // saving would lose them
+		return nil, nil
+	}
+	if doc.Recovery != nil {
+		c.paths.get(c.tr.remote()).restoreRecovery(*doc.Recovery)
+	}
+	var out []*Session
+	for k, s := range doc.Sessions {
+		sess, err := restoreSession(s)
+		if err == nil && c.sessions.get(sess.LocalCTEID) != nil {
+			err = fmt.Errorf("local control TEID 0x%08x already in use", sess.LocalCTEID)
+		}
+		if err != nil {
+			log.Printf("state-file: skipping session %d (imsi=%s): %v", k+1, s.IMSI, err)
+			continue
+		}
+		c.sessions.add(sess)
+		out = append(out, sess)
+	}
+	log.Printf("state-file: restored %d of %d session(s) with %s saved %s ago",
+		len(out), len(doc.Sessions), doc.Peer, time.Since(doc.Saved).Round(time.Second))
+	return out, nil
+}
+
+// RestoredSessions returns the sessions loaded from Config.StateFile when
+// the client started, in file order.
+func (c *Client) RestoredSessions() []*Session { return c.restored }
@@ -0,0 +1,203 @@
+package sim
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latWindow is how many recent RTT samples the rolling p95 is computed over.
+const latWindow = 1024
+
+// stats collects per-interval message counts, in-flight requests and a
+// rolling RTT window for the periodic -stats-every line, and the run totals
+// behind the metrics.
+type stats struct {
+	mu      sync.Mutex
+	tx, rx  map[uint8]uint64     // by message type, since last tick
+	pending map[uint32]time.Time // in-flight requests by sequence
+	lat     []time.Duration      // ring of recent RTTs
+	latPos  int
+	errs    map[TxnErrorKind]uint64 // failed transactions, whole run
+	causes  map[uint8]uint64        // rejections by cause value, whole run
+
+	txTotal, rxTotal map[uint8]uint64 // by message type, whole run
+	rttSum           time.Duration
+	rttCount         uint64
+
+	// Requests begun before warmEnd are left out of the RTTs (see
+	// Config.Warmup); warm counts them.
+	warmEnd time.Time
+	warm    uint64
+}
+
+func newStats() *stats {
+	return &stats{
+		tx:      make(map[uint8]uint64),
+		rx:      make(map[uint8]uint64),
+		pending: make(map[uint32]time.Time),
+		errs:    make(map[TxnErrorKind]uint64),
+		causes:  make(map[uint8]uint64),
+		txTotal: make(map[uint8]uint64),
+		rxTotal: make(map[uint8]uint64),
+	}
+}
+
+func (s *stats) countTx(msgType uint8) {
+	s.mu.Lock()
+	s.tx[msgType]++
+	s.txTotal[msgType]++
+	s.mu.Unlock()
+}
+
+func (s *stats) countRx(msgType uint8) {
+	s.mu.Lock()
+	s.rx[msgType]++
+	s.rxTotal[msgType]++
+	s.mu.Unlock()
+}
+
+func (s *stats) countErr(kind TxnErrorKind) {
+	s.mu.Lock()
+	s.errs[kind]++
+	s.mu.Unlock()
+}
+
+func (s *stats) countCause(v uint8) {
+	s.mu.Lock()
+	s.causes[v]++
+	s.mu.Unlock()
+}
+
+// errSummary renders the run's failed transactions by kind, e.g.
+// "timeout=2 rejected=1", or "none".
+func (s *stats) errSummary() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var parts []string
+	for k := TxnTimeout; k <= TxnTransport; k++ {
+		if n := s.errs[k]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", k, n))
+		}
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, " ")
+}
+
+// errCounts returns the run's failed transactions by kind, every kind
+// present, e.g. {"timeout": 2, "rejected": 1, "parse": 0, "transport": 0}.
+func (s *stats) errCounts() map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := make(map[string]uint64, int(TxnTransport))
+	for k := TxnTimeout; k <= TxnTransport; k++ {
+		m[k.String()] = s.errs[k]
+	}
+	return m
+}
+
+// begin marks the request with sequence seq as in flight.
+func (s *stats) begin(seq uint32) {
+	s.mu.Lock()
+	s.pending[seq] = time.Now()
+	s.mu.Unlock()
+}
+
+// end completes the request with sequence seq and records its RTT.
+func (s *stats) end(seq uint32) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t0, ok := s.pending[seq]
+	if !ok {
+		return 0, false
+	}
+	delete(s.pending, seq)
+	rtt := time.Since(t0)
+	if t0.Before(s.warmEnd) {
+		s.warm++
+		return rtt, true
+	}
+	s.rttSum += rtt
+	s.rttCount++
+	if len(s.lat) < latWindow {
+		s.lat = append(s.lat, rtt)
+	} else {
+		s.lat[s.latPos] = rtt
+		s.latPos = (s.latPos + 1) % latWindow
+	}
+	return rtt, true
+}
+
+func (s *stats) setWarmup(end time.Time) {
+	s.mu.Lock()
+	s.warmEnd = end
+	s.mu.Unlock()
+}
+
+func (s *stats) warming(t time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return t.Before(s.warmEnd)
+}
+
+func (s *stats) warmRTTs() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.warm
+}
+
+// abandon drops the request with sequence seq without recording an RTT.
+func (s *stats) abandon(seq uint32) {
+	s.mu.Lock()
+	delete(s.pending, seq)
+	s.mu.Unlock()
+}
+
+// tick renders the stats line for the elapsed interval and resets the
+// per-interval counters; inflight is the registry's outstanding count. The
+// RTT window carries over.
+func (s *stats) tick(inflight int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	line := fmt.Sprintf("stats: tx[%s] rx[%s] inflight=%d p95=%s",
+		formatCounts(s.tx), formatCounts(s.rx), inflight, percentile(s.lat, 0.95))
+	clear(s.tx)
+	clear(s.rx)
+	return line
+}
+
+func formatCounts(m map[uint8]uint64) string {
+	types := make([]int, 0, len(m))
+	for t := range m {
+		types = append(types, int(t))
+	}
+	sort.Ints(types)
+	parts := make([]string, 0, len(types))
+	for _, t := range types {
+		parts = append(parts, fmt.Sprintf("%s=%d", msgName(uint8(t)), m[uint8(t)]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// percentile returns the p-th percentile of samples (nearest rank), or 0.
+func percentile(samples []time.Duration, p float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(p*float64(len(sorted)) + 0.5)
+	if idx < 1 {
+		idx = 1
+	}
+	if idx > len(sorted) {
+		idx = len(sorted)
+	}
+	return sorted[idx-1].Round(time.Microsecond)
+}
@@ -0,0 +1,101 @@
+package sim
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// pathHealthWindow is how many echo intervals may pass without any response
+// from the peer before the GTP-C path counts as down.
+const pathHealthWindow = 3
+
+// health remembers what /healthz and /status report: when the peer last
+// answered and the last failed transaction.
+type health struct {
+	mu        sync.Mutex
+	lastResp  time.Time
+	lastErr   string
+	lastErrAt time.Time
+}
+
+func (h *health) responded() {
+	h.mu.Lock()
+	h.lastResp = time.Now()
+	h.mu.Unlock()
+}
+
+func (h *health) failed(err error) {
+	h.mu.Lock()
+	h.lastErr, h.lastErrAt = err.Error(), time.Now()
+	h.mu.Unlock()
+}
+
+// Status is the /status document.
+type Status struct {
+	PathUp       bool       `json:"path_up"`
+	LastResponse *time.Time `json:"last_response,omitempty"`
+	Sessions     int        `json:"sessions"`
+	Inflight     int        `json:"inflight"`
+	LastError    string     `json:"last_error,omitempty"`
+	LastErrorAt  *time.Time `json:"last_error_at,omitempty"`
+
+	Failures map[string]uint64 `json:"failures"` // failed transactions by TxnError kind, whole run
+
+	Peers []PeerState `json:"peers,omitempty"` // the path to each peer, see peerPath
+}
+
+// Status reports the GTP-C path state, the live sessions, the failed
+// transactions by kind, the last failure and what each peer's path has
+// cached. The path is up when the peer
+// answered (Echo or any other request) within pathHealthWindow echo
+// intervals, or the timeout when periodic Echo is off.
+func (c *Client) Status() Status {
+	st := Status{Sessions: len(c.sessions.all()), Inflight: c.reg.inflight(), Failures: c.tr.st.errCounts()}
+	for _, pp := range c.paths.all() {
+		st.Peers = append(st.Peers, pp.state())
+	}
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+	st.LastError = c.health.lastErr
+	window := pathHealthWindow * c.cfg.EchoEvery
+	if window == 0 {
+		window = pathHealthWindow * c.cfg.Timeout
+	}
+	if t := c.health.lastResp; !t.IsZero() {
+		st.LastResponse = &t
+		st.PathUp = time.Since(t) <= window
+	}
+	if t := c.health.lastErrAt; !t.IsZero() {
+		st.LastErrorAt = &t
+	}
+	return st
+}
+
+// StatusHandler serves /healthz (200 while the path is up, else 503) and
+// /status, both as JSON, and /metrics (see WriteMetrics).
+func (c *Client) StatusHandler() http.Handler {
+	mux := http.NewServeMux()
+	writeJSON := func(w http.ResponseWriter, code int, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		st := c.Status()
+		code := http.StatusOK
+		if !st.PathUp {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, map[string]bool{"path_up": st.PathUp})
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, c.Status())
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", openMetricsType)
+		_ = c.WriteMetrics(w)
+	})
+	return mux
+}
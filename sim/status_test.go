@@ -0,0 +1,39 @@
+package sim
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestStatusFailures checks /status counts failed transactions by kind.
+func TestStatusFailures(t *testing.T) {
+	peer := newDroppingPeer(t, 1<<30)
+	cfg := DefaultConfig()
+	cfg.Local, cfg.Remote, cfg.EchoEvery = "127.0.0.1:0", peer.conn.LocalAddr().String(), 0
+	cfg.Timeout = 20 * time.Millisecond
+	c, err := NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for range 2 {
+		if _, err := c.Echo(); err == nil {
+			t.Fatal("Echo to a peer that never answers succeeded")
+		}
+	}
+
+	w := httptest.NewRecorder()
+	c.StatusHandler().ServeHTTP(w, httptest.NewRequest("GET", "/status", nil))
+	var st Status
+	if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+		t.Fatalf("/status: %v: %s", err, w.Body)
+	}
+	want := map[string]uint64{"timeout": 2, "rejected": 0, "parse": 0, "transport": 0}
+	for kind, n := range want {
+		if got, ok := st.Failures[kind]; !ok || got != n {
+			t.Errorf("/status failures[%s] = %d (present %t), want %d: %s", kind, got, ok, n, w.Body)
+		}
+	}
+}
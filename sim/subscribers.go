@@ -0,0 +1,101 @@
+package sim
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Subscriber is one row of a subscriber CSV file. Empty fields fall back to
+// the client configuration.
+type Subscriber struct {
+	IMSI    string
+	MSISDN  string
+	APN     string
+	PDNType string // ipv4|ipv6|ipv4v6
+	Group   string
+}
+
+// LoadSubscribers reads a CSV file whose header names the columns: imsi
+// (required), msisdn, apn, pdn and group, in any order. Errors name the line.
+func LoadSubscribers(path string) ([]Subscriber, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: header: %w", path, err)
+	}
+	col := make(map[string]int)
+	for k, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "imsi", "msisdn", "apn", "pdn", "group":
+			col[name] = k
+		default:
+			return nil, fmt.Errorf("%s: unknown column %q (want imsi,msisdn,apn,pdn,group)", path, name)
+		}
+	}
+	if _, ok := col["imsi"]; !ok {
+		return nil, fmt.Errorf("%s: no imsi column", path)
+	}
+
+	var out []Subscriber
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		line, _ := r.FieldPos(0)
+		field := func(name string) string {
+			if k, ok := col[name]; ok && k < len(rec) {
+				return strings.TrimSpace(rec[k])
+			}
+			return ""
+		}
+		s := Subscriber{IMSI: field("imsi"), MSISDN: field("msisdn"), APN: field("apn"), PDNType: strings.ToLower(field("pdn")), Group: field("group")}
+		if err := validateIMSI(s.IMSI); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if s.PDNType != "" {
+			if _, err := pdnTypeValue(s.PDNType); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+		}
+		out = append(out, s)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%s: no subscribers", path)
+	}
+	return out, nil
+}
+
+// apply returns cfg with the subscriber's non-empty fields set.
+func (s Subscriber) apply(cfg Config) Config {
+	cfg.IMSI = s.IMSI
+	if s.MSISDN != "" {
+		cfg.MSISDN = s.MSISDN
+	}
+	if s.APN != "" {
+		cfg.APN = s.APN
+	}
+	if s.PDNType != "" {
+		cfg.PDNType = s.PDNType
+	}
+	if s.Group != "" {
+		cfg.Group = s.Group
+	}
+	return cfg
+}
@@ -0,0 +1,91 @@
+package sim
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strings"
+
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// ParseTEIDField parses a -teid-field value: comma-separated TYPE=present
+// or TYPE=absent pairs, types as ParseMsgTypes takes them, e.g.
+// "EchoReq=present,CSR=absent". The result maps each type to whether its
+// header gets a TEID field.
+func ParseTEIDField(s string) (map[uint8]bool, error) {
+	out := make(map[uint8]bool)
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		name, v, ok := strings.Cut(f, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q: want TYPE=present or TYPE=absent", f)
+		}
+		types, err := ParseMsgTypes(name)
+		if err != nil {
+			return nil, err
+		}
+		var present bool
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "present":
+			present = true
+		case "absent":
+		default:
+			return nil, fmt.Errorf("%q: want present or absent", f)
+		}
+		for t := range types {
+			out[t] = present
+		}
+	}
+	return out, nil
+}
+
+// forceTEIDField rewrites the header of the marshaled request b to carry a
+// TEID field or not, with the T flag and the length field to match: a TEID
+// field added holds 0, one removed is dropped whatever its value. b is
+// returned as is if it already has the wanted form. The result is parsed
+// back to check the header is consistent before it goes out.
+func forceTEIDField(b []byte, present bool, seq uint32) ([]byte, error) {
+	if len(b) < 8 {
+		return nil, fmt.Errorf("teid-field: message too short (%d bytes)", len(b))
+	}
+	has := b[0]&0x08 != 0
+	if has == present {
+		return b, nil
+	}
+	var out []byte
+	if present {
+		out = make([]byte, 0, len(b)+4)
+		out = append(out, b[:4]...)
+		out = append(out, 0, 0, 0, 0)
+		out = append(out, b[4:]...)
+		out[0] |= 0x08
+	} else {
+		if len(b) < 12 {
+			return nil, fmt.Errorf("teid-field: message too short (%d bytes)", len(b))
+		}
+		out = make([]byte, 0, len(b)-4)
+		out = append(out, b[:4]...)
+		out = append(out, b[8:]...)
+		out[0] &^= 0x08
+	}
+	binary.BigEndian.PutUint16(out[2:4], uint16(len(out)-4))
+
+	h, err := gtpv2msg.ParseHeader(out)
+	if err != nil {
+		return nil, fmt.Errorf("teid-field: rewritten header does not parse: %w", err)
+	}
+	if h.HasTEID() != present || h.SequenceNumber != seq || int(h.Length) != len(out)-4 {
+		return nil, fmt.Errorf("teid-field: rewritten header inconsistent (T=%t seq=%d length=%d for %d bytes)",
+			h.HasTEID(), h.SequenceNumber, h.Length, len(out))
+	}
+	what := "removed"
+	if present {
+		what = "added"
+	}
+	log.Printf("teid-field: %s seq=%d TEID field %s, T flag %t, length %d", msgName(out[1]), seq, what, present, h.Length)
+	return out, nil
+}
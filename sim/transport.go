@@ -0,0 +1,427 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// transport wraps the GTP-C UDP socket. In connected mode the socket is
+// DialUDP'd to the single remote peer, so Write/Read skip the per-call route
+// lookup and ICMP errors (e.g. port unreachable) surface on the next call.
+// Otherwise an unconnected socket is used with WriteToUDP/ReadFromUDP.
+type transport struct {
+	conn      *net.UDPConn
+	raddr     *net.UDPAddr
+	connected bool
+	st        *stats
+
+	// With follow set, learned replaces raddr as the send target once a
+	// response arrives from a different address (peer behind NAT).
+	follow  bool
+	learned atomic.Pointer[net.UDPAddr]
+
+	// retries is how many more times a write failing with a transient error
+	// (e.g. ENOBUFS under burst load) is attempted, writeRetries the count of
+	// such retries so far.
+	retries      int
+	writeRetries atomic.Uint64
+	retransmits  atomic.Uint64 // T3 expiries that re-sent a request
+
+	batch *batcher // nil: one write per datagram
+	spoof *spoofer // nil: send from the UDP socket
+
+	// tap, if set, sees every datagram before it is sent (see
+	// Config.IntegrityCheck).
+	tap func([]byte)
+
+	// tos holds, by message type, the control message marking a datagram
+	// with that type's DSCP (see Config.DSCPMap); other types keep the
+	// socket's marking.
+	tos map[uint8][]byte
+
+	// srcs are extra unconnected sockets on the local address, one source
+	// port each; new requests take conn and srcs in turn (see pickSrc).
+	srcs    []*net.UDPConn
+	nextSrc atomic.Uint32
+
+	// routes maps each PGW of Config.Peers to the socket bound for it (see
+	// addPeer); sendFrom sends a datagram to it out of that socket, whatever
+	// src it is given.
+	routes map[string]int
+
+	// mu guards conn and srcs, which rebind replaces after a fatal socket
+	// error, and closed, which stops it once Close has run. setup applies
+	// the socket options to a rebound socket as to the originals.
+	mu      sync.RWMutex
+	closed  bool
+	setup   func(*net.UDPConn) error
+	rebinds atomic.Uint64
+
+	start   time.Time
+	txPkts  atomic.Uint64
+	rxPkts  atomic.Uint64
+	txBytes atomic.Uint64
+	rxBytes atomic.Uint64
+}
+
+func newTransport(laddr, raddr *net.UDPAddr, connected bool, fd int) (*transport, error) {
+	var (
+		conn *net.UDPConn
+		err  error
+	)
+	switch {
+	case fd >= 0:
+		conn, err = udpFromFD(fd)
+	case connected:
+		conn, err = net.DialUDP("udp", laddr, raddr)
+	default:
+		conn, err = net.ListenUDP("udp", laddr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &transport{conn: conn, raddr: raddr, connected: connected, st: newStats(), start: time.Now()}, nil
+}
+
+// udpFromFD adopts an already bound UDP socket passed in by a supervisor
+// (e.g. systemd socket activation) as file descriptor fd.
+func udpFromFD(fd int) (*net.UDPConn, error) {
+	f := os.NewFile(uintptr(fd), "gtp-c")
+	if f == nil {
+		return nil, fmt.Errorf("fd %d: invalid descriptor", fd)
+	}
+	defer f.Close() // FilePacketConn works on a dup
+	pc, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, fmt.Errorf("fd %d: not a usable UDP socket: %w", fd, err)
+	}
+	conn, ok := pc.(*net.UDPConn)
+	if !ok {
+		pc.Close()
+		return nil, fmt.Errorf("fd %d: not a UDP socket (%T)", fd, pc)
+	}
+	return conn, nil
+}
+
+// egressIP returns the source address packets to raddr will carry: the bound
+// address if laddr names one, else whatever the routing table picks. A UDP
+// "dial" only does the route lookup; nothing is sent.
+func egressIP(laddr, raddr *net.UDPAddr) (net.IP, error) {
+	if laddr.IP != nil && !laddr.IP.IsUnspecified() {
+		return laddr.IP, nil
+	}
+	c, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// addSrcPorts opens n more sockets on ip, each with an ephemeral port.
+func (t *transport) addSrcPorts(ip net.IP, n int) error {
+	for range n {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip})
+		if err != nil {
+			return err
+		}
+		t.srcs = append(t.srcs, conn)
+	}
+	return nil
+}
+
+// addPeer opens a socket on laddr, as an extra source, for the datagrams
+// to raddr.
+func (t *transport) addPeer(laddr, raddr *net.UDPAddr) error {
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return err
+	}
+	t.srcs = append(t.srcs, conn)
+	t.route(raddr, len(t.srcs))
+	return nil
+}
+
+// route sends the datagrams to raddr out of socket src.
+func (t *transport) route(raddr *net.UDPAddr, src int) {
+	if t.routes == nil {
+		t.routes = make(map[string]int)
+	}
+	t.routes[raddr.String()] = src
+}
+
+// srcFor returns the socket datagrams to peer leave from: its route, if it
+// has one, else src.
+func (t *transport) srcFor(src int, peer *net.UDPAddr) int {
+	if t.routes != nil && peer != nil {
+		if r, ok := t.routes[peer.String()]; ok {
+			return r
+		}
+	}
+	return src
+}
+
+// conns returns every socket: the main one first, then the extra sources.
+func (t *transport) conns() []*net.UDPConn {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]*net.UDPConn{t.conn}, t.srcs...)
+}
+
+// srcConn returns socket src, 0 being the main one.
+func (t *transport) srcConn(src int) *net.UDPConn {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if src == 0 {
+		return t.conn
+	}
+	return t.srcs[src-1]
+}
+
+// rebind replaces socket src, after a fatal error on it, with a new one on
+// the same local address (connected to the same remote in connected mode)
+// and applies setup to it. The old socket is closed first so its port is
+// free again; a failed rebind can simply be retried.
+func (t *transport) rebind(src int) (*net.UDPConn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, net.ErrClosed
+	}
+	old := t.conn
+	if src > 0 {
+		old = t.srcs[src-1]
+	}
+	laddr := old.LocalAddr().(*net.UDPAddr)
+	old.Close()
+	var (
+		conn *net.UDPConn
+		err  error
+	)
+	if t.connected {
+		conn, err = net.DialUDP("udp", laddr, t.raddr)
+	} else {
+		conn, err = net.ListenUDP("udp", laddr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.setup != nil {
+		if err := t.setup(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if src == 0 {
+		t.conn = conn
+	} else {
+		t.srcs[src-1] = conn
+	}
+	t.rebinds.Add(1)
+	return conn, nil
+}
+
+// pickSrc returns the socket a new request goes out of.
+func (t *transport) pickSrc() int {
+	if len(t.srcs) == 0 {
+		return 0
+	}
+	return int((t.nextSrc.Add(1) - 1) % uint32(len(t.srcs)+1))
+}
+
+// srcAfter returns the socket following src, for retransmissions that are
+// meant to leave from another port.
+func (t *transport) srcAfter(src int) int {
+	return (src + 1) % (len(t.srcs) + 1)
+}
+
+func (t *transport) LocalAddr() net.Addr { return t.srcConn(0).LocalAddr() }
+
+func (t *transport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	if t.spoof != nil {
+		t.spoof.Close()
+	}
+	for _, conn := range t.srcs {
+		conn.Close()
+	}
+	return t.conn.Close()
+}
+
+func (t *transport) mode() string {
+	switch {
+	case t.connected:
+		return "connected"
+	case t.spoof != nil:
+		return "unconnected+spoof-src=" + t.spoof.src.String()
+	case len(t.routes) > 0:
+		return fmt.Sprintf("unconnected+peers=%d", len(t.routes))
+	case len(t.srcs) > 0:
+		return fmt.Sprintf("unconnected+src-ports=%d", len(t.srcs)+1)
+	}
+	return "unconnected"
+}
+
+// send writes b to the remote peer: the configured one, or the learned one
+// in follow mode.
+func (t *transport) send(b []byte) error {
+	return t.sendTo(b, t.remote())
+}
+
+// remote returns the address requests are currently sent to.
+func (t *transport) remote() *net.UDPAddr {
+	if a := t.learned.Load(); a != nil {
+		return a
+	}
+	return t.raddr
+}
+
+// learn records peer as the send target if following is enabled and it
+// differs from the current one. Only addresses that answered one of our
+// requests are passed in, so stray packets can't redirect us.
+func (t *transport) learn(peer *net.UDPAddr) {
+	if !t.follow {
+		return
+	}
+	cur := t.remote()
+	if peer.IP.Equal(cur.IP) && peer.Port == cur.Port {
+		return
+	}
+	t.learned.Store(peer)
+	log.Printf("follow-peer: peer answered from %s, sending there from now on (was %s)", peer, cur)
+}
+
+// sendTo writes b to peer. A connected socket can only reach its remote, so
+// peer is ignored there (the kernel only delivers datagrams from it anyway).
+func (t *transport) sendTo(b []byte, peer *net.UDPAddr) error {
+	return t.sendFrom(0, b, peer)
+}
+
+// sendFrom is sendTo out of socket src (see pickSrc), or out of peer's own
+// socket with Config.Peers.
+func (t *transport) sendFrom(src int, b []byte, peer *net.UDPAddr) error {
+	src = t.srcFor(src, peer)
+	if t.tap != nil {
+		t.tap(b)
+	}
+	var err error
+	if t.batch != nil {
+		err = t.batch.send(b, peer)
+	} else {
+		err = t.write(t.srcConn(src), b, peer)
+	}
+	if err != nil {
+		return err
+	}
+	t.txPkts.Add(1)
+	t.txBytes.Add(uint64(len(b)))
+	if len(b) > 1 {
+		t.st.countTx(b[1])
+	}
+	return nil
+}
+
+// typeTOS returns the control message marking b with its message type's
+// DSCP, or nil to keep the socket's.
+func (t *transport) typeTOS(b []byte) []byte {
+	if len(t.tos) == 0 || len(b) < 2 {
+		return nil
+	}
+	return t.tos[b[1]]
+}
+
+// write sends one datagram out of conn, retrying transient errors.
+func (t *transport) write(conn *net.UDPConn, b []byte, peer *net.UDPAddr) error {
+	var err error
+	oob := t.typeTOS(b)
+	for attempt := 0; ; attempt++ {
+		switch {
+		case t.spoof != nil:
+			err = t.spoof.writeTo(b, peer)
+		case oob != nil && t.connected:
+			_, _, err = conn.WriteMsgUDP(b, oob, nil)
+		case oob != nil:
+			_, _, err = conn.WriteMsgUDP(b, oob, peer)
+		case t.connected:
+			_, err = conn.Write(b)
+		default:
+			_, err = conn.WriteToUDP(b, peer)
+		}
+		if err == nil || attempt >= t.retries || !transientWriteErr(err) {
+			return err
+		}
+		t.writeRetries.Add(1)
+		time.Sleep(writeRetryBackoff << attempt)
+	}
+}
+
+// writeRetryBackoff is the sleep before the first write retry; it doubles
+// with each further attempt.
+const writeRetryBackoff = 2 * time.Millisecond
+
+// transientWriteErr reports whether a failed write is worth retrying: the
+// socket buffer or kernel memory was momentarily exhausted.
+func transientWriteErr(err error) bool {
+	if errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.ENOMEM) {
+		return true
+	}
+	var ne interface{ Temporary() bool }
+	return errors.As(err, &ne) && ne.Temporary()
+}
+
+// recv reads one datagram from socket src (see pickSrc).
+func (t *transport) recv(src int, buf []byte) (int, *net.UDPAddr, error) {
+	var (
+		n    int
+		peer *net.UDPAddr
+		err  error
+	)
+	if t.connected {
+		n, err = t.srcConn(0).Read(buf)
+		peer = t.raddr
+	} else {
+		n, peer, err = t.srcConn(src).ReadFromUDP(buf)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	t.rxPkts.Add(1)
+	t.rxBytes.Add(uint64(n))
+	if n > 1 {
+		t.st.countRx(buf[1])
+	}
+	return n, peer, nil
+}
+
+// report logs packet/byte counters and throughput for the run, tagged with
+// the socket mode so connected vs unconnected runs can be compared.
+func (t *transport) report() {
+	elapsed := time.Since(t.start).Seconds()
+	if elapsed <= 0 {
+		elapsed = 1
+	}
+	tx, rx := t.txPkts.Load(), t.rxPkts.Load()
+	log.Printf("run report: socket=%s elapsed=%.1fs tx=%d pkts (%d B, %.1f pps) rx=%d pkts (%d B, %.1f pps)",
+		t.mode(), elapsed,
+		tx, t.txBytes.Load(), float64(tx)/elapsed,
+		rx, t.rxBytes.Load(), float64(rx)/elapsed)
+	log.Printf("run report: failed transactions: %s, write retries: %d, retransmits: %d", t.st.errSummary(), t.writeRetries.Load(), t.retransmits.Load())
+	if n := t.rebinds.Load(); n > 0 {
+		log.Printf("run report: sockets rebound after fatal errors: %d", n)
+	}
+	if drops, err := socketDrops(t.srcConn(0)); err == nil && drops > 0 {
+		log.Printf("run report: WARNING: kernel dropped %d received datagrams on a full socket buffer (try a larger -so-rcvbuf)", drops)
+	}
+	if t.batch != nil {
+		t.batch.report()
+	}
+}
@@ -0,0 +1,118 @@
+package sim
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// tunMTU leaves room for the outer IPv4, UDP and GTP-U headers (20+8+8)
+// within a 1500 octet path.
+const tunMTU = 1464
+
+// tunnel is the UE data path of one session: IPv4 packets read from a TUN
+// device are sent to the PGW as G-PDUs on the default bearer's PGW TEID, and
+// G-PDUs arriving on our TEID are written back to the device.
+type tunnel struct {
+	dev   io.ReadWriteCloser
+	name  string
+	ue    net.IP // the session's IPv4 PAA, the only source forwarded uplink
+	teid  uint32 // PGW S5/S8-U TEID, uplink
+	local uint32 // our S5/S8-U TEID, downlink
+	peer  *net.UDPAddr
+
+	ulPkts, ulBytes, dlPkts, dlBytes, drops atomic.Uint64
+}
+
+// StartTUN turns sess into a working UE: it creates the TUN interface
+// Config.TUN with the session's IPv4 PAA and forwards its traffic through
+// the GTP-U tunnel of the default bearer until the client is closed. Routes
+// into the interface are left to the operator. It needs Linux and root (or
+// CAP_NET_ADMIN).
+func (c *Client) StartTUN(sess *Session) error {
+	if c.u == nil {
+		return errors.New("tun: gtp-u path not enabled")
+	}
+	v4, _, _ := strings.Cut(sess.PAA, ",")
+	ue := net.ParseIP(v4).To4()
+	if ue == nil {
+		return fmt.Errorf("tun: session %s has no IPv4 PAA (%q)", sess.IMSI, sess.PAA)
+	}
+	sess.mu.Lock()
+	teid, pgw := sess.RemoteUTEID, sess.RemoteUIP
+	sess.mu.Unlock()
+	if teid == 0 || pgw == nil {
+		return fmt.Errorf("tun: session %s has no PGW S5/S8-U F-TEID", sess.IMSI)
+	}
+	dev, name, err := openTUN(c.cfg.TUN)
+	if err != nil {
+		return fmt.Errorf("tun: %w", err)
+	}
+	if err := configureTUN(name, ue, tunMTU); err != nil {
+		dev.Close()
+		return fmt.Errorf("tun %s: %w", name, err)
+	}
+	t := &tunnel{dev: dev, name: name, ue: ue, teid: teid, local: sess.LocalUTEID,
+		peer: &net.UDPAddr{IP: pgw, Port: c.u.raddr.Port}}
+	c.u.gpdu.Store(t)
+	c.tun = t
+	go t.uplink(c.u.conn)
+	log.Printf("tun %s up: ue=%s mtu=%d, uplink teid=0x%08x -> %s, downlink teid=0x%08x; route traffic in with e.g. ip route add DST dev %s",
+		name, ue, tunMTU, teid, t.peer, t.local, name)
+	return nil
+}
+
+// uplink encapsulates the UE's packets until the device is closed.
+func (t *tunnel) uplink(conn *net.UDPConn) {
+	buf := make([]byte, 8+tunMTU+100)
+	for {
+		n, err := t.dev.Read(buf[8:])
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) && !errors.Is(err, io.EOF) {
+				log.Printf("tun %s: read: %v", t.name, err)
+			}
+			return
+		}
+		pkt := buf[8 : 8+n]
+		// Only IPv4 from the UE address: anything else (IPv6 router
+		// solicitations, stray sources) has no business on this bearer.
+		if n < 20 || pkt[0]>>4 != 4 || !net.IP(pkt[12:16]).Equal(t.ue) {
+			t.drops.Add(1)
+			continue
+		}
+		buf[0], buf[1] = 0x30, 0xff // GTPv1, PT=1, no options; T-PDU
+		binary.BigEndian.PutUint16(buf[2:4], uint16(n))
+		binary.BigEndian.PutUint32(buf[4:8], t.teid)
+		if _, err := conn.WriteToUDP(buf[:8+n], t.peer); err != nil {
+			t.drops.Add(1)
+			continue
+		}
+		t.ulPkts.Add(1)
+		t.ulBytes.Add(uint64(n))
+	}
+}
+
+// downlink writes a G-PDU's payload to the device if it is for our TEID.
+func (t *tunnel) downlink(teid uint32, pkt []byte) {
+	if teid != t.local {
+		t.drops.Add(1)
+		return
+	}
+	if _, err := t.dev.Write(pkt); err != nil {
+		t.drops.Add(1)
+		return
+	}
+	t.dlPkts.Add(1)
+	t.dlBytes.Add(uint64(len(pkt)))
+}
+
+func (t *tunnel) report() {
+	log.Printf("run report: tun %s ue=%s: uplink %d pkts (%d B), downlink %d pkts (%d B), dropped %d",
+		t.name, t.ue, t.ulPkts.Load(), t.ulBytes.Load(), t.dlPkts.Load(), t.dlBytes.Load(), t.drops.Load())
+}
@@ -0,0 +1,94 @@
+package sim
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// ifreq is struct ifreq: the interface name and a 24 octet union, used
+// here as a sockaddr_in, flags or MTU.
+type ifreq struct {
+	name [syscall.IFNAMSIZ]byte
+	data [24]byte
+}
+
+func ioctl(fd uintptr, req uintptr, r *ifreq) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(r))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// openTUN creates (or attaches to) the TUN device name, without packet
+// information headers, and returns it with the name the kernel gave it.
+func openTUN(name string) (io.ReadWriteCloser, string, error) {
+	f, err := os.OpenFile("/dev/net/tun", os.O_RDWR, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	var r ifreq
+	copy(r.name[:syscall.IFNAMSIZ-1], name)
+	binary.NativeEndian.PutUint16(r.data[:2], syscall.IFF_TUN|syscall.IFF_NO_PI)
+	if err := ioctl(f.Fd(), syscall.TUNSETIFF, &r); err != nil {
+		f.Close()
+		return nil, "", fmt.Errorf("TUNSETIFF %s (needs root or CAP_NET_ADMIN): %w", name, err)
+	}
+	return f, string(r.name[:clen(r.name[:])]), nil
+}
+
+// configureTUN gives the interface ip/32 and mtu and brings it up, with the
+// classic SIOCSIF* ioctls so no netlink library is needed.
+func configureTUN(name string, ip net.IP, mtu int) error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	req := func() *ifreq {
+		var r ifreq
+		copy(r.name[:syscall.IFNAMSIZ-1], name)
+		return &r
+	}
+	inet := func(r *ifreq, a net.IP) *ifreq {
+		binary.NativeEndian.PutUint16(r.data[0:2], syscall.AF_INET)
+		copy(r.data[4:8], a.To4())
+		return r
+	}
+
+	if err := ioctl(uintptr(fd), syscall.SIOCSIFADDR, inet(req(), ip)); err != nil {
+		return fmt.Errorf("set address %s: %w", ip, err)
+	}
+	if err := ioctl(uintptr(fd), syscall.SIOCSIFNETMASK, inet(req(), net.IPv4(255, 255, 255, 255))); err != nil {
+		return fmt.Errorf("set netmask: %w", err)
+	}
+	r := req()
+	binary.NativeEndian.PutUint32(r.data[:4], uint32(mtu))
+	if err := ioctl(uintptr(fd), syscall.SIOCSIFMTU, r); err != nil {
+		return fmt.Errorf("set mtu %d: %w", mtu, err)
+	}
+	r = req()
+	if err := ioctl(uintptr(fd), syscall.SIOCGIFFLAGS, r); err != nil {
+		return fmt.Errorf("get flags: %w", err)
+	}
+	flags := binary.NativeEndian.Uint16(r.data[:2]) | syscall.IFF_UP | syscall.IFF_RUNNING
+	binary.NativeEndian.PutUint16(r.data[:2], flags)
+	if err := ioctl(uintptr(fd), syscall.SIOCSIFFLAGS, r); err != nil {
+		return fmt.Errorf("bring up: %w", err)
+	}
+	return nil
+}
+
+// clen is the length of the NUL terminated string in b.
+func clen(b []byte) int {
+	for i, c := range b {
+		if c == 0 {
+			return i
+		}
+	}
+	return len(b)
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package sim
+
+import (
+	"errors"
+	"io"
+	"net"
+)
+
+func openTUN(name string) (io.ReadWriteCloser, string, error) {
+	return nil, "", errors.New("-tun is only supported on linux")
+}
+
+func configureTUN(name string, ip net.IP, mtu int) error {
+	return errors.New("-tun is only supported on linux")
+}
@@ -0,0 +1,384 @@
+package sim
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gtp "github.com/wmnsk/go-gtp"
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// txnRegistry hands received responses to the goroutine waiting on the
+// request with the same sequence number. With a limit, register blocks while
+// that many transactions are outstanding.
+type txnRegistry struct {
+	mu    sync.Mutex
+	m     map[uint32]*pendingTxn
+	slots chan struct{} // one token per outstanding transaction; nil: no limit
+	swept atomic.Uint64 // transactions removed by sweep
+
+	// Transactions given up on, kept for lateKeep so that deliver can tell
+	// a late answer from an unsolicited one; onLate, if set, gets each.
+	expired map[uint32]expiredTxn
+	late    atomic.Uint64
+	onLate  func(m gtpv2msg.Message, req uint8, after time.Duration)
+}
+
+// lateKeep is how long after giving up on a transaction its answer still
+// counts as late rather than unsolicited.
+const lateKeep = time.Minute
+
+// expiredTxn is a transaction that ended without an answer: its request's
+// type and when it was given up on.
+type expiredTxn struct {
+	req uint8
+	at  time.Time
+}
+
+// pendingTxn is one outstanding transaction: its waiter's channel, closed
+// if the transaction is swept, its request's type and when sweep may
+// remove it.
+type pendingTxn struct {
+	ch       chan gtpv2msg.Message
+	req      uint8
+	deadline time.Time
+}
+
+func newTxnRegistry(maxInflight int) *txnRegistry {
+	r := &txnRegistry{m: make(map[uint32]*pendingTxn), expired: make(map[uint32]expiredTxn)}
+	if maxInflight > 0 {
+		r.slots = make(chan struct{}, maxInflight)
+	}
+	return r
+}
+
+// register adds a transaction for seq, a request of type req, that sweep
+// removes after ttl. It reports false, adding nothing, while an earlier
+// transaction with the same sequence is still pending (the 24-bit sequence
+// space wrapped under it).
+func (r *txnRegistry) register(seq uint32, req uint8, ttl time.Duration) (<-chan gtpv2msg.Message, bool) {
+	if r.slots != nil {
+		r.slots <- struct{}{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, dup := r.m[seq]; dup {
+		if r.slots != nil {
+			<-r.slots
+		}
+		return nil, false
+	}
+	ch := make(chan gtpv2msg.Message, 1)
+	r.m[seq] = &pendingTxn{ch: ch, req: req, deadline: time.Now().Add(ttl)}
+	return ch, true
+}
+
+// take removes the transaction for seq, freeing its in-flight slot.
+func (r *txnRegistry) take(seq uint32) (chan gtpv2msg.Message, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.m[seq]
+	if !ok {
+		return nil, false
+	}
+	delete(r.m, seq)
+	if r.slots != nil {
+		<-r.slots
+	}
+	return p.ch, true
+}
+
+func (r *txnRegistry) cancel(seq uint32) { r.take(seq) }
+
+// expire removes the transaction for seq, whose request of type req timed
+// out, and remembers it so an answer arriving later is reported as late.
+func (r *txnRegistry) expire(seq uint32, req uint8) {
+	r.take(seq)
+	r.mu.Lock()
+	r.expired[seq] = expiredTxn{req: req, at: time.Now()}
+	r.mu.Unlock()
+}
+
+// sweep removes the transactions past their deadline and closes their
+// channels, so their waiters fail instead of holding an entry and an
+// in-flight slot, and remembers them so a late answer is reported as such.
+// It also forgets the transactions expired more than lateKeep ago.
+func (r *txnRegistry) sweep() int {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for seq, e := range r.expired {
+		if now.Sub(e.at) > lateKeep {
+			delete(r.expired, seq)
+		}
+	}
+	n := 0
+	for seq, p := range r.m {
+		if p.deadline.After(now) {
+			continue
+		}
+		delete(r.m, seq)
+		if r.slots != nil {
+			<-r.slots
+		}
+		close(p.ch)
+		r.expired[seq] = expiredTxn{req: p.req, at: now}
+		n++
+	}
+	r.swept.Add(uint64(n))
+	return n
+}
+
+// inflight returns the number of outstanding transactions.
+func (r *txnRegistry) inflight() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.m)
+}
+
+// deliver passes m to the waiter for its sequence. It reports false if no
+// transaction is pending for it (unsolicited, duplicate or late); an answer
+// to a transaction that expired within lateKeep is counted as late and
+// handed to onLate.
+func (r *txnRegistry) deliver(m gtpv2msg.Message) bool {
+	ch, ok := r.take(m.Sequence())
+	if ok {
+		ch <- m
+		return true
+	}
+	r.mu.Lock()
+	e, late := r.expired[m.Sequence()]
+	delete(r.expired, m.Sequence())
+	r.mu.Unlock()
+	if late {
+		r.late.Add(1)
+		if r.onLate != nil {
+			r.onLate(m, e.req, time.Since(e.at))
+		}
+	}
+	return false
+}
+
+// txnSweepEvery is how often the client sweeps stale transactions; with T3
+// set, every T3 if that is shorter.
+const txnSweepEvery = time.Second
+
+// txnSweepSlack is how long after its timeout a transaction without
+// retransmission is swept, as a backstop: transact's own timer fails it first.
+const txnSweepSlack = time.Second
+
+// txnTimeout is how long transact waits for a response: the configured
+// timeout, but with retransmission on at least N3+1 T3 periods, so the last
+// of the N3 retransmissions gets its T3 as well.
+func (c *Client) txnTimeout() time.Duration {
+	if c.cfg.T3 <= 0 {
+		return c.cfg.Timeout
+	}
+	return max(c.cfg.Timeout, max(c.cfg.T3, c.t3())*time.Duration(c.cfg.N3+1))
+}
+
+// sweepTxns is run periodically by the client: it evicts stale transactions,
+// whose waiters then fail them as timeouts.
+func (c *Client) sweepTxns() {
+	if n := c.reg.sweep(); n > 0 {
+		log.Printf("swept %d unanswered transaction(s)", n)
+	}
+	if c.integrity != nil {
+		c.integrity.prune()
+	}
+}
+
+// seqCollisionPoll is how often transact rechecks a sequence number that is
+// still in use.
+const seqCollisionPoll = 10 * time.Millisecond
+
+// transact sends req and waits up to txnTimeout for the response with the
+// same sequence number. With T3 set, an unanswered request is sent again
+// every T3 (adaptive with AdaptiveT3), at most N3 times, and the sweep ends
+// the wait: it removes the transaction at its deadline, which fails it as a
+// timeout. Without T3 transact's own timer does. Failures are returned as
+// *TxnError.
+func (c *Client) transact(req gtpv2msg.Message) (gtpv2msg.Message, time.Duration, error) {
+	return c.transactTo(req, c.tr.remote())
+}
+
+// transactTo is transact with the PGW to, e.g. the peer of a session (see
+// Config.Peers).
+func (c *Client) transactTo(req gtpv2msg.Message, to *net.UDPAddr) (gtpv2msg.Message, time.Duration, error) {
+	tr, reg, timeout := c.tr, c.reg, c.txnTimeout()
+	seq := req.Sequence()
+	fail := func(kind TxnErrorKind, err error) (gtpv2msg.Message, time.Duration, error) {
+		tr.st.countErr(kind)
+		te := &TxnError{Kind: kind, MsgType: req.MessageType(), Seq: seq, Peer: to, Err: err}
+		c.health.failed(te)
+		return nil, 0, te
+	}
+
+	b, err := gtp.Marshal(req)
+	if err != nil {
+		return fail(TxnParse, err)
+	}
+	if present, ok := c.cfg.TEIDField[req.MessageType()]; ok {
+		if b, err = forceTEIDField(b, present, seq); err != nil {
+			return fail(TxnParse, err)
+		}
+	}
+	if c.cfg.BadLength != 0 {
+		if err := skewLength(b, c.cfg.BadLength, seq); err != nil {
+			return fail(TxnParse, err)
+		}
+	}
+
+	ttl := timeout
+	if c.cfg.T3 <= 0 {
+		ttl += txnSweepSlack
+	}
+	ch, ok := reg.register(seq, req.MessageType(), ttl)
+	if !ok {
+		// Correlation would break; wait for the old transaction, which ends
+		// within its own timeout.
+		c.seq.collisions.Add(1)
+		log.Printf("WARNING: seq=%d still pending from before the sequence wrapped; waiting to send %s", seq, msgName(req.MessageType()))
+		for !ok {
+			time.Sleep(seqCollisionPoll)
+			ch, ok = reg.register(seq, req.MessageType(), ttl)
+		}
+	}
+	tr.st.begin(seq)
+	src := tr.srcFor(tr.pickSrc(), to)
+	c.sent.store(seq, b, src, to)
+	if c.cfg.DupSend > 1 {
+		c.dups.start(seq, req.MessageType(), c.cfg.DupSend)
+		defer c.endDups(seq)
+	}
+	if err := tr.sendFrom(src, b, to); err != nil {
+		reg.cancel(seq)
+		tr.st.abandon(seq)
+		return fail(TxnTransport, err)
+	}
+	if c.cfg.DupSend > 1 {
+		c.sendDups(src, b, to, seq, req.MessageType())
+	}
+
+	var (
+		t3, deadline <-chan time.Time
+		t3Timer      *time.Timer
+		sent         = 1
+	)
+	if c.cfg.T3 > 0 {
+		t3Timer = time.NewTimer(c.t3())
+		defer t3Timer.Stop()
+		t3 = t3Timer.C
+	} else {
+		d := time.NewTimer(timeout)
+		defer d.Stop()
+		deadline = d.C
+	}
+	timedOut := func() (gtpv2msg.Message, time.Duration, error) {
+		tr.st.abandon(seq)
+		if sent > 1 {
+			return fail(TxnTimeout, fmt.Errorf("no response within %s (sent %d times)", timeout, sent))
+		}
+		return fail(TxnTimeout, fmt.Errorf("no response within %s", timeout))
+	}
+
+	for {
+		select {
+		case resp, ok := <-ch:
+			if !ok {
+				return timedOut() // swept at its deadline
+			}
+			rtt, _ := tr.st.end(seq)
+			c.health.responded()
+			c.paths.get(to).answered(resp, rtt)
+			c.resps.store(resp)
+			if sent == 1 {
+				c.rtt.sample(rtt)
+			}
+			return resp, rtt, nil
+		case <-t3:
+			if sent > c.cfg.N3 {
+				t3 = nil // out of retransmissions; wait for the sweep
+				continue
+			}
+			// Retransmissions leave from the original's port: peers may
+			// match them by source port as well as sequence.
+			if c.cfg.RotateSrcPort {
+				src = tr.srcAfter(src)
+			}
+			if err := tr.sendFrom(src, b, to); err != nil {
+				log.Printf("retransmit %s seq=%d: %v", msgName(req.MessageType()), seq, err)
+			}
+			tr.retransmits.Add(1)
+			sent++
+			from := ""
+			if len(tr.srcs) > 0 {
+				from = " from " + tr.srcConn(src).LocalAddr().String()
+			}
+			log.Printf("retransmit %s seq=%d (%d/%d)%s", msgName(req.MessageType()), seq, sent-1, c.cfg.N3, from)
+			t3Timer.Reset(c.t3())
+		case <-deadline:
+			reg.expire(seq, req.MessageType())
+			return timedOut()
+		}
+	}
+}
+
+// skewLength adds delta to the header length field of the marshaled message
+// b, so the peer sees a length that doesn't match the payload.
+func skewLength(b []byte, delta int, seq uint32) error {
+	if len(b) < 4 {
+		return fmt.Errorf("bad-length: message too short (%d bytes)", len(b))
+	}
+	was := int(binary.BigEndian.Uint16(b[2:4]))
+	now := was + delta
+	if now < 0 || now > 0xffff {
+		return fmt.Errorf("bad-length: length %d%+d out of range", was, delta)
+	}
+	binary.BigEndian.PutUint16(b[2:4], uint16(now))
+	log.Printf("bad-length: %s seq=%d length field %d (0x%04x) -> %d (0x%04x), actual %d after the first 4 octets",
+		msgName(b[1]), seq, was, was, now, now, len(b)-4)
+	return nil
+}
+
+// t3 returns the current retransmission timer: the fixed T3, or the RTT
+// based estimate once AdaptiveT3 has enough samples.
+func (c *Client) t3() time.Duration {
+	if !c.cfg.AdaptiveT3 {
+		return c.cfg.T3
+	}
+	return c.rtt.rto(c.cfg.T3)
+}
+
+// checkResponse verifies resp answers req and carries an accepted Cause,
+// returning the cause value. cause is the response's Cause IE (may be nil).
+func (c *Client) checkResponse(req, resp gtpv2msg.Message, want uint8, cause *gtpv2ie.IE) (uint8, error) {
+	tr := c.tr
+	fail := func(kind TxnErrorKind, v uint8, err error) (uint8, error) {
+		tr.st.countErr(kind)
+		te := &TxnError{Kind: kind, MsgType: req.MessageType(), Seq: req.Sequence(), Peer: c.sentTo(req.Sequence()), Cause: v, Err: err}
+		c.health.failed(te)
+		return v, te
+	}
+	if resp.MessageType() != want {
+		return fail(TxnParse, 0, fmt.Errorf("unexpected %s", resp.MessageTypeName()))
+	}
+	if cause == nil {
+		return fail(TxnParse, 0, fmt.Errorf("%s missing Cause", msgName(want)))
+	}
+	v, err := cause.Cause()
+	if err != nil {
+		return fail(TxnParse, 0, fmt.Errorf("%s cause: %w", msgName(want), err))
+	}
+	if !causeAccepted(cause) {
+		tr.st.countCause(v)
+		return fail(TxnRejected, v, nil)
+	}
+	return v, nil
+}
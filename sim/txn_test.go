@@ -0,0 +1,195 @@
+package sim
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	gtp "github.com/wmnsk/go-gtp"
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// droppingPeer answers EchoRequests, but only the copy after the first
+// drop of each sequence number, and records the source port of every copy.
+type droppingPeer struct {
+	conn *net.UDPConn
+	drop int
+
+	mu    sync.Mutex
+	ports map[uint32][]int // by sequence number, in arrival order
+}
+
+func newDroppingPeer(t *testing.T, drop int) *droppingPeer {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &droppingPeer{conn: conn, drop: drop, ports: make(map[uint32][]int)}
+	t.Cleanup(func() { conn.Close() })
+	go p.serve()
+	return p
+}
+
+func (p *droppingPeer) serve() {
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := p.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		m, err := gtp.Parse(buf[:n])
+		if err != nil {
+			continue
+		}
+		req, ok := m.(*gtpv2msg.EchoRequest)
+		if !ok {
+			continue
+		}
+		seq := req.Sequence()
+		p.mu.Lock()
+		p.ports[seq] = append(p.ports[seq], from.Port)
+		copies := len(p.ports[seq])
+		p.mu.Unlock()
+		if copies <= p.drop {
+			continue
+		}
+		resp := gtpv2msg.NewEchoResponse(seq, gtpv2ie.NewRecovery(1))
+		if b, err := gtp.Marshal(resp); err == nil {
+			p.conn.WriteToUDP(b, from)
+		}
+	}
+}
+
+func (p *droppingPeer) copies() map[uint32][]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make(map[uint32][]int, len(p.ports))
+	for seq, ports := range p.ports {
+		out[seq] = append([]int(nil), ports...)
+	}
+	return out
+}
+
+// TestRetransmitSourcePort checks that with -src-ports > 1 every
+// retransmission of a request leaves from the original's port, and that
+// RotateSrcPort moves each one to the next port instead.
+func TestRetransmitSourcePort(t *testing.T) {
+	const (
+		drop     = 2 // copies the peer ignores; the third is answered
+		requests = 6
+	)
+	for _, rotate := range []bool{false, true} {
+		peer := newDroppingPeer(t, drop)
+		cfg := DefaultConfig()
+		cfg.Local, cfg.Remote, cfg.EchoEvery = "127.0.0.1:0", peer.conn.LocalAddr().String(), 0
+		cfg.SrcPorts, cfg.RotateSrcPort = 3, rotate
+		cfg.T3, cfg.N3, cfg.Timeout = 30*time.Millisecond, drop, 2*time.Second
+		c, err := NewClient(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range requests {
+			if _, err := c.Echo(); err != nil {
+				t.Errorf("rotate=%t: Echo: %v", rotate, err)
+			}
+		}
+		c.Close()
+
+		got := peer.copies()
+		if len(got) != requests {
+			t.Fatalf("rotate=%t: peer saw %d sequence numbers, want %d", rotate, len(got), requests)
+		}
+		originals := make(map[int]bool)
+		for seq, ports := range got {
+			if len(ports) != drop+1 {
+				t.Errorf("rotate=%t: seq=%d arrived %d times, want %d", rotate, seq, len(ports), drop+1)
+				continue
+			}
+			originals[ports[0]] = true
+			for k := 1; k < len(ports); k++ {
+				same := ports[k] == ports[0]
+				if !rotate && !same {
+					t.Errorf("seq=%d: retransmission %d from port %d, original from %d", seq, k, ports[k], ports[0])
+				}
+				if rotate && ports[k] == ports[k-1] {
+					t.Errorf("rotate: seq=%d: retransmission %d from port %d again", seq, k, ports[k])
+				}
+			}
+		}
+		if len(originals) != cfg.SrcPorts {
+			t.Errorf("rotate=%t: originals left from %d ports, want all %d", rotate, len(originals), cfg.SrcPorts)
+		}
+	}
+}
+
+// TestRetransmitAll checks a request to a peer that never answers goes out
+// N3+1 times even with a -timeout shorter than the retransmissions take,
+// and then fails as a timeout.
+func TestRetransmitAll(t *testing.T) {
+	const n3 = 3
+	peer := newDroppingPeer(t, 1<<30)
+	cfg := DefaultConfig()
+	cfg.Local, cfg.Remote, cfg.EchoEvery = "127.0.0.1:0", peer.conn.LocalAddr().String(), 0
+	cfg.T3, cfg.N3, cfg.Timeout = 30*time.Millisecond, n3, 50*time.Millisecond
+	c, err := NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = c.Echo()
+	var te *TxnError
+	if !errors.As(err, &te) || te.Kind != TxnTimeout {
+		t.Fatalf("Echo = %v, want a timeout", err)
+	}
+	got := peer.copies()
+	if len(got) != 1 {
+		t.Fatalf("peer saw %d sequence numbers, want 1", len(got))
+	}
+	for seq, ports := range got {
+		if len(ports) != n3+1 {
+			t.Errorf("seq=%d sent %d times, want %d (N3=%d retransmissions)", seq, len(ports), n3+1, n3)
+		}
+	}
+}
+
+// TestSweepUnanswered checks the sweeper removes the transactions a peer
+// never answers, failing their waiters as timeouts and freeing their
+// -max-inflight slots, and counts them.
+func TestSweepUnanswered(t *testing.T) {
+	const requests = 4
+	peer := newDroppingPeer(t, 1<<30)
+	cfg := DefaultConfig()
+	cfg.Local, cfg.Remote, cfg.EchoEvery = "127.0.0.1:0", peer.conn.LocalAddr().String(), 0
+	cfg.T3, cfg.N3, cfg.Timeout = 20*time.Millisecond, 1, 10*time.Millisecond
+	cfg.MaxInflight = 2
+	c, err := NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	errs := make(chan error, requests)
+	for range requests {
+		go func() {
+			_, err := c.Echo()
+			errs <- err
+		}()
+	}
+	for range requests {
+		var te *TxnError
+		if err := <-errs; !errors.As(err, &te) || te.Kind != TxnTimeout {
+			t.Errorf("Echo = %v, want a timeout", err)
+		}
+	}
+	if n := c.reg.swept.Load(); n != requests {
+		t.Errorf("swept %d transactions, want %d", n, requests)
+	}
+	if n := c.reg.inflight(); n != 0 {
+		t.Errorf("%d transactions still registered", n)
+	}
+}
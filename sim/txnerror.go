@@ -0,0 +1,103 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// TxnErrorKind classifies why a request/response transaction failed.
+type TxnErrorKind int
+
+const (
+	TxnTimeout   TxnErrorKind = iota + 1 // no response before the deadline
+	TxnRejected                          // response carried a non-accepted Cause
+	TxnParse                             // request could not be encoded or response decoded
+	TxnTransport                         // socket send failed
+)
+
+func (k TxnErrorKind) String() string {
+	switch k {
+	case TxnTimeout:
+		return "timeout"
+	case TxnRejected:
+		return "rejected"
+	case TxnParse:
+		return "parse"
+	case TxnTransport:
+		return "transport"
+	}
+	return fmt.Sprintf("kind%d", int(k))
+}
+
+// TxnError is returned by the send paths when a transaction fails.
+type TxnError struct {
+	Kind    TxnErrorKind
+	MsgType uint8 // request message type
+	Seq     uint32
+	Peer    net.Addr
+	Cause   uint8 // set for TxnRejected
+	Err     error // underlying error, if any
+}
+
+func (e *TxnError) Error() string {
+	s := fmt.Sprintf("%s seq=%d to %v: %s", msgName(e.MsgType), e.Seq, e.Peer, e.Kind)
+	if e.Kind == TxnRejected {
+		if n, ok := causeNames[e.Cause]; ok {
+			s += fmt.Sprintf(" (cause=%d, %s)", e.Cause, n)
+		} else {
+			s += fmt.Sprintf(" (cause=%d)", e.Cause)
+		}
+	}
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+func (e *TxnError) Unwrap() error { return e.Err }
+
+// Process exit codes by failure kind; 1 stays "usage/setup error".
+const (
+	exitOK        = 0
+	exitFailure   = 1
+	exitTimeout   = 3
+	exitRejected  = 4
+	exitParse     = 5
+	exitTransport = 6
+	exitThreshold = 7
+)
+
+// ExitCode maps an error from a Client method to a process exit code:
+// 0 ok, 1 other failure, 3 timeout, 4 rejected, 5 parse, 6 transport.
+func ExitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var (
+		fe *FailureThresholdError
+		se *SLAError
+		ie *IntegrityError
+	)
+	if errors.As(err, &fe) || errors.As(err, &se) {
+		return exitThreshold
+	}
+	if errors.As(err, &ie) {
+		return exitParse
+	}
+	var te *TxnError
+	if !errors.As(err, &te) {
+		return exitFailure
+	}
+	switch te.Kind {
+	case TxnTimeout:
+		return exitTimeout
+	case TxnRejected:
+		return exitRejected
+	case TxnParse:
+		return exitParse
+	case TxnTransport:
+		return exitTransport
+	}
+	return exitFailure
+}
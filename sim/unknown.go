@@ -0,0 +1,67 @@
+package sim
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	gtp "github.com/wmnsk/go-gtp"
+	gtpv2ie "github.com/wmnsk/go-gtp/gtpv2/ie"
+	gtpv2msg "github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// probeWait is an UnknownMessage probe waiting for anything the peer sends
+// back with its sequence number.
+type probeWait struct {
+	seq uint32
+	ch  chan gtpv2msg.Message
+}
+
+// observeProbe hands m to a pending UnknownMessage probe if it carries the
+// probe's sequence number. Normal handling of m goes on either way.
+func (c *Client) observeProbe(m gtpv2msg.Message) {
+	p := c.probe.Load()
+	if p == nil || p.seq != m.Sequence() {
+		return
+	}
+	select {
+	case p.ch <- m:
+	default:
+	}
+}
+
+// UnknownMessage checks how the peer treats a message type it shouldn't
+// recognize: it marshals an EchoRequest, overwrites the message type octet
+// with msgType and sends it, then reports whatever comes back with its
+// sequence number within the timeout. TS 29.274 7.7.4 has the peer
+// silently discard it, so no answer is the conforming outcome; an answer
+// of any kind is logged as such. Neither counts as a transaction failure.
+func (c *Client) UnknownMessage(msgType uint8) error {
+	if n, ok := msgNames[msgType]; ok {
+		log.Printf("WARNING: unknown-msg-type %d is a known message type (%s)", msgType, n)
+	}
+	seq := c.seq.next()
+	req := gtpv2msg.NewEchoRequest(seq, gtpv2ie.NewRecovery(0))
+	b, err := gtp.Marshal(req)
+	if err != nil {
+		return err
+	}
+	b[1] = msgType
+
+	p := &probeWait{seq: seq, ch: make(chan gtpv2msg.Message, 1)}
+	c.probe.Store(p)
+	defer c.probe.Store(nil)
+	start := time.Now()
+	if err := c.tr.sendTo(b, c.tr.remote()); err != nil {
+		return fmt.Errorf("unknown-msg-type %d: %w", msgType, err)
+	}
+	log.Printf("tx message type %d (EchoReq body, type octet overwritten) seq=%d (%d B) to %s", msgType, seq, len(b), c.tr.remote())
+	select {
+	case m := <-p.ch:
+		log.Printf("unknown-msg-type %d seq=%d: peer answered %s%s after %s (TS 29.274 7.7.4 expects a silent discard)",
+			msgType, seq, m.MessageTypeName(), respCause(m), time.Since(start).Round(time.Microsecond))
+	case <-time.After(c.cfg.Timeout):
+		log.Printf("unknown-msg-type %d seq=%d: no answer within %s: dropped silently, as TS 29.274 7.7.4 requires", msgType, seq, c.cfg.Timeout)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package sim
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// startWarmup opens the Config.Warmup window: transactions begun within it
+// and the CreateSessions sent in it are left out of the RTT statistics, the
+// failure thresholds and the load summaries.
+func (c *Client) startWarmup() {
+	if c.cfg.Warmup <= 0 {
+		return
+	}
+	c.tr.st.setWarmup(time.Now().Add(c.cfg.Warmup))
+	log.Printf("warmup: the next %s are excluded from the statistics", c.cfg.Warmup)
+}
+
+// warming reports whether t falls in the warmup window.
+func (c *Client) warming(t time.Time) bool {
+	return c.tr.st.warming(t)
+}
+
+// csrTiming is when one CreateSession of a load run was sent and how long
+// its answer took.
+type csrTiming struct {
+	at  time.Time
+	rtt time.Duration
+	err error
+}
+
+// answered reports whether the peer answered, accepting or rejecting.
+func (t csrTiming) answered() bool {
+	var te *TxnError
+	return t.err == nil || errors.As(t.err, &te) && te.Kind == TxnRejected
+}
+
+// logLoadSummary logs the CreateSessions of a load run sent after the
+// warmup, marking how many warmup ones it leaves out.
+func (c *Client) logLoadSummary(ts []csrTiming) {
+	var (
+		warm, sent, accepted int
+		lat                  []time.Duration
+	)
+	for _, t := range ts {
+		if c.warming(t.at) {
+			warm++
+			continue
+		}
+		sent++
+		if t.err == nil {
+			accepted++
+		}
+		if t.answered() {
+			lat = append(lat, t.rtt)
+		}
+	}
+	if sent == 0 {
+		log.Printf("CreateSessions after warmup: none (all %d were sent in the %s warmup)", warm, c.cfg.Warmup)
+		return
+	}
+	log.Printf("CreateSessions after warmup: accepted=%d/%d (%.1f%%) p50=%s p95=%s; %d sent in the %s warmup excluded",
+		accepted, sent, 100*float64(accepted)/float64(sent), percentile(lat, 0.5), percentile(lat, 0.95), warm, c.cfg.Warmup)
+}
+
+// warmupReport is the run report line on what the warmup left out.
+func (c *Client) warmupReport() {
+	if c.cfg.Warmup <= 0 {
+		return
+	}
+	rtts := c.tr.st.warmRTTs()
+	c.csrs.mu.Lock()
+	csrs := c.csrs.warm
+	c.csrs.mu.Unlock()
+	log.Printf("run report: warmup %s excluded %d RTT sample(s) and %d CreateSession(s) from the statistics above and the metrics",
+		c.cfg.Warmup, rtts, csrs)
+}